@@ -0,0 +1,272 @@
+// Package auth authenticates the callers with hashed api keys or JWT bearer
+// tokens and scopes each of them to the providers they may ship with.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// KeyHeader is the header an api key can be sent with,
+// besides the Authorization: Bearer header.
+const KeyHeader = "X-API-Key"
+
+var (
+	// ErrUnauthenticated is returned when the request carries no credentials.
+	ErrUnauthenticated = errors.New("missing credentials")
+	// ErrInvalidCredentials is returned when the credentials are not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is who made the request.
+type Principal struct {
+	Subject string `json:"subject"`
+	Tenant  string `json:"tenant,omitempty"`
+	// Providers the principal may ship with, "*" allows all of them.
+	Providers []string `json:"providers"`
+	Admin     bool     `json:"admin"`
+}
+
+// Allowed reports if the principal may ship with the provider.
+func (p *Principal) Allowed(provider string) bool {
+	return p.Admin ||
+		slices.Contains(p.Providers, "*") ||
+		slices.Contains(p.Providers, provider)
+}
+
+type key struct{}
+
+// With returns a copy of the context carrying the principal.
+func With(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, key{}, p)
+}
+
+// From returns the principal from the context.
+func From(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(key{}).(*Principal)
+	return p, ok
+}
+
+// Auth authenticates the requests and manages the api keys.
+type Auth struct {
+	keys     KeyStore
+	log      *slog.Logger
+	jwks     *JWKS
+	parser   *jwt.Parser
+	issuer   string
+	audience string
+	admin    string
+}
+
+type Option func(a *Auth)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(a *Auth) {
+		a.log = log.WithGroup("auth")
+	}
+}
+
+// WithJWKS enables the JWT bearer tokens signed by the keys in the set.
+func WithJWKS(jwks *JWKS) Option {
+	return func(a *Auth) {
+		a.jwks = jwks
+	}
+}
+
+// WithIssuer requires the JWTs to be issued by the issuer.
+func WithIssuer(issuer string) Option {
+	return func(a *Auth) {
+		a.issuer = issuer
+	}
+}
+
+// WithAudience requires the JWTs to be issued for the audience.
+func WithAudience(audience string) Option {
+	return func(a *Auth) {
+		a.audience = audience
+	}
+}
+
+// WithAdminKey accepts a static admin api key, it's meant
+// to bootstrap the service and issue the first keys.
+func WithAdminKey(token string) Option {
+	return func(a *Auth) {
+		if token != "" {
+			a.admin = Hash(token)
+		}
+	}
+}
+
+// New returns a new auth instance using the store for the api keys,
+// without a store only the admin key and the JWTs are accepted.
+func New(keys KeyStore, options ...Option) *Auth {
+	if keys == nil {
+		keys = noKeys{}
+	}
+	a := &Auth{
+		keys: keys,
+		log:  log.Noop(),
+	}
+	for _, option := range options {
+		option(a)
+	}
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+	}
+	if a.issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(a.audience))
+	}
+	a.parser = jwt.NewParser(parserOptions...)
+	return a
+}
+
+func credentials(r *http.Request) string {
+	if token := r.Header.Get(KeyHeader); token != "" {
+		return token
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Authenticate returns who made the request.
+func (a *Auth) Authenticate(r *http.Request) (*Principal, error) {
+	token := credentials(r)
+	switch {
+	case token == "":
+		return nil, ErrUnauthenticated
+	case a.isAdmin(token):
+		return &Principal{Subject: "admin", Admin: true}, nil
+	case isKey(token):
+		return a.key(r.Context(), token)
+	case a.jwks != nil:
+		return a.jwt(token)
+	default:
+		return nil, ErrInvalidCredentials
+	}
+}
+
+// isAdmin reports if the token is the static admin key, which
+// is accepted even without the prefix of the issued keys.
+func (a *Auth) isAdmin(token string) bool {
+	return a.admin != "" && subtle.ConstantTimeCompare([]byte(Hash(token)), []byte(a.admin)) == 1
+}
+
+func (a *Auth) key(ctx context.Context, token string) (*Principal, error) {
+	hash := Hash(token)
+	k, err := a.keys.KeyByHash(ctx, hash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key, %w", err)
+	}
+	if k.RevokedAt != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return k.Principal(), nil
+}
+
+func (a *Auth) jwt(token string) (*Principal, error) {
+	claims := &Claims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.jwks.keyfunc); err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrInvalidCredentials, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w, missing subject", ErrInvalidCredentials)
+	}
+	return claims.Principal(), nil
+}
+
+// Middleware authenticates every request and puts the principal in its
+// context, the requests that fail to authenticate are answered with a 401.
+func (a *Auth) Middleware() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := a.Authenticate(r)
+			switch {
+			case errors.Is(err, ErrUnauthenticated), errors.Is(err, ErrInvalidCredentials):
+				a.log.With(log.Error(err)).InfoContext(r.Context(), "Authentication failed")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="axiogate"`)
+				response.New(w).Unauthorized(err.Error())
+				return
+			case err != nil:
+				a.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to authenticate")
+				response.New(w).InternalServer("failed to authenticate")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(With(r.Context(), p)))
+		})
+	}
+}
+
+// Admin allows only the admin principals, it must run after Middleware.
+func (a *Auth) Admin() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := From(r.Context())
+			if !ok || !p.Admin {
+				response.New(w).Forbidden("admin access required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var _ shipment.Authorizer = (*Auth)(nil)
+
+// Allowed reports if the principal in the context may ship with the provider.
+// Calls made without a principal, from inside the service, are allowed.
+func (a *Auth) Allowed(ctx context.Context, provider string) bool {
+	p, ok := From(ctx)
+	if !ok {
+		return true
+	}
+	return p.Allowed(provider)
+}
+
+// Issue creates a new api key and returns its token, the token
+// is not stored so it can't be retrieved afterwards.
+func (a *Auth) Issue(ctx context.Context, k *Key) (string, error) {
+	if err := k.Validate(); err != nil {
+		return "", err
+	}
+	token := newToken()
+	k.Prefix = prefix(token)
+	k.Hash = Hash(token)
+	k.RevokedAt = nil
+	if err := a.keys.CreateKey(ctx, k); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Keys returns all the issued keys.
+func (a *Auth) Keys(ctx context.Context) ([]Key, error) {
+	return a.keys.Keys(ctx)
+}
+
+// Revoke revokes the key, it can't be used anymore.
+func (a *Auth) Revoke(ctx context.Context, id int64) error {
+	return a.keys.RevokeKey(ctx, id)
+}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockStore struct{ mock.Mock }
+
+func (m *mockStore) CreateKey(ctx context.Context, k *Key) error {
+	args := m.Called(ctx, k)
+	k.ID = 1
+	return args.Error(0)
+}
+
+func (m *mockStore) KeyByHash(ctx context.Context, hash string) (*Key, error) {
+	args := m.Called(ctx, hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Key), args.Error(1)
+}
+
+func (m *mockStore) Keys(ctx context.Context) ([]Key, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]Key), args.Error(1)
+}
+
+func (m *mockStore) RevokeKey(ctx context.Context, id int64) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+// signer generates an ES256 key and its JWKS.
+func signer(t *testing.T, kid string) (*ecdsa.PrivateKey, *JWKS) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	b, err := priv.PublicKey.Bytes()
+	require.NoError(t, err)
+	enc := base64.RawURLEncoding.EncodeToString
+	set, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": enc(b[1:33]), "y": enc(b[33:]),
+	}}})
+	require.NoError(t, err)
+	jwks, err := ParseJWKS(set)
+	require.NoError(t, err)
+	return priv, jwks
+}
+
+func sign(t *testing.T, priv *ecdsa.PrivateKey, kid string, claims *Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(priv)
+	require.NoError(t, err)
+	return s
+}
+
+func TestAuth_Issue(t *testing.T) {
+	store := new(mockStore)
+	store.On("CreateKey", mock.Anything, mock.Anything).Return(nil)
+	a := New(store)
+
+	k := &Key{Name: "shop", Providers: []string{"a"}}
+	token, err := a.Issue(context.Background(), k)
+	require.NoError(t, err)
+	assert.True(t, isKey(token))
+	assert.Equal(t, Hash(token), k.Hash)
+	assert.Equal(t, token[:len(k.Prefix)], k.Prefix)
+
+	_, err = a.Issue(context.Background(), &Key{Name: "no scope"})
+	assert.Equal(t, &ErrInvalidKey{Reason: "at least one provider is required"}, err)
+}
+
+func TestAuth_Authenticate(t *testing.T) {
+	const kid = "test"
+	priv, jwks := signer(t, kid)
+	other, _ := signer(t, kid)
+	expires := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	revoked := time.Now()
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		key     *Key
+		want    *Principal
+		wantErr error
+	}{
+		{
+			name:    "missing credentials",
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:   "api key in the bearer header",
+			header: "Authorization",
+			value:  "Bearer axg_valid",
+			key:    &Key{ID: 7, Providers: []string{"a"}, Tenant: "acme"},
+			want:   &Principal{Subject: "key:7", Tenant: "acme", Providers: []string{"a"}},
+		},
+		{
+			name:   "api key in the api key header",
+			header: KeyHeader,
+			value:  "axg_valid",
+			key:    &Key{ID: 7, Providers: []string{"a"}},
+			want:   &Principal{Subject: "key:7", Providers: []string{"a"}},
+		},
+		{
+			name:    "revoked api key",
+			header:  KeyHeader,
+			value:   "axg_valid",
+			key:     &Key{ID: 7, Providers: []string{"a"}, RevokedAt: &revoked},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:    "unknown api key",
+			header:  KeyHeader,
+			value:   "axg_unknown",
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:   "static admin key",
+			header: KeyHeader,
+			value:  "axg_admin",
+			want:   &Principal{Subject: "admin", Admin: true},
+		},
+		{
+			name:   "valid jwt",
+			header: "Authorization",
+			value: "Bearer " + sign(t, priv, kid, &Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "client", Issuer: "idp", ExpiresAt: expires},
+				Providers:        []string{"b"},
+			}),
+			want: &Principal{Subject: "client", Providers: []string{"b"}},
+		},
+		{
+			name:   "expired jwt",
+			header: "Authorization",
+			value: "Bearer " + sign(t, priv, kid, &Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject: "client", Issuer: "idp",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+				},
+			}),
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:   "jwt from another issuer",
+			header: "Authorization",
+			value: "Bearer " + sign(t, priv, kid, &Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "client", Issuer: "other", ExpiresAt: expires},
+			}),
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:   "jwt signed by another key",
+			header: "Authorization",
+			value: "Bearer " + sign(t, other, kid, &Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "client", Issuer: "idp", ExpiresAt: expires},
+			}),
+			wantErr: ErrInvalidCredentials,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := new(mockStore)
+			if tt.key != nil {
+				store.On("KeyByHash", mock.Anything, Hash("axg_valid")).Return(tt.key, nil)
+			}
+			store.On("KeyByHash", mock.Anything, mock.Anything).Return(nil, ErrNotFound).Maybe()
+
+			a := New(store, WithJWKS(jwks), WithIssuer("idp"), WithAdminKey("axg_admin"))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			p, err := a.Authenticate(r)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, p)
+		})
+	}
+}
+
+func TestAuthenticate_AdminKeyWithoutPrefix(t *testing.T) {
+	a := New(new(mockStore), WithAdminKey("change-me"))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(KeyHeader, "change-me")
+	p, err := a.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Subject: "admin", Admin: true}, p)
+
+	r.Header.Set(KeyHeader, "change-me-not")
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestAuth_Middleware(t *testing.T) {
+	store := new(mockStore)
+	store.On("KeyByHash", mock.Anything, Hash("axg_user")).
+		Return(&Key{ID: 1, Providers: []string{"a"}}, nil)
+	store.On("KeyByHash", mock.Anything, mock.Anything).Return(nil, ErrNotFound)
+	a := New(store, WithAdminKey("axg_admin"))
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, found := From(r.Context())
+		assert.True(t, found)
+		assert.Equal(t, p.Admin, a.Allowed(r.Context(), "b"))
+		assert.True(t, a.Allowed(r.Context(), "a"))
+	})
+	tests := []struct {
+		name  string
+		key   string
+		admin bool
+		code  int
+	}{
+		{name: "no credentials", code: http.StatusUnauthorized},
+		{name: "bad credentials", key: "axg_bad", code: http.StatusUnauthorized},
+		{name: "user", key: "axg_user", code: http.StatusOK},
+		{name: "user on admin route", key: "axg_user", admin: true, code: http.StatusForbidden},
+		{name: "admin on admin route", key: "axg_admin", admin: true, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h http.Handler = ok
+			if tt.admin {
+				h = a.Admin()(h)
+			}
+			h = a.Middleware()(h)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.key != "" {
+				r.Header.Set(KeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestAuth_AllowedWithoutPrincipal(t *testing.T) {
+	assert.True(t, New(new(mockStore)).Allowed(context.Background(), "a"))
+}
+
+func TestAuth_WithoutKeyStore(t *testing.T) {
+	a := New(nil, WithAdminKey("axg_admin"))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(KeyHeader, "axg_admin")
+	p, err := a.Authenticate(r)
+	require.NoError(t, err)
+	assert.True(t, p.Admin)
+
+	r.Header.Set(KeyHeader, "axg_other")
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = a.Issue(context.Background(), &Key{Name: "shop", Providers: []string{"a"}})
+	assert.ErrorIs(t, err, ErrNoKeyStore)
+}
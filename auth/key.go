@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// KeyPrefix starts every api key so they can be told apart from the JWTs.
+const KeyPrefix = "axg_"
+
+// ErrNotFound is returned when a key does not exist.
+var ErrNotFound = errors.New("key not found")
+
+// ErrNoKeyStore is returned when the keys are managed without a store.
+var ErrNoKeyStore = errors.New("no key store, the api keys need postgres")
+
+// ErrInvalidKey is returned when a key can't be issued.
+type ErrInvalidKey struct {
+	Reason string
+}
+
+var _ error = (*ErrInvalidKey)(nil)
+
+func (e *ErrInvalidKey) Error() string {
+	return fmt.Sprintf("invalid key, %s", e.Reason)
+}
+
+// Key is an api key, only its hash is stored, the token
+// is handed out once when the key is issued.
+type Key struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the token, it helps to tell the keys apart.
+	Prefix string `json:"prefix"`
+	Hash   string `json:"-"`
+	Tenant string `json:"tenant,omitempty"`
+	// Providers the key may ship with, "*" allows all of them.
+	Providers []string   `json:"providers"`
+	Admin     bool       `json:"admin"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Validate checks if the key can be issued.
+func (k *Key) Validate() error {
+	if k.Name == "" {
+		return &ErrInvalidKey{Reason: "name is required"}
+	}
+	if len(k.Providers) == 0 && !k.Admin {
+		return &ErrInvalidKey{Reason: "at least one provider is required"}
+	}
+	return nil
+}
+
+// Principal returns who the key authenticates.
+func (k *Key) Principal() *Principal {
+	return &Principal{
+		Subject:   fmt.Sprintf("key:%d", k.ID),
+		Tenant:    k.Tenant,
+		Providers: k.Providers,
+		Admin:     k.Admin,
+	}
+}
+
+// KeyStore persists the api keys.
+type KeyStore interface {
+	CreateKey(ctx context.Context, k *Key) error
+	// KeyByHash returns the key, revoked or not, with the token hash.
+	KeyByHash(ctx context.Context, hash string) (*Key, error)
+	Keys(ctx context.Context) ([]Key, error)
+	RevokeKey(ctx context.Context, id int64) error
+}
+
+// noKeys is the store of a service without postgres, it has no keys
+// and can't issue any, only the admin key and the JWTs are accepted.
+type noKeys struct{}
+
+func (noKeys) CreateKey(context.Context, *Key) error { return ErrNoKeyStore }
+
+func (noKeys) KeyByHash(context.Context, string) (*Key, error) { return nil, ErrNotFound }
+
+func (noKeys) Keys(context.Context) ([]Key, error) { return nil, ErrNoKeyStore }
+
+func (noKeys) RevokeKey(context.Context, int64) error { return ErrNoKeyStore }
+
+// Hash returns the hex encoded sha256 of the token.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken generates a new random api key token.
+func newToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return KeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func prefix(token string) string {
+	const n = len(KeyPrefix) + 6
+	if len(token) < n {
+		return token
+	}
+	return token[:n]
+}
+
+func isKey(token string) bool {
+	return strings.HasPrefix(token, KeyPrefix)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	shttp "net/http"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/config"
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/metrics"
+	"github.com/hoenirvili/axiogate/migrate"
+	"github.com/hoenirvili/axiogate/migrations"
+	"github.com/hoenirvili/axiogate/ratelimit"
+	"github.com/hoenirvili/axiogate/rule"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/storage"
+	"github.com/hoenirvili/axiogate/tenant"
+	"github.com/hoenirvili/axiogate/tracing"
+	"github.com/hoenirvili/axiogate/webhook"
+)
+
+// app is the shipment service and everything it's built on, the
+// server and the operator subcommands build it the same way. Without
+// postgres the storage, the migrator, the dispatcher, the rules and
+// the tenants are nil.
+type app struct {
+	migrator   *migrate.Migrator
+	st         *storage.Storage
+	store      shipmentStore
+	dispatcher *webhook.Dispatcher
+	rules      *rule.Engine
+	tenants    *tenant.Manager
+	authn      *auth.Auth
+	service    *shipment.Shipment
+
+	closers []func()
+}
+
+// newApp connects to the storage and builds the shipment service,
+// instrumented with the metrics and the traces. Close releases it.
+func newApp(ctx context.Context, cfg *config.Config, logger *slog.Logger, m *metrics.Metrics, tr *tracing.Tracing) (a *app, err error) {
+	a = &app{}
+	defer func() {
+		if err != nil {
+			a.Close()
+		}
+	}()
+
+	if cfg.Postgres() {
+		if err := a.postgres(ctx, cfg, logger, tr); err != nil {
+			return nil, err
+		}
+	}
+	a.store, err = shipments(ctx, cfg.Storage, a.st, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the shipment storage, %w", err)
+	}
+	if c, ok := a.store.(io.Closer); ok {
+		a.closers = append(a.closers, func() { _ = c.Close() })
+	}
+	var saver shipment.Storage = a.store
+	if cfg.Storage.Backend == config.BackendPostgres && cfg.Storage.BatchSize > 0 {
+		writer := a.st.NewWriter(
+			storage.WithWriterLogger(logger),
+			storage.WithBatchSize(cfg.Storage.BatchSize),
+			storage.WithFlushInterval(cfg.Storage.FlushInterval),
+			storage.WithFlushObserver(m),
+		)
+		a.closers = append(a.closers, func() { _ = writer.Close() })
+		saver = writer
+	}
+
+	var (
+		keys            auth.KeyStore
+		requestOptions  = []request.Option{request.WithLogger(logger), request.WithRetries(cfg.Providers.Retries, cfg.Providers.RetryBackoff)}
+		limiterOptions  = []ratelimit.ProviderLimiterOption{ratelimit.WithProviderLimiterLogger(logger)}
+		shipmentOptions = []shipment.Option{shipment.WithLogger(logger), shipment.WithObserver(m, tr), shipment.WithPriority(priority...)}
+	)
+	// Without postgres the service runs without the webhooks, the rules,
+	// the tenants, the issued keys, the audit, the shared provider rates
+	// and the dead letters, they are all kept in postgres.
+	if a.st != nil {
+		a.dispatcher = webhook.New(a.st, webhook.WithLogger(logger))
+		a.closers = append(a.closers, a.dispatcher.Close)
+		a.rules = rule.New(a.st, rule.WithLogger(logger))
+		a.tenants = tenant.New(a.st, providers, tenant.WithLogger(logger))
+		keys = a.st
+		// The exchanges are audited in the background, batched,
+		// the calls to the providers don't wait on the db.
+		auditor := a.st.NewAuditWriter(storage.WithAuditWriterLogger(logger))
+		a.closers = append(a.closers, func() { _ = auditor.Close() })
+		requestOptions = append(requestOptions, request.WithAuditor(auditor))
+		limiterOptions = append(limiterOptions, ratelimit.WithTokens(a.st))
+		shipmentOptions = append(shipmentOptions,
+			shipment.WithNotifier(a.dispatcher),
+			shipment.WithEligibility(a.rules),
+			shipment.WithResolver(a.tenants),
+			shipment.WithDeadLetters(m.NewDeadLetters(a.st)),
+		)
+	}
+	a.authn, err = authenticator(cfg.Auth, keys, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up authentication, %w", err)
+	}
+	requestOptions = append(requestOptions, request.WithLimiter(ratelimit.NewProviderLimiter(providers, limiterOptions...)))
+	cli := m.NewClient(request.NewClient(&shttp.Client{Transport: tr.Transport(nil)}, requestOptions...), providers)
+	a.service = shipment.New(cli, providers, m.NewStorage(saver),
+		append(shipmentOptions, shipment.WithAuthorizer(a.authn))...,
+	)
+	return a, nil
+}
+
+// postgres connects to postgres, migrating it if asked, and
+// opens the storage of the state kept in it.
+func (a *app) postgres(ctx context.Context, cfg *config.Config, logger *slog.Logger, tr *tracing.Tracing) error {
+	db, err := db(ctx, cfg.Database.URL, tr)
+	if err != nil {
+		return err
+	}
+	a.closers = append(a.closers, db.Close)
+	a.migrator, err = migrate.New(db, migrations.FS, migrate.WithLogger(logger))
+	if err != nil {
+		return fmt.Errorf("failed to load the migrations, %w", err)
+	}
+	if cfg.Database.MigrateOnStart {
+		if err := a.migrator.Up(ctx); err != nil {
+			return fmt.Errorf("failed to migrate the db, %w", err)
+		}
+	}
+	a.st, err = newStorage(db, cfg.Encryption, logger)
+	return err
+}
+
+// Close flushes the pending writes, exchanges and deliveries and closes the storage.
+func (a *app) Close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i]()
+	}
+}
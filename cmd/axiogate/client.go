@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	shttp "net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/config"
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/handler"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/metrics"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/tracing"
+)
+
+// operator is what the operator subcommands ship and read the
+// shipments with, a running server or the storage directly.
+type operator interface {
+	Send(ctx context.Context, providers []string, req *api.ShippingRequest) ([]api.ShippingResponse, error)
+	handler.RecordReader
+	handler.Catalog
+}
+
+// Output formats of the operator subcommands.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// client holds the flags shared by the operator subcommands.
+type client struct {
+	server string
+	apiKey string
+	output string
+	tenant string
+}
+
+// flagSet returns the flag set of the subcommand with the shared flags.
+func (c *client) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&c.server, "server", os.Getenv("AXIOGATE_SERVER"), "url of the running server, empty to use the storage directly")
+	fs.StringVar(&c.apiKey, "api-key", os.Getenv("AXIOGATE_API_KEY"), "api key sent to the server")
+	fs.StringVar(&c.output, "output", outputTable, "output format, table or json")
+	fs.StringVar(&c.tenant, "tenant", "", "tenant to act as without a server, with a server only admins listing shipments may set it")
+	return fs
+}
+
+// split separates the args of the flag set from
+// the rest, which are left for the configuration.
+func split(fs *flag.FlagSet, args []string) (own, rest []string) {
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		f := fs.Lookup(name)
+		if !strings.HasPrefix(args[i], "-") || f == nil {
+			rest = append(rest, args[i])
+			continue
+		}
+		own = append(own, args[i])
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			own = append(own, args[i])
+		}
+	}
+	return own, rest
+}
+
+// parse parses the subcommand flags and loads the configuration from the
+// rest of the args, if it fails the command exits with the returned code.
+func (c *client) parse(fs *flag.FlagSet, args []string, usage string) (*config.Config, int, bool) {
+	own, rest := split(fs, args)
+	cfg, err := config.Load(rest, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, usage)
+		fs.SetOutput(os.Stderr)
+		fs.PrintDefaults()
+		return nil, 0, false
+	}
+	if err == nil {
+		err = fs.Parse(own)
+	}
+	switch {
+	case err != nil:
+	case c.output != outputTable && c.output != outputJSON:
+		err = fmt.Errorf("invalid output %s, expected %s or %s", c.output, outputTable, outputJSON)
+	case c.server != "" && c.tenant != "" && fs.Name() != "shipments":
+		err = errors.New("the tenant is set by the api key of the server")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n%s\n", err, usage)
+		return nil, 2, false
+	}
+	return cfg, 0, true
+}
+
+// open returns the client of the server if one is given, otherwise the
+// shipment service over the configured storage. The storage is connected
+// only if it's needed, release closes it.
+func (c *client) open(ctx context.Context, cfg *config.Config, needsStorage bool) (operator, func(), error) {
+	if c.server != "" {
+		return &remote{url: strings.TrimSuffix(c.server, "/"), key: c.apiKey, cli: &shttp.Client{}}, func() {}, nil
+	}
+	if !needsStorage && c.tenant == "" {
+		service := shipment.New(nil, providers, nil, shipment.WithPriority(priority...))
+		return &local{service: service}, func() {}, nil
+	}
+	logger := slog.New(log.NewHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Log.SlogLevel()})))
+
+	core, err := newApp(ctx, cfg, logger, metrics.New(), tracing.New(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &local{service: core.service, records: core.store, tenant: c.tenant}, core.Close, nil
+}
+
+// filter scopes the shipments to the tenant, all of them if none is given.
+func (c *client) filter() shipment.Filter {
+	if c.tenant == "" {
+		return shipment.Filter{AllTenants: true}
+	}
+	return shipment.Filter{Tenant: c.tenant}
+}
+
+// render writes v as indented json or as the table written by table.
+func (c *client) render(v any, table func(w io.Writer)) error {
+	if c.output == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// readRequest reads the shipping request from the file, - is the stdin.
+func readRequest(file string) (*api.ShippingRequest, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the shipping request, %w", err)
+	}
+	req := &api.ShippingRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("failed to decode the shipping request, %w", err)
+	}
+	return req, nil
+}
+
+// local ships and reads the shipments directly, with the storage.
+type local struct {
+	service *shipment.Shipment
+	records handler.RecordReader
+	tenant  string
+}
+
+var _ operator = (*local)(nil)
+
+// as returns the context of the operator, acting as the tenant if any.
+func (l *local) as(ctx context.Context) context.Context {
+	return auth.With(ctx, &auth.Principal{Subject: "cli", Tenant: l.tenant, Admin: true})
+}
+
+func (l *local) Send(ctx context.Context, providers []string, req *api.ShippingRequest) ([]api.ShippingResponse, error) {
+	return l.service.Send(l.as(ctx), providers, req)
+}
+
+func (l *local) Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error) {
+	return l.records.Records(l.as(ctx), f)
+}
+
+func (l *local) Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error) {
+	return l.records.Record(l.as(ctx), f, id)
+}
+
+func (l *local) Providers(ctx context.Context) ([]api.Provider, error) {
+	return l.service.Providers(l.as(ctx))
+}
+
+func (l *local) Map(ctx context.Context, provider string, req *api.ShippingRequest) (json.RawMessage, error) {
+	return l.service.Map(l.as(ctx), provider, req)
+}
+
+// remote ships and reads the shipments through a running server.
+type remote struct {
+	url string
+	key string
+	cli *shttp.Client
+}
+
+var _ operator = (*remote)(nil)
+
+// do calls the server api and decodes its response into out.
+func (r *remote) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode the request, %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := r.url + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := shttp.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create the request, %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.key != "" {
+		req.Header.Set("X-API-Key", r.key)
+	}
+	resp, err := r.cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call the server, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= shttp.StatusMultipleChoices {
+		var e response.Error
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			e.Error = shttp.StatusText(resp.StatusCode)
+		}
+		return fmt.Errorf("the server replied %d, %s", resp.StatusCode, e.Error)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the server response, %w", err)
+	}
+	return nil
+}
+
+func (r *remote) Send(ctx context.Context, providers []string, req *api.ShippingRequest) ([]api.ShippingResponse, error) {
+	var out api.ShippingResponses
+	err := r.do(ctx, shttp.MethodPost, "/api/v1/createShipping", url.Values{"providers": providers}, req, &out)
+	return out.Responses, err
+}
+
+// scope returns the query selecting the tenant of the filter, by default
+// the server picks the tenant of the api key, or all of them for the admins.
+func scope(f shipment.Filter) url.Values {
+	query := url.Values{}
+	if !f.AllTenants {
+		query.Set("tenant", f.Tenant)
+	}
+	return query
+}
+
+func (r *remote) Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error) {
+	query := scope(f)
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Before != "" {
+		query.Set("before", f.Before)
+	}
+	var out []shipment.Record
+	err := r.do(ctx, shttp.MethodGet, "/api/v1/shipments", query, nil, &out)
+	return out, err
+}
+
+func (r *remote) Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error) {
+	var out shipment.Record
+	if err := r.do(ctx, shttp.MethodGet, "/api/v1/shipments/"+url.PathEscape(id), scope(f), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (r *remote) Providers(ctx context.Context) ([]api.Provider, error) {
+	var out api.Providers
+	err := r.do(ctx, shttp.MethodGet, "/api/v1/providers", nil, nil, &out)
+	return out.Providers, err
+}
+
+func (r *remote) Map(ctx context.Context, provider string, req *api.ShippingRequest) (json.RawMessage, error) {
+	var out json.RawMessage
+	err := r.do(ctx, shttp.MethodPost, "/api/v1/providers/"+url.PathEscape(provider)+"/map", nil, req, &out)
+	return out, err
+}
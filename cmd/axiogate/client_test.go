@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantOwn  []string
+		wantRest []string
+	}{
+		{name: "no args"},
+		{
+			name:     "flags with their values apart",
+			args:     []string{"-server", "http://localhost:8080", "-log-level", "debug"},
+			wantOwn:  []string{"-server", "http://localhost:8080"},
+			wantRest: []string{"-log-level", "debug"},
+		},
+		{
+			name:     "flags with their values joined",
+			args:     []string{"--output=json", "-http-addr=:9090"},
+			wantOwn:  []string{"--output=json"},
+			wantRest: []string{"-http-addr=:9090"},
+		},
+		{
+			name:     "boolean flag takes no value",
+			args:     []string{"-dry-run", "-log-level", "warn"},
+			wantOwn:  []string{"-dry-run"},
+			wantRest: []string{"-log-level", "warn"},
+		},
+		{
+			name:    "last flag without its value",
+			args:    []string{"-tenant"},
+			wantOwn: []string{"-tenant"},
+		},
+		{
+			name:     "not a flag",
+			args:     []string{"server", "-output", "table"},
+			wantOwn:  []string{"-output", "table"},
+			wantRest: []string{"server"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &client{}
+			fs := c.flagSet("test")
+			fs.Bool("dry-run", false, "")
+
+			own, rest := split(fs, tt.args)
+			assert.Equal(t, tt.wantOwn, own)
+			assert.Equal(t, tt.wantRest, rest)
+		})
+	}
+}
+
+func TestClient_Parse(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    string
+		args       []string
+		wantCode   int
+		wantOk     bool
+		wantOutput string
+		wantLevel  string
+	}{
+		{
+			name:       "flags and configuration",
+			command:    "ship",
+			args:       []string{"-output", "json", "-log-level", "debug"},
+			wantOk:     true,
+			wantOutput: outputJSON,
+			wantLevel:  "debug",
+		},
+		{
+			name:     "help",
+			command:  "ship",
+			args:     []string{"-h"},
+			wantCode: 0,
+		},
+		{
+			name:     "invalid output",
+			command:  "ship",
+			args:     []string{"-output", "xml"},
+			wantCode: 2,
+		},
+		{
+			name:     "invalid configuration",
+			command:  "ship",
+			args:     []string{"-log-level", "loud"},
+			wantCode: 2,
+		},
+		{
+			name:     "tenant with a server",
+			command:  "ship",
+			args:     []string{"-server", "http://localhost:8080", "-tenant", "acme"},
+			wantCode: 2,
+		},
+		{
+			name:       "tenant with a server listing the shipments",
+			command:    "shipments",
+			args:       []string{"-server", "http://localhost:8080", "-tenant", "acme"},
+			wantOk:     true,
+			wantOutput: outputTable,
+			wantLevel:  "info",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &client{}
+			fs := c.flagSet(tt.command)
+
+			cfg, code, ok := c.parse(fs, tt.args, "usage")
+			assert.Equal(t, tt.wantCode, code)
+			require.Equal(t, tt.wantOk, ok)
+			if !ok {
+				assert.Nil(t, cfg)
+				return
+			}
+			assert.Equal(t, tt.wantOutput, c.output)
+			assert.Equal(t, tt.wantLevel, cfg.Log.Level)
+		})
+	}
+}
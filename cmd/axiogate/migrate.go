@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/hoenirvili/axiogate/config"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/migrate"
+	"github.com/hoenirvili/axiogate/migrations"
+)
+
+const migrateUsage = `usage: axiogate migrate up|down [n|all]|status|version [flags]`
+
+// runMigrate runs the migrate subcommand, down rolls back
+// one migration unless told how many or all of them.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	command, args := args[0], args[1:]
+	steps := 1
+	if command == "down" && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		n, err := strconv.Atoi(args[0])
+		switch {
+		case args[0] == "all":
+			steps = -1
+		case err != nil || n <= 0:
+			fmt.Fprintf(os.Stderr, "invalid number of migrations %q\n%s\n", args[0], migrateUsage)
+			return 2
+		default:
+			steps = n
+		}
+		args = args[1:]
+	}
+
+	cfg, err := config.Load(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !cfg.Postgres() {
+		fmt.Fprintln(os.Stderr, "nothing to migrate without postgres, set the database url")
+		return 2
+	}
+	logger := newLogger(cfg.Log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	db, err := db(ctx, cfg.Database.URL, nil)
+	if err != nil {
+		logger.With(log.Error(err)).Error("Failed to connect to db")
+		return 1
+	}
+	defer db.Close()
+	migrator, err := migrate.New(db, migrations.FS, migrate.WithLogger(logger))
+	if err != nil {
+		logger.With(log.Error(err)).Error("Failed to load the migrations")
+		return 1
+	}
+
+	switch command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx, steps)
+	case "status":
+		err = status(ctx, migrator)
+	case "version":
+		var (
+			version int64
+			dirty   bool
+		)
+		version, dirty, err = migrator.Version(ctx)
+		if err == nil {
+			fmt.Printf("%d (latest %d, dirty %t)\n", version, migrator.Latest(), dirty)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n%s\n", command, migrateUsage)
+		return 2
+	}
+	if err != nil {
+		logger.With(log.Error(err)).Error("Failed to migrate the db")
+		return 1
+	}
+	return 0
+}
+
+// status prints every migration and whether it's applied.
+func status(ctx context.Context, migrator *migrate.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		fmt.Fprintf(w, "%d\t%s\t%t\n", s.Version, s.Name, s.Applied)
+	}
+	return w.Flush()
+}
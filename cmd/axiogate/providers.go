@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+)
+
+const (
+	providersUsage = `usage: axiogate providers list [-server url] [-output table|json] [flags]`
+	mapUsage       = `usage: axiogate map -provider b -file input.json [-server url] [-output table|json] [flags]`
+)
+
+// runProviders lists the providers that can be shipped with, in the order they are preferred.
+func runProviders(args []string) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, providersUsage)
+		return 2
+	}
+	var c client
+	fs := c.flagSet("providers")
+	cfg, code, ok := c.parse(fs, args[1:], providersUsage)
+	if !ok {
+		return code
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	op, release, err := c.open(ctx, cfg, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer release()
+	list, err := op.Providers(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list providers, %s\n", err)
+		return 1
+	}
+	err = c.render(list, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tENDPOINT\tQUOTES")
+		for _, p := range list {
+			fmt.Fprintf(w, "%s\t%s\t%t\n", p.Name, p.Endpoint, p.Quotes)
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runMap prints the payload the provider would be sent
+// for the shipping request of the file, nothing is sent.
+func runMap(args []string) int {
+	var c client
+	fs := c.flagSet("map")
+	provider := fs.String("provider", "", "provider to map the request for")
+	file := fs.String("file", "", "shipping request to map, - reads it from stdin")
+	cfg, code, ok := c.parse(fs, args, mapUsage)
+	if !ok {
+		return code
+	}
+	if *provider == "" || *file == "" {
+		fmt.Fprintf(os.Stderr, "missing the provider or the shipping request file\n%s\n", mapUsage)
+		return 2
+	}
+	req, err := readRequest(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	op, release, err := c.open(ctx, cfg, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer release()
+	payload, err := op.Map(ctx, *provider, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to map the request, %s\n", err)
+		return 1
+	}
+	err = c.render(payload, func(w io.Writer) { fields(w, payload) })
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// fields writes the top level fields of the payload sorted by name, the
+// nested ones as compact json. Payloads that aren't objects are written as is.
+func fields(w io.Writer, payload json.RawMessage) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &object); err != nil {
+		fmt.Fprintf(w, "%s\n", payload)
+		return
+	}
+	fmt.Fprintln(w, "FIELD\tVALUE")
+	for _, name := range slices.Sorted(maps.Keys(object)) {
+		fmt.Fprintf(w, "%s\t%s\n", name, object[name])
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hoenirvili/axiogate/config"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/retention"
+	"github.com/hoenirvili/axiogate/storage"
+)
+
+const purgeUsage = `usage: axiogate purge [-dry-run] [flags]`
+
+// policy returns the retention policy of the configuration.
+func policy(cfg config.Retention) retention.Policy {
+	return retention.Policy{
+		ScrubAfter:  cfg.ScrubAfter,
+		DeleteAfter: cfg.DeleteAfter,
+		ArchiveDir:  cfg.ArchiveDir,
+		Fields:      cfg.Fields,
+	}
+}
+
+// newRetention returns the retention of the configuration, the deleted
+// shipments are archived encrypted if the storage encrypts them.
+func newRetention(st *storage.Storage, cfg config.Retention, logger *slog.Logger) *retention.Retention {
+	options := []retention.Option{retention.WithLogger(logger)}
+	if c := st.Cipher(); c != nil {
+		options = append(options, retention.WithSealer(c))
+	}
+	return retention.New(st, policy(cfg), options...)
+}
+
+// runPurge applies the retention once and prints its report, a
+// dry run only reports what would be scrubbed and deleted.
+func runPurge(args []string) int {
+	dryRun := false
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "-dry-run" || arg == "--dry-run" {
+			dryRun = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+
+	cfg, err := config.Load(rest, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, purgeUsage)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !cfg.Retention.Enabled() {
+		fmt.Fprintf(os.Stderr, "no retention configured, set the scrub or the delete ttl\n%s\n", purgeUsage)
+		return 2
+	}
+	logger := newLogger(cfg.Log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	db, err := db(ctx, cfg.Database.URL, nil)
+	if err != nil {
+		logger.With(log.Error(err)).Error("Failed to connect to db")
+		return 1
+	}
+	defer db.Close()
+
+	st, err := newStorage(db, cfg.Encryption, logger)
+	if err != nil {
+		logger.With(log.Error(err)).Error("Failed to set up the storage")
+		return 1
+	}
+	report, err := newRetention(st, cfg.Retention, logger).Run(ctx, dryRun)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	}
+	if err != nil {
+		logger.With(log.Error(err)).Error("Failed to apply the retention")
+		return 1
+	}
+	return 0
+}
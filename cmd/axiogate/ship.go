@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const shipUsage = `usage: axiogate ship -file input.json [-providers a,b] [-server url] [-output table|json] [flags]`
+
+// list splits the comma separated values, leaving out the empty ones.
+func list(value string) []string {
+	var out []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// runShip sends the shipping request of the file to the providers,
+// or to all of them, and prints their responses.
+func runShip(args []string) int {
+	var c client
+	fs := c.flagSet("ship")
+	file := fs.String("file", "", "shipping request to send, - reads it from stdin")
+	providers := fs.String("providers", "", "comma separated providers to send to, all of them by default")
+	cfg, code, ok := c.parse(fs, args, shipUsage)
+	if !ok {
+		return code
+	}
+	if *file == "" {
+		fmt.Fprintf(os.Stderr, "missing the shipping request file\n%s\n", shipUsage)
+		return 2
+	}
+	req, err := readRequest(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	op, release, err := c.open(ctx, cfg, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer release()
+	resp, err := op.Send(ctx, list(*providers), req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to ship, %s\n", err)
+		return 1
+	}
+	err = c.render(resp, func(w io.Writer) {
+		fmt.Fprintln(w, "ENDPOINT\tERROR\tRESPONSE")
+		for _, r := range resp {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.Endpoint, r.Error, r.RawResponse)
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
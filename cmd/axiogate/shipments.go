@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+const shipmentsUsage = `usage: axiogate shipments list [-limit n] [-before id]|get <id> [-tenant id] [-server url] [-output table|json] [flags]`
+
+// runShipments lists the stored shipments newest first or prints one of them.
+func runShipments(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, shipmentsUsage)
+		return 2
+	}
+	command, args := args[0], args[1:]
+	var id string
+	if command == "get" {
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			fmt.Fprintf(os.Stderr, "missing the shipment id\n%s\n", shipmentsUsage)
+			return 2
+		}
+		id, args = args[0], args[1:]
+		if err := uuid.Validate(id); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid id %s\n%s\n", id, shipmentsUsage)
+			return 2
+		}
+	}
+
+	var c client
+	fs := c.flagSet("shipments")
+	limit := fs.Int("limit", 50, "number of shipments to list")
+	before := fs.String("before", "", "list the shipments stored before the one with the id, for paging")
+	cfg, code, ok := c.parse(fs, args, shipmentsUsage)
+	if !ok {
+		return code
+	}
+	f := c.filter()
+	switch command {
+	case "list":
+		if *limit <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid limit %d\n%s\n", *limit, shipmentsUsage)
+			return 2
+		}
+		if *before != "" {
+			if err := uuid.Validate(*before); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid before %s\n%s\n", *before, shipmentsUsage)
+				return 2
+			}
+		}
+		f.Limit, f.Before = *limit, *before
+	case "get":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown shipments command %q\n%s\n", command, shipmentsUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	op, release, err := c.open(ctx, cfg, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer release()
+	if command == "get" {
+		rec, err := op.Record(ctx, f, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch shipment, %s\n", err)
+			return 1
+		}
+		err = c.render(rec, func(w io.Writer) { describe(w, rec) })
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	records, err := op.Records(ctx, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list shipments, %s\n", err)
+		return 1
+	}
+	err = c.render(records, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tPROVIDER\tSTATUS\tHTTP STATUS\tTENANT\tCREATED\tERROR")
+		for _, rec := range records {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				rec.ID, rec.Provider, rec.Status, rec.HTTPStatus, rec.Tenant, rec.CreatedAt.Format(time.RFC3339), rec.Error)
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// describe writes the fields of the shipment one per line,
+// the payloads are left for the json output.
+func describe(w io.Writer, rec *shipment.Record) {
+	fmt.Fprintf(w, "ID\t%s\n", rec.ID)
+	fmt.Fprintf(w, "GROUP\t%s\n", rec.GroupID)
+	fmt.Fprintf(w, "PROVIDER\t%s\n", rec.Provider)
+	fmt.Fprintf(w, "ENDPOINT\t%s\n", rec.Endpoint)
+	fmt.Fprintf(w, "STATUS\t%s\n", rec.Status)
+	fmt.Fprintf(w, "HTTP STATUS\t%d\n", rec.HTTPStatus)
+	fmt.Fprintf(w, "ERROR\t%s\n", rec.Error)
+	fmt.Fprintf(w, "TENANT\t%s\n", rec.Tenant)
+	fmt.Fprintf(w, "RESEND OF\t%s\n", rec.ResendOf)
+	fmt.Fprintf(w, "REQUEST ID\t%s\n", rec.RequestID)
+	fmt.Fprintf(w, "CREATED\t%s\n", rec.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "UPDATED\t%s\n", rec.UpdatedAt.Format(time.RFC3339))
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func file(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "axiogate.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Default(t *testing.T) {
+	c, err := Load(nil, env(nil))
+	require.NoError(t, err)
+	want := Default()
+	want.Database.URL = LocalDatabaseURL
+	assert.Equal(t, want, *c)
+	assert.True(t, c.Postgres())
+}
+
+func TestLoad_Postgres(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want bool
+	}{
+		{name: "postgres backend", want: true},
+		{name: "memory backend", env: map[string]string{"AXIOGATE_STORAGE_BACKEND": "memory"}},
+		{name: "sqlite backend", env: map[string]string{"AXIOGATE_STORAGE_BACKEND": "sqlite"}},
+		{
+			name: "sqlite backend with a database",
+			env: map[string]string{
+				"AXIOGATE_STORAGE_BACKEND": "sqlite",
+				"AXIOGATE_DATABASE_URL":    "postgres://db:5432/axiogate",
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load(nil, env(tt.env))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, c.Postgres())
+		})
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := file(t, `
+http:
+  addr: ":9000"
+  shutdownTimeout: 30s
+log:
+  level: debug
+rateLimit:
+  rate: 5
+  dailyQuota: 100
+`)
+	c, err := Load(
+		[]string{"-config", path, "-rate-limit", "7"},
+		env(map[string]string{
+			"AXIOGATE_LOG_LEVEL":            "warn",
+			"AXIOGATE_RATE_LIMIT":           "6",
+			"AXIOGATE_CORS_ALLOWED_ORIGINS": "https://a.example, https://b.example",
+		}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, ":9000", c.HTTP.Addr, "from the file")
+	assert.Equal(t, 30*time.Second, c.HTTP.ShutdownTimeout, "from the file")
+	assert.Equal(t, 100, c.RateLimit.DailyQuota, "from the file")
+	assert.Equal(t, "warn", c.Log.Level, "env overrides the file")
+	assert.Equal(t, []string{"https://a.example", "https://b.example"}, c.HTTP.AllowedOrigins)
+	assert.Equal(t, 7, c.RateLimit.Rate, "flags override env")
+	assert.Equal(t, int64(1<<20), c.HTTP.MaxBodySize, "default")
+}
+
+func TestLoad_Boolean(t *testing.T) {
+	c, err := Load([]string{"-health-probe-providers"}, env(nil))
+	require.NoError(t, err)
+	assert.True(t, c.Health.ProbeProviders)
+
+	c, err = Load(nil, env(map[string]string{"AXIOGATE_HEALTH_PROBE_PROVIDERS": "true"}))
+	require.NoError(t, err)
+	assert.True(t, c.Health.ProbeProviders)
+
+	_, err = Load(nil, env(map[string]string{"AXIOGATE_HEALTH_PROBE_PROVIDERS": "maybe"}))
+	assert.Error(t, err)
+}
+
+func TestLoad_ConfigFromEnv(t *testing.T) {
+	path := file(t, "database:\n  url: postgres://db:5432/axiogate\n")
+	c, err := Load(nil, env(map[string]string{"AXIOGATE_CONFIG": path}))
+	require.NoError(t, err)
+	assert.Equal(t, "postgres://db:5432/axiogate", c.Database.URL)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want error
+	}{
+		{
+			name: "bad number in env",
+			env:  map[string]string{"AXIOGATE_RATE_LIMIT": "fast"},
+			want: &ErrInvalidConfig{Reason: `AXIOGATE_RATE_LIMIT, expected a number, strconv.ParseInt: parsing "fast": invalid syntax`},
+		},
+		{
+			name: "bad duration flag",
+			args: []string{"-shutdown-timeout", "soon"},
+			want: &ErrInvalidConfig{Reason: `-shutdown-timeout, time: invalid duration "soon"`},
+		},
+		{
+			name: "unknown log level",
+			args: []string{"-log-level", "loud"},
+			want: &ErrInvalidConfig{Reason: `unknown log level "loud"`},
+		},
+		{
+			name: "not a postgres url",
+			env:  map[string]string{"AXIOGATE_DATABASE_URL": "mysql://localhost"},
+			want: &ErrInvalidConfig{Reason: "database url must be a postgres:// url"},
+		},
+		{
+			name: "daily quota without postgres",
+			env:  map[string]string{"AXIOGATE_STORAGE_BACKEND": "memory", "AXIOGATE_DAILY_QUOTA": "100"},
+			want: &ErrInvalidConfig{Reason: "the daily quota is counted in postgres, it needs a database url"},
+		},
+		{
+			name: "migrate on start without postgres",
+			env:  map[string]string{"AXIOGATE_STORAGE_BACKEND": "sqlite", "AXIOGATE_MIGRATE_ON_START": "true"},
+			want: &ErrInvalidConfig{Reason: "migrate on start needs a database url"},
+		},
+		{
+			name: "unknown storage backend",
+			env:  map[string]string{"AXIOGATE_STORAGE_BACKEND": "mongo"},
+			want: &ErrInvalidConfig{Reason: `unknown storage backend "mongo"`},
+		},
+		{
+			name: "sqlite without a path",
+			file: "storage:\n  backend: sqlite\n  sqlitePath: \"\"\n",
+			want: &ErrInvalidConfig{Reason: "the sqlite backend needs a sqlite path"},
+		},
+		{
+			name: "zero flush interval",
+			args: []string{"-storage-flush-interval", "0s"},
+			want: &ErrInvalidConfig{Reason: "storage flush interval must be positive"},
+		},
+		{
+			name: "negative retention",
+			args: []string{"-retention-scrub-after", "-24h"},
+			want: &ErrInvalidConfig{Reason: "retention ttls can't be negative"},
+		},
+		{
+			name: "retention of the memory backend",
+			env:  map[string]string{"AXIOGATE_STORAGE_BACKEND": "memory", "AXIOGATE_RETENTION_DELETE_AFTER": "720h"},
+			want: &ErrInvalidConfig{Reason: "retention is only applied by the postgres storage backend"},
+		},
+		{
+			name: "encryption of the sqlite backend",
+			env:  map[string]string{"AXIOGATE_STORAGE_BACKEND": "sqlite", "AXIOGATE_ENCRYPTION_KEYS_FILE": "/run/secrets/keys"},
+			want: &ErrInvalidConfig{Reason: "encryption is only applied by the postgres storage backend"},
+		},
+		{
+			name: "current key without keys",
+			args: []string{"-encryption-current-key", "k2"},
+			want: &ErrInvalidConfig{Reason: "encryption current key needs the keys"},
+		},
+		{
+			name: "negative provider retries",
+			env:  map[string]string{"AXIOGATE_PROVIDER_RETRIES": "-1"},
+			want: &ErrInvalidConfig{Reason: "provider retries and backoff can't be negative"},
+		},
+		{
+			name: "zero dead letter interval",
+			args: []string{"-provider-dead-letter-interval", "0s"},
+			want: &ErrInvalidConfig{Reason: "provider dead letter interval must be positive"},
+		},
+		{
+			name: "negative rate",
+			args: []string{"-rate-limit", "-1"},
+			want: &ErrInvalidConfig{Reason: "rate limits can't be negative"},
+		},
+		{
+			name: "issuer without jwks",
+			env:  map[string]string{"AXIOGATE_JWT_ISSUER": "idp"},
+			want: &ErrInvalidConfig{Reason: "jwt issuer and audience need a jwks file"},
+		},
+		{
+			name: "zero shutdown timeout",
+			file: "http:\n  shutdownTimeout: 0s\n",
+			want: &ErrInvalidConfig{Reason: "http shutdown timeout must be positive"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", file(t, tt.file)}, args...)
+			}
+			_, err := Load(args, env(tt.env))
+			assert.Equal(t, tt.want, err)
+		})
+	}
+}
+
+func TestLoad_UnknownKey(t *testing.T) {
+	_, err := Load([]string{"-config", file(t, "http:\n  port: 8080\n")}, env(nil))
+	var invalid *ErrInvalidConfig
+	assert.ErrorAs(t, err, &invalid)
+}
@@ -0,0 +1,185 @@
+// Package deadletter keeps the provider calls that failed for good, once
+// the retries are exhausted, until an operator requeues or discards them.
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// ErrNotFound is returned when a dead letter does not exist.
+var ErrNotFound = errors.New("dead letter not found")
+
+// Letter is a provider call that failed for good.
+type Letter struct {
+	ID int64 `json:"id"`
+	// Shipment is the id of the stored shipment that failed.
+	Shipment   string    `json:"shipment"`
+	Provider   string    `json:"provider"`
+	Reason     string    `json:"reason"`
+	HTTPStatus int       `json:"httpStatus,omitempty"`
+	Tenant     string    `json:"tenant,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Inspected is a dead letter with the shipment that failed.
+type Inspected struct {
+	Letter
+	Record *shipment.Record `json:"record"`
+}
+
+// Store persists the dead letters.
+type Store interface {
+	DeadLetters(ctx context.Context, f Filter) ([]Letter, error)
+	DeadLetter(ctx context.Context, id int64) (*Letter, error)
+	DeleteDeadLetter(ctx context.Context, id int64) error
+	// RestoreDeadLetter stores back a deleted dead letter, with the same id.
+	RestoreDeadLetter(ctx context.Context, letter *Letter) error
+	CountDeadLetters(ctx context.Context) (int, error)
+}
+
+// Filter selects the dead letters listed.
+type Filter struct {
+	// Provider returns only the dead letters of the provider.
+	Provider string
+	// Before returns only the dead letters older than the one with the given id, for paging.
+	Before int64
+	// Limit bounds the number of dead letters returned.
+	Limit int
+}
+
+// RecordReader reads the stored shipments.
+type RecordReader interface {
+	Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error)
+}
+
+// Resender sends a stored shipment again.
+type Resender interface {
+	Resend(ctx context.Context, rec *shipment.Record, providers []string) ([]api.ShippingResponse, error)
+}
+
+// Observer is told about the size of the backlog.
+type Observer interface {
+	// DeadLetterBacklog is called with the number of dead letters waiting for an operator.
+	DeadLetterBacklog(n int)
+}
+
+type noopObserver struct{}
+
+func (noopObserver) DeadLetterBacklog(int) {}
+
+// Queue manages the dead letters.
+type Queue struct {
+	store    Store
+	records  RecordReader
+	resender Resender
+	observer Observer
+	log      *slog.Logger
+}
+
+type Option func(q *Queue)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(q *Queue) {
+		q.log = log.WithGroup("deadletter")
+	}
+}
+
+// WithObserver sets who is told about the size of the backlog.
+func WithObserver(o Observer) Option {
+	return func(q *Queue) {
+		q.observer = o
+	}
+}
+
+// New creates a new dead letter queue, the dead letters are requeued
+// by resending their shipments with the resender.
+func New(store Store, records RecordReader, resender Resender, options ...Option) *Queue {
+	q := &Queue{
+		store:    store,
+		records:  records,
+		resender: resender,
+		observer: noopObserver{},
+		log:      log.Noop(),
+	}
+	for _, option := range options {
+		option(q)
+	}
+	return q
+}
+
+// DeadLetters returns the dead letters selected by the filter, newest first.
+func (q *Queue) DeadLetters(ctx context.Context, f Filter) ([]Letter, error) {
+	return q.store.DeadLetters(ctx, f)
+}
+
+// Inspect returns the dead letter with the shipment that failed,
+// the record is missing if the retention deleted the shipment.
+func (q *Queue) Inspect(ctx context.Context, id int64) (*Inspected, error) {
+	letter, err := q.store.DeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := q.records.Record(ctx, shipment.Filter{AllTenants: true}, letter.Shipment)
+	if err != nil && !errors.Is(err, shipment.ErrNotFound) {
+		return nil, err
+	}
+	return &Inspected{Letter: *letter, Record: rec}, nil
+}
+
+// Requeue resends the shipment of the dead letter to its provider and
+// discards the letter, if the provider fails again a new letter is kept.
+// The letter is taken off the queue before the shipment is resent so
+// concurrent requeues can't book it twice, it's put back if the shipment
+// can't be resent.
+func (q *Queue) Requeue(ctx context.Context, letter *Letter) ([]api.ShippingResponse, error) {
+	rec, err := q.records.Record(ctx, shipment.Filter{AllTenants: true}, letter.Shipment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the dead-lettered shipment, %w", err)
+	}
+	if err := q.store.DeleteDeadLetter(ctx, letter.ID); err != nil {
+		return nil, err
+	}
+	l := q.log.With(slog.Int64("id", letter.ID), slog.String("shipment", letter.Shipment))
+	responses, err := q.resender.Resend(ctx, rec, []string{letter.Provider})
+	if err != nil {
+		if err := q.store.RestoreDeadLetter(context.WithoutCancel(ctx), letter); err != nil {
+			l.With(log.Error(err)).ErrorContext(ctx, "Failed to restore the dead letter")
+		}
+		return nil, err
+	}
+	l.InfoContext(ctx, "Dead letter requeued")
+	return responses, nil
+}
+
+// Discard drops the dead letter, the shipment is left failed.
+func (q *Queue) Discard(ctx context.Context, id int64) error {
+	return q.store.DeleteDeadLetter(ctx, id)
+}
+
+// Watch reports the size of the backlog right away and then
+// every interval, until the context is done.
+func (q *Queue) Watch(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		n, err := q.store.CountDeadLetters(ctx)
+		if err != nil {
+			q.log.With(log.Error(err)).ErrorContext(ctx, "Failed to count the dead letters")
+		} else {
+			q.observer.DeadLetterBacklog(n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type store struct {
+	mu      sync.Mutex
+	letters []Letter
+}
+
+func (s *store) DeadLetters(_ context.Context, f Filter) ([]Letter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.letters), nil
+}
+
+func (s *store) DeadLetter(_ context.Context, id int64) (*Letter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.letters {
+		if l.ID == id {
+			return &l, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *store) DeleteDeadLetter(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.letters)
+	s.letters = slices.DeleteFunc(s.letters, func(l Letter) bool { return l.ID == id })
+	if n == len(s.letters) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *store) RestoreDeadLetter(_ context.Context, l *Letter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters = append(s.letters, *l)
+	return nil
+}
+
+func (s *store) CountDeadLetters(context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.letters), nil
+}
+
+type records map[string]*shipment.Record
+
+func (r records) Record(_ context.Context, _ shipment.Filter, id string) (*shipment.Record, error) {
+	rec, ok := r[id]
+	if !ok {
+		return nil, shipment.ErrNotFound
+	}
+	return rec, nil
+}
+
+type mockResender struct{ mock.Mock }
+
+func (m *mockResender) Resend(ctx context.Context, rec *shipment.Record, providers []string) ([]api.ShippingResponse, error) {
+	args := m.Called(ctx, rec, providers)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]api.ShippingResponse), args.Error(1)
+}
+
+const shipmentID = "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10"
+
+func letter() Letter {
+	return Letter{ID: 1, Shipment: shipmentID, Provider: "b", Reason: "connection refused"}
+}
+
+func TestQueue_Requeue(t *testing.T) {
+	rec := &shipment.Record{ID: shipmentID, Provider: "b", Status: shipment.StatusFailed}
+	tests := []struct {
+		name        string
+		letters     []Letter
+		resendErr   error
+		wantErr     error
+		wantLetters int
+	}{
+		{name: "resent and discarded", letters: []Letter{letter()}},
+		{
+			name:        "put back if it can't be resent",
+			letters:     []Letter{letter()},
+			resendErr:   &shipment.ErrNotResendable{ID: shipmentID},
+			wantErr:     &shipment.ErrNotResendable{ID: shipmentID},
+			wantLetters: 1,
+		},
+		{name: "requeued by someone else", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &store{letters: tt.letters}
+			resender := new(mockResender)
+			if tt.resendErr != nil {
+				resender.On("Resend", mock.Anything, rec, []string{"b"}).Return(nil, tt.resendErr)
+			} else {
+				resender.On("Resend", mock.Anything, rec, []string{"b"}).
+					Return([]api.ShippingResponse{{Endpoint: "http://b"}}, nil).Maybe()
+			}
+			q := New(st, records{shipmentID: rec}, resender)
+
+			l := letter()
+			_, err := q.Requeue(context.Background(), &l)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Len(t, st.letters, tt.wantLetters)
+			if errors.Is(tt.wantErr, ErrNotFound) {
+				resender.AssertNotCalled(t, "Resend", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestQueue_Inspect(t *testing.T) {
+	rec := &shipment.Record{ID: shipmentID, Provider: "b", Status: shipment.StatusFailed}
+	q := New(&store{letters: []Letter{letter()}}, records{shipmentID: rec}, new(mockResender))
+
+	got, err := q.Inspect(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, letter(), got.Letter)
+	assert.Equal(t, rec, got.Record)
+
+	q = New(&store{letters: []Letter{letter()}}, records{}, new(mockResender))
+	got, err = q.Inspect(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Nil(t, got.Record, "the shipment was deleted by the retention")
+
+	_, err = q.Inspect(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+type backlog chan int
+
+func (b backlog) DeadLetterBacklog(n int) { b <- n }
+
+func TestQueue_Watch(t *testing.T) {
+	st := &store{letters: []Letter{letter()}}
+	b := make(backlog, 1)
+	q := New(st, records{}, new(mockResender), WithObserver(b))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Watch(ctx, time.Millisecond)
+
+	assert.Equal(t, 1, <-b, "reported right away")
+	require.NoError(t, st.RestoreDeadLetter(ctx, &Letter{ID: 2}))
+	assert.Eventually(t, func() bool { return <-b == 2 }, time.Second, time.Millisecond)
+}
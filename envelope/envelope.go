@@ -0,0 +1,200 @@
+// Package envelope encrypts the payloads with AES-GCM, each one under its
+// own data key which is stored next to it, wrapped by a key encryption key.
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of the key encryption keys and of the data keys, AES-256.
+const KeySize = 32
+
+// ErrUnknownKey is returned when a payload was encrypted with a key the keyring doesn't hold.
+type ErrUnknownKey struct {
+	ID string
+}
+
+var _ error = (*ErrUnknownKey)(nil)
+
+func (e *ErrUnknownKey) Error() string {
+	return fmt.Sprintf("unknown key encryption key %q", e.ID)
+}
+
+// Envelope is an encrypted payload, as stored.
+type Envelope struct {
+	// KeyID is the key encryption key the data key is wrapped with.
+	KeyID string `json:"kid"`
+	// Key is the wrapped data key, prefixed by its nonce.
+	Key []byte `json:"key"`
+	// Data is the encrypted payload, prefixed by its nonce.
+	Data []byte `json:"data"`
+	// Version is 1 when the data is authenticated with the additional data
+	// it was sealed with, 0 for the envelopes sealed before that.
+	Version int `json:"v,omitempty"`
+}
+
+// version is the version of the envelopes sealed.
+const version = 1
+
+// Keyring holds the key encryption keys by id, the current one encrypts
+// and all of them decrypt, so the payloads can be moved to a new key.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// New returns the keyring of the keys, current is the id of the one encrypting.
+func New(current string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use key %q, %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	if _, ok := k.keys[current]; !ok {
+		return nil, &ErrUnknownKey{ID: current}
+	}
+	return k, nil
+}
+
+// Parse returns the keyring of the keys written as id:base64, current is
+// the id of the one encrypting, empty means the first key.
+func Parse(entries []string, current string) (*Keyring, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("the keyring holds no keys")
+	}
+	keys := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, errors.New("keys must be written as id:base64")
+		}
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("key %q is repeated", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q, %w", id, err)
+		}
+		keys[id] = key
+		if current == "" {
+			current = id
+		}
+	}
+	return New(current, keys)
+}
+
+// ReadFile returns the keys of the file, one id:base64 per line. The
+// empty lines and the ones starting with # are skipped.
+func ReadFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the keys, %w", err)
+	}
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the key must be %d bytes, not %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyID returns the id of the key encrypting.
+func (k *Keyring) KeyID() string {
+	return k.current
+}
+
+// Seal encrypts the payload under a new data key and returns its envelope as
+// json. The additional data, where the payload is stored, is authenticated
+// and not stored, the envelope only opens with the same additional data.
+func (k *Keyring) Seal(plaintext, additional []byte) ([]byte, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate a data key, %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	e := Envelope{
+		KeyID: k.current,
+		// The key id is authenticated with the data key, so an envelope
+		// can't be made to claim another key encryption key.
+		Key:     seal(k.keys[k.current], dataKey, []byte(k.current)),
+		Data:    seal(data, plaintext, additional),
+		Version: version,
+	}
+	return json.Marshal(e)
+}
+
+// Open decrypts the envelope returned by Seal with the same additional data.
+func (k *Keyring) Open(sealed, additional []byte) ([]byte, error) {
+	var e Envelope
+	if err := json.Unmarshal(sealed, &e); err != nil {
+		return nil, fmt.Errorf("failed to decode the envelope, %w", err)
+	}
+	kek, ok := k.keys[e.KeyID]
+	if !ok {
+		return nil, &ErrUnknownKey{ID: e.KeyID}
+	}
+	dataKey, err := open(kek, e.Key, []byte(e.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key, %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if e.Version == 0 {
+		// Sealed before the additional data was authenticated, they are
+		// bound to it once moved to a new key.
+		additional = nil
+	}
+	plaintext, err := open(data, e.Data, additional)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the payload, %w", err)
+	}
+	return plaintext, nil
+}
+
+func seal(aead cipher.AEAD, plaintext, additional []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	// rand.Read never fails, it crashes the program if it can't read.
+	_, _ = rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, additional)
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
@@ -0,0 +1,116 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KeySize))
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	old, err := Parse([]string{"k1:" + key(1)}, "")
+	require.NoError(t, err)
+	sealed, err := old.Seal([]byte(`{"name":"Jane"}`), []byte("shipment.request/1"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "Jane")
+
+	rotated, err := Parse([]string{"k2:" + key(2), "k1:" + key(1)}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "k2", rotated.KeyID())
+	plaintext, err := rotated.Open(sealed, []byte("shipment.request/1"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"Jane"}`, string(plaintext))
+
+	resealed, err := rotated.Seal(plaintext, []byte("shipment.request/1"))
+	require.NoError(t, err)
+	var e Envelope
+	require.NoError(t, json.Unmarshal(resealed, &e))
+	assert.Equal(t, "k2", e.KeyID)
+
+	_, err = old.Open(resealed, []byte("shipment.request/1"))
+	assert.Equal(t, &ErrUnknownKey{ID: "k2"}, err)
+}
+
+func TestKeyring_Tampered(t *testing.T) {
+	k, err := Parse([]string{"k1:" + key(1), "k2:" + key(2)}, "k1")
+	require.NoError(t, err)
+	sealed, err := k.Seal([]byte("payload"), []byte("shipment.request/1"))
+	require.NoError(t, err)
+
+	_, err = k.Open(sealed, []byte("shipment.response/1"))
+	assert.Error(t, err, "the payload is bound to its column")
+	_, err = k.Open(sealed, []byte("shipment.request/2"))
+	assert.Error(t, err, "the payload is bound to its row")
+
+	var e Envelope
+	require.NoError(t, json.Unmarshal(sealed, &e))
+	e.Data[len(e.Data)-1] ^= 1
+	b, _ := json.Marshal(e)
+	_, err = k.Open(b, []byte("shipment.request/1"))
+	assert.Error(t, err, "the payload is authenticated")
+
+	require.NoError(t, json.Unmarshal(sealed, &e))
+	e.KeyID = "k2"
+	b, _ = json.Marshal(e)
+	_, err = k.Open(b, []byte("shipment.request/1"))
+	assert.Error(t, err, "the data key is bound to its key encryption key")
+
+	require.NoError(t, json.Unmarshal(sealed, &e))
+	e.Version = 0
+	b, _ = json.Marshal(e)
+	_, err = k.Open(b, nil)
+	assert.Error(t, err, "the envelope can't claim it was sealed without additional data")
+}
+
+func TestKeyring_Unversioned(t *testing.T) {
+	k, err := Parse([]string{"k1:" + key(1)}, "")
+	require.NoError(t, err)
+	sealed, err := k.Seal([]byte("payload"), nil)
+	require.NoError(t, err)
+
+	var e Envelope
+	require.NoError(t, json.Unmarshal(sealed, &e))
+	e.Version = 0
+	b, _ := json.Marshal(e)
+	plaintext, err := k.Open(b, []byte("shipment.request/1"))
+	require.NoError(t, err, "the envelopes sealed before the additional data still open")
+	assert.Equal(t, "payload", string(plaintext))
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		current string
+	}{
+		{name: "no keys"},
+		{name: "no id", entries: []string{key(1)}},
+		{name: "not base64", entries: []string{"k1:???"}},
+		{name: "short key", entries: []string{"k1:" + base64.StdEncoding.EncodeToString([]byte("short"))}},
+		{name: "repeated", entries: []string{"k1:" + key(1), "k1:" + key(2)}},
+		{name: "unknown current", entries: []string{"k1:" + key(1)}, current: "k2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.entries, tt.current)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(path, []byte("# rotated on 2026-10-18\nk2:"+key(2)+"\n\nk1:"+key(1)+"\n"), 0o600))
+	entries, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"k2:" + key(2), "k1:" + key(1)}, entries)
+}
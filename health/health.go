@@ -0,0 +1,185 @@
+// Package health reports if the service is alive and ready to handle requests.
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// Checker checks a dependency the service needs.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is a function used as a Checker.
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Statuses of the service and of each dependency.
+const (
+	StatusOK          = "ok"
+	StatusFailing     = "failing"
+	StatusUnavailable = "unavailable"
+)
+
+// Result is the outcome of a dependency check.
+type Result struct {
+	Status string `json:"status"`
+	// Optional results are reported without affecting the readiness.
+	Optional bool   `json:"optional,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the readiness of the service with the result of every check.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+type check struct {
+	name     string
+	checker  Checker
+	optional bool
+}
+
+// Health serves the liveness and the readiness of the service.
+type Health struct {
+	checks  []check
+	timeout time.Duration
+	log     *slog.Logger
+
+	shutdown atomic.Bool
+}
+
+type Option func(h *Health)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(h *Health) {
+		h.log = log.WithGroup("health")
+	}
+}
+
+// WithCheck adds a dependency the service can't be ready without.
+func WithCheck(name string, checker Checker) Option {
+	return func(h *Health) {
+		h.checks = append(h.checks, check{name: name, checker: checker})
+	}
+}
+
+// WithOptionalCheck adds a dependency that is reported but doesn't affect the readiness.
+func WithOptionalCheck(name string, checker Checker) Option {
+	return func(h *Health) {
+		h.checks = append(h.checks, check{name: name, checker: checker, optional: true})
+	}
+}
+
+// WithTimeout bounds how long each check can take.
+func WithTimeout(timeout time.Duration) Option {
+	return func(h *Health) {
+		h.timeout = timeout
+	}
+}
+
+// New returns a health with the checks run on every readiness probe.
+func New(options ...Option) *Health {
+	h := &Health{
+		timeout: 2 * time.Second,
+		log:     log.Noop(),
+	}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+// Shutdown marks the service as not ready, so no new traffic is sent
+// its way while the in flight requests are drained.
+func (h *Health) Shutdown() {
+	h.shutdown.Store(true)
+}
+
+// Ready runs all checks at once and reports if the service is ready.
+func (h *Health) Ready(ctx context.Context) Report {
+	if h.shutdown.Load() {
+		return Report{Status: StatusUnavailable}
+	}
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		report = Report{Status: StatusOK, Checks: make(map[string]Result, len(h.checks))}
+	)
+	for _, c := range h.checks {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+			start := time.Now()
+			err := c.checker.Check(ctx)
+			result := Result{
+				Status:   StatusOK,
+				Optional: c.optional,
+				Duration: time.Since(start).Round(time.Millisecond).String(),
+			}
+			if err != nil {
+				result.Status, result.Error = StatusFailing, err.Error()
+				h.log.With(log.Error(err), slog.String("check", c.name)).WarnContext(ctx, "Check failed")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if err != nil && !c.optional {
+				report.Status = StatusUnavailable
+			}
+		})
+	}
+	wg.Wait()
+	return report
+}
+
+// Live reports the process is up and serving requests.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	response.New(w).OK(&Report{Status: StatusOK})
+}
+
+// Readiness reports if the service is ready, with a 503 if it isn't.
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := h.Ready(r.Context())
+	if report.Status != StatusOK {
+		response.New(w).ServiceUnavailable(&report)
+		return
+	}
+	response.New(w).OK(&report)
+}
+
+// Reachable checks the url answers, any http response counts, only
+// the requests that can't get one fail.
+func Reachable(client *http.Client, url string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request, %w", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to reach %s, %w", url, err)
+		}
+		return resp.Body.Close()
+	})
+}
+
+// Append appends the health routes into the router.
+func (h *Health) Append(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", h.Live)
+	mux.HandleFunc("GET /readyz", h.Readiness)
+}
@@ -0,0 +1,121 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	ok      = CheckerFunc(func(context.Context) error { return nil })
+	failing = CheckerFunc(func(context.Context) error { return errors.New("boom") })
+	slow    = CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+)
+
+func TestHealth_Readiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []Option
+		shutdown   bool
+		statusCode int
+		want       Report
+	}{
+		{
+			name:       "all checks pass",
+			options:    []Option{WithCheck("postgres", ok), WithCheck("migrations", ok)},
+			statusCode: http.StatusOK,
+			want: Report{Status: StatusOK, Checks: map[string]Result{
+				"postgres":   {Status: StatusOK},
+				"migrations": {Status: StatusOK},
+			}},
+		},
+		{
+			name:       "required check fails",
+			options:    []Option{WithCheck("postgres", ok), WithCheck("migrations", failing)},
+			statusCode: http.StatusServiceUnavailable,
+			want: Report{Status: StatusUnavailable, Checks: map[string]Result{
+				"postgres":   {Status: StatusOK},
+				"migrations": {Status: StatusFailing, Error: "boom"},
+			}},
+		},
+		{
+			name:       "optional check fails",
+			options:    []Option{WithCheck("postgres", ok), WithOptionalCheck("provider:a", failing)},
+			statusCode: http.StatusOK,
+			want: Report{Status: StatusOK, Checks: map[string]Result{
+				"postgres":   {Status: StatusOK},
+				"provider:a": {Status: StatusFailing, Optional: true, Error: "boom"},
+			}},
+		},
+		{
+			name:       "check times out",
+			options:    []Option{WithCheck("postgres", slow), WithTimeout(10 * time.Millisecond)},
+			statusCode: http.StatusServiceUnavailable,
+			want: Report{Status: StatusUnavailable, Checks: map[string]Result{
+				"postgres": {Status: StatusFailing, Error: context.DeadlineExceeded.Error()},
+			}},
+		},
+		{
+			name:       "shutting down",
+			options:    []Option{WithCheck("postgres", ok)},
+			shutdown:   true,
+			statusCode: http.StatusServiceUnavailable,
+			want:       Report{Status: StatusUnavailable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(tt.options...)
+			if tt.shutdown {
+				h.Shutdown()
+			}
+			mux := http.NewServeMux()
+			h.Append(mux)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			var got Report
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+			for name, result := range got.Checks {
+				assert.NotEmpty(t, result.Duration)
+				result.Duration = ""
+				got.Checks[name] = result
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHealth_Live(t *testing.T) {
+	h := New(WithCheck("postgres", failing))
+	h.Shutdown()
+	mux := http.NewServeMux()
+	h.Append(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "liveness doesn't depend on the checks")
+}
+
+func TestReachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	url := srv.URL
+	assert.NoError(t, Reachable(srv.Client(), url).Check(context.Background()))
+
+	srv.Close()
+	assert.Error(t, Reachable(http.DefaultClient, url).Check(context.Background()))
+}
@@ -1,6 +1,9 @@
 package api
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+)
 
 type ShippingRequest struct {
 	Weight        Weight        `json:"weight"`
@@ -21,6 +24,19 @@ type Weight struct {
 	Unit  string  `json:"unit"` // "Grams", "KG"
 }
 
+// KG returns the weight value converted in kilograms.
+// Unknown units are assumed to be kilograms.
+func (w Weight) KG() float64 {
+	switch strings.ToLower(w.Unit) {
+	case "grams", "gram", "g":
+		return w.Value / 1000
+	case "lb", "lbs", "pounds":
+		return w.Value * 0.45359237
+	default:
+		return w.Value
+	}
+}
+
 type Party struct {
 	Contact   Contact `json:"contact"`
 	Address   Address `json:"address"`
@@ -81,3 +97,40 @@ type ShippingResponse struct {
 	RawResponse json.RawMessage `json:"rawReponse"`
 	Error       string          `json:"error"`
 }
+
+// Provider is a provider the caller may ship with.
+type Provider struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	// Quotes reports if the provider prices the shipments before booking.
+	Quotes bool `json:"quotes"`
+}
+
+type Providers struct {
+	Providers []Provider `json:"providers"`
+}
+
+// Resent is the outcome of resending a stored shipment.
+type Resent struct {
+	Shipment  string             `json:"shipment"`
+	Responses []ShippingResponse `json:"responses"`
+	Error     string             `json:"error,omitempty"`
+}
+
+type ResentShipments struct {
+	Resent []Resent `json:"resent"`
+}
+
+type Quotes struct {
+	Quotes []Quote `json:"quotes"`
+}
+
+// Quote is the normalized price a provider asks for a shipment.
+type Quote struct {
+	Provider     string  `json:"provider"`
+	Price        float64 `json:"price"`
+	Currency     string  `json:"currency"`
+	TransitDays  int     `json:"transitDays"`
+	ServiceLevel string  `json:"serviceLevel"`
+	Error        string  `json:"error,omitempty"`
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/hoenirvili/axiogate/deadletter"
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// DeadLetterQueue defines how the dead letters are managed.
+type DeadLetterQueue interface {
+	DeadLetters(ctx context.Context, f deadletter.Filter) ([]deadletter.Letter, error)
+	Inspect(ctx context.Context, id int64) (*deadletter.Inspected, error)
+	Requeue(ctx context.Context, letter *deadletter.Letter) ([]api.ShippingResponse, error)
+	Discard(ctx context.Context, id int64) error
+}
+
+type DeadLetter struct {
+	queue      DeadLetterQueue
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type DeadLetterOption func(d *DeadLetter)
+
+func WithDeadLetterLogger(log *slog.Logger) DeadLetterOption {
+	return func(d *DeadLetter) {
+		d.log = log.WithGroup("deadletter")
+	}
+}
+
+// WithDeadLetterMiddleware wraps the dead letter routes with the middleware.
+func WithDeadLetterMiddleware(mw ...middleware.Middleware) DeadLetterOption {
+	return func(d *DeadLetter) {
+		d.middleware = append(d.middleware, mw...)
+	}
+}
+
+// NewDeadLetter creates a new handler to manage the dead letters.
+func NewDeadLetter(queue DeadLetterQueue, options ...DeadLetterOption) *DeadLetter {
+	d := &DeadLetter{
+		queue: queue,
+		log:   log.Noop(),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+func (h *DeadLetter) fail(ctx context.Context, response response.Response, err error, message string) {
+	var notResendable *shipment.ErrNotResendable
+	switch {
+	case errors.Is(err, deadletter.ErrNotFound):
+		response.NotFound(err.Error())
+	case errors.Is(err, shipment.ErrNotFound):
+		response.Conflict("the dead-lettered shipment is not stored anymore")
+	case errors.As(err, &notResendable):
+		response.Conflict(err.Error())
+	case forbidden(err):
+		response.Forbidden(err.Error())
+	case badRequest(err):
+		response.BadRequest(err.Error())
+	default:
+		h.log.With(log.Error(err)).ErrorContext(ctx, "Dead letter operation failed")
+		response.InternalServer(message)
+	}
+}
+
+// DeadLetters returns the dead letters newest first, optionally of the
+// ?provider=. They are paged with ?limit= and ?before=, the id of the
+// last dead letter of the previous page.
+func (h *DeadLetter) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	query := r.URL.Query()
+	f := deadletter.Filter{Provider: query.Get("provider"), Limit: defaultLimit}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			response.BadRequestf("invalid limit %s, expected between 1 and %d", value, maxLimit)
+			return
+		}
+		f.Limit = limit
+	}
+	if value := query.Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before <= 0 {
+			response.BadRequestf("invalid before %s", value)
+			return
+		}
+		f.Before = before
+	}
+	letters, err := h.queue.DeadLetters(r.Context(), f)
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to list dead letters")
+		return
+	}
+	response.OK(letters)
+}
+
+// Inspect returns the dead letter with the shipment that failed.
+func (h *DeadLetter) Inspect(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	inspected, err := h.queue.Inspect(r.Context(), id)
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to fetch dead letter")
+		return
+	}
+	response.OK(inspected)
+}
+
+// Requeue resends the shipment of the dead letter to its provider,
+// with the accounts of the tenant it belongs to.
+func (h *DeadLetter) Requeue(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	inspected, err := h.queue.Inspect(r.Context(), id)
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to fetch dead letter")
+		return
+	}
+	resp, err := h.queue.Requeue(onBehalf(r.Context(), inspected.Tenant), &inspected.Letter)
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to requeue dead letter")
+		return
+	}
+	response.Created(&api.ShippingResponses{Responses: resp})
+}
+
+// Discard drops the dead letter, its shipment is left failed.
+func (h *DeadLetter) Discard(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	if err := h.queue.Discard(r.Context(), id); err != nil {
+		h.fail(r.Context(), response, err, "failed to discard dead letter")
+		return
+	}
+	response.NoContent()
+}
+
+// Append appends all dead letter admin routes into the router.
+func (h *DeadLetter) Append(mux *http.ServeMux) {
+	handle(mux, "GET /api/v1/admin/deadletters", h.DeadLetters, h.middleware)
+	handle(mux, "GET /api/v1/admin/deadletters/{id}", h.Inspect, h.middleware)
+	handle(mux, "POST /api/v1/admin/deadletters/{id}/requeue", h.Requeue, h.middleware)
+	handle(mux, "DELETE /api/v1/admin/deadletters/{id}", h.Discard, h.middleware)
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/deadletter"
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+type mockDeadLetterQueue struct{ mock.Mock }
+
+func (m *mockDeadLetterQueue) DeadLetters(ctx context.Context, f deadletter.Filter) ([]deadletter.Letter, error) {
+	args := m.Called(ctx, f)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]deadletter.Letter), args.Error(1)
+}
+
+func (m *mockDeadLetterQueue) Inspect(ctx context.Context, id int64) (*deadletter.Inspected, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*deadletter.Inspected), args.Error(1)
+}
+
+func (m *mockDeadLetterQueue) Requeue(ctx context.Context, letter *deadletter.Letter) ([]api.ShippingResponse, error) {
+	args := m.Called(ctx, letter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]api.ShippingResponse), args.Error(1)
+}
+
+func (m *mockDeadLetterQueue) Discard(ctx context.Context, id int64) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func TestDeadLetter(t *testing.T) {
+	letter := deadletter.Letter{ID: 7, Shipment: "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10", Provider: "b", Tenant: "acme"}
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		setup    func(q *mockDeadLetterQueue)
+		wantCode int
+	}{
+		{
+			name:   "list",
+			method: http.MethodGet,
+			target: "/api/v1/admin/deadletters?provider=b&before=9&limit=5",
+			setup: func(q *mockDeadLetterQueue) {
+				q.On("DeadLetters", mock.Anything, deadletter.Filter{Provider: "b", Before: 9, Limit: 5}).
+					Return([]deadletter.Letter{letter}, nil)
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "invalid limit",
+			method:   http.MethodGet,
+			target:   "/api/v1/admin/deadletters?limit=0",
+			setup:    func(*mockDeadLetterQueue) {},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "inspect not found",
+			method: http.MethodGet,
+			target: "/api/v1/admin/deadletters/8",
+			setup: func(q *mockDeadLetterQueue) {
+				q.On("Inspect", mock.Anything, int64(8)).Return(nil, deadletter.ErrNotFound)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:   "requeue on behalf of the tenant",
+			method: http.MethodPost,
+			target: "/api/v1/admin/deadletters/7/requeue",
+			setup: func(q *mockDeadLetterQueue) {
+				q.On("Inspect", mock.Anything, int64(7)).Return(&deadletter.Inspected{Letter: letter}, nil)
+				onBehalf := mock.MatchedBy(func(ctx context.Context) bool { return tenant.ID(ctx) == "acme" })
+				q.On("Requeue", onBehalf, &letter).Return([]api.ShippingResponse{{Endpoint: "http://b"}}, nil)
+			},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:   "requeue a purged shipment",
+			method: http.MethodPost,
+			target: "/api/v1/admin/deadletters/7/requeue",
+			setup: func(q *mockDeadLetterQueue) {
+				q.On("Inspect", mock.Anything, int64(7)).Return(&deadletter.Inspected{Letter: letter}, nil)
+				q.On("Requeue", mock.Anything, &letter).Return(nil, shipment.ErrNotFound)
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:   "discard",
+			method: http.MethodDelete,
+			target: "/api/v1/admin/deadletters/7",
+			setup: func(q *mockDeadLetterQueue) {
+				q.On("Discard", mock.Anything, int64(7)).Return(nil)
+			},
+			wantCode: http.StatusNoContent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := new(mockDeadLetterQueue)
+			tt.setup(q)
+			mux := http.NewServeMux()
+			NewDeadLetter(q).Append(mux)
+
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req = req.WithContext(auth.With(req.Context(), &auth.Principal{Subject: "admin", Admin: true}))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			q.AssertExpectations(t)
+		})
+	}
+}
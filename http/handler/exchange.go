@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// ExchangeReader defines how the audited provider exchanges are read.
+type ExchangeReader interface {
+	Exchanges(ctx context.Context, shipmentID string) ([]request.Exchange, error)
+}
+
+type Exchanges struct {
+	records    RecordReader
+	exchanges  ExchangeReader
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type ExchangesOption func(e *Exchanges)
+
+func WithExchangesLogger(log *slog.Logger) ExchangesOption {
+	return func(e *Exchanges) {
+		e.log = log.WithGroup("exchanges")
+	}
+}
+
+// WithExchangesMiddleware wraps the exchanges routes with the middleware.
+func WithExchangesMiddleware(mw ...middleware.Middleware) ExchangesOption {
+	return func(e *Exchanges) {
+		e.middleware = append(e.middleware, mw...)
+	}
+}
+
+// NewExchanges creates a new handler to export the audited exchanges of
+// the shipments, the records scope the shipments to the caller.
+func NewExchanges(records RecordReader, exchanges ExchangeReader, options ...ExchangesOption) *Exchanges {
+	e := &Exchanges{
+		records:   records,
+		exchanges: exchanges,
+		log:       log.Noop(),
+	}
+	for _, option := range options {
+		option(e)
+	}
+	return e
+}
+
+// exported is an exchange in the manifest of the export, with the
+// files its bodies are written to and if they were redacted.
+type exported struct {
+	request.Exchange
+	RequestFile      string `json:"requestFile,omitempty"`
+	RequestRedacted  bool   `json:"requestRedacted,omitempty"`
+	ResponseFile     string `json:"responseFile,omitempty"`
+	ResponseRedacted bool   `json:"responseRedacted,omitempty"`
+}
+
+// Export returns a zip with the shipment, the manifest of its exchanges
+// with the providers and their bodies. The bodies are audited byte for
+// byte and verified against their digests before they are exported, the
+// json ones holding credentials are exported with them redacted and
+// flagged as such, they don't match their digests anymore.
+func (h *Exchanges) Export(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	id := r.PathValue("id")
+	if err := uuid.Validate(id); err != nil {
+		response.BadRequestf("invalid id %s", id)
+		return
+	}
+	rec, err := h.records.Record(r.Context(), filter(r), id)
+	if errors.Is(err, shipment.ErrNotFound) {
+		response.NotFound(err.Error())
+		return
+	}
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to fetch shipment")
+		response.InternalServer("failed to fetch shipment")
+		return
+	}
+	exchanges, err := h.exchanges.Exchanges(r.Context(), id)
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to fetch exchanges")
+		response.InternalServer("failed to fetch exchanges")
+		return
+	}
+	// An export is the proof of what was exchanged, the bodies that are
+	// not the ones audited are not exported as if they were.
+	for _, e := range exchanges {
+		if err := e.Verify(); err != nil {
+			h.log.With(log.Error(err), slog.String("shipment", id)).ErrorContext(r.Context(), "Audited exchange failed verification")
+			response.InternalServer("audited exchanges failed verification")
+			return
+		}
+	}
+	b, err := archive(rec, exchanges)
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to archive exchanges")
+		response.InternalServer("failed to archive exchanges")
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="shipment-%s.zip"`, id))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// archive returns the zip of the shipment and its exchanges.
+func archive(rec *shipment.Record, exchanges []request.Exchange) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, content []byte) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(content)
+		return err
+	}
+	manifest := make([]exported, 0, len(exchanges))
+	for i, e := range exchanges {
+		ex := exported{Exchange: e}
+		if e.Request != nil {
+			body := request.RedactBody(e.Request)
+			ex.RequestFile, ex.RequestRedacted = fmt.Sprintf("exchanges/%03d-request.body", i+1), !bytes.Equal(body, e.Request)
+			if err := add(ex.RequestFile, body); err != nil {
+				return nil, err
+			}
+		}
+		if e.Response != nil {
+			body := request.RedactBody(e.Response)
+			ex.ResponseFile, ex.ResponseRedacted = fmt.Sprintf("exchanges/%03d-response.body", i+1), !bytes.Equal(body, e.Response)
+			if err := add(ex.ResponseFile, body); err != nil {
+				return nil, err
+			}
+		}
+		manifest = append(manifest, ex)
+	}
+	for _, f := range []struct {
+		name string
+		v    any
+	}{{"shipment.json", rec}, {"exchanges.json", manifest}} {
+		b, err := json.MarshalIndent(f.v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := add(f.name, b); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Append appends all exchanges routes into the router.
+func (h *Exchanges) Append(mux *http.ServeMux) {
+	handle(mux, "GET /api/v1/shipments/{id}/exchanges", h.Export, h.middleware)
+}
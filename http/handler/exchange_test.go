@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type mockExchangeReader struct{ mock.Mock }
+
+func (m *mockExchangeReader) Exchanges(ctx context.Context, shipmentID string) ([]request.Exchange, error) {
+	args := m.Called(ctx, shipmentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]request.Exchange), args.Error(1)
+}
+
+func TestExchanges_Export(t *testing.T) {
+	const id = "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10"
+	principal := &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}}
+	records, exchanges := new(mockRecordReader), new(mockExchangeReader)
+	records.On("Record", mock.Anything, shipment.Filter{Tenant: "acme"}, id).
+		Return(&shipment.Record{ID: id, Provider: "b", Status: shipment.StatusBooked}, nil)
+	exchanges.On("Exchanges", mock.Anything, id).Return([]request.Exchange{
+		{Shipment: id, Endpoint: "http://b/v1/b", Attempt: 1, Status: 503, Request: []byte(`{"a":1}`), Response: []byte("busy")},
+		{
+			Shipment: id, Endpoint: "http://b/v1/b", Attempt: 2, Status: 200, Request: []byte(`{"a":1, "password":"p1"}`),
+			Response:      []byte(`{"awb":"1"}`),
+			RequestSHA256: request.Digest([]byte(`{"a":1, "password":"p1"}`)), ResponseSHA256: request.Digest([]byte(`{"awb":"1"}`)),
+		},
+	}, nil)
+	mux := http.NewServeMux()
+	NewExchanges(records, exchanges).Append(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/shipments/"+id+"/exchanges", nil)
+	req = req.WithContext(auth.With(req.Context(), principal))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		files[f.Name], err = io.ReadAll(rc)
+		require.NoError(t, err)
+		_ = rc.Close()
+	}
+	assert.Equal(t, "busy", string(files["exchanges/001-response.body"]))
+	assert.Equal(t, `{"awb":"1"}`, string(files["exchanges/002-response.body"]))
+	assert.JSONEq(t, `{"a":1,"password":"REDACTED"}`, string(files["exchanges/002-request.body"]),
+		"the credentials are redacted once the audited body is verified")
+	var manifest []map[string]any
+	require.NoError(t, json.Unmarshal(files["exchanges.json"], &manifest))
+	require.Len(t, manifest, 2)
+	assert.Equal(t, "exchanges/002-request.body", manifest[1]["requestFile"])
+	assert.Equal(t, true, manifest[1]["requestRedacted"])
+	assert.Nil(t, manifest[1]["responseRedacted"])
+	assert.Equal(t, request.Digest([]byte(`{"a":1, "password":"p1"}`)), manifest[1]["requestSha256"])
+	assert.EqualValues(t, 2, manifest[1]["attempt"])
+	assert.Equal(t, request.Digest([]byte(`{"awb":"1"}`)), manifest[1]["responseSha256"])
+	assert.Contains(t, string(files["shipment.json"]), id)
+}
+
+func TestExchanges_ExportNotFound(t *testing.T) {
+	const id = "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10"
+	principal := &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}}
+	records, exchanges := new(mockRecordReader), new(mockExchangeReader)
+	records.On("Record", mock.Anything, shipment.Filter{Tenant: "acme"}, id).Return(nil, shipment.ErrNotFound)
+	mux := http.NewServeMux()
+	NewExchanges(records, exchanges).Append(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/shipments/"+id+"/exchanges", nil)
+	req = req.WithContext(auth.With(req.Context(), principal))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	exchanges.AssertNotCalled(t, "Exchanges", mock.Anything, mock.Anything)
+}
+
+func TestExchanges_ExportTampered(t *testing.T) {
+	const id = "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10"
+	principal := &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}}
+	records, exchanges := new(mockRecordReader), new(mockExchangeReader)
+	records.On("Record", mock.Anything, shipment.Filter{Tenant: "acme"}, id).
+		Return(&shipment.Record{ID: id, Provider: "b", Status: shipment.StatusBooked}, nil)
+	exchanges.On("Exchanges", mock.Anything, id).Return([]request.Exchange{{
+		Shipment: id, Endpoint: "http://b/v1/b", Attempt: 1, Status: 200,
+		Request: []byte(`{"a":2}`), RequestSHA256: request.Digest([]byte(`{"a":1}`)),
+	}}, nil)
+	mux := http.NewServeMux()
+	NewExchanges(records, exchanges).Append(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/shipments/"+id+"/exchanges", nil)
+	req = req.WithContext(auth.With(req.Context(), principal))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotEqual(t, "application/zip", w.Header().Get("Content-Type"))
+}
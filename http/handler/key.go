@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// KeyManager defines how the api keys are issued and revoked.
+type KeyManager interface {
+	Issue(ctx context.Context, k *auth.Key) (string, error)
+	Keys(ctx context.Context) ([]auth.Key, error)
+	Revoke(ctx context.Context, id int64) error
+}
+
+type Key struct {
+	manager    KeyManager
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type KeyOption func(k *Key)
+
+func WithKeyLogger(log *slog.Logger) KeyOption {
+	return func(k *Key) {
+		k.log = log.WithGroup("key")
+	}
+}
+
+// WithKeyMiddleware wraps the key routes with the middleware.
+func WithKeyMiddleware(mw ...middleware.Middleware) KeyOption {
+	return func(k *Key) {
+		k.middleware = append(k.middleware, mw...)
+	}
+}
+
+// NewKey creates a new admin handler to issue and revoke the api keys.
+func NewKey(manager KeyManager, options ...KeyOption) *Key {
+	k := &Key{
+		manager: manager,
+		log:     log.Noop(),
+	}
+	for _, option := range options {
+		option(k)
+	}
+	return k
+}
+
+// IssuedKey is the key with its token, returned only once when issued.
+type IssuedKey struct {
+	auth.Key
+	Token string `json:"token"`
+}
+
+func (h *Key) fail(ctx context.Context, response response.Response, err error, message string) {
+	var invalid *auth.ErrInvalidKey
+	switch {
+	case errors.As(err, &invalid):
+		response.BadRequest(invalid.Error())
+	case errors.Is(err, auth.ErrNotFound):
+		response.NotFound(err.Error())
+	default:
+		h.log.With(log.Error(err)).ErrorContext(ctx, message)
+		response.InternalServer(message)
+	}
+}
+
+// Issue issues a new api key.
+func (h *Key) Issue(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	k := &auth.Key{}
+	if err := json.NewDecoder(r.Body).Decode(k); err != nil {
+		response.BadRequest("invalid body used, please consult the api")
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to decode body")
+		return
+	}
+	defer r.Body.Close()
+
+	token, err := h.manager.Issue(r.Context(), k)
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to issue key")
+		return
+	}
+	h.log.With(slog.Int64("id", k.ID), slog.String("name", k.Name)).
+		InfoContext(r.Context(), "Key issued")
+	response.Created(&IssuedKey{Key: *k, Token: token})
+}
+
+// Keys lists all the issued keys.
+func (h *Key) Keys(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	keys, err := h.manager.Keys(r.Context())
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to list keys")
+		return
+	}
+	response.OK(keys)
+}
+
+// Revoke revokes a key.
+func (h *Key) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	if err := h.manager.Revoke(r.Context(), id); err != nil {
+		h.fail(r.Context(), response, err, "failed to revoke key")
+		return
+	}
+	h.log.With(slog.Int64("id", id)).InfoContext(r.Context(), "Key revoked")
+	response.NoContent()
+}
+
+// Append appends all key admin routes into the router.
+func (h *Key) Append(mux *http.ServeMux) {
+	handle(mux, "POST /api/v1/admin/keys", h.Issue, h.middleware)
+	handle(mux, "GET /api/v1/admin/keys", h.Keys, h.middleware)
+	handle(mux, "DELETE /api/v1/admin/keys/{id}", h.Revoke, h.middleware)
+}
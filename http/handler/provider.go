@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// Catalog defines how the providers of the caller are listed
+// and how a request is mapped for them.
+type Catalog interface {
+	// Providers returns the providers the caller may ship with.
+	Providers(ctx context.Context) ([]api.Provider, error)
+	// Map returns the payload the provider would be sent for the request.
+	Map(ctx context.Context, provider string, req *api.ShippingRequest) (json.RawMessage, error)
+}
+
+type Providers struct {
+	catalog    Catalog
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type ProvidersOption func(p *Providers)
+
+func WithProvidersLogger(log *slog.Logger) ProvidersOption {
+	return func(p *Providers) {
+		p.log = log.WithGroup("providers")
+	}
+}
+
+// WithProvidersMiddleware wraps the providers routes with the middleware.
+func WithProvidersMiddleware(mw ...middleware.Middleware) ProvidersOption {
+	return func(p *Providers) {
+		p.middleware = append(p.middleware, mw...)
+	}
+}
+
+// NewProviders creates a new handler to list the providers of the caller.
+func NewProviders(catalog Catalog, options ...ProvidersOption) *Providers {
+	p := &Providers{
+		catalog: catalog,
+		log:     log.Noop(),
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// List returns the providers the caller may ship with, in the order they are preferred.
+func (h *Providers) List(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	providers, err := h.catalog.Providers(r.Context())
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to list providers")
+		response.InternalServer("failed to list providers")
+		return
+	}
+	response.OK(&api.Providers{Providers: providers})
+}
+
+// Map returns the payload the provider would be sent for the request, nothing is sent.
+func (h *Providers) Map(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	req := &api.ShippingRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		response.BadRequest("invalid body used, please consult the api")
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to decode body")
+		return
+	}
+	defer r.Body.Close()
+
+	provider := r.PathValue("provider")
+	payload, err := h.catalog.Map(r.Context(), provider, req)
+	if err != nil {
+		if forbidden(err) {
+			response.Forbidden(err.Error())
+			return
+		}
+		if badRequest(err) {
+			response.BadRequest(err.Error())
+			return
+		}
+		h.log.With(slog.String("provider", provider), log.Error(err)).ErrorContext(r.Context(), "Failed to map request")
+		response.InternalServer("failed to map request")
+		return
+	}
+	response.OK(payload)
+}
+
+// Append appends all providers routes into the router.
+func (h *Providers) Append(mux *http.ServeMux) {
+	handle(mux, "GET /api/v1/providers", h.List, h.middleware)
+	handle(mux, "POST /api/v1/providers/{provider}/map", h.Map, h.middleware)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type mockCatalog struct{ mock.Mock }
+
+func (m *mockCatalog) Providers(ctx context.Context) ([]api.Provider, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]api.Provider), args.Error(1)
+}
+
+func (m *mockCatalog) Map(ctx context.Context, provider string, req *api.ShippingRequest) (json.RawMessage, error) {
+	args := m.Called(ctx, provider, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(json.RawMessage), args.Error(1)
+}
+
+func TestProviders(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		setup    func(c *mockCatalog)
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:   "list",
+			method: http.MethodGet,
+			target: "/api/v1/providers",
+			setup: func(c *mockCatalog) {
+				c.On("Providers", mock.Anything).Return([]api.Provider{{Name: "a", Endpoint: "http://a", Quotes: true}}, nil)
+			},
+			wantCode: http.StatusOK,
+			wantBody: `{"providers":[{"name":"a","endpoint":"http://a","quotes":true}]}`,
+		},
+		{
+			name:   "map",
+			method: http.MethodPost,
+			target: "/api/v1/providers/b/map",
+			body:   `{"serviceType":"express"}`,
+			setup: func(c *mockCatalog) {
+				c.On("Map", mock.Anything, "b", &api.ShippingRequest{ServiceType: "express"}).
+					Return(json.RawMessage(`{"ServiceType":"EXP"}`), nil)
+			},
+			wantCode: http.StatusOK,
+			wantBody: `{"ServiceType":"EXP"}`,
+		},
+		{
+			name:     "map invalid body",
+			method:   http.MethodPost,
+			target:   "/api/v1/providers/b/map",
+			body:     `{`,
+			setup:    func(*mockCatalog) {},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "map unknown provider",
+			method: http.MethodPost,
+			target: "/api/v1/providers/c/map",
+			body:   `{}`,
+			setup: func(c *mockCatalog) {
+				c.On("Map", mock.Anything, "c", mock.Anything).Return(nil, &shipment.ErrProviderUnsupported{Provider: "c"})
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "map outside the scope",
+			method: http.MethodPost,
+			target: "/api/v1/providers/b/map",
+			body:   `{}`,
+			setup: func(c *mockCatalog) {
+				c.On("Map", mock.Anything, "b", mock.Anything).Return(nil, &shipment.ErrProviderForbidden{Provider: "b"})
+			},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := new(mockCatalog)
+			tt.setup(c)
+			mux := http.NewServeMux()
+			NewProviders(c).Append(mux)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+			c.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// Quoter defines how we ask the providers for rates.
+type Quoter interface {
+	// Quote asks all providers in the providers list for a rate and ranks
+	// them by the sort criterion. If providers slice is empty then we ask
+	// all internal providers that support quotes.
+	Quote(ctx context.Context, providers []string, sort string, req *api.ShippingRequest) ([]api.Quote, error)
+}
+
+type Quote struct {
+	quoter     Quoter
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type QuoteOption func(q *Quote)
+
+func WithQuoteLogger(log *slog.Logger) QuoteOption {
+	return func(q *Quote) {
+		q.log = log.WithGroup("quote")
+	}
+}
+
+// WithQuoteMiddleware wraps the quote routes with the middleware.
+func WithQuoteMiddleware(mw ...middleware.Middleware) QuoteOption {
+	return func(q *Quote) {
+		q.middleware = append(q.middleware, mw...)
+	}
+}
+
+// NewQuote creates a new handler to rank the providers by their rates.
+func NewQuote(quoter Quoter, options ...QuoteOption) *Quote {
+	q := &Quote{
+		quoter: quoter,
+		log:    log.Noop(),
+	}
+	for _, option := range options {
+		option(q)
+	}
+	return q
+}
+
+// CreateQuotes handles the create quotes http method.
+func (q *Quote) CreateQuotes(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	req := &api.ShippingRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		response.BadRequest("invalid body used, please consult the api")
+		q.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to decode body")
+		return
+	}
+	defer r.Body.Close()
+
+	providers := providerList(r)
+	sort := r.URL.Query().Get("sort")
+	l := q.log.With(log.Strings("providers", providers), slog.String("sort", sort))
+	l.InfoContext(r.Context(), "Create quotes")
+
+	quotes, err := q.quoter.Quote(r.Context(), providers, sort, req)
+	if err != nil {
+		if forbidden(err) {
+			response.Forbidden(err.Error())
+			return
+		}
+		if badRequest(err) {
+			response.BadRequest(err.Error())
+			return
+		}
+		l.With(log.Error(err)).ErrorContext(r.Context(), "Failed to quote providers")
+		response.InternalServer("quote failed")
+		return
+	}
+
+	response.OK(&api.Quotes{Quotes: quotes})
+}
+
+// Append appends all quote routes into the router.
+func (q *Quote) Append(mux *http.ServeMux) {
+	handle(mux, "POST /api/v1/quotes", q.CreateQuotes, q.middleware)
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+// RecordReader defines how the stored shipments are read.
+type RecordReader interface {
+	Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error)
+	Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error)
+}
+
+type Records struct {
+	reader     RecordReader
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type RecordsOption func(r *Records)
+
+func WithRecordsLogger(log *slog.Logger) RecordsOption {
+	return func(r *Records) {
+		r.log = log.WithGroup("records")
+	}
+}
+
+// WithRecordsMiddleware wraps the records routes with the middleware.
+func WithRecordsMiddleware(mw ...middleware.Middleware) RecordsOption {
+	return func(r *Records) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
+
+// NewRecords creates a new handler to read the stored shipments.
+func NewRecords(reader RecordReader, options ...RecordsOption) *Records {
+	rec := &Records{
+		reader: reader,
+		log:    log.Noop(),
+	}
+	for _, option := range options {
+		option(rec)
+	}
+	return rec
+}
+
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+// filter scopes the shipments to the tenant of the caller, only
+// the admins can read all of them or pick a tenant with ?tenant=.
+func filter(r *http.Request) shipment.Filter {
+	if p, ok := auth.From(r.Context()); ok && p.Admin {
+		if values, ok := r.URL.Query()["tenant"]; ok {
+			return shipment.Filter{Tenant: values[0]}
+		}
+		return shipment.Filter{AllTenants: true}
+	}
+	return shipment.Filter{Tenant: tenant.ID(r.Context())}
+}
+
+// List returns the stored shipments, newest first. They are paged with
+// ?limit= and ?before=, the id of the last shipment of the previous page.
+func (h *Records) List(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	f := filter(r)
+	f.Limit = defaultLimit
+	query := r.URL.Query()
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			response.BadRequestf("invalid limit %s, expected between 1 and %d", value, maxLimit)
+			return
+		}
+		f.Limit = limit
+	}
+	if value := query.Get("before"); value != "" {
+		if err := uuid.Validate(value); err != nil {
+			response.BadRequestf("invalid before %s", value)
+			return
+		}
+		f.Before = value
+	}
+	records, err := h.reader.Records(r.Context(), f)
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to list shipments")
+		response.InternalServer("failed to list shipments")
+		return
+	}
+	response.OK(records)
+}
+
+// Get returns a single stored shipment, the ones of other tenants are not found.
+func (h *Records) Get(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	id := r.PathValue("id")
+	if err := uuid.Validate(id); err != nil {
+		response.BadRequestf("invalid id %s", id)
+		return
+	}
+	rec, err := h.reader.Record(r.Context(), filter(r), id)
+	if errors.Is(err, shipment.ErrNotFound) {
+		response.NotFound(err.Error())
+		return
+	}
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to fetch shipment")
+		response.InternalServer("failed to fetch shipment")
+		return
+	}
+	response.OK(rec)
+}
+
+// Append appends all records routes into the router.
+func (h *Records) Append(mux *http.ServeMux) {
+	handle(mux, "GET /api/v1/shipments", h.List, h.middleware)
+	handle(mux, "GET /api/v1/shipments/{id}", h.Get, h.middleware)
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type mockRecordReader struct{ mock.Mock }
+
+func (m *mockRecordReader) Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error) {
+	args := m.Called(ctx, f)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]shipment.Record), args.Error(1)
+}
+
+func (m *mockRecordReader) Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error) {
+	args := m.Called(ctx, f, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*shipment.Record), args.Error(1)
+}
+
+func TestRecords(t *testing.T) {
+	tenant := &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}}
+	admin := &auth.Principal{Subject: "admin", Admin: true}
+
+	tests := []struct {
+		name       string
+		principal  *auth.Principal
+		target     string
+		setupMock  func(m *mockRecordReader)
+		statusCode int
+	}{
+		{
+			name:      "tenant lists its own shipments",
+			principal: tenant,
+			target:    "/api/v1/shipments?limit=10&before=0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10",
+			setupMock: func(m *mockRecordReader) {
+				m.On("Records", mock.Anything, shipment.Filter{Tenant: "acme", Limit: 10, Before: "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10"}).
+					Return([]shipment.Record{}, nil)
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:      "tenant can't pick another tenant",
+			principal: tenant,
+			target:    "/api/v1/shipments?tenant=globex",
+			setupMock: func(m *mockRecordReader) {
+				m.On("Records", mock.Anything, shipment.Filter{Tenant: "acme", Limit: defaultLimit}).
+					Return([]shipment.Record{}, nil)
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:      "admin lists all tenants",
+			principal: admin,
+			target:    "/api/v1/shipments",
+			setupMock: func(m *mockRecordReader) {
+				m.On("Records", mock.Anything, shipment.Filter{AllTenants: true, Limit: defaultLimit}).
+					Return([]shipment.Record{}, nil)
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:      "admin picks a tenant",
+			principal: admin,
+			target:    "/api/v1/shipments?tenant=globex",
+			setupMock: func(m *mockRecordReader) {
+				m.On("Records", mock.Anything, shipment.Filter{Tenant: "globex", Limit: defaultLimit}).
+					Return([]shipment.Record{}, nil)
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "invalid limit",
+			principal:  tenant,
+			target:     "/api/v1/shipments?limit=1000",
+			setupMock:  func(*mockRecordReader) {},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid before",
+			principal:  tenant,
+			target:     "/api/v1/shipments?before=100",
+			setupMock:  func(*mockRecordReader) {},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid id",
+			principal:  tenant,
+			target:     "/api/v1/shipments/7",
+			setupMock:  func(*mockRecordReader) {},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:      "shipment of another tenant is not found",
+			principal: tenant,
+			target:    "/api/v1/shipments/0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10",
+			setupMock: func(m *mockRecordReader) {
+				m.On("Record", mock.Anything, shipment.Filter{Tenant: "acme"}, "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10").
+					Return(nil, shipment.ErrNotFound)
+			},
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := new(mockRecordReader)
+			tt.setupMock(reader)
+			mux := http.NewServeMux()
+			NewRecords(reader).Append(mux)
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req = req.WithContext(auth.With(req.Context(), tt.principal))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			reader.AssertExpectations(t)
+		})
+	}
+}
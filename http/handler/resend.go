@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// Resender defines how a stored shipment is sent again.
+type Resender interface {
+	// Resend sends the original request of the stored shipment to the providers,
+	// if providers slice is empty then to the provider it was first sent to.
+	Resend(ctx context.Context, rec *shipment.Record, providers []string) ([]api.ShippingResponse, error)
+}
+
+type Resends struct {
+	records    RecordReader
+	resender   Resender
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type ResendsOption func(r *Resends)
+
+func WithResendsLogger(log *slog.Logger) ResendsOption {
+	return func(r *Resends) {
+		r.log = log.WithGroup("resends")
+	}
+}
+
+// WithResendsMiddleware wraps the resends routes with the middleware.
+func WithResendsMiddleware(mw ...middleware.Middleware) ResendsOption {
+	return func(r *Resends) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
+
+// NewResends creates a new handler to resend the stored shipments,
+// the records scope the shipments to the caller.
+func NewResends(records RecordReader, resender Resender, options ...ResendsOption) *Resends {
+	r := &Resends{
+		records:  records,
+		resender: resender,
+		log:      log.Noop(),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// defaultSince is how far back the bulk resend looks by default.
+const defaultSince = time.Hour
+
+// onBehalf returns the context a shipment of the tenant is resent with, the
+// admins resend the shipments of a tenant with the accounts of that tenant.
+func onBehalf(ctx context.Context, tenant string) context.Context {
+	p, ok := auth.From(ctx)
+	if !ok || !p.Admin || p.Tenant == tenant {
+		return ctx
+	}
+	as := *p
+	as.Tenant = tenant
+	return auth.With(ctx, &as)
+}
+
+// Resend sends the stored shipment again, to the providers given
+// with ?providers= or to the provider it was first sent to.
+func (h *Resends) Resend(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	id := r.PathValue("id")
+	if err := uuid.Validate(id); err != nil {
+		response.BadRequestf("invalid id %s", id)
+		return
+	}
+	rec, err := h.records.Record(r.Context(), filter(r), id)
+	if errors.Is(err, shipment.ErrNotFound) {
+		response.NotFound(err.Error())
+		return
+	}
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to fetch shipment")
+		response.InternalServer("failed to fetch shipment")
+		return
+	}
+	providers := providerList(r)
+	l := h.log.With(slog.String("id", id), log.Strings("providers", providers))
+	l.InfoContext(r.Context(), "Resend shipment")
+	resp, err := h.resender.Resend(onBehalf(r.Context(), rec.Tenant), rec, providers)
+	var notResendable *shipment.ErrNotResendable
+	switch {
+	case err == nil:
+		response.Created(&api.ShippingResponses{Responses: resp})
+	case errors.As(err, &notResendable):
+		response.Conflict(err.Error())
+	case forbidden(err):
+		response.Forbidden(err.Error())
+	case badRequest(err):
+		response.BadRequest(err.Error())
+	default:
+		l.With(log.Error(err)).ErrorContext(r.Context(), "Failed to resend shipment")
+		response.InternalServer("resend failed")
+	}
+}
+
+// ResendAll sends again the shipments sent to the ?provider= in the last
+// ?since= duration, one hour by default, with the ?status=, failed by default.
+// The shipments with another status than failed are only resent with ?force=true.
+// The shipments already resent are left out and at most ?limit= of them are
+// resent by each call, to the providers given with ?providers= or to the
+// provider they were first sent to.
+func (h *Resends) ResendAll(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	query := r.URL.Query()
+	f := filter(r)
+	f.Unresent = true
+	f.Limit = defaultLimit
+	if f.Provider = query.Get("provider"); f.Provider == "" {
+		response.BadRequest("missing provider")
+		return
+	}
+	f.Status = shipment.StatusFailed
+	if value := query.Get("status"); value != "" {
+		if f.Status = shipment.Status(value); !f.Status.Valid() {
+			response.BadRequestf("invalid status %s", value)
+			return
+		}
+	}
+	// Only the failed shipments are surely not booked, the others
+	// would be booked again so they are resent only when forced.
+	if f.Status != shipment.StatusFailed && query.Get("force") != "true" {
+		response.BadRequestf("resending the %s shipments books them again, set force=true to resend them", f.Status)
+		return
+	}
+	since := defaultSince
+	if value := query.Get("since"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			response.BadRequestf("invalid since %s", value)
+			return
+		}
+		since = d
+	}
+	f.Since = time.Now().Add(-since)
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			response.BadRequestf("invalid limit %s, expected between 1 and %d", value, maxLimit)
+			return
+		}
+		f.Limit = limit
+	}
+	records, err := h.records.Records(r.Context(), f)
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to list shipments")
+		response.InternalServer("failed to list shipments")
+		return
+	}
+	providers := providerList(r)
+	h.log.With(
+		slog.String("provider", f.Provider),
+		slog.String("status", string(f.Status)),
+		slog.Duration("since", since),
+		slog.Int("shipments", len(records)),
+		log.Strings("providers", providers),
+	).InfoContext(r.Context(), "Resend shipments")
+	out := api.ResentShipments{Resent: make([]api.Resent, 0, len(records))}
+	for _, rec := range records {
+		resp, err := h.resender.Resend(onBehalf(r.Context(), rec.Tenant), &rec, providers)
+		resent := api.Resent{Shipment: rec.ID, Responses: resp}
+		if err != nil {
+			h.log.With(slog.String("id", rec.ID), log.Error(err)).ErrorContext(r.Context(), "Failed to resend shipment")
+			resent.Error = err.Error()
+		}
+		out.Resent = append(out.Resent, resent)
+	}
+	response.OK(&out)
+}
+
+// Append appends all resends routes into the router.
+func (h *Resends) Append(mux *http.ServeMux) {
+	handle(mux, "POST /api/v1/shipments/{id}/resend", h.Resend, h.middleware)
+	handle(mux, "POST /api/v1/shipments/resend", h.ResendAll, h.middleware)
+}
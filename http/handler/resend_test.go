@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+type mockResender struct{ mock.Mock }
+
+func (m *mockResender) Resend(ctx context.Context, rec *shipment.Record, providers []string) ([]api.ShippingResponse, error) {
+	args := m.Called(ctx, rec, providers)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]api.ShippingResponse), args.Error(1)
+}
+
+func TestResends_Resend(t *testing.T) {
+	const id = "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10"
+	rec := &shipment.Record{ID: id, Provider: "a", Status: shipment.StatusFailed, Tenant: "acme"}
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		target    string
+		setup     func(records *mockRecordReader, resender *mockResender)
+		wantCode  int
+	}{
+		{
+			name:      "resent to the given providers",
+			principal: &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}},
+			target:    "/api/v1/shipments/" + id + "/resend?providers=b",
+			setup: func(records *mockRecordReader, resender *mockResender) {
+				records.On("Record", mock.Anything, shipment.Filter{Tenant: "acme"}, id).Return(rec, nil)
+				resender.On("Resend", mock.Anything, rec, []string{"b"}).
+					Return([]api.ShippingResponse{{Endpoint: "http://b"}}, nil)
+			},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:      "admins resend on behalf of the tenant",
+			principal: &auth.Principal{Subject: "admin", Admin: true},
+			target:    "/api/v1/shipments/" + id + "/resend",
+			setup: func(records *mockRecordReader, resender *mockResender) {
+				records.On("Record", mock.Anything, shipment.Filter{AllTenants: true}, id).Return(rec, nil)
+				onBehalf := mock.MatchedBy(func(ctx context.Context) bool { return tenant.ID(ctx) == "acme" })
+				resender.On("Resend", onBehalf, rec, []string(nil)).
+					Return([]api.ShippingResponse{{Endpoint: "http://a"}}, nil)
+			},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:      "not found",
+			principal: &auth.Principal{Subject: "key:1", Tenant: "globex", Providers: []string{"*"}},
+			target:    "/api/v1/shipments/" + id + "/resend",
+			setup: func(records *mockRecordReader, resender *mockResender) {
+				records.On("Record", mock.Anything, shipment.Filter{Tenant: "globex"}, id).Return(nil, shipment.ErrNotFound)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:      "original request not stored",
+			principal: &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}},
+			target:    "/api/v1/shipments/" + id + "/resend",
+			setup: func(records *mockRecordReader, resender *mockResender) {
+				records.On("Record", mock.Anything, shipment.Filter{Tenant: "acme"}, id).Return(rec, nil)
+				resender.On("Resend", mock.Anything, rec, []string(nil)).Return(nil, &shipment.ErrNotResendable{ID: id})
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:      "unknown provider",
+			principal: &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}},
+			target:    "/api/v1/shipments/" + id + "/resend?providers=z",
+			setup: func(records *mockRecordReader, resender *mockResender) {
+				records.On("Record", mock.Anything, shipment.Filter{Tenant: "acme"}, id).Return(rec, nil)
+				resender.On("Resend", mock.Anything, rec, []string{"z"}).
+					Return(nil, &shipment.ErrProviderUnsupported{Provider: "z"})
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:      "invalid id",
+			principal: &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}},
+			target:    "/api/v1/shipments/42/resend",
+			setup:     func(*mockRecordReader, *mockResender) {},
+			wantCode:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, resender := new(mockRecordReader), new(mockResender)
+			tt.setup(records, resender)
+			mux := http.NewServeMux()
+			NewResends(records, resender).Append(mux)
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			req = req.WithContext(auth.With(req.Context(), tt.principal))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			records.AssertExpectations(t)
+			resender.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResends_ResendAll(t *testing.T) {
+	principal := &auth.Principal{Subject: "key:1", Tenant: "acme", Providers: []string{"*"}}
+	failed := []shipment.Record{
+		{ID: "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10", Provider: "a", Status: shipment.StatusFailed, Tenant: "acme"},
+		{ID: "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c11", Provider: "a", Status: shipment.StatusFailed, Tenant: "acme"},
+	}
+	records, resender := new(mockRecordReader), new(mockResender)
+	records.On("Records", mock.Anything, mock.MatchedBy(func(f shipment.Filter) bool {
+		since := time.Since(f.Since)
+		return f.Tenant == "acme" && f.Provider == "a" && f.Status == shipment.StatusFailed &&
+			f.Unresent && f.Limit == defaultLimit && since >= time.Hour && since < time.Hour+time.Minute
+	})).Return(failed, nil)
+	resender.On("Resend", mock.Anything, &failed[0], []string(nil)).
+		Return([]api.ShippingResponse{{Endpoint: "http://a"}}, nil)
+	resender.On("Resend", mock.Anything, &failed[1], []string(nil)).
+		Return(nil, &shipment.ErrNotResendable{ID: failed[1].ID})
+	mux := http.NewServeMux()
+	NewResends(records, resender).Append(mux)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/shipments/resend?provider=a", nil)
+	req = req.WithContext(auth.With(req.Context(), principal))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var got api.ResentShipments
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	require.Len(t, got.Resent, 2)
+	assert.Equal(t, failed[0].ID, got.Resent[0].Shipment)
+	assert.Empty(t, got.Resent[0].Error)
+	assert.Len(t, got.Resent[0].Responses, 1)
+	assert.Equal(t, failed[1].ID, got.Resent[1].Shipment)
+	assert.NotEmpty(t, got.Resent[1].Error)
+	resender.AssertExpectations(t)
+}
+
+func TestResends_ResendAllInvalid(t *testing.T) {
+	for _, query := range []string{"", "?provider=a&status=lost", "?provider=a&status=booked", "?provider=a&since=-1h", "?provider=a&limit=0"} {
+		t.Run(query, func(t *testing.T) {
+			mux := http.NewServeMux()
+			NewResends(new(mockRecordReader), new(mockResender)).Append(mux)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/shipments/resend"+query, nil))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestResends_ResendAllForce(t *testing.T) {
+	records, resender := new(mockRecordReader), new(mockResender)
+	records.On("Records", mock.Anything, mock.MatchedBy(func(f shipment.Filter) bool {
+		return f.Status == shipment.StatusBooked
+	})).Return([]shipment.Record{}, nil)
+	mux := http.NewServeMux()
+	NewResends(records, resender).Append(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/shipments/resend?provider=a&status=booked&force=true", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	records.AssertExpectations(t)
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/rule"
+)
+
+// RuleManager defines how the provider eligibility rules are managed.
+type RuleManager interface {
+	Rules(ctx context.Context) ([]rule.Rule, error)
+	Rule(ctx context.Context, id int64) (*rule.Rule, error)
+	Create(ctx context.Context, r *rule.Rule) error
+	Update(ctx context.Context, r *rule.Rule) error
+	Delete(ctx context.Context, id int64) error
+}
+
+type Rule struct {
+	manager    RuleManager
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type RuleOption func(r *Rule)
+
+func WithRuleLogger(log *slog.Logger) RuleOption {
+	return func(r *Rule) {
+		r.log = log.WithGroup("rule")
+	}
+}
+
+// WithRuleMiddleware wraps the rule routes with the middleware.
+func WithRuleMiddleware(mw ...middleware.Middleware) RuleOption {
+	return func(r *Rule) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
+
+// NewRule creates a new admin handler to manage the eligibility rules.
+func NewRule(manager RuleManager, options ...RuleOption) *Rule {
+	rl := &Rule{
+		manager: manager,
+		log:     log.Noop(),
+	}
+	for _, option := range options {
+		option(rl)
+	}
+	return rl
+}
+
+func (h *Rule) decode(w http.ResponseWriter, r *http.Request) (*rule.Rule, bool) {
+	rl := &rule.Rule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(rl); err != nil {
+		response.New(w).BadRequest("invalid body used, please consult the api")
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to decode body")
+		return nil, false
+	}
+	defer r.Body.Close()
+	return rl, true
+}
+
+func (h *Rule) fail(ctx context.Context, response response.Response, err error, message string) {
+	var invalid *rule.ErrInvalidRule
+	switch {
+	case errors.As(err, &invalid):
+		response.BadRequest(invalid.Error())
+	case errors.Is(err, rule.ErrNotFound):
+		response.NotFound(err.Error())
+	default:
+		h.log.With(log.Error(err)).ErrorContext(ctx, message)
+		response.InternalServer(message)
+	}
+}
+
+// Rules lists all the rules.
+func (h *Rule) Rules(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	rules, err := h.manager.Rules(r.Context())
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to list rules")
+		return
+	}
+	response.OK(rules)
+}
+
+// Rule returns a single rule.
+func (h *Rule) Rule(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	rl, err := h.manager.Rule(r.Context(), id)
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to fetch rule")
+		return
+	}
+	response.OK(rl)
+}
+
+// Create adds a new rule, rules are enabled unless stated otherwise.
+func (h *Rule) Create(w http.ResponseWriter, r *http.Request) {
+	rl, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	if err := h.manager.Create(r.Context(), rl); err != nil {
+		h.fail(r.Context(), response, err, "failed to create rule")
+		return
+	}
+	response.Created(rl)
+}
+
+// Update replaces an existing rule.
+func (h *Rule) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	rl, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	rl.ID = id
+	response := response.New(w)
+	if err := h.manager.Update(r.Context(), rl); err != nil {
+		h.fail(r.Context(), response, err, "failed to update rule")
+		return
+	}
+	response.OK(rl)
+}
+
+// Delete removes a rule.
+func (h *Rule) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	if err := h.manager.Delete(r.Context(), id); err != nil {
+		h.fail(r.Context(), response, err, "failed to delete rule")
+		return
+	}
+	response.NoContent()
+}
+
+// Append appends all rule admin routes into the router.
+func (h *Rule) Append(mux *http.ServeMux) {
+	handle(mux, "GET /api/v1/admin/rules", h.Rules, h.middleware)
+	handle(mux, "POST /api/v1/admin/rules", h.Create, h.middleware)
+	handle(mux, "GET /api/v1/admin/rules/{id}", h.Rule, h.middleware)
+	handle(mux, "PUT /api/v1/admin/rules/{id}", h.Update, h.middleware)
+	handle(mux, "DELETE /api/v1/admin/rules/{id}", h.Delete, h.middleware)
+}
@@ -6,16 +6,20 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/middleware"
 	"github.com/hoenirvili/axiogate/http/response"
 	"github.com/hoenirvili/axiogate/log"
 	"github.com/hoenirvili/axiogate/shipment"
 )
 
 type Shipment struct {
-	sender Sender
-	log    *slog.Logger
+	sender     Sender
+	log        *slog.Logger
+	middleware []middleware.Middleware
 }
 
 type Option func(s *Shipment)
@@ -26,13 +30,38 @@ func WithLogger(log *slog.Logger) Option {
 	}
 }
 
+// WithMiddleware wraps the shipment routes with the middleware.
+func WithMiddleware(mw ...middleware.Middleware) Option {
+	return func(s *Shipment) {
+		s.middleware = append(s.middleware, mw...)
+	}
+}
+
 // Sender defines how we send the request down the chain.
 type Sender interface {
 	// Send sends the req to all providers in the providers list.
 	// If providers slice is empty then we sent to all internal providers.
 	Send(ctx context.Context, providers []string, req *api.ShippingRequest) ([]api.ShippingResponse, error)
+	// Route sends the req to a single provider picked by the strategy,
+	// falling back to the next candidate if the picked one fails.
+	// If providers slice is empty then all internal providers are candidates.
+	Route(ctx context.Context, strategy string, providers []string, req *api.ShippingRequest) ([]api.ShippingResponse, error)
+	// Race sends the req to the providers, each one starting hedge after
+	// the previous one, and keeps only the first successful booking.
+	// If providers slice is empty then all internal providers are candidates.
+	Race(ctx context.Context, providers []string, hedge time.Duration, req *api.ShippingRequest) ([]api.ShippingResponse, error)
 }
 
+// Modes a shipment can be dispatched with.
+const (
+	ModeBroadcast = "broadcast"
+	ModeRace      = "race"
+)
+
+// maxHedge bounds the delay between the providers of a race, the
+// request waits on it for every provider started.
+const maxHedge = 5 * time.Second
+
 // NewShipment create a new handler shipment instance to shipment http requests.
 func NewShipment(sender Sender, options ...Option) *Shipment {
 	ship := &Shipment{
@@ -45,7 +74,12 @@ func NewShipment(sender Sender, options ...Option) *Shipment {
 	return ship
 }
 
-func (s *Shipment) providerList(r *http.Request) []string {
+// handle registers the handler for the pattern wrapped with the route middleware.
+func handle(mux *http.ServeMux, pattern string, h http.HandlerFunc, mw []middleware.Middleware) {
+	mux.Handle(pattern, middleware.Chain(h, mw...))
+}
+
+func providerList(r *http.Request) []string {
 	values := r.URL.Query()
 	if values == nil {
 		return nil
@@ -64,29 +98,99 @@ func (s *Shipment) providerList(r *http.Request) []string {
 	return out
 }
 
+// pathID parses the id path value, on failure it
+// writes the bad request response.
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		response.New(w).BadRequestf("invalid id %s", r.PathValue("id"))
+		return 0, false
+	}
+	return id, true
+}
+
+// forbidden reports if the error is caused by a provider outside the caller scope.
+func forbidden(err error) bool {
+	var forbidden *shipment.ErrProviderForbidden
+	return errors.As(err, &forbidden)
+}
+
+// badRequest reports if the error is caused by the caller request.
+func badRequest(err error) bool {
+	var (
+		unsupported *shipment.ErrProviderUnsupported
+		ineligible  *shipment.ErrProviderIneligible
+		strategy    *shipment.ErrStrategyUnsupported
+		sort        *shipment.ErrSortUnsupported
+	)
+	return errors.As(err, &unsupported) ||
+		errors.As(err, &ineligible) ||
+		errors.As(err, &strategy) ||
+		errors.As(err, &sort) ||
+		errors.Is(err, shipment.ErrNoEligibleProvider)
+}
+
 // CreateShipping handles the create shipping http method.
 func (s *Shipment) CreateShipping(w http.ResponseWriter, r *http.Request) {
 	response := response.New(w)
 	req := &api.ShippingRequest{}
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
 		response.BadRequest("invalid body used, please consult the api")
-		s.log.With(log.Error(err)).Error("Failed to decode body")
+		s.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to decode body")
 		return
 	}
 	defer r.Body.Close()
 
-	providers := s.providerList(r)
-	l := s.log.With(log.Strings("providers", providers))
-	l.Info("Create shipping")
+	providers := providerList(r)
+	query := r.URL.Query()
+	strategy, mode := query.Get("strategy"), query.Get("mode")
+	l := s.log.With(
+		log.Strings("providers", providers),
+		slog.String("strategy", strategy),
+		slog.String("mode", mode),
+	)
+	l.InfoContext(r.Context(), "Create shipping")
 
-	resp, err := s.sender.Send(r.Context(), providers, req)
+	var (
+		resp []api.ShippingResponse
+		err  error
+	)
+	switch {
+	case mode != "" && mode != ModeBroadcast && mode != ModeRace:
+		response.BadRequestf("unsupported mode %s", mode)
+		return
+	case mode == ModeRace && strategy != "":
+		response.BadRequest("strategy can't be used in race mode")
+		return
+	case mode == ModeRace:
+		var hedge time.Duration
+		if h := query.Get("hedge"); h != "" {
+			hedge, err = time.ParseDuration(h)
+			if err != nil || hedge < 0 {
+				response.BadRequestf("invalid hedge %s", h)
+				return
+			}
+			if hedge > maxHedge {
+				response.BadRequestf("hedge %s is longer than %s", h, maxHedge)
+				return
+			}
+		}
+		resp, err = s.sender.Race(r.Context(), providers, hedge, req)
+	case strategy != "":
+		resp, err = s.sender.Route(r.Context(), strategy, providers, req)
+	default:
+		resp, err = s.sender.Send(r.Context(), providers, req)
+	}
 	if err != nil {
-		var target *shipment.ErrProviderUnsupported
-		if errors.As(err, &target) {
-			response.BadRequest(target.Error())
+		if forbidden(err) {
+			response.Forbidden(err.Error())
+			return
+		}
+		if badRequest(err) {
+			response.BadRequest(err.Error())
 			return
 		}
-		l.With(log.Error(err)).Error("Failed to ship to providers")
+		l.With(log.Error(err)).ErrorContext(r.Context(), "Failed to ship to providers")
 		response.InternalServer("shipment failed")
 		return
 	}
@@ -96,5 +200,5 @@ func (s *Shipment) CreateShipping(w http.ResponseWriter, r *http.Request) {
 
 // Append appends all shipment routes into the router.
 func (s *Shipment) Append(mux *http.ServeMux) {
-	mux.HandleFunc("POST /api/v1/createShipping", s.CreateShipping)
+	handle(mux, "POST /api/v1/createShipping", s.CreateShipping, s.middleware)
 }
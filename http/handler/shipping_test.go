@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -26,6 +27,22 @@ func (m *mockSender) Send(ctx context.Context, providers []string, req *api.Ship
 	return args.Get(0).([]api.ShippingResponse), args.Error(1)
 }
 
+func (m *mockSender) Route(ctx context.Context, strategy string, providers []string, req *api.ShippingRequest) ([]api.ShippingResponse, error) {
+	args := m.Called(ctx, strategy, providers, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]api.ShippingResponse), args.Error(1)
+}
+
+func (m *mockSender) Race(ctx context.Context, providers []string, hedge time.Duration, req *api.ShippingRequest) ([]api.ShippingResponse, error) {
+	args := m.Called(ctx, providers, hedge, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]api.ShippingResponse), args.Error(1)
+}
+
 func TestShipmentCreateShipping(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -163,6 +180,143 @@ func TestShipmentCreateShipping(t *testing.T) {
 				assert.Contains(t, string(body), "unsupported")
 			},
 		},
+		{
+			name:        "sender returns ErrProviderForbidden",
+			requestBody: &api.ShippingRequest{},
+			queryParams: map[string][]string{
+				"providers": {"b"},
+			},
+			setupMock: func(ms *mockSender) {
+				ms.On("Send", mock.Anything, []string{"b"}, mock.AnythingOfType("*api.ShippingRequest")).
+					Return(nil, &shipment.ErrProviderForbidden{Provider: "b"})
+			},
+			expectedStatusCode: http.StatusForbidden,
+			validateResponse: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), "not allowed")
+			},
+		},
+		{
+			name:        "strategy routes to a single provider",
+			requestBody: &api.ShippingRequest{},
+			queryParams: map[string][]string{
+				"strategy": {"cheapest"},
+			},
+			setupMock: func(ms *mockSender) {
+				ms.On("Route", mock.Anything, "cheapest", []string(nil), mock.AnythingOfType("*api.ShippingRequest")).
+					Return([]api.ShippingResponse{
+						{
+							Endpoint:    "https://provider1.example.com/ship",
+							RawResponse: json.RawMessage(`{}`),
+							Error:       "provider down",
+						},
+						{
+							Endpoint:    "https://provider2.example.com/ship",
+							RawResponse: json.RawMessage(`{"tracking_id":"DEF456"}`),
+						},
+					}, nil)
+			},
+			expectedStatusCode: http.StatusCreated,
+			validateResponse: func(t *testing.T, body []byte) {
+				var resp api.ShippingResponses
+				err := json.Unmarshal(body, &resp)
+				assert.NoError(t, err)
+				assert.Len(t, resp.Responses, 2)
+				assert.Empty(t, resp.Responses[1].Error)
+			},
+		},
+		{
+			name:        "sender returns ErrStrategyUnsupported",
+			requestBody: &api.ShippingRequest{},
+			queryParams: map[string][]string{
+				"strategy": {"random"},
+			},
+			setupMock: func(ms *mockSender) {
+				ms.On("Route", mock.Anything, "random", []string(nil), mock.AnythingOfType("*api.ShippingRequest")).
+					Return(nil, &shipment.ErrStrategyUnsupported{Strategy: "random"})
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			validateResponse: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), "unsupported strategy")
+			},
+		},
+		{
+			name:        "sender returns ErrNoEligibleProvider",
+			requestBody: &api.ShippingRequest{},
+			queryParams: map[string][]string{
+				"strategy": {"priority"},
+			},
+			setupMock: func(ms *mockSender) {
+				ms.On("Route", mock.Anything, "priority", []string(nil), mock.AnythingOfType("*api.ShippingRequest")).
+					Return(nil, shipment.ErrNoEligibleProvider)
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			validateResponse: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), "no provider accepts")
+			},
+		},
+		{
+			name:        "race mode with hedge",
+			requestBody: &api.ShippingRequest{},
+			queryParams: map[string][]string{
+				"mode":      {"race"},
+				"hedge":     {"200ms"},
+				"providers": {"provider1", "provider2"},
+			},
+			setupMock: func(ms *mockSender) {
+				ms.On("Race", mock.Anything, []string{"provider1", "provider2"}, 200*time.Millisecond, mock.AnythingOfType("*api.ShippingRequest")).
+					Return([]api.ShippingResponse{
+						{
+							Endpoint:    "https://provider2.example.com/ship",
+							RawResponse: json.RawMessage(`{"tracking_id":"DEF456"}`),
+						},
+					}, nil)
+			},
+			expectedStatusCode: http.StatusCreated,
+			validateResponse: func(t *testing.T, body []byte) {
+				var resp api.ShippingResponses
+				err := json.Unmarshal(body, &resp)
+				assert.NoError(t, err)
+				assert.Len(t, resp.Responses, 1)
+			},
+		},
+		{
+			name:        "race mode with invalid hedge",
+			requestBody: &api.ShippingRequest{},
+			queryParams: map[string][]string{
+				"mode":  {"race"},
+				"hedge": {"soon"},
+			},
+			setupMock:          func(ms *mockSender) {},
+			expectedStatusCode: http.StatusBadRequest,
+			validateResponse: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), "invalid hedge")
+			},
+		},
+		{
+			name:        "race mode with hedge too long",
+			requestBody: &api.ShippingRequest{},
+			queryParams: map[string][]string{
+				"mode":  {"race"},
+				"hedge": {"1m"},
+			},
+			setupMock:          func(ms *mockSender) {},
+			expectedStatusCode: http.StatusBadRequest,
+			validateResponse: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), "hedge 1m is longer than 5s")
+			},
+		},
+		{
+			name:        "unsupported mode",
+			requestBody: &api.ShippingRequest{},
+			queryParams: map[string][]string{
+				"mode": {"lottery"},
+			},
+			setupMock:          func(ms *mockSender) {},
+			expectedStatusCode: http.StatusBadRequest,
+			validateResponse: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), "unsupported mode")
+			},
+		},
 		{
 			name:        "sender returns generic error",
 			requestBody: &api.ShippingRequest{},
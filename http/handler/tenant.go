@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+// TenantManager defines how the tenants are managed.
+type TenantManager interface {
+	Tenants(ctx context.Context) ([]tenant.Tenant, error)
+	Tenant(ctx context.Context, id string) (*tenant.Tenant, error)
+	Create(ctx context.Context, t *tenant.Tenant) error
+	Update(ctx context.Context, t *tenant.Tenant) error
+	Delete(ctx context.Context, id string) error
+}
+
+type Tenant struct {
+	manager    TenantManager
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type TenantOption func(t *Tenant)
+
+func WithTenantLogger(log *slog.Logger) TenantOption {
+	return func(t *Tenant) {
+		t.log = log.WithGroup("tenant")
+	}
+}
+
+// WithTenantMiddleware wraps the tenant routes with the middleware.
+func WithTenantMiddleware(mw ...middleware.Middleware) TenantOption {
+	return func(t *Tenant) {
+		t.middleware = append(t.middleware, mw...)
+	}
+}
+
+// NewTenant creates a new admin handler to manage the tenants.
+func NewTenant(manager TenantManager, options ...TenantOption) *Tenant {
+	t := &Tenant{
+		manager: manager,
+		log:     log.Noop(),
+	}
+	for _, option := range options {
+		option(t)
+	}
+	return t
+}
+
+func (h *Tenant) decode(w http.ResponseWriter, r *http.Request) (*tenant.Tenant, bool) {
+	t := &tenant.Tenant{}
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		response.New(w).BadRequest("invalid body used, please consult the api")
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to decode body")
+		return nil, false
+	}
+	defer r.Body.Close()
+	return t, true
+}
+
+func (h *Tenant) fail(ctx context.Context, response response.Response, err error, message string) {
+	var invalid *tenant.ErrInvalidTenant
+	switch {
+	case errors.As(err, &invalid):
+		response.BadRequest(invalid.Error())
+	case errors.Is(err, tenant.ErrNotFound):
+		response.NotFound(err.Error())
+	default:
+		h.log.With(log.Error(err)).ErrorContext(ctx, message)
+		response.InternalServer(message)
+	}
+}
+
+// Tenants lists all the tenants, their credentials are redacted.
+func (h *Tenant) Tenants(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	tenants, err := h.manager.Tenants(r.Context())
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to list tenants")
+		return
+	}
+	out := make([]tenant.Tenant, 0, len(tenants))
+	for _, t := range tenants {
+		out = append(out, t.Redacted())
+	}
+	response.OK(out)
+}
+
+// Tenant returns a single tenant, its credentials are redacted.
+func (h *Tenant) Tenant(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	t, err := h.manager.Tenant(r.Context(), r.PathValue("id"))
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to fetch tenant")
+		return
+	}
+	response.OK(t.Redacted())
+}
+
+// Create adds a new tenant.
+func (h *Tenant) Create(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	if err := h.manager.Create(r.Context(), t); err != nil {
+		h.fail(r.Context(), response, err, "failed to create tenant")
+		return
+	}
+	response.Created(t.Redacted())
+}
+
+// Update replaces an existing tenant, the credentials left
+// out or sent back redacted are kept as they are.
+func (h *Tenant) Update(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	t.ID = r.PathValue("id")
+	response := response.New(w)
+	if err := h.manager.Update(r.Context(), t); err != nil {
+		h.fail(r.Context(), response, err, "failed to update tenant")
+		return
+	}
+	response.OK(t.Redacted())
+}
+
+// Delete removes a tenant, its shipments are kept.
+func (h *Tenant) Delete(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	if err := h.manager.Delete(r.Context(), r.PathValue("id")); err != nil {
+		h.fail(r.Context(), response, err, "failed to delete tenant")
+		return
+	}
+	response.NoContent()
+}
+
+// Append appends all tenant admin routes into the router.
+func (h *Tenant) Append(mux *http.ServeMux) {
+	handle(mux, "GET /api/v1/admin/tenants", h.Tenants, h.middleware)
+	handle(mux, "POST /api/v1/admin/tenants", h.Create, h.middleware)
+	handle(mux, "GET /api/v1/admin/tenants/{id}", h.Tenant, h.middleware)
+	handle(mux, "PUT /api/v1/admin/tenants/{id}", h.Update, h.middleware)
+	handle(mux, "DELETE /api/v1/admin/tenants/{id}", h.Delete, h.middleware)
+}
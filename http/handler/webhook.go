@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/webhook"
+)
+
+// Subscriber defines how webhook subscriptions and their deliveries are managed.
+type Subscriber interface {
+	Subscribe(ctx context.Context, sub *webhook.Subscription) error
+	Subscriptions(ctx context.Context) ([]webhook.Subscription, error)
+	Unsubscribe(ctx context.Context, id int64) error
+	Deliveries(ctx context.Context, subscriptionID int64) ([]webhook.Delivery, error)
+	Redeliver(ctx context.Context, id int64) (*webhook.Delivery, error)
+}
+
+type Webhook struct {
+	subscriber Subscriber
+	log        *slog.Logger
+	middleware []middleware.Middleware
+}
+
+type WebhookOption func(w *Webhook)
+
+func WithWebhookLogger(log *slog.Logger) WebhookOption {
+	return func(w *Webhook) {
+		w.log = log.WithGroup("webhook")
+	}
+}
+
+// WithWebhookMiddleware wraps the webhook routes with the middleware.
+func WithWebhookMiddleware(mw ...middleware.Middleware) WebhookOption {
+	return func(w *Webhook) {
+		w.middleware = append(w.middleware, mw...)
+	}
+}
+
+// NewWebhook creates a new handler to manage the webhook subscriptions.
+func NewWebhook(subscriber Subscriber, options ...WebhookOption) *Webhook {
+	w := &Webhook{
+		subscriber: subscriber,
+		log:        log.Noop(),
+	}
+	for _, option := range options {
+		option(w)
+	}
+	return w
+}
+
+type subscriptionRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// Subscribe registers a new callback url, the secret used to
+// sign the deliveries is returned only once in this response.
+func (h *Webhook) Subscribe(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	req := &subscriptionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		response.BadRequest("invalid body used, please consult the api")
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to decode body")
+		return
+	}
+	defer r.Body.Close()
+
+	sub := &webhook.Subscription{
+		URL:    req.URL,
+		Secret: req.Secret,
+		Events: req.Events,
+	}
+	if err := h.subscriber.Subscribe(r.Context(), sub); err != nil {
+		var target *webhook.ErrInvalidSubscription
+		if errors.As(err, &target) {
+			response.BadRequest(target.Error())
+			return
+		}
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to subscribe")
+		response.InternalServer("subscription failed")
+		return
+	}
+	response.Created(sub)
+}
+
+// Subscriptions lists all registered subscriptions without their secrets.
+func (h *Webhook) Subscriptions(w http.ResponseWriter, r *http.Request) {
+	response := response.New(w)
+	subs, err := h.subscriber.Subscriptions(r.Context())
+	if err != nil {
+		h.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to list subscriptions")
+		response.InternalServer("failed to list subscriptions")
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	response.OK(subs)
+}
+
+// Unsubscribe removes a subscription.
+func (h *Webhook) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	if err := h.subscriber.Unsubscribe(r.Context(), id); err != nil {
+		h.fail(r.Context(), response, err, "failed to unsubscribe")
+		return
+	}
+	response.NoContent()
+}
+
+// Deliveries returns the delivery log of a subscription.
+func (h *Webhook) Deliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	deliveries, err := h.subscriber.Deliveries(r.Context(), id)
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to list deliveries")
+		return
+	}
+	response.OK(deliveries)
+}
+
+// Redeliver sends again a delivery from the log.
+func (h *Webhook) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	response := response.New(w)
+	delivery, err := h.subscriber.Redeliver(r.Context(), id)
+	if err != nil {
+		h.fail(r.Context(), response, err, "failed to redeliver")
+		return
+	}
+	response.Accepted(delivery)
+}
+
+func (h *Webhook) fail(ctx context.Context, response response.Response, err error, message string) {
+	if errors.Is(err, webhook.ErrNotFound) {
+		response.NotFound(err.Error())
+		return
+	}
+	if errors.Is(err, webhook.ErrPending) {
+		response.Conflict(err.Error())
+		return
+	}
+	h.log.With(log.Error(err)).ErrorContext(ctx, message)
+	response.InternalServer(message)
+}
+
+// Append appends all webhook routes into the router.
+func (h *Webhook) Append(mux *http.ServeMux) {
+	handle(mux, "POST /api/v1/webhooks", h.Subscribe, h.middleware)
+	handle(mux, "GET /api/v1/webhooks", h.Subscriptions, h.middleware)
+	handle(mux, "DELETE /api/v1/webhooks/{id}", h.Unsubscribe, h.middleware)
+	handle(mux, "GET /api/v1/webhooks/{id}/deliveries", h.Deliveries, h.middleware)
+	handle(mux, "POST /api/v1/webhooks/deliveries/{id}/redeliver", h.Redeliver, h.middleware)
+}
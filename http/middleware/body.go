@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/hoenirvili/axiogate/http/response"
+)
+
+// MaxBodySize rejects the requests with a body larger than n bytes. Bodies
+// of an unknown size are cut at n bytes so reading them past it fails.
+func MaxBodySize(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				response.New(w).RequestEntityTooLargef("request body larger than %d bytes", n)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures which cross origin requests are allowed.
+type CORSConfig struct {
+	// AllowedOrigins holds the allowed origins, "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, POST, PUT and DELETE.
+	AllowedMethods []string
+	// AllowedHeaders defaults to Content-Type, Authorization and X-Request-ID.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers the browser can read.
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and authorization headers.
+	AllowCredentials bool
+	// MaxAge is how long the preflight response can be cached.
+	MaxAge time.Duration
+}
+
+// CORS answers the preflight requests and sets the cross origin
+// headers on the requests coming from an allowed origin.
+func CORS(config CORSConfig) Middleware {
+	if len(config.AllowedMethods) == 0 {
+		config.AllowedMethods = []string{
+			http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete,
+		}
+	}
+	if len(config.AllowedHeaders) == 0 {
+		config.AllowedHeaders = []string{"Content-Type", "Authorization", "X-Request-ID"}
+	}
+	anyOrigin := slices.Contains(config.AllowedOrigins, "*")
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	exposed := strings.Join(config.ExposedHeaders, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if !anyOrigin && !slices.Contains(config.AllowedOrigins, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if anyOrigin && !config.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if config.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			preflight := r.Method == http.MethodOptions &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+			if !preflight {
+				if exposed != "" {
+					h.Set("Access-Control-Expose-Headers", exposed)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			if config.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
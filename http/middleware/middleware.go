@@ -0,0 +1,15 @@
+// Package middleware holds the http middleware used by the server and routes.
+package middleware
+
+import "net/http"
+
+// Middleware wraps a handler with additional behaviour.
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps the handler with the middleware, the first one being the outermost.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
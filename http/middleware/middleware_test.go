@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/log"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		order = append(order, "handler")
+	}), mw("first"), mw("second"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, []string{"first", "second", "handler"}, order)
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		code    int
+		body    string
+		aborted bool
+	}{
+		{
+			name:    "panic before writing",
+			handler: func(http.ResponseWriter, *http.Request) { panic("boom") },
+			code:    http.StatusInternalServerError,
+			body:    `{"error":"internal server error"}` + "\n",
+		},
+		{
+			name: "panic after writing",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				panic("boom")
+			},
+			code:    http.StatusOK,
+			aborted: true,
+		},
+		{
+			name:    "no panic",
+			handler: func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusCreated) },
+			code:    http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serve := func() {
+				Recover(log.Noop())(tt.handler).
+					ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+			if tt.aborted {
+				assert.PanicsWithValue(t, http.ErrAbortHandler, serve)
+			} else {
+				assert.NotPanics(t, serve)
+			}
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	h := AccessLog(logger)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("done"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api?x=1", nil))
+
+	line := map[string]any{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "POST", line["method"])
+	assert.Equal(t, "/api", line["path"])
+	assert.Equal(t, "x=1", line["query"])
+	assert.Equal(t, 202.0, line["status"])
+	assert.Equal(t, 4.0, line["bytes"])
+}
+
+func TestMaxBodySize(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		unknownLength bool
+		code          int
+	}{
+		{name: "under the limit", body: "1234", code: http.StatusOK},
+		{name: "over the limit", body: "123456", code: http.StatusRequestEntityTooLarge},
+		{name: "over the limit with unknown length", body: "123456", unknownLength: true, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := MaxBodySize(5)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, err := io.ReadAll(r.Body); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.unknownLength {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    CORSConfig
+		method    string
+		origin    string
+		preflight bool
+		code      int
+		headers   map[string]string
+	}{
+		{
+			name:   "allowed origin",
+			config: CORSConfig{AllowedOrigins: []string{"https://app.example"}, ExposedHeaders: []string{"X-Request-ID"}},
+			method: http.MethodPost,
+			origin: "https://app.example",
+			code:   http.StatusOK,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example",
+				"Access-Control-Expose-Headers": "X-Request-ID",
+			},
+		},
+		{
+			name:    "disallowed origin",
+			config:  CORSConfig{AllowedOrigins: []string{"https://app.example"}},
+			method:  http.MethodPost,
+			origin:  "https://evil.example",
+			code:    http.StatusOK,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:      "preflight",
+			config:    CORSConfig{AllowedOrigins: []string{"*"}, MaxAge: time.Minute},
+			method:    http.MethodOptions,
+			origin:    "https://app.example",
+			preflight: true,
+			code:      http.StatusNoContent,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET, POST, PUT, DELETE",
+				"Access-Control-Allow-Headers": "Content-Type, Authorization, X-Request-ID",
+				"Access-Control-Max-Age":       "60",
+			},
+		},
+		{
+			name:   "credentials echo the origin",
+			config: CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method: http.MethodGet,
+			origin: "https://app.example",
+			code:   http.StatusOK,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := CORS(tt.config)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+			for k, v := range tt.headers {
+				assert.Equal(t, v, w.Header().Get(k), k)
+			}
+		})
+	}
+}
+
+func TestGzip(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		code     int
+		encoding string
+	}{
+		{name: "compressed", accept: "gzip, deflate", code: http.StatusOK, encoding: "gzip"},
+		{name: "not accepted", code: http.StatusOK},
+		{name: "no content", accept: "gzip", code: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat(`{"ok":true}`, 10)
+			h := Gzip()(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.code)
+				if tt.code != http.StatusNoContent {
+					_, _ = w.Write([]byte(body))
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"))
+			switch {
+			case tt.code == http.StatusNoContent:
+				assert.Empty(t, w.Body.Bytes())
+			case tt.encoding == "gzip":
+				gz, err := gzip.NewReader(w.Body)
+				require.NoError(t, err)
+				got, err := io.ReadAll(gz)
+				require.NoError(t, err)
+				assert.Equal(t, body, string(got))
+			default:
+				assert.Equal(t, body, w.Body.String())
+			}
+		})
+	}
+}
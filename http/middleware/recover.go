@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// Recover turns a panic in the handler into a json 500 response.
+// If the handler already started writing the response the
+// connection is aborted instead, as the status can't be changed.
+func Recover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &recorder{ResponseWriter: w, code: http.StatusOK}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logger.With(
+					log.Error(fmt.Errorf("%v", v)),
+					slog.String("stack", string(debug.Stack())),
+				).ErrorContext(r.Context(), "Recovered from panic")
+				if rec.wroteHeader {
+					panic(http.ErrAbortHandler)
+				}
+				response.New(w).InternalServer("internal server error")
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hoenirvili/axiogate/requestid"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		generate bool
+	}{
+		{name: "accepted from the caller", header: "abc-123"},
+		{name: "generated when missing", generate: true},
+		{name: "generated when invalid", header: "bad id\n", generate: true},
+		{name: "generated when too long", header: strings.Repeat("a", 129), generate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = requestid.From(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(requestid.Header, tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if tt.generate {
+				assert.Len(t, got, 32)
+			} else {
+				assert.Equal(t, tt.header, got)
+			}
+			assert.Equal(t, got, w.Header().Get(requestid.Header))
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Exchange is a single call made to a provider, as sent and as answered,
+// the bodies byte for byte and the headers with the credentials redacted.
+type Exchange struct {
+	// Shipment is the id of the shipment the call was made for, empty for the quotes.
+	Shipment  string `json:"shipment,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	Endpoint  string `json:"endpoint"`
+	// Attempt counts the calls made to the endpoint, the retries start at 2.
+	Attempt        int         `json:"attempt"`
+	RequestHeaders http.Header `json:"requestHeaders"`
+	Request        []byte      `json:"-"`
+	// Status is zero if the provider never answered.
+	Status          int         `json:"status,omitempty"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+	Response        []byte      `json:"-"`
+	Error           string      `json:"error,omitempty"`
+	StartedAt       time.Time   `json:"startedAt"`
+	FinishedAt      time.Time   `json:"finishedAt"`
+	// RequestSHA256 and ResponseSHA256 are the hex digests the bodies were
+	// audited with, empty for the bodies audited before the digests.
+	RequestSHA256  string `json:"requestSha256,omitempty"`
+	ResponseSHA256 string `json:"responseSha256,omitempty"`
+}
+
+// Digest returns the hex encoded sha256 of the body, empty if there's no body.
+func Digest(body []byte) string {
+	if body == nil {
+		return ""
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrDigestMismatch is returned when an audited body is not
+// the one its digest was computed on when it was audited.
+type ErrDigestMismatch struct {
+	Endpoint string
+	Attempt  int
+	Body     string
+}
+
+var _ error = (*ErrDigestMismatch)(nil)
+
+func (e *ErrDigestMismatch) Error() string {
+	return fmt.Sprintf("the %s of attempt %d to %s doesn't match its digest", e.Body, e.Attempt, e.Endpoint)
+}
+
+// Verify checks the bodies against the digests they were audited with,
+// the bodies without a digest, or gone, can't be checked.
+func (e Exchange) Verify() error {
+	for _, b := range []struct {
+		name   string
+		body   []byte
+		digest string
+	}{{"request", e.Request, e.RequestSHA256}, {"response", e.Response, e.ResponseSHA256}} {
+		if b.body != nil && b.digest != "" && Digest(b.body) != b.digest {
+			return &ErrDigestMismatch{Endpoint: e.Endpoint, Attempt: e.Attempt, Body: b.name}
+		}
+	}
+	return nil
+}
+
+// Auditor records the exchanges with the providers.
+type Auditor interface {
+	Audit(ctx context.Context, e Exchange) error
+}
+
+// Redacted replaces the values of the headers and the body keys holding credentials.
+const Redacted = "REDACTED"
+
+// sensitive are the header names, or parts of them, holding credentials.
+var sensitive = []string{"authorization", "cookie", "token", "secret", "password", "key", "signature"}
+
+// Redact returns a copy of the headers with the credentials redacted.
+func Redact(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for name, values := range h {
+		lower := strings.ToLower(name)
+		redacted := false
+		for _, s := range sensitive {
+			if strings.Contains(lower, s) {
+				redacted = true
+				break
+			}
+		}
+		if !redacted {
+			out[name] = append([]string(nil), values...)
+			continue
+		}
+		out[name] = make([]string, len(values))
+		for i := range values {
+			out[name][i] = Redacted
+		}
+	}
+	return out
+}
+
+// credentials are the body keys, or parts of them, holding the
+// credentials the providers are called with.
+var credentials = []string{"username", "password", "account", "secret", "token", "apikey", "api_key", "signature", "authorization"}
+
+// credential reports if the body key holds a credential.
+func credential(key string) bool {
+	lower := strings.ToLower(key)
+	for _, c := range credentials {
+		if strings.Contains(lower, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactBody returns the json body with the values of the keys holding
+// credentials redacted, the objects and the arrays are redacted deeply.
+// A body that isn't json, or holds no credentials, is returned as is.
+func RedactBody(body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// The numbers are kept as they are, the long references don't fit a float.
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	if !redact(v) {
+		return body
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// redact redacts the credentials in place and reports if it found any.
+func redact(v any) bool {
+	found := false
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if value != nil && credential(key) {
+				// The accounts are objects for some providers, they're redacted whole.
+				v[key], found = Redacted, true
+				continue
+			}
+			switch value.(type) {
+			case map[string]any, []any:
+				found = redact(value) || found
+			}
+		}
+	case []any:
+		for _, value := range v {
+			found = redact(value) || found
+		}
+	}
+	return found
+}
+
+type shipmentKey struct{}
+
+// WithShipment returns a copy of the context carrying the id of the
+// shipment the calls are made for, the exchanges are recorded under it.
+func WithShipment(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, shipmentKey{}, id)
+}
+
+// Shipment returns the shipment id from the context or empty if there is none.
+func Shipment(ctx context.Context) string {
+	id, _ := ctx.Value(shipmentKey{}).(string)
+	return id
+}
@@ -0,0 +1,63 @@
+package request
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer answers with the code and the body it was sent.
+func echoServer(t *testing.T, code int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(code)
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient_DoPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload any
+		want    string
+	}{
+		{name: "raw bytes are sent as they are", payload: []byte(`{"weight":1}`), want: `{"weight":1}`},
+		{name: "values are json encoded", payload: map[string]int{"weight": 1}, want: `{"weight":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := echoServer(t, http.StatusOK)
+			got, err := NewClient(srv.Client()).Do(context.Background(), srv.URL, tt.payload)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestClient_DoStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		wantErr error
+	}{
+		{name: "created", code: http.StatusCreated},
+		{name: "rejected", code: http.StatusBadRequest, wantErr: &StatusError{Code: http.StatusBadRequest}},
+		{name: "failed", code: http.StatusBadGateway, wantErr: &StatusError{Code: http.StatusBadGateway}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := echoServer(t, tt.code)
+			got, err := NewClient(srv.Client()).Do(context.Background(), srv.URL, map[string]string{"awb": "1"})
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, `{"awb":"1"}`, string(got), "the body is returned with the error")
+		})
+	}
+}
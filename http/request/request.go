@@ -4,37 +4,185 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"time"
+
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/requestid"
 )
 
+// Limiter holds the calls back so they stay within the rate the providers accept.
+type Limiter interface {
+	// Wait blocks until a call to the endpoint can be made, it fails
+	// if the call can't be made without going over the rate.
+	Wait(ctx context.Context, to string) error
+}
+
 type Client struct {
-	cli *http.Client
+	cli     *http.Client
+	retries int
+	backoff time.Duration
+	auditor Auditor
+	limiter Limiter
+	log     *slog.Logger
+}
+
+type Option func(c *Client)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(c *Client) {
+		c.log = log.WithGroup("request")
+	}
+}
+
+// WithRetries retries up to n times the calls the provider didn't process,
+// the ones answered with 429 or 503 and the ones that couldn't connect.
+// The first retry waits backoff, each next one twice as long.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries, c.backoff = n, backoff
+	}
+}
+
+// WithAuditor sets who records every exchange with the providers, retries included.
+func WithAuditor(a Auditor) Option {
+	return func(c *Client) {
+		c.auditor = a
+	}
+}
+
+// WithLimiter sets who holds back every call, retries included.
+func WithLimiter(l Limiter) Option {
+	return func(c *Client) {
+		c.limiter = l
+	}
+}
+
+func NewClient(cli *http.Client, options ...Option) *Client {
+	c := &Client{
+		cli: cli,
+		log: log.Noop(),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// StatusError is returned when the provider answers with a non 2xx status code.
+type StatusError struct {
+	Code int
 }
 
-func NewClient(cli *http.Client) *Client {
-	return &Client{cli: cli}
+var _ error = (*StatusError)(nil)
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.Code)
 }
 
+// Do posts the payload to the endpoint and returns the response body.
+// A payload of raw bytes is sent as it is, anything else is json encoded.
+// The request id found in the context is forwarded to the provider.
+// On a non 2xx response the body is returned alongside a *StatusError.
 func (c *Client) Do(ctx context.Context, to string, payload any) ([]byte, error) {
-	data, err := json.Marshal(payload)
-	if err != nil {
+	data, ok := payload.([]byte)
+	if !ok {
+		var err error
+		data, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := c.wait(ctx, to); err != nil {
 		return nil, err
 	}
+	for attempt := 1; ; attempt++ {
+		b, retry, err := c.send(ctx, to, data, attempt)
+		if !retry || attempt > c.retries {
+			return b, err
+		}
+		wait := c.backoff << (attempt - 1)
+		c.log.With(log.Error(err), slog.String("to", to), slog.Int("attempt", attempt), slog.Duration("wait", wait)).
+			WarnContext(ctx, "Provider didn't process the call, retrying")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return b, err
+		}
+		// A retry that can't be made within the rate ends with the last answer.
+		if limitErr := c.wait(ctx, to); limitErr != nil {
+			c.log.With(log.Error(limitErr), slog.String("to", to)).WarnContext(ctx, "Retry held back by the provider rate")
+			return b, err
+		}
+	}
+}
 
+// wait holds the call back until it can be made within the provider rate.
+func (c *Client) wait(ctx context.Context, to string) error {
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.Wait(ctx, to)
+}
+
+// send makes a single call, it reports if the call can be retried.
+func (c *Client) send(ctx context.Context, to string, data []byte, attempt int) ([]byte, bool, error) {
 	req, err := http.NewRequestWithContext(ctx, "POST", to, bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if id := requestid.From(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+	e := Exchange{
+		Shipment:       Shipment(ctx),
+		RequestID:      requestid.From(ctx),
+		Endpoint:       to,
+		Attempt:        attempt,
+		RequestHeaders: Redact(req.Header),
+		Request:        data,
+		StartedAt:      time.Now(),
+	}
 	resp, err := c.cli.Do(req)
 	if err != nil {
-		return nil, err
+		c.audit(ctx, e, err)
+		var op *net.OpError
+		return nil, errors.As(err, &op) && op.Op == "dial", err
 	}
+	defer resp.Body.Close()
 	b, err := io.ReadAll(resp.Body)
+	e.Status, e.ResponseHeaders, e.Response = resp.StatusCode, Redact(resp.Header), b
 	if err != nil {
-		return nil, err
+		c.audit(ctx, e, err)
+		return nil, false, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := &StatusError{Code: resp.StatusCode}
+		c.audit(ctx, e, err)
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		return b, retry, err
+	}
+	c.audit(ctx, e, nil)
+	return b, false, nil
+}
+
+// audit records the exchange, a failure to record it doesn't fail the call.
+func (c *Client) audit(ctx context.Context, e Exchange, err error) {
+	if c.auditor == nil {
+		return
+	}
+	e.FinishedAt = time.Now()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	// The exchange is recorded even if the caller went away, it happened.
+	if err := c.auditor.Audit(context.WithoutCancel(ctx), e); err != nil {
+		c.log.With(log.Error(err), slog.String("to", e.Endpoint)).ErrorContext(ctx, "Failed to audit the provider exchange")
 	}
-	defer resp.Body.Close()
-	return b, nil
 }
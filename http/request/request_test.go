@@ -0,0 +1,232 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/requestid"
+)
+
+type auditor struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+func (a *auditor) Audit(_ context.Context, e Exchange) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.exchanges = append(a.exchanges, e)
+	return nil
+}
+
+// provider answers the calls with the codes in order, then with the last one.
+func provider(t *testing.T, codes ...int) *httptest.Server {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		code := codes[min(calls, len(codes)-1)]
+		calls++
+		mu.Unlock()
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(http.StatusText(code)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient_Do(t *testing.T) {
+	tests := []struct {
+		name     string
+		codes    []int
+		retries  int
+		wantErr  error
+		attempts []int
+	}{
+		{name: "success", codes: []int{200}, retries: 2, attempts: []int{200}},
+		{name: "retried until success", codes: []int{503, 429, 201}, retries: 2, attempts: []int{503, 429, 201}},
+		{name: "retries exhausted", codes: []int{503}, retries: 2, wantErr: &StatusError{Code: 503}, attempts: []int{503, 503, 503}},
+		{name: "rejection not retried", codes: []int{400}, retries: 2, wantErr: &StatusError{Code: 400}, attempts: []int{400}},
+		{name: "maybe processed not retried", codes: []int{502}, retries: 2, wantErr: &StatusError{Code: 502}, attempts: []int{502}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := provider(t, tt.codes...)
+			audit := new(auditor)
+			c := NewClient(srv.Client(), WithRetries(tt.retries, 0), WithAuditor(audit))
+
+			ctx := WithShipment(requestid.With(context.Background(), "req-1"), "ship-1")
+			_, err := c.Do(ctx, srv.URL, map[string]string{"name": "Jane"})
+			assert.Equal(t, tt.wantErr, err)
+
+			require.Len(t, audit.exchanges, len(tt.attempts))
+			for i, e := range audit.exchanges {
+				assert.Equal(t, i+1, e.Attempt)
+				assert.Equal(t, tt.attempts[i], e.Status)
+				assert.Equal(t, "ship-1", e.Shipment)
+				assert.Equal(t, "req-1", e.RequestID)
+				assert.Equal(t, srv.URL, e.Endpoint)
+				assert.Equal(t, `{"name":"Jane"}`, string(e.Request))
+				assert.NotEmpty(t, e.Response)
+				assert.Equal(t, []string{Redacted}, e.ResponseHeaders["Set-Cookie"])
+				assert.False(t, e.FinishedAt.Before(e.StartedAt))
+			}
+		})
+	}
+}
+
+// limiter lets through the first n calls, then refuses the rest.
+type limiter struct {
+	n     int
+	waits int
+}
+
+func (l *limiter) Wait(context.Context, string) error {
+	l.waits++
+	if l.waits > l.n {
+		return errors.New("rate limited")
+	}
+	return nil
+}
+
+func TestClient_DoLimited(t *testing.T) {
+	srv := provider(t, 503, 503, 200)
+	audit := new(auditor)
+	l := &limiter{n: 2}
+	c := NewClient(srv.Client(), WithRetries(2, 0), WithAuditor(audit), WithLimiter(l))
+
+	_, err := c.Do(context.Background(), srv.URL, []byte(`{}`))
+	assert.Equal(t, &StatusError{Code: 503}, err, "the retry held back ends with the last answer")
+	assert.Equal(t, 3, l.waits, "every attempt waits on the limiter")
+	assert.Len(t, audit.exchanges, 2)
+
+	l = &limiter{}
+	c = NewClient(srv.Client(), WithLimiter(l))
+	_, err = c.Do(context.Background(), srv.URL, []byte(`{}`))
+	assert.EqualError(t, err, "rate limited")
+}
+
+func TestClient_DoUnreachable(t *testing.T) {
+	srv := provider(t, 200)
+	srv.Close()
+	audit := new(auditor)
+	c := NewClient(srv.Client(), WithRetries(1, 0), WithAuditor(audit))
+
+	_, err := c.Do(context.Background(), srv.URL, []byte(`{}`))
+	assert.Error(t, err)
+	require.Len(t, audit.exchanges, 2, "the calls that couldn't connect are retried")
+	assert.Zero(t, audit.exchanges[1].Status)
+	assert.NotEmpty(t, audit.exchanges[1].Error)
+}
+
+func TestRedact(t *testing.T) {
+	h := http.Header{
+		"Authorization": {"Bearer abc"},
+		"X-Api-Key":     {"k1", "k2"},
+		"Content-Type":  {"application/json"},
+	}
+	assert.Equal(t, http.Header{
+		"Authorization": {Redacted},
+		"X-Api-Key":     {Redacted, Redacted},
+		"Content-Type":  {"application/json"},
+	}, Redact(h))
+	assert.Equal(t, "Bearer abc", h.Get("Authorization"), "the headers are copied")
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		redacted bool
+	}{
+		{
+			name:     "credentials",
+			redacted: true,
+			body:     `{"UserName":"u1","Password":"p1","AccountNo":"123","ShipperRefNo":12345678901234567,"Origin":"CLJ"}`,
+			want:     `{"UserName":"REDACTED","Password":"REDACTED","AccountNo":"REDACTED","ShipperRefNo":12345678901234567,"Origin":"CLJ"}`,
+		},
+		{
+			name:     "account object",
+			redacted: true,
+			body:     `{"account":{"number":123},"weight":1}`,
+			want:     `{"account":"REDACTED","weight":1}`,
+		},
+		{
+			name:     "nested credentials",
+			redacted: true,
+			body:     `{"auth":{"apiKey":"k1","token":null},"items":[{"secret":"s1"}]}`,
+			want:     `{"auth":{"apiKey":"REDACTED","token":null},"items":[{"secret":"REDACTED"}]}`,
+		},
+		{
+			name: "no credentials is kept byte for byte",
+			body: `{ "trackingNumber": "T1" }`,
+			want: `{ "trackingNumber": "T1" }`,
+		},
+		{
+			name: "not json",
+			body: `user=u1&password=p1`,
+			want: `user=u1&password=p1`,
+		},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RedactBody([]byte(tt.body))
+			if tt.redacted {
+				assert.JSONEq(t, tt.want, string(got))
+				return
+			}
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestExchange_Verify(t *testing.T) {
+	audited := Exchange{
+		Endpoint:       "http://b/v1/b",
+		Attempt:        2,
+		Request:        []byte(`{"name":"Jane"}`),
+		Response:       []byte("busy"),
+		RequestSHA256:  Digest([]byte(`{"name":"Jane"}`)),
+		ResponseSHA256: Digest([]byte("busy")),
+	}
+	tests := []struct {
+		name    string
+		change  func(e *Exchange)
+		wantErr error
+	}{
+		{name: "as audited", change: func(*Exchange) {}},
+		{name: "without digests", change: func(e *Exchange) { e.RequestSHA256, e.ResponseSHA256 = "", "" }},
+		{name: "bodies gone", change: func(e *Exchange) { e.Request, e.Response = nil, nil }},
+		{
+			name:    "request swapped",
+			change:  func(e *Exchange) { e.Request = []byte(`{"name":"John"}`) },
+			wantErr: &ErrDigestMismatch{Endpoint: "http://b/v1/b", Attempt: 2, Body: "request"},
+		},
+		{
+			name:    "response swapped",
+			change:  func(e *Exchange) { e.Response = []byte("booked") },
+			wantErr: &ErrDigestMismatch{Endpoint: "http://b/v1/b", Attempt: 2, Body: "response"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := audited
+			tt.change(&e)
+			assert.Equal(t, tt.wantErr, e.Verify())
+		})
+	}
+	assert.Empty(t, Digest(nil))
+}
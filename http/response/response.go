@@ -3,7 +3,10 @@ package response
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type Response struct {
@@ -24,6 +27,20 @@ func (r Response) Created(payload any) {
 	r.write(payload)
 }
 
+func (r Response) Accepted(payload any) {
+	r.w.WriteHeader(http.StatusAccepted)
+	r.write(payload)
+}
+
+func (r Response) ServiceUnavailable(payload any) {
+	r.w.WriteHeader(http.StatusServiceUnavailable)
+	r.write(payload)
+}
+
+func (r Response) NoContent() {
+	r.w.WriteHeader(http.StatusNoContent)
+}
+
 func (r Response) write(payload any) {
 	if err := json.NewEncoder(r.w).
 		Encode(payload); err != nil {
@@ -64,3 +81,32 @@ func (r Response) BadRequestf(format string, a ...any) {
 	r.w.WriteHeader(http.StatusBadRequest)
 	r.write(&Error{Error: fmt.Sprintf(format, a...)})
 }
+
+func (r Response) Conflict(message string) {
+	r.w.WriteHeader(http.StatusConflict)
+	r.write(&Error{Error: message})
+}
+
+func (r Response) RequestEntityTooLargef(format string, a ...any) {
+	r.w.WriteHeader(http.StatusRequestEntityTooLarge)
+	r.write(&Error{Error: fmt.Sprintf(format, a...)})
+}
+
+func (r Response) Unauthorized(message string) {
+	r.w.WriteHeader(http.StatusUnauthorized)
+	r.write(&Error{Error: message})
+}
+
+func (r Response) Forbidden(message string) {
+	r.w.WriteHeader(http.StatusForbidden)
+	r.write(&Error{Error: message})
+}
+
+// TooManyRequests tells the caller to slow down and when to retry,
+// the retry after is rounded up to whole seconds.
+func (r Response) TooManyRequests(retryAfter time.Duration, message string) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	r.w.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+	r.w.WriteHeader(http.StatusTooManyRequests)
+	r.write(&Error{Error: message})
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/hoenirvili/axiogate/http/middleware"
 	"github.com/hoenirvili/axiogate/log"
 )
 
@@ -17,9 +18,13 @@ type sig struct {
 }
 
 type Server struct {
-	s   http.Server
-	sig sig
-	log *slog.Logger
+	s               http.Server
+	sig             sig
+	log             *slog.Logger
+	middleware      []middleware.Middleware
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+	onShutdown      []func()
 }
 
 type Option func(s *Server)
@@ -37,16 +42,54 @@ func WithLogger(log *slog.Logger) Option {
 	}
 }
 
-const port = 8080
+// WithMiddleware wraps all routes with the middleware,
+// the first one being the outermost.
+func WithMiddleware(mw ...middleware.Middleware) Option {
+	return func(s *Server) {
+		s.middleware = append(s.middleware, mw...)
+	}
+}
+
+// WithAddr sets the address the server listens on.
+func WithAddr(addr string) Option {
+	return func(s *Server) {
+		s.s.Addr = addr
+	}
+}
+
+// WithShutdownTimeout sets how long the in flight requests
+// are waited on shutdown before the server is closed.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownTimeout = timeout
+	}
+}
+
+// WithOnShutdown registers functions called as soon as the shutdown starts,
+// before the server stops accepting requests.
+func WithOnShutdown(fn ...func()) Option {
+	return func(s *Server) {
+		s.onShutdown = append(s.onShutdown, fn...)
+	}
+}
+
+// WithDrainDelay sets how long the server keeps accepting requests after the
+// shutdown starts, giving the load balancers time to see it isn't ready anymore.
+func WithDrainDelay(delay time.Duration) Option {
+	return func(s *Server) {
+		s.drainDelay = delay
+	}
+}
 
 // NewServer is an http server that serves the static files
 // the spa app and has the rest api.
 func NewServer(options ...Option) *Server {
 	s := &Server{
 		s: http.Server{
-			Addr: fmt.Sprintf(":%d", port),
+			Addr: ":8080",
 		},
-		log: log.Noop(),
+		log:             log.Noop(),
+		shutdownTimeout: 10 * time.Second,
 		sig: sig{
 			ctx:  context.Background(),
 			stop: func() {},
@@ -70,7 +113,7 @@ func (s *Server) Routes(routes ...Route) {
 	for _, route := range routes {
 		route.Append(mux)
 	}
-	s.s.Handler = mux
+	s.s.Handler = middleware.Chain(mux, s.middleware...)
 }
 
 func (s *Server) start() chan error {
@@ -79,10 +122,7 @@ func (s *Server) start() chan error {
 	go func() {
 		defer close(errs)
 		var err error
-		s.log.With(
-			slog.Int("port", port),
-			slog.String("addr", "127.0.0.1"),
-		).Info("Http server start")
+		s.log.With(slog.String("addr", s.s.Addr)).Info("Http server start")
 		if err = s.s.ListenAndServe(); err != nil &&
 			!errors.Is(err, http.ErrServerClosed) {
 			errs <- fmt.Errorf("failed to start server, %w", err)
@@ -103,9 +143,17 @@ func Start(s *Server) error {
 		return err
 	}
 
+	for _, fn := range s.onShutdown {
+		fn()
+	}
+	if s.drainDelay > 0 {
+		s.log.With(slog.Duration("delay", s.drainDelay)).Info("Draining before closing")
+		time.Sleep(s.drainDelay)
+	}
+
 	ctx, cancel := context.WithTimeout(
 		context.Background(),
-		10*time.Second,
+		s.shutdownTimeout,
 	)
 	defer cancel()
 
@@ -0,0 +1,83 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"github.com/hoenirvili/axiogate/requestid"
+)
+
+// Handler wraps a slog.Handler adding the request id
+// found in the context to every record, at the top level
+// even if the logger was grouped.
+type Handler struct {
+	// next has the attrs added before any group.
+	next slog.Handler
+	// groups are opened on the handler itself so the request
+	// id can still be added outside of them, each one keeps
+	// the attrs added while it was the innermost.
+	groups []group
+}
+
+type group struct {
+	name  string
+	attrs []slog.Attr
+}
+
+var _ slog.Handler = (*Handler)(nil)
+
+// NewHandler returns a new handler wrapping next.
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{next: next}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	id := requestid.From(ctx)
+	if len(h.groups) == 0 {
+		if id != "" {
+			record = record.Clone()
+			record.AddAttrs(slog.String("request_id", id))
+		}
+		return h.next.Handle(ctx, record)
+	}
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		g := h.groups[i]
+		attrs = []slog.Attr{slog.GroupAttrs(g.name, slices.Concat(g.attrs, attrs)...)}
+	}
+	out := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	if id != "" {
+		out.AddAttrs(slog.String("request_id", id))
+	}
+	out.AddAttrs(attrs...)
+	return h.next.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	if len(h.groups) == 0 {
+		return &Handler{next: h.next.WithAttrs(attrs)}
+	}
+	groups := slices.Clone(h.groups)
+	last := &groups[len(groups)-1]
+	last.attrs = slices.Concat(last.attrs, attrs)
+	return &Handler{next: h.next, groups: groups}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &Handler{next: h.next, groups: append(slices.Clip(h.groups), group{name: name})}
+}
@@ -0,0 +1,48 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/requestid"
+)
+
+func TestHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(NewHandler(slog.NewJSONHandler(buf, nil))).With(slog.String("component", "test")).WithGroup("group")
+
+	logger.InfoContext(requestid.With(context.Background(), "abc"), "with id", slog.Int("n", 1))
+	logger.InfoContext(context.Background(), "without id", slog.Int("n", 2))
+
+	dec := json.NewDecoder(buf)
+	var with, without map[string]any
+	require.NoError(t, dec.Decode(&with))
+	require.NoError(t, dec.Decode(&without))
+	assert.Equal(t, "abc", with["request_id"])
+	assert.Equal(t, "test", with["component"])
+	assert.Equal(t, map[string]any{"n": 1.0}, with["group"])
+	assert.NotContains(t, without, "request_id")
+	assert.Equal(t, map[string]any{"n": 2.0}, without["group"])
+}
+
+func TestHandler_NestedGroups(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(NewHandler(slog.NewJSONHandler(buf, nil))).
+		WithGroup("outer").With(slog.String("k", "v")).WithGroup("inner").WithGroup("empty")
+
+	logger.InfoContext(requestid.With(context.Background(), "abc"), "nested", slog.Int("n", 1))
+
+	var got map[string]any
+	require.NoError(t, json.NewDecoder(buf).Decode(&got))
+	assert.Equal(t, "abc", got["request_id"])
+	assert.Equal(t, map[string]any{
+		"k":     "v",
+		"inner": map[string]any{"empty": map[string]any{"n": 1.0}},
+	}, got["outer"])
+}
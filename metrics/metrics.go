@@ -0,0 +1,334 @@
+// Package metrics exposes prometheus metrics about the http routes,
+// the provider calls, the fan-out and the storage. It instruments the
+// service through middleware and decorators so the core stays free of it.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/ratelimit"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+const namespace = "axiogate"
+
+// Metrics holds all the collectors registered in its own registry.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+
+	providerRequests *prometheus.CounterVec
+	providerDuration *prometheus.HistogramVec
+
+	fanOutWidth  prometheus.Histogram
+	inFlightJobs prometheus.Gauge
+
+	storageDuration prometheus.Histogram
+	storageFailures prometheus.Counter
+
+	batchRows     prometheus.Histogram
+	flushDuration prometheus.Histogram
+	flushFailures prometheus.Counter
+
+	deadLetters       *prometheus.CounterVec
+	deadLetterBacklog prometheus.Gauge
+}
+
+// New creates and registers all the collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of http requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of the http requests by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		providerRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "provider",
+			Name:      "requests_total",
+			Help:      "Number of outbound provider calls by provider, status code and error class.",
+		}, []string{"provider", "code", "error"}),
+		providerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "provider",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of the outbound provider calls by provider.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"provider"}),
+		fanOutWidth: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "shipment",
+			Name:      "fanout_width",
+			Help:      "Number of providers a single shipment request fans out to.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 13),
+		}),
+		inFlightJobs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "shipment",
+			Name:      "inflight_jobs",
+			Help:      "Number of provider jobs currently running.",
+		}),
+		storageDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "write_duration_seconds",
+			Help:      "Latency of the shipment writes.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		storageFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "write_failures_total",
+			Help:      "Number of failed shipment writes.",
+		}),
+		batchRows: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "batch_rows",
+			Help:      "Number of shipment rows written by a single flush.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		flushDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "flush_duration_seconds",
+			Help:      "Latency of the batched shipment writes.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		flushFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "flush_failures_total",
+			Help:      "Number of failed batched shipment writes.",
+		}),
+		deadLetters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "deadletter",
+			Name:      "letters_total",
+			Help:      "Number of provider calls dead-lettered by provider.",
+		}, []string{"provider"}),
+		deadLetterBacklog: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "deadletter",
+			Name:      "backlog",
+			Help:      "Number of dead letters waiting to be requeued or discarded.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.providerRequests,
+		m.providerDuration,
+		m.fanOutWidth,
+		m.inFlightJobs,
+		m.storageDuration,
+		m.storageFailures,
+		m.batchRows,
+		m.flushDuration,
+		m.flushFailures,
+		m.deadLetters,
+		m.deadLetterBacklog,
+	)
+	return m
+}
+
+// Registry returns the registry where all collectors live,
+// other packages can register their own collectors in it.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Append appends the metrics route into the router.
+func (m *Metrics) Append(mux *http.ServeMux) {
+	mux.Handle("GET /metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+type recorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *recorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Middleware counts and times the requests by their route pattern. It relies on
+// the pattern set by the mux on the request, so it should be the innermost
+// server middleware or otherwise be passed the same request the mux receives.
+func (m *Metrics) Middleware() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &recorder{ResponseWriter: w, code: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			route := r.Pattern
+			if route == "" {
+				route = "unmatched"
+			}
+			m.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.code)).Inc()
+			m.requestDuration.WithLabelValues(route, r.Method).
+				Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+var _ shipment.Observer = (*Metrics)(nil)
+
+// FanOut records the width of a fan-out.
+func (m *Metrics) FanOut(_ context.Context, width int) {
+	m.fanOutWidth.Observe(float64(width))
+}
+
+// Job tracks the number of in flight provider jobs.
+func (m *Metrics) Job(ctx context.Context, _ string) (context.Context, func()) {
+	m.inFlightJobs.Inc()
+	return ctx, m.inFlightJobs.Dec
+}
+
+// Client decorates the provider client with latency, status code and error class metrics.
+type Client struct {
+	next      shipment.Client
+	metrics   *Metrics
+	providers map[string]shipment.Payloader
+}
+
+var _ shipment.Client = (*Client)(nil)
+
+// NewClient returns a decorated client, the providers are used to
+// label each call with the provider owning the endpoint.
+func (m *Metrics) NewClient(next shipment.Client, providers map[string]shipment.Payloader) *Client {
+	return &Client{next: next, metrics: m, providers: providers}
+}
+
+func (c *Client) provider(to string) string {
+	if name, ok := shipment.Owner(c.providers, to); ok {
+		return name
+	}
+	return "unknown"
+}
+
+// class returns the status code and the error class of a provider call.
+func class(err error) (string, string) {
+	var (
+		status  *request.StatusError
+		limited *ratelimit.ErrRateLimited
+		netErr  net.Error
+	)
+	switch {
+	case err == nil:
+		return "2xx", "none"
+	case errors.As(err, &status):
+		return strconv.Itoa(status.Code), "status"
+	case errors.As(err, &limited):
+		return "none", "rate_limited"
+	case errors.Is(err, context.Canceled):
+		return "none", "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "none", "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "none", "timeout"
+	case errors.As(err, &netErr):
+		return "none", "network"
+	default:
+		return "none", "other"
+	}
+}
+
+func (c *Client) Do(ctx context.Context, to string, payload any) ([]byte, error) {
+	start := time.Now()
+	b, err := c.next.Do(ctx, to, payload)
+	provider := c.provider(to)
+	code, errClass := class(err)
+	c.metrics.providerRequests.WithLabelValues(provider, code, errClass).Inc()
+	c.metrics.providerDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	return b, err
+}
+
+// Storage decorates the shipment storage with write latency and failure metrics.
+type Storage struct {
+	next    shipment.Storage
+	metrics *Metrics
+}
+
+var _ shipment.Storage = (*Storage)(nil)
+
+// NewStorage returns a decorated storage.
+func (m *Metrics) NewStorage(next shipment.Storage) *Storage {
+	return &Storage{next: next, metrics: m}
+}
+
+func (s *Storage) Save(ctx context.Context, attempts []shipment.Attempt) error {
+	start := time.Now()
+	err := s.next.Save(ctx, attempts)
+	s.metrics.storageDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.metrics.storageFailures.Inc()
+	}
+	return err
+}
+
+// Flushed records the size and the latency of a batched shipment write.
+func (m *Metrics) Flushed(rows int, duration time.Duration, err error) {
+	m.batchRows.Observe(float64(rows))
+	m.flushDuration.Observe(duration.Seconds())
+	if err != nil {
+		m.flushFailures.Inc()
+	}
+}
+
+// DeadLetters decorates the dead letters with a counter by provider.
+type DeadLetters struct {
+	next    shipment.DeadLetters
+	metrics *Metrics
+}
+
+var _ shipment.DeadLetters = (*DeadLetters)(nil)
+
+// NewDeadLetters returns decorated dead letters.
+func (m *Metrics) NewDeadLetters(next shipment.DeadLetters) *DeadLetters {
+	return &DeadLetters{next: next, metrics: m}
+}
+
+func (d *DeadLetters) CreateDeadLetters(ctx context.Context, attempts []shipment.Attempt) error {
+	if err := d.next.CreateDeadLetters(ctx, attempts); err != nil {
+		return err
+	}
+	for _, a := range attempts {
+		d.metrics.deadLetters.WithLabelValues(a.Provider).Inc()
+	}
+	return nil
+}
+
+// DeadLetterBacklog records the number of dead letters waiting for an operator.
+func (m *Metrics) DeadLetterBacklog(n int) {
+	m.deadLetterBacklog.Set(float64(n))
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/ratelimit"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type endpoint string
+
+func (e endpoint) Payload(*api.ShippingRequest) []byte { return nil }
+
+func (e endpoint) To() string { return string(e) }
+
+type clientFunc func(ctx context.Context, to string, payload any) ([]byte, error)
+
+func (f clientFunc) Do(ctx context.Context, to string, payload any) ([]byte, error) {
+	return f(ctx, to, payload)
+}
+
+type storageFunc func(ctx context.Context, attempts []shipment.Attempt) error
+
+func (f storageFunc) Save(ctx context.Context, attempts []shipment.Attempt) error {
+	return f(ctx, attempts)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	h := m.Middleware()(mux)
+
+	for _, path := range []string{"/api/v1/things/1", "/api/v1/things/2", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("POST /api/v1/things/{id}", "POST", "201")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("unmatched", "POST", "404")))
+}
+
+func TestClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		to       string
+		err      error
+		provider string
+		code     string
+		errClass string
+	}{
+		{name: "success", to: "http://a/v1/a", provider: "a", code: "2xx", errClass: "none"},
+		{name: "quote endpoint", to: "http://a/v1/a/quote", provider: "a", code: "2xx", errClass: "none"},
+		{name: "status", to: "http://b/v1/b", err: &request.StatusError{Code: 503}, provider: "b", code: "503", errClass: "status"},
+		{name: "timeout", to: "http://b/v1/b", err: context.DeadlineExceeded, provider: "b", code: "none", errClass: "timeout"},
+		{name: "rate limited", to: "http://b/v1/b", err: &ratelimit.ErrRateLimited{Provider: "b"}, provider: "b", code: "none", errClass: "rate_limited"},
+		{name: "nested provider", to: "http://a/v1/a/nested/ship", provider: "nested", code: "2xx", errClass: "none"},
+		{name: "other", to: "http://c", err: errors.New("boom"), provider: "unknown", code: "none", errClass: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New()
+			cli := m.NewClient(clientFunc(func(context.Context, string, any) ([]byte, error) {
+				return nil, tt.err
+			}), map[string]shipment.Payloader{
+				"a":      endpoint("http://a/v1/a"),
+				"b":      endpoint("http://b/v1/b"),
+				"nested": endpoint("http://a/v1/a/nested"),
+			})
+			_, err := cli.Do(context.Background(), tt.to, nil)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, 1.0, testutil.ToFloat64(m.providerRequests.WithLabelValues(tt.provider, tt.code, tt.errClass)))
+		})
+	}
+}
+
+func TestStorage(t *testing.T) {
+	m := New()
+	fail := true
+	st := m.NewStorage(storageFunc(func(context.Context, []shipment.Attempt) error {
+		if fail {
+			return errors.New("db down")
+		}
+		return nil
+	}))
+	assert.Error(t, st.Save(context.Background(), nil))
+	fail = false
+	assert.NoError(t, st.Save(context.Background(), nil))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.storageFailures))
+}
+
+func TestObserver(t *testing.T) {
+	m := New()
+	m.FanOut(context.Background(), 3)
+	_, done1 := m.Job(context.Background(), "a")
+	_, done2 := m.Job(context.Background(), "b")
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.inFlightJobs))
+	done1()
+	done2()
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.inFlightJobs))
+}
+
+func TestFlushed(t *testing.T) {
+	m := New()
+	m.Flushed(40, 3*time.Millisecond, nil)
+	m.Flushed(2, time.Millisecond, errors.New("db down"))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.batchRows))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.flushFailures))
+}
+
+type deadLettersFunc func(ctx context.Context, attempts []shipment.Attempt) error
+
+func (f deadLettersFunc) CreateDeadLetters(ctx context.Context, attempts []shipment.Attempt) error {
+	return f(ctx, attempts)
+}
+
+func TestDeadLetters(t *testing.T) {
+	m := New()
+	dl := m.NewDeadLetters(deadLettersFunc(func(context.Context, []shipment.Attempt) error { return nil }))
+	assert.NoError(t, dl.CreateDeadLetters(context.Background(), []shipment.Attempt{{Provider: "a"}, {Provider: "b"}, {Provider: "a"}}))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.deadLetters.WithLabelValues("a")))
+
+	m.DeadLetterBacklog(7)
+	assert.Equal(t, 7.0, testutil.ToFloat64(m.deadLetterBacklog))
+}
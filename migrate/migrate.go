@@ -0,0 +1,302 @@
+// Package migrate applies the schema migrations, keeping track of them in the
+// schema_migrations table the same way golang-migrate does.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// ErrDirty is returned when a migration failed halfway and
+// the schema has to be fixed by hand before migrating again.
+type ErrDirty struct {
+	Version int64
+}
+
+var _ error = (*ErrDirty)(nil)
+
+func (e *ErrDirty) Error() string {
+	return fmt.Sprintf("migration %d failed and left the schema dirty", e.Version)
+}
+
+// Migration changes the schema from the previous version to its version.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+var file = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load reads the migrations from the files named <version>_<name>.up.sql
+// and <version>_<name>.down.sql, sorted by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations, %w", err)
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := file.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %s, %w", entry.Name(), err)
+		}
+		sql, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s, %w", entry.Name(), err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// lockID is the advisory lock held while migrating, so
+// replicas starting at the same time migrate one by one.
+const lockID = 7_140_812_455_017
+
+// Migrator applies the migrations to the database.
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+	log        *slog.Logger
+}
+
+type Option func(m *Migrator)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(m *Migrator) {
+		m.log = log.WithGroup("migrate")
+	}
+}
+
+// New returns a migrator applying the migrations found in fsys.
+func New(db *pgxpool.Pool, fsys fs.FS, options ...Option) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{
+		db:         db,
+		migrations: migrations,
+		log:        log.Noop(),
+	}
+	for _, option := range options {
+		option(m)
+	}
+	return m, nil
+}
+
+// Latest returns the version of the last migration, zero if there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version the schema is at, zero if no migration
+// was applied, and if the last migration failed halfway.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	return version(ctx, m.db)
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// undefinedTable is the postgres error code of a missing table.
+const undefinedTable = "42P01"
+
+func version(ctx context.Context, q querier) (int64, bool, error) {
+	var (
+		v     int64
+		dirty bool
+		pgErr *pgconn.PgError
+	)
+	err := q.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == undefinedTable) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to fetch the schema version, %w", err)
+	}
+	return v, dirty, nil
+}
+
+// Check reports an error if the schema isn't at the latest version.
+func (m *Migrator) Check(ctx context.Context) error {
+	v, dirty, err := m.Version(ctx)
+	switch {
+	case err != nil:
+		return err
+	case dirty:
+		return &ErrDirty{Version: v}
+	case v != m.Latest():
+		return fmt.Errorf("schema at version %d, expected %d", v, m.Latest())
+	}
+	return nil
+}
+
+// Status is a migration and whether it's applied.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Status returns all the migrations and which of them are applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	v, _, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status = append(status, Status{Migration: migration, Applied: migration.Version <= v})
+	}
+	return status, nil
+}
+
+// locked runs fn on a connection holding the migrations advisory lock.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire a connection, %w", err)
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("failed to take the migrations lock, %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID); err != nil {
+			m.log.With(log.Error(err)).ErrorContext(ctx, "Failed to release the migrations lock")
+		}
+	}()
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)`
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create the migrations table, %w", err)
+	}
+	return fn(conn)
+}
+
+// apply runs the sql and records the version in a single transaction,
+// if it fails the schema is left as it was.
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, sql string, to int64) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration, %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `TRUNCATE schema_migrations`); err != nil {
+		return fmt.Errorf("failed to clear the schema version, %w", err)
+	}
+	if to > 0 {
+		_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, to)
+		if err != nil {
+			return fmt.Errorf("failed to record the schema version, %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration, %w", err)
+	}
+	return nil
+}
+
+// Up applies all the migrations newer than the schema version.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *pgxpool.Conn) error {
+		v, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return &ErrDirty{Version: v}
+		}
+		for _, migration := range m.migrations {
+			if migration.Version <= v {
+				continue
+			}
+			l := m.log.With(slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+			if err := m.apply(ctx, conn, migration.Up, migration.Version); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s, %w", migration.Version, migration.Name, err)
+			}
+			l.InfoContext(ctx, "Migration applied")
+		}
+		return nil
+	})
+}
+
+// Down rolls back the last steps applied migrations, all of them if steps is negative.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(conn *pgxpool.Conn) error {
+		v, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return &ErrDirty{Version: v}
+		}
+		for i := len(m.migrations) - 1; i >= 0 && steps != 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version > v {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s can't be rolled back, it has no down file", migration.Version, migration.Name)
+			}
+			var previous int64
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+			l := m.log.With(slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+			if err := m.apply(ctx, conn, migration.Down, previous); err != nil {
+				return fmt.Errorf("failed to roll back migration %d_%s, %w", migration.Version, migration.Name, err)
+			}
+			l.InfoContext(ctx, "Migration rolled back")
+			steps--
+		}
+		return nil
+	})
+}
@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/migrations"
+	"github.com/hoenirvili/axiogate/storage/pgtest"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr string
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"2_b.up.sql":    {Data: []byte("up b")},
+				"2_b.down.sql":  {Data: []byte("down b")},
+				"1_a.up.sql":    {Data: []byte("up a")},
+				"migrations.go": {Data: []byte("package migrations")},
+			},
+			want: []Migration{
+				{Version: 1, Name: "a", Up: "up a"},
+				{Version: 2, Name: "b", Up: "up b", Down: "down b"},
+			},
+		},
+		{
+			name:    "missing up",
+			fsys:    fstest.MapFS{"1_a.down.sql": {Data: []byte("down a")}},
+			wantErr: "migration 1_a has no up file",
+		},
+		{
+			name: "two names",
+			fsys: fstest.MapFS{
+				"1_a.up.sql":   {Data: []byte("up a")},
+				"1_b.down.sql": {Data: []byte("down b")},
+			},
+			wantErr: "migration 1 has two names, a and b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLoad_Embedded(t *testing.T) {
+	got, err := Load(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, got)
+	for _, m := range got {
+		assert.NotEmpty(t, m.Down, "migration %d_%s has no down file", m.Version, m.Name)
+	}
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(pgtest.Run(m))
+}
+
+// testDB returns a pool on a fresh schema.
+func testDB(t *testing.T) *pgxpool.Pool {
+	return pgtest.DB(t)
+}
+
+func TestMigrator(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	m, err := New(db, migrations.FS)
+	require.NoError(t, err)
+
+	v, dirty, err := m.Version(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, v)
+	assert.False(t, dirty)
+	assert.Error(t, m.Check(ctx))
+
+	// Replicas starting together migrate one after the other.
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Go(func() { errs[i] = m.Up(ctx) })
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	require.NoError(t, m.Check(ctx))
+
+	require.NoError(t, m.Down(ctx, 1))
+	v, _, err = m.Version(ctx)
+	require.NoError(t, err)
+	assert.Less(t, v, m.Latest())
+
+	require.NoError(t, m.Down(ctx, -1))
+	status, err := m.Status(ctx)
+	require.NoError(t, err)
+	for _, s := range status {
+		assert.False(t, s.Applied)
+	}
+	require.NoError(t, m.Up(ctx))
+	require.NoError(t, m.Check(ctx))
+}
@@ -0,0 +1 @@
+DROP TABLE routing_rule;
@@ -0,0 +1,11 @@
+CREATE TABLE routing_rule (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    priority INT NOT NULL DEFAULT 0,
+    condition JSONB NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    providers TEXT[] NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,2 @@
+DROP INDEX shipment_request_id_idx;
+ALTER TABLE shipment DROP COLUMN request_id;
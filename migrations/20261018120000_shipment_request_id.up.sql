@@ -0,0 +1,2 @@
+ALTER TABLE shipment ADD COLUMN request_id VARCHAR(128);
+CREATE INDEX shipment_request_id_idx ON shipment (request_id);
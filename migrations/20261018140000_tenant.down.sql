@@ -0,0 +1,4 @@
+DROP INDEX shipment_tenant_idx;
+ALTER TABLE shipment DROP COLUMN created_at;
+ALTER TABLE shipment DROP COLUMN tenant_id;
+DROP TABLE tenant;
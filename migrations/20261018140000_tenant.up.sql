@@ -0,0 +1,13 @@
+CREATE TABLE tenant (
+    id VARCHAR(100) PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    providers TEXT[] NOT NULL,
+    credentials JSONB NOT NULL DEFAULT '{}',
+    rate_limit INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE shipment ADD COLUMN tenant_id VARCHAR(100);
+ALTER TABLE shipment ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX shipment_tenant_idx ON shipment (tenant_id, id DESC);
@@ -0,0 +1,6 @@
+CREATE TABLE quota_usage (
+    client VARCHAR(200) NOT NULL,
+    day DATE NOT NULL,
+    count INT NOT NULL,
+    PRIMARY KEY (client, day)
+);
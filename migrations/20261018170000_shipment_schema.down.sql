@@ -0,0 +1,21 @@
+DROP INDEX shipment_tenant_idx;
+DROP INDEX shipment_created_idx;
+DROP INDEX shipment_provider_created_idx;
+DROP INDEX shipment_group_idx;
+
+DELETE FROM shipment WHERE status <> 'booked' OR response IS NULL;
+ALTER TABLE shipment
+    DROP COLUMN updated_at,
+    DROP COLUMN request,
+    DROP COLUMN error,
+    DROP COLUMN http_status,
+    DROP COLUMN status,
+    DROP COLUMN endpoint,
+    DROP COLUMN group_id,
+    DROP COLUMN id;
+ALTER TABLE shipment RENAME COLUMN response TO payload;
+ALTER TABLE shipment ALTER COLUMN payload SET NOT NULL;
+ALTER TABLE shipment ADD COLUMN id SERIAL PRIMARY KEY;
+CREATE INDEX shipment_tenant_idx ON shipment (tenant_id, id DESC);
+
+DROP TYPE shipment_status;
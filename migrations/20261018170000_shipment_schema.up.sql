@@ -0,0 +1,24 @@
+CREATE TYPE shipment_status AS ENUM ('booked', 'failed', 'voided');
+
+DROP INDEX shipment_tenant_idx;
+ALTER TABLE shipment DROP COLUMN id;
+ALTER TABLE shipment ADD COLUMN id UUID PRIMARY KEY DEFAULT gen_random_uuid();
+ALTER TABLE shipment ADD COLUMN group_id UUID;
+UPDATE shipment SET group_id = id;
+ALTER TABLE shipment ALTER COLUMN group_id SET NOT NULL;
+
+ALTER TABLE shipment RENAME COLUMN payload TO response;
+ALTER TABLE shipment ALTER COLUMN response DROP NOT NULL;
+ALTER TABLE shipment
+    ADD COLUMN endpoint TEXT NOT NULL DEFAULT '',
+    ADD COLUMN status shipment_status NOT NULL DEFAULT 'booked',
+    ADD COLUMN http_status INT,
+    ADD COLUMN error TEXT,
+    ADD COLUMN request JSONB,
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE shipment ALTER COLUMN endpoint DROP DEFAULT, ALTER COLUMN status DROP DEFAULT;
+
+CREATE INDEX shipment_group_idx ON shipment (group_id);
+CREATE INDEX shipment_provider_created_idx ON shipment (provider, created_at DESC);
+CREATE INDEX shipment_created_idx ON shipment (created_at);
+CREATE INDEX shipment_tenant_idx ON shipment (tenant_id, created_at DESC, id DESC);
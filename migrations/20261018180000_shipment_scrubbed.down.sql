@@ -0,0 +1,2 @@
+DROP INDEX shipment_unscrubbed_idx;
+ALTER TABLE shipment DROP COLUMN scrubbed_at;
@@ -0,0 +1,2 @@
+ALTER TABLE shipment ADD COLUMN scrubbed_at TIMESTAMPTZ;
+CREATE INDEX shipment_unscrubbed_idx ON shipment (created_at) WHERE scrubbed_at IS NULL;
@@ -0,0 +1,2 @@
+DROP INDEX shipment_key_idx;
+ALTER TABLE shipment DROP COLUMN key_id;
@@ -0,0 +1,2 @@
+ALTER TABLE shipment ADD COLUMN key_id TEXT;
+CREATE INDEX shipment_key_idx ON shipment (key_id);
@@ -0,0 +1,2 @@
+DROP TABLE exchange;
+DROP FUNCTION exchange_append_only;
@@ -0,0 +1,36 @@
+CREATE TABLE exchange (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    shipment_id UUID,
+    endpoint TEXT NOT NULL,
+    attempt INT NOT NULL,
+    request_headers JSONB NOT NULL,
+    request BYTEA,
+    status INT,
+    response_headers JSONB,
+    response BYTEA,
+    error TEXT,
+    request_id TEXT,
+    tenant_id TEXT,
+    key_id TEXT,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX exchange_shipment_idx ON exchange (shipment_id);
+CREATE INDEX exchange_started_idx ON exchange (started_at);
+CREATE INDEX exchange_key_idx ON exchange (key_id);
+
+-- The exchanges are the proof of what was sent to the providers and what
+-- they answered, once written they can only be encrypted with another key.
+CREATE FUNCTION exchange_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.key_id IS DISTINCT FROM OLD.key_id
+        AND to_jsonb(NEW) - 'request' - 'response' - 'key_id' = to_jsonb(OLD) - 'request' - 'response' - 'key_id' THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'exchange is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER exchange_append_only BEFORE UPDATE OR DELETE ON exchange
+    FOR EACH ROW EXECUTE FUNCTION exchange_append_only();
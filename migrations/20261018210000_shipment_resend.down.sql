@@ -0,0 +1,2 @@
+DROP INDEX shipment_resend_of_idx;
+ALTER TABLE shipment DROP COLUMN resend_of, DROP COLUMN original;
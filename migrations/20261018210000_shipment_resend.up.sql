@@ -0,0 +1,4 @@
+ALTER TABLE shipment
+    ADD COLUMN original JSONB,
+    ADD COLUMN resend_of UUID REFERENCES shipment (id) ON DELETE SET NULL;
+CREATE INDEX shipment_resend_of_idx ON shipment (resend_of);
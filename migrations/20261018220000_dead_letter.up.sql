@@ -0,0 +1,11 @@
+CREATE TABLE dead_letter (
+    id BIGSERIAL PRIMARY KEY,
+    shipment_id UUID NOT NULL UNIQUE,
+    provider TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    http_status INT,
+    tenant_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX dead_letter_provider_idx ON dead_letter (provider, id DESC);
@@ -0,0 +1,17 @@
+DROP FUNCTION exchange_reseal;
+
+CREATE OR REPLACE FUNCTION exchange_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.key_id IS DISTINCT FROM OLD.key_id
+        AND to_jsonb(NEW) - 'request' - 'response' - 'key_id' = to_jsonb(OLD) - 'request' - 'response' - 'key_id' THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'exchange is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER exchange_append_only ON exchange;
+CREATE TRIGGER exchange_append_only BEFORE UPDATE OR DELETE ON exchange
+    FOR EACH ROW EXECUTE FUNCTION exchange_append_only();
+
+ALTER TABLE exchange DROP COLUMN request_sha256, DROP COLUMN response_sha256;
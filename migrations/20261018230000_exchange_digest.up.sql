@@ -0,0 +1,43 @@
+-- The digests are the sha256 of the plaintext bodies, written with the
+-- exchange and never changed, so a body swapped afterwards is caught. The
+-- exchanges audited before have none, as the bodies without one.
+ALTER TABLE exchange ADD COLUMN request_sha256 BYTEA, ADD COLUMN response_sha256 BYTEA;
+
+-- Once written an exchange can only be encrypted with another key, through
+-- exchange_reseal, the digests and the rest of the row never change. The
+-- plaintext bodies are checked against their digests here, the encrypted
+-- ones by whoever decrypts them.
+CREATE OR REPLACE FUNCTION exchange_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF TG_OP = 'UPDATE' AND (
+        current_setting('axiogate.exchange', true) IS DISTINCT FROM 'reseal'
+        OR NEW.key_id IS NOT DISTINCT FROM OLD.key_id
+        OR to_jsonb(NEW) - 'request' - 'response' - 'key_id' <> to_jsonb(OLD) - 'request' - 'response' - 'key_id') THEN
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF NEW.key_id IS NULL AND (
+        (NEW.request IS NOT NULL AND NEW.request_sha256 IS NOT NULL AND sha256(NEW.request) <> NEW.request_sha256)
+        OR (NEW.response IS NOT NULL AND NEW.response_sha256 IS NOT NULL AND sha256(NEW.response) <> NEW.response_sha256)) THEN
+        RAISE EXCEPTION 'exchange body does not match its digest';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER exchange_append_only ON exchange;
+CREATE TRIGGER exchange_append_only BEFORE INSERT OR UPDATE OR DELETE ON exchange
+    FOR EACH ROW EXECUTE FUNCTION exchange_append_only();
+
+-- exchange_reseal replaces the bodies of the exchange with the same ones
+-- encrypted with another key, it's the only way the bodies are changed.
+CREATE FUNCTION exchange_reseal(exchange_id BIGINT, new_key_id TEXT, new_request BYTEA, new_response BYTEA)
+RETURNS VOID AS $$
+BEGIN
+    PERFORM set_config('axiogate.exchange', 'reseal', true);
+    UPDATE exchange SET key_id = new_key_id, request = new_request, response = new_response WHERE id = exchange_id;
+    PERFORM set_config('axiogate.exchange', '', true);
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,22 @@
+DROP FUNCTION exchange_expire;
+DROP FUNCTION exchange_scrub;
+
+CREATE OR REPLACE FUNCTION exchange_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF TG_OP = 'UPDATE' AND (
+        current_setting('axiogate.exchange', true) IS DISTINCT FROM 'reseal'
+        OR NEW.key_id IS NOT DISTINCT FROM OLD.key_id
+        OR to_jsonb(NEW) - 'request' - 'response' - 'key_id' <> to_jsonb(OLD) - 'request' - 'response' - 'key_id') THEN
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF NEW.key_id IS NULL AND (
+        (NEW.request IS NOT NULL AND NEW.request_sha256 IS NOT NULL AND sha256(NEW.request) <> NEW.request_sha256)
+        OR (NEW.response IS NOT NULL AND NEW.response_sha256 IS NOT NULL AND sha256(NEW.response) <> NEW.response_sha256)) THEN
+        RAISE EXCEPTION 'exchange body does not match its digest';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,61 @@
+-- The retention is the only other writer of the exchanges, through
+-- exchange_scrub and exchange_expire. A scrub drops the bodies and keeps
+-- the rest, the digests included, an expired exchange is deleted.
+CREATE OR REPLACE FUNCTION exchange_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF current_setting('axiogate.exchange', true) = 'retention' THEN
+            RETURN OLD;
+        END IF;
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF TG_OP = 'UPDATE' AND current_setting('axiogate.exchange', true) = 'retention' THEN
+        IF NEW.request IS NOT NULL OR NEW.response IS NOT NULL
+            OR to_jsonb(NEW) - 'request' - 'response' <> to_jsonb(OLD) - 'request' - 'response' THEN
+            RAISE EXCEPTION 'exchange is append-only';
+        END IF;
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'UPDATE' AND (
+        current_setting('axiogate.exchange', true) IS DISTINCT FROM 'reseal'
+        OR NEW.key_id IS NOT DISTINCT FROM OLD.key_id
+        OR to_jsonb(NEW) - 'request' - 'response' - 'key_id' <> to_jsonb(OLD) - 'request' - 'response' - 'key_id') THEN
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF NEW.key_id IS NULL AND (
+        (NEW.request IS NOT NULL AND NEW.request_sha256 IS NOT NULL AND sha256(NEW.request) <> NEW.request_sha256)
+        OR (NEW.response IS NOT NULL AND NEW.response_sha256 IS NOT NULL AND sha256(NEW.response) <> NEW.response_sha256)) THEN
+        RAISE EXCEPTION 'exchange body does not match its digest';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- exchange_scrub drops the bodies of the exchanges started before the
+-- time and returns how many it scrubbed.
+CREATE FUNCTION exchange_scrub(before TIMESTAMPTZ) RETURNS BIGINT AS $$
+DECLARE
+    scrubbed BIGINT;
+BEGIN
+    PERFORM set_config('axiogate.exchange', 'retention', true);
+    UPDATE exchange SET request = NULL, response = NULL
+        WHERE started_at < before AND (request IS NOT NULL OR response IS NOT NULL);
+    GET DIAGNOSTICS scrubbed = ROW_COUNT;
+    PERFORM set_config('axiogate.exchange', '', true);
+    RETURN scrubbed;
+END;
+$$ LANGUAGE plpgsql;
+
+-- exchange_expire deletes the exchanges started before the time
+-- and returns how many it deleted.
+CREATE FUNCTION exchange_expire(before TIMESTAMPTZ) RETURNS BIGINT AS $$
+DECLARE
+    deleted BIGINT;
+BEGIN
+    PERFORM set_config('axiogate.exchange', 'retention', true);
+    DELETE FROM exchange WHERE started_at < before;
+    GET DIAGNOSTICS deleted = ROW_COUNT;
+    PERFORM set_config('axiogate.exchange', '', true);
+    RETURN deleted;
+END;
+$$ LANGUAGE plpgsql;
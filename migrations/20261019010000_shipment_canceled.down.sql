@@ -0,0 +1,6 @@
+-- An enum value can't be dropped, the type is made again without it.
+UPDATE shipment SET status = 'failed' WHERE status = 'canceled';
+ALTER TYPE shipment_status RENAME TO shipment_status_old;
+CREATE TYPE shipment_status AS ENUM ('booked', 'failed', 'voided');
+ALTER TABLE shipment ALTER COLUMN status TYPE shipment_status USING status::TEXT::shipment_status;
+DROP TYPE shipment_status_old;
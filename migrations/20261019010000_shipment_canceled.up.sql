@@ -0,0 +1 @@
+ALTER TYPE shipment_status ADD VALUE 'canceled';
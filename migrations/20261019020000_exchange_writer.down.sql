@@ -0,0 +1,42 @@
+GRANT axiogate_exchange TO CURRENT_USER;
+ALTER FUNCTION exchange_reseal(BIGINT, TEXT, BYTEA, BYTEA) OWNER TO CURRENT_USER;
+ALTER FUNCTION exchange_scrub(TIMESTAMPTZ) OWNER TO CURRENT_USER;
+ALTER FUNCTION exchange_expire(TIMESTAMPTZ) OWNER TO CURRENT_USER;
+REVOKE axiogate_exchange FROM CURRENT_USER;
+
+ALTER FUNCTION exchange_reseal(BIGINT, TEXT, BYTEA, BYTEA) SECURITY INVOKER RESET search_path;
+ALTER FUNCTION exchange_scrub(TIMESTAMPTZ) SECURITY INVOKER RESET search_path;
+ALTER FUNCTION exchange_expire(TIMESTAMPTZ) SECURITY INVOKER RESET search_path;
+GRANT EXECUTE ON FUNCTION exchange_reseal(BIGINT, TEXT, BYTEA, BYTEA), exchange_scrub(TIMESTAMPTZ),
+    exchange_expire(TIMESTAMPTZ) TO PUBLIC;
+REVOKE ALL ON exchange FROM axiogate_exchange;
+
+CREATE OR REPLACE FUNCTION exchange_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF current_setting('axiogate.exchange', true) = 'retention' THEN
+            RETURN OLD;
+        END IF;
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF TG_OP = 'UPDATE' AND current_setting('axiogate.exchange', true) = 'retention' THEN
+        IF NEW.request IS NOT NULL OR NEW.response IS NOT NULL
+            OR to_jsonb(NEW) - 'request' - 'response' <> to_jsonb(OLD) - 'request' - 'response' THEN
+            RAISE EXCEPTION 'exchange is append-only';
+        END IF;
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'UPDATE' AND (
+        current_setting('axiogate.exchange', true) IS DISTINCT FROM 'reseal'
+        OR NEW.key_id IS NOT DISTINCT FROM OLD.key_id
+        OR to_jsonb(NEW) - 'request' - 'response' - 'key_id' <> to_jsonb(OLD) - 'request' - 'response' - 'key_id') THEN
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF NEW.key_id IS NULL AND (
+        (NEW.request IS NOT NULL AND NEW.request_sha256 IS NOT NULL AND sha256(NEW.request) <> NEW.request_sha256)
+        OR (NEW.response IS NOT NULL AND NEW.response_sha256 IS NOT NULL AND sha256(NEW.response) <> NEW.response_sha256)) THEN
+        RAISE EXCEPTION 'exchange body does not match its digest';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,60 @@
+-- The exchanges are changed as the axiogate_exchange role only, the
+-- functions below run as it and no one else can take it, so a session
+-- setting axiogate.exchange on its own can't change them. The migrating
+-- role needs CREATEROLE to create it.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'axiogate_exchange') THEN
+        CREATE ROLE axiogate_exchange NOLOGIN;
+    END IF;
+END;
+$$;
+GRANT SELECT, UPDATE, DELETE ON exchange TO axiogate_exchange;
+
+CREATE OR REPLACE FUNCTION exchange_append_only() RETURNS TRIGGER AS $$
+DECLARE
+    writer TEXT := CASE WHEN current_user = 'axiogate_exchange' THEN current_setting('axiogate.exchange', true) END;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF writer = 'retention' THEN
+            RETURN OLD;
+        END IF;
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF TG_OP = 'UPDATE' AND writer = 'retention' THEN
+        IF NEW.request IS NOT NULL OR NEW.response IS NOT NULL
+            OR to_jsonb(NEW) - 'request' - 'response' <> to_jsonb(OLD) - 'request' - 'response' THEN
+            RAISE EXCEPTION 'exchange is append-only';
+        END IF;
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'UPDATE' AND (
+        writer IS DISTINCT FROM 'reseal'
+        OR NEW.key_id IS NOT DISTINCT FROM OLD.key_id
+        OR to_jsonb(NEW) - 'request' - 'response' - 'key_id' <> to_jsonb(OLD) - 'request' - 'response' - 'key_id') THEN
+        RAISE EXCEPTION 'exchange is append-only';
+    END IF;
+    IF NEW.key_id IS NULL AND (
+        (NEW.request IS NOT NULL AND NEW.request_sha256 IS NOT NULL AND sha256(NEW.request) <> NEW.request_sha256)
+        OR (NEW.response IS NOT NULL AND NEW.response_sha256 IS NOT NULL AND sha256(NEW.response) <> NEW.response_sha256)) THEN
+        RAISE EXCEPTION 'exchange body does not match its digest';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER FUNCTION exchange_reseal(BIGINT, TEXT, BYTEA, BYTEA) SECURITY DEFINER SET search_path FROM CURRENT;
+ALTER FUNCTION exchange_scrub(TIMESTAMPTZ) SECURITY DEFINER SET search_path FROM CURRENT;
+ALTER FUNCTION exchange_expire(TIMESTAMPTZ) SECURITY DEFINER SET search_path FROM CURRENT;
+
+-- The migrating role is a member only long enough to hand the functions over.
+GRANT axiogate_exchange TO CURRENT_USER;
+ALTER FUNCTION exchange_reseal(BIGINT, TEXT, BYTEA, BYTEA) OWNER TO axiogate_exchange;
+ALTER FUNCTION exchange_scrub(TIMESTAMPTZ) OWNER TO axiogate_exchange;
+ALTER FUNCTION exchange_expire(TIMESTAMPTZ) OWNER TO axiogate_exchange;
+REVOKE axiogate_exchange FROM CURRENT_USER;
+
+REVOKE EXECUTE ON FUNCTION exchange_reseal(BIGINT, TEXT, BYTEA, BYTEA), exchange_scrub(TIMESTAMPTZ),
+    exchange_expire(TIMESTAMPTZ) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION exchange_reseal(BIGINT, TEXT, BYTEA, BYTEA), exchange_scrub(TIMESTAMPTZ),
+    exchange_expire(TIMESTAMPTZ) TO CURRENT_USER;
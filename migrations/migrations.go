@@ -0,0 +1,9 @@
+// Package migrations holds the sql schema migrations embedded in the binary.
+package migrations
+
+import "embed"
+
+// FS has the <version>_<name>.up.sql and <version>_<name>.down.sql migrations.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -2,14 +2,44 @@ package a
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/ratelimit"
+	"github.com/hoenirvili/axiogate/shipment"
 )
 
-type provider string
+// Credentials are the account provider A books the shipments with.
+type Credentials struct {
+	Account int `json:"account"`
+}
+
+type provider struct {
+	to          string
+	credentials Credentials
+}
+
+// Provider is provider A booking with the default account.
+var Provider = New("http://localhost:3030/v1/a", Credentials{Account: 123})
 
-const Provider provider = "http://localhost:3030/v1/a"
+// New returns provider A posting to the endpoint with the credentials.
+func New(to string, credentials Credentials) provider {
+	return provider{to: to, credentials: credentials}
+}
+
+// WithCredentials returns a copy of the provider booking with the given credentials.
+func (p provider) WithCredentials(raw json.RawMessage) (shipment.Payloader, error) {
+	var credentials Credentials
+	if err := json.Unmarshal(raw, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to decode credentials, %w", err)
+	}
+	if credentials.Account == 0 {
+		return nil, errors.New("account number is required")
+	}
+	return New(p.to, credentials), nil
+}
 
 type ProviderARequest struct {
 	Weight              WeightA               `json:"weight"`
@@ -86,7 +116,7 @@ type MoneyA struct {
 }
 
 func (p provider) To() string {
-	return string(p)
+	return p.to
 }
 
 func (p provider) Payload(req *api.ShippingRequest) []byte {
@@ -153,7 +183,7 @@ func (p provider) Payload(req *api.ShippingRequest) []byte {
 			Unit:   req.Dimensions.Unit,
 		},
 		Account: AccountA{
-			Number: 123, // Should come from config
+			Number: p.credentials.Account,
 		},
 		ProductCode:         "International",
 		ServiceType:         req.ServiceType,
@@ -172,3 +202,122 @@ func (p provider) Payload(req *api.ShippingRequest) []byte {
 	})
 	return b
 }
+
+type ProviderAQuoteRequest struct {
+	Weight         WeightA     `json:"weight"`
+	Origin         AddressA    `json:"origin"`
+	Destination    AddressA    `json:"destination"`
+	Dimensions     DimensionsA `json:"dimensions"`
+	Account        AccountA    `json:"account"`
+	ProductCode    string      `json:"productCode"`
+	ServiceType    string      `json:"serviceType"`
+	DeclaredValue  MoneyA      `json:"declaredValue"`
+	NumberOfPieces int         `json:"numberOfPieces"`
+	IsCod          bool        `json:"isCod"`
+}
+
+type ProviderAQuoteResponse struct {
+	TotalAmount  MoneyA `json:"totalAmount"`
+	TransitDays  int    `json:"transitDays"`
+	ServiceLevel string `json:"serviceLevel"`
+}
+
+func (p provider) QuoteTo() string {
+	return p.to + "/quote"
+}
+
+func (p provider) QuotePayload(req *api.ShippingRequest) []byte {
+	b, _ := json.Marshal(&ProviderAQuoteRequest{
+		Weight: WeightA{
+			Value: req.Weight.Value,
+			Unit:  req.Weight.Unit,
+		},
+		Origin: AddressA{
+			City:        req.Shipper.Address.City,
+			CountryCode: req.Shipper.Address.CountryCode,
+			ZipCode:     req.Shipper.Address.ZipCode,
+		},
+		Destination: AddressA{
+			City:        req.Consignee.Address.City,
+			CountryCode: req.Consignee.Address.CountryCode,
+			ZipCode:     req.Consignee.Address.ZipCode,
+		},
+		Dimensions: DimensionsA{
+			Length: req.Dimensions.Length,
+			Width:  req.Dimensions.Width,
+			Height: req.Dimensions.Height,
+			Unit:   req.Dimensions.Unit,
+		},
+		Account: AccountA{
+			Number: p.credentials.Account,
+		},
+		ProductCode: "International",
+		ServiceType: req.ServiceType,
+		DeclaredValue: MoneyA{
+			Amount:   req.DeclaredValue.Amount,
+			Currency: req.DeclaredValue.Currency,
+		},
+		NumberOfPieces: len(req.Packages),
+		IsCod:          req.IsCOD,
+	})
+	return b
+}
+
+func (p provider) Quote(raw []byte) (*api.Quote, error) {
+	resp := &ProviderAQuoteResponse{}
+	if err := json.Unmarshal(raw, resp); err != nil {
+		return nil, fmt.Errorf("failed to decode quote, %w", err)
+	}
+	return &api.Quote{
+		Price:        resp.TotalAmount.Amount,
+		Currency:     resp.TotalAmount.Currency,
+		TransitDays:  resp.TransitDays,
+		ServiceLevel: resp.ServiceLevel,
+	}, nil
+}
+
+func (p provider) Constraints() shipment.Constraints {
+	return shipment.Constraints{
+		MaxWeight: 70,
+	}
+}
+
+// OutboundLimit is the rate provider A accepts, calls over it wait their turn.
+func (p provider) OutboundLimit() ratelimit.Outbound {
+	return ratelimit.Outbound{
+		Rate:    50,
+		Mode:    ratelimit.ModeQueue,
+		MaxWait: 2 * time.Second,
+	}
+}
+
+type ProviderAResponse struct {
+	AWB string `json:"awb"`
+}
+
+type ProviderAVoidRequest struct {
+	Account AccountA `json:"account"`
+	AWB     string   `json:"awb"`
+	Reason  string   `json:"reason"`
+}
+
+func (p provider) VoidTo() string {
+	return p.to + "/void"
+}
+
+func (p provider) VoidPayload(raw []byte) ([]byte, error) {
+	resp := &ProviderAResponse{}
+	if err := json.Unmarshal(raw, resp); err != nil {
+		return nil, fmt.Errorf("failed to decode booking, %w", err)
+	}
+	if resp.AWB == "" {
+		return nil, errors.New("booking has no awb")
+	}
+	return json.Marshal(&ProviderAVoidRequest{
+		Account: AccountA{
+			Number: p.credentials.Account,
+		},
+		AWB:    resp.AWB,
+		Reason: "Duplicate",
+	})
+}
@@ -2,14 +2,50 @@ package b
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/ratelimit"
+	"github.com/hoenirvili/axiogate/shipment"
 )
 
-type provider string
+// Credentials are the account provider B books the shipments with.
+type Credentials struct {
+	UserName  string `json:"username"`
+	Password  string `json:"password"`
+	AccountNo string `json:"account"`
+}
+
+type provider struct {
+	to          string
+	credentials Credentials
+}
+
+// Provider is provider B booking with the default account.
+var Provider = New("http://localhost:3031/v1/b", Credentials{
+	UserName:  "123",
+	Password:  "123",
+	AccountNo: "123",
+})
+
+// New returns provider B posting to the endpoint with the credentials.
+func New(to string, credentials Credentials) provider {
+	return provider{to: to, credentials: credentials}
+}
 
-const Provider provider = "http://localhost:3031/v1/b"
+// WithCredentials returns a copy of the provider booking with the given credentials.
+func (p provider) WithCredentials(raw json.RawMessage) (shipment.Payloader, error) {
+	var credentials Credentials
+	if err := json.Unmarshal(raw, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to decode credentials, %w", err)
+	}
+	if credentials.UserName == "" || credentials.Password == "" || credentials.AccountNo == "" {
+		return nil, errors.New("username, password and account are required")
+	}
+	return New(p.to, credentials), nil
+}
 
 type ProviderBRequest struct {
 	Origin                       string                   `json:"Origin"`
@@ -69,7 +105,7 @@ type ExportItemDeclarationB struct {
 }
 
 func (p provider) To() string {
-	return string(p)
+	return p.to
 }
 
 func (p provider) Payload(req *api.ShippingRequest) []byte {
@@ -138,12 +174,129 @@ func (p provider) Payload(req *api.ShippingRequest) []byte {
 		ValueCurrency:                req.DeclaredValue.Currency,
 		GoodsDescription:             goodsDescription,
 		NumberofPeices:               len(req.Packages),
-		Weight:                       req.Weight.Value / 1000, // Convert to KG
+		Weight:                       req.Weight.KG(),
 		PackageRequest:               packages,
 		ExportItemDeclarationRequest: items,
-		UserName:                     "123", // From config
-		Password:                     "123",
-		AccountNo:                    "123",
+		UserName:                     p.credentials.UserName,
+		Password:                     p.credentials.Password,
+		AccountNo:                    p.credentials.AccountNo,
+	})
+	return b
+}
+
+type ProviderBRateRequest struct {
+	Origin          string  `json:"Origin"`
+	Destination     string  `json:"Destination"`
+	ProductType     string  `json:"ProductType"`
+	ServiceType     string  `json:"ServiceType"`
+	CODAmount       string  `json:"CODAmount"`
+	CODCurrency     string  `json:"CODCurrency"`
+	ValueOfShipment float64 `json:"ValueOfShipment"`
+	ValueCurrency   string  `json:"ValueCurrency"`
+	NumberofPeices  int     `json:"NumberofPeices"`
+	Weight          float64 `json:"Weight"`
+	UserName        string  `json:"UserName"`
+	Password        string  `json:"Password"`
+	AccountNo       string  `json:"AccountNo"`
+}
+
+type ProviderBRateResponse struct {
+	Rate        string `json:"Rate"`
+	Currency    string `json:"Currency"`
+	TransitTime int    `json:"TransitTime"`
+	Service     string `json:"Service"`
+}
+
+func (p provider) QuoteTo() string {
+	return p.to + "/rate"
+}
+
+func (p provider) QuotePayload(req *api.ShippingRequest) []byte {
+	codAmount := "0"
+	codCurrency := "USD"
+	if req.IsCOD && req.CODAmount != nil {
+		codAmount = fmt.Sprintf("%.2f", req.CODAmount.Amount)
+		codCurrency = req.CODAmount.Currency
+	}
+	b, _ := json.Marshal(&ProviderBRateRequest{
+		Origin:          req.Shipper.Address.CountryCode,
+		Destination:     req.Consignee.Address.CountryCode,
+		ProductType:     "XPS",
+		ServiceType:     req.ServiceType,
+		CODAmount:       codAmount,
+		CODCurrency:     codCurrency,
+		ValueOfShipment: req.DeclaredValue.Amount,
+		ValueCurrency:   req.DeclaredValue.Currency,
+		NumberofPeices:  len(req.Packages),
+		Weight:          req.Weight.KG(),
+		UserName:        p.credentials.UserName,
+		Password:        p.credentials.Password,
+		AccountNo:       p.credentials.AccountNo,
 	})
 	return b
 }
+
+func (p provider) Quote(raw []byte) (*api.Quote, error) {
+	resp := &ProviderBRateResponse{}
+	if err := json.Unmarshal(raw, resp); err != nil {
+		return nil, fmt.Errorf("failed to decode rate, %w", err)
+	}
+	price, err := strconv.ParseFloat(resp.Rate, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate %q, %w", resp.Rate, err)
+	}
+	return &api.Quote{
+		Price:        price,
+		Currency:     resp.Currency,
+		TransitDays:  resp.TransitTime,
+		ServiceLevel: resp.Service,
+	}, nil
+}
+
+func (p provider) Constraints() shipment.Constraints {
+	return shipment.Constraints{
+		MaxWeight: 30,
+	}
+}
+
+// OutboundLimit is the rate provider B accepts across all replicas,
+// calls over it fail right away.
+func (p provider) OutboundLimit() ratelimit.Outbound {
+	return ratelimit.Outbound{
+		Rate:   10,
+		Burst:  20,
+		Mode:   ratelimit.ModeFailFast,
+		Shared: true,
+	}
+}
+
+type ProviderBResponse struct {
+	AirwayBillNumber string `json:"AirwayBillNumber"`
+}
+
+type ProviderBCancelRequest struct {
+	AirwayBillNumber string `json:"AirwayBillNumber"`
+	UserName         string `json:"UserName"`
+	Password         string `json:"Password"`
+	AccountNo        string `json:"AccountNo"`
+}
+
+func (p provider) VoidTo() string {
+	return p.to + "/cancel"
+}
+
+func (p provider) VoidPayload(raw []byte) ([]byte, error) {
+	resp := &ProviderBResponse{}
+	if err := json.Unmarshal(raw, resp); err != nil {
+		return nil, fmt.Errorf("failed to decode booking, %w", err)
+	}
+	if resp.AirwayBillNumber == "" {
+		return nil, errors.New("booking has no airway bill number")
+	}
+	return json.Marshal(&ProviderBCancelRequest{
+		AirwayBillNumber: resp.AirwayBillNumber,
+		UserName:         p.credentials.UserName,
+		Password:         p.credentials.Password,
+		AccountNo:        p.credentials.AccountNo,
+	})
+}
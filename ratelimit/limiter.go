@@ -0,0 +1,193 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// Rates returns the requests per second allowed for the caller
+// found in the context, zero if the default rate applies.
+type Rates interface {
+	RateLimit(ctx context.Context) (int, error)
+}
+
+// bucket holds the tokens of a client, a request takes one token
+// and the tokens refill at the rate up to the burst.
+type bucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last request.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take takes a token, if there is none left it returns
+// how long until the next one is available.
+func (b *bucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// sweepEvery is how often the full buckets are dropped, a new bucket starts full anyway.
+const sweepEvery = time.Minute
+
+// buckets holds the token bucket of every key.
+type buckets struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	m     map[string]*bucket
+	swept time.Time
+}
+
+func newBuckets() *buckets {
+	return &buckets{now: time.Now, m: map[string]*bucket{}}
+}
+
+// take takes a token from the key bucket, if there is none left
+// it returns how long until the next one is available.
+func (b *buckets) take(key string, rate, burst int) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if now.Sub(b.swept) > sweepEvery {
+		for key, bk := range b.m {
+			if bk.refill(now); bk.tokens >= bk.burst {
+				delete(b.m, key)
+			}
+		}
+		b.swept = now
+	}
+	bk, ok := b.m[key]
+	if !ok {
+		bk = &bucket{tokens: float64(burst), last: now}
+		b.m[key] = bk
+	}
+	// The rate of a key changes when its configuration is updated.
+	bk.rate, bk.burst = float64(rate), float64(burst)
+	return bk.take(now)
+}
+
+// Limiter limits the requests per second of every client with a token bucket.
+type Limiter struct {
+	rate    int
+	burst   int
+	rates   Rates
+	key     func(r *http.Request) string
+	log     *slog.Logger
+	buckets *buckets
+}
+
+type Option func(l *Limiter)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(l *Limiter) {
+		l.log = log.WithGroup("ratelimit")
+	}
+}
+
+// WithBurst sets how many requests a client can make at once,
+// by default it's the same as the rate.
+func WithBurst(burst int) Option {
+	return func(l *Limiter) {
+		l.burst = burst
+	}
+}
+
+// WithRates overrides the default rate for the callers that have their own.
+func WithRates(rates Rates) Option {
+	return func(l *Limiter) {
+		l.rates = rates
+	}
+}
+
+// WithKey sets the key the requests are limited by, by default the ClientKey.
+func WithKey(key func(r *http.Request) string) Option {
+	return func(l *Limiter) {
+		l.key = key
+	}
+}
+
+// New returns a limiter allowing each client rate requests per second,
+// a zero rate leaves the clients without their own rate unlimited.
+func New(rate int, options ...Option) *Limiter {
+	l := &Limiter{
+		rate:    rate,
+		key:     ClientKey,
+		log:     log.Noop(),
+		buckets: newBuckets(),
+	}
+	for _, option := range options {
+		option(l)
+	}
+	return l
+}
+
+// Allow takes a token from the client bucket, if the client ran out
+// of tokens it returns how long until it can try again.
+func (l *Limiter) Allow(client string, rate int) (bool, time.Duration) {
+	if rate <= 0 {
+		return true, 0
+	}
+	burst := rate
+	if l.burst > 0 && rate == l.rate {
+		burst = l.burst
+	}
+	return l.buckets.take(client, rate, burst)
+}
+
+// rateOf returns the rate of the caller found in the context.
+func (l *Limiter) rateOf(ctx context.Context) (int, error) {
+	if l.rates == nil {
+		return l.rate, nil
+	}
+	rate, err := l.rates.RateLimit(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if rate == 0 {
+		return l.rate, nil
+	}
+	return rate, nil
+}
+
+// Middleware answers with a 429 the clients that made too many requests,
+// it must run after the authentication to limit each principal on its own.
+// Keyed by the IPKey it runs before the authentication, so the requests
+// flooding it are turned away before their credentials are looked up.
+func (l *Limiter) Middleware() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rate, err := l.rateOf(r.Context())
+			if err != nil {
+				l.log.With(log.Error(err)).ErrorContext(r.Context(), "Failed to fetch the rate limit")
+				response.New(w).InternalServer("failed to fetch the rate limit")
+				return
+			}
+			client := l.key(r)
+			if ok, retry := l.Allow(client, rate); !ok {
+				l.log.With(slog.String("client", client)).InfoContext(r.Context(), "Rate limited")
+				response.New(w).TooManyRequests(retry, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
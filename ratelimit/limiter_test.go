@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hoenirvili/axiogate/auth"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestLimiter_Allow(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	l := New(2)
+	l.buckets.now = c.now
+
+	for range 2 {
+		ok, _ := l.Allow("client", 2)
+		assert.True(t, ok)
+	}
+	ok, retry := l.Allow("client", 2)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retry)
+
+	ok, _ = l.Allow("other", 2)
+	assert.True(t, ok, "clients have their own buckets")
+
+	c.advance(500 * time.Millisecond)
+	ok, _ = l.Allow("client", 2)
+	assert.True(t, ok)
+
+	ok, _ = l.Allow("client", 0)
+	assert.True(t, ok, "zero rate is unlimited")
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	l := New(1)
+	l.buckets.now = c.now
+
+	l.Allow("idle", 1)
+	c.advance(2 * sweepEvery)
+	l.Allow("busy", 1)
+	assert.NotContains(t, l.buckets.m, "idle")
+	assert.Contains(t, l.buckets.m, "busy")
+}
+
+type rates struct {
+	rate int
+	err  error
+}
+
+func (r rates) RateLimit(context.Context) (int, error) { return r.rate, r.err }
+
+func TestLimiter_Middleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		rates     Rates
+		requests  int
+		principal *auth.Principal
+		key       func(r *http.Request) string
+		spent     string
+		want      []int
+	}{
+		{
+			name:     "default rate",
+			requests: 2,
+			want:     []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "caller rate",
+			rates:    rates{rate: 2},
+			requests: 3,
+			want:     []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "caller without its own rate",
+			rates:    rates{},
+			requests: 2,
+			want:     []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:      "principal is limited on its own",
+			principal: &auth.Principal{Subject: "key:1"},
+			spent:     "ip:192.0.2.1",
+			requests:  1,
+			want:      []int{http.StatusOK},
+		},
+		{
+			name:      "keyed by ip the principals share the ip rate",
+			principal: &auth.Principal{Subject: "key:1"},
+			key:       IPKey,
+			spent:     "ip:192.0.2.1",
+			requests:  1,
+			want:      []int{http.StatusTooManyRequests},
+		},
+		{
+			name:     "rate lookup fails",
+			rates:    rates{err: errors.New("boom")},
+			requests: 1,
+			want:     []int{http.StatusInternalServerError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := []Option{WithRates(tt.rates)}
+			if tt.key != nil {
+				options = append(options, WithKey(tt.key))
+			}
+			l := New(1, options...)
+			if tt.spent != "" {
+				l.Allow(tt.spent, 1)
+			}
+			h := l.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			var got []int
+			for range tt.requests {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				if tt.principal != nil {
+					r = r.WithContext(auth.With(r.Context(), tt.principal))
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				got = append(got, w.Code)
+				if w.Code == http.StatusTooManyRequests {
+					assert.Equal(t, "1", w.Header().Get("Retry-After"))
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
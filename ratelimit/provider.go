@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// Modes a provider call is handled with when the provider rate is used up.
+const (
+	// ModeQueue waits for a token, up to the max wait.
+	ModeQueue = "queue"
+	// ModeFailFast fails the call right away.
+	ModeFailFast = "fail-fast"
+)
+
+// Outbound is the rate a provider accepts requests at.
+type Outbound struct {
+	// Rate is the number of requests per second.
+	Rate int
+	// Burst is how many requests can be made at once, by default the rate.
+	Burst int
+	// Mode is ModeQueue, the default, or ModeFailFast.
+	Mode string
+	// MaxWait bounds how long a queued call waits for a token, by default a second.
+	MaxWait time.Duration
+	// Shared makes all the replicas share the rate instead of each one having its own.
+	Shared bool
+}
+
+// Limited is implemented by the providers that publish a rate limit.
+type Limited interface {
+	OutboundLimit() Outbound
+}
+
+// Tokens hands out the tokens of the buckets shared by all replicas.
+type Tokens interface {
+	// TakeToken takes a token from the key bucket, if there is none
+	// left it returns how long until the next one is available.
+	TakeToken(ctx context.Context, key string, rate, burst int) (bool, time.Duration, error)
+}
+
+// ErrRateLimited is returned when a provider call can't be made without
+// going over the provider rate limit.
+type ErrRateLimited struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+var _ error = (*ErrRateLimited)(nil)
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("provider %s rate limit exceeded, retry after %s", e.Provider, e.RetryAfter)
+}
+
+// ProviderLimiter holds the provider calls back so they stay within the
+// provider rate limits, it's asked before every call, retries included.
+type ProviderLimiter struct {
+	providers map[string]shipment.Payloader
+	tokens    Tokens
+	buckets   *buckets
+	log       *slog.Logger
+}
+
+var _ request.Limiter = (*ProviderLimiter)(nil)
+
+type ProviderLimiterOption func(l *ProviderLimiter)
+
+func WithProviderLimiterLogger(log *slog.Logger) ProviderLimiterOption {
+	return func(l *ProviderLimiter) {
+		l.log = log.WithGroup("ratelimit")
+	}
+}
+
+// WithTokens shares the rate of the providers with a shared limit between the replicas.
+func WithTokens(tokens Tokens) ProviderLimiterOption {
+	return func(l *ProviderLimiter) {
+		l.tokens = tokens
+	}
+}
+
+// NewProviderLimiter returns a new limiter, the providers are used to find
+// the provider owning the endpoint of each call and its rate limit.
+func NewProviderLimiter(providers map[string]shipment.Payloader, options ...ProviderLimiterOption) *ProviderLimiter {
+	l := &ProviderLimiter{
+		providers: providers,
+		buckets:   newBuckets(),
+		log:       log.Noop(),
+	}
+	for _, option := range options {
+		option(l)
+	}
+	return l
+}
+
+// voids reports if the endpoint is the cancel endpoint of the provider.
+func voids(p shipment.Payloader, to string) bool {
+	voider, ok := p.(shipment.Voider)
+	return ok && strings.HasPrefix(to, voider.VoidTo())
+}
+
+// limit returns the provider owning the endpoint, its rate limit
+// and if the endpoint is the one cancelling the bookings.
+func (l *ProviderLimiter) limit(to string) (string, Outbound, bool, bool) {
+	name, ok := shipment.Owner(l.providers, to)
+	if !ok {
+		return "", Outbound{}, false, false
+	}
+	p := l.providers[name]
+	limited, ok := p.(Limited)
+	if !ok {
+		return "", Outbound{}, false, false
+	}
+	limit := limited.OutboundLimit()
+	return name, limit, voids(p, to), limit.Rate > 0
+}
+
+// take takes a token of the provider, from the shared bucket if the limit
+// is shared. If the shared bucket can't be reached the local one is used.
+func (l *ProviderLimiter) take(ctx context.Context, provider string, limit Outbound) (bool, time.Duration) {
+	key := "provider:" + provider
+	burst := cmp.Or(limit.Burst, limit.Rate)
+	if limit.Shared && l.tokens != nil {
+		ok, wait, err := l.tokens.TakeToken(ctx, key, limit.Rate, burst)
+		if err == nil {
+			return ok, wait
+		}
+		l.log.With(log.Error(err), slog.String("provider", provider)).
+			WarnContext(ctx, "Failed to take a shared token, using the local rate")
+	}
+	return l.buckets.take(key, limit.Rate, burst)
+}
+
+// Wait blocks until the call to the endpoint can be made within the
+// rate of its provider, the endpoints without a rate limit are not held.
+func (l *ProviderLimiter) Wait(ctx context.Context, to string) error {
+	provider, limit, void, ok := l.limit(to)
+	if !ok {
+		return nil
+	}
+	// A void releases a booking the caller doesn't keep, dropping it
+	// would leave the booking paid for, so it waits as long as it takes.
+	deadline := time.Now().Add(cmp.Or(limit.MaxWait, time.Second))
+	for {
+		ok, wait := l.take(ctx, provider, limit)
+		if ok {
+			return nil
+		}
+		if !void && (limit.Mode == ModeFailFast || time.Now().Add(wait).After(deadline)) {
+			return &ErrRateLimited{Provider: provider, RetryAfter: wait}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type limited struct {
+	to    string
+	limit Outbound
+}
+
+func (l limited) Payload(*api.ShippingRequest) []byte { return nil }
+
+func (l limited) To() string { return l.to }
+
+func (l limited) OutboundLimit() Outbound { return l.limit }
+
+type voiding struct{ limited }
+
+func (v voiding) VoidPayload(raw []byte) ([]byte, error) { return raw, nil }
+
+func (v voiding) VoidTo() string { return v.to + "/void" }
+
+type unlimited struct{}
+
+func (unlimited) Payload(*api.ShippingRequest) []byte { return nil }
+
+func (unlimited) To() string { return "https://free" }
+
+type mockTokens struct{ mock.Mock }
+
+func (m *mockTokens) TakeToken(ctx context.Context, key string, rate, burst int) (bool, time.Duration, error) {
+	args := m.Called(ctx, key, rate, burst)
+	return args.Bool(0), args.Get(1).(time.Duration), args.Error(2)
+}
+
+func TestProviderLimiter_FailFast(t *testing.T) {
+	l := NewProviderLimiter(map[string]shipment.Payloader{
+		"a":    limited{to: "https://a", limit: Outbound{Rate: 1, Burst: 2, Mode: ModeFailFast}},
+		"free": unlimited{},
+	})
+	for range 2 {
+		require.NoError(t, l.Wait(context.Background(), "https://a/shipments"))
+	}
+	err := l.Wait(context.Background(), "https://a/shipments")
+	var limitedErr *ErrRateLimited
+	require.ErrorAs(t, err, &limitedErr)
+	assert.Equal(t, "a", limitedErr.Provider)
+
+	for range 5 {
+		require.NoError(t, l.Wait(context.Background(), "https://free"))
+	}
+}
+
+func TestProviderLimiter_Void(t *testing.T) {
+	l := NewProviderLimiter(map[string]shipment.Payloader{
+		"a": voiding{limited{to: "https://a", limit: Outbound{Rate: 50, Burst: 1, Mode: ModeFailFast}}},
+	})
+	require.NoError(t, l.Wait(context.Background(), "https://a"))
+	var limitedErr *ErrRateLimited
+	require.ErrorAs(t, l.Wait(context.Background(), "https://a"), &limitedErr)
+
+	require.NoError(t, l.Wait(context.Background(), "https://a/void"), "the voids are queued even when failing fast")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.Wait(ctx, "https://a/void"), context.Canceled)
+}
+
+func TestProviderLimiter_Nested(t *testing.T) {
+	l := NewProviderLimiter(map[string]shipment.Payloader{
+		"gateway": limited{to: "https://gw", limit: Outbound{Rate: 1, Mode: ModeFailFast}},
+		"nested":  limited{to: "https://gw/nested", limit: Outbound{Rate: 1, Burst: 3, Mode: ModeFailFast}},
+	})
+	for range 3 {
+		require.NoError(t, l.Wait(context.Background(), "https://gw/nested/ship"), "the longest endpoint owns the call")
+	}
+	var limitedErr *ErrRateLimited
+	require.ErrorAs(t, l.Wait(context.Background(), "https://gw/nested/ship"), &limitedErr)
+	assert.Equal(t, "nested", limitedErr.Provider)
+	require.NoError(t, l.Wait(context.Background(), "https://gw/ship"))
+}
+
+func TestProviderLimiter_Queue(t *testing.T) {
+	l := NewProviderLimiter(map[string]shipment.Payloader{
+		"a": limited{to: "https://a", limit: Outbound{Rate: 50, Burst: 1, MaxWait: time.Second}},
+	})
+	start := time.Now()
+	for range 3 {
+		require.NoError(t, l.Wait(context.Background(), "https://a"))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.Wait(ctx, "https://a"), context.Canceled)
+}
+
+func TestProviderLimiter_QueueMaxWait(t *testing.T) {
+	l := NewProviderLimiter(map[string]shipment.Payloader{
+		"a": limited{to: "https://a", limit: Outbound{Rate: 1, MaxWait: 10 * time.Millisecond}},
+	})
+	require.NoError(t, l.Wait(context.Background(), "https://a"))
+	var limitedErr *ErrRateLimited
+	assert.ErrorAs(t, l.Wait(context.Background(), "https://a"), &limitedErr)
+}
+
+func TestProviderLimiter_Shared(t *testing.T) {
+	tokens := new(mockTokens)
+	tokens.On("TakeToken", mock.Anything, "provider:a", 10, 10).Return(true, time.Duration(0), nil).Once()
+	tokens.On("TakeToken", mock.Anything, "provider:a", 10, 10).Return(false, time.Second, nil).Once()
+	tokens.On("TakeToken", mock.Anything, "provider:a", 10, 10).Return(false, time.Duration(0), errors.New("boom")).Once()
+	l := NewProviderLimiter(map[string]shipment.Payloader{
+		"a": limited{to: "https://a", limit: Outbound{Rate: 10, Mode: ModeFailFast, Shared: true}},
+	}, WithTokens(tokens))
+
+	require.NoError(t, l.Wait(context.Background(), "https://a"))
+	assert.Equal(t, &ErrRateLimited{Provider: "a", RetryAfter: time.Second}, l.Wait(context.Background(), "https://a"))
+	require.NoError(t, l.Wait(context.Background(), "https://a"), "the local rate is used when the shared one can't be reached")
+	tokens.AssertExpectations(t)
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/http/response"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// Counter counts the shipments every client booked per day.
+type Counter interface {
+	// QuotaUsed returns how many shipments the client booked on the day.
+	QuotaUsed(ctx context.Context, client string, day time.Time) (int, error)
+	// UseQuota counts n more shipments booked by the client on the day.
+	UseQuota(ctx context.Context, client string, day time.Time, n int) error
+}
+
+// Quota limits how many shipments each client can book per day,
+// the days start at midnight UTC.
+type Quota struct {
+	counter Counter
+	limit   int
+	log     *slog.Logger
+	now     func() time.Time
+}
+
+type QuotaOption func(q *Quota)
+
+func WithQuotaLogger(log *slog.Logger) QuotaOption {
+	return func(q *Quota) {
+		q.log = log.WithGroup("quota")
+	}
+}
+
+// NewQuota returns a quota allowing each client to book limit shipments
+// per day, a zero limit disables the quota.
+func NewQuota(counter Counter, limit int, options ...QuotaOption) *Quota {
+	q := &Quota{
+		counter: counter,
+		limit:   limit,
+		log:     log.Noop(),
+		now:     time.Now,
+	}
+	for _, option := range options {
+		option(q)
+	}
+	return q
+}
+
+// Middleware answers with a 429 the clients that used their daily quota.
+// The shipments booked while handling a request are counted once it's
+// handled, the concurrent requests of a client can go over the quota by
+// what they book.
+func (q *Quota) Middleware() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if q.limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			now := q.now().UTC()
+			day := now.Truncate(24 * time.Hour)
+			client := ClientKey(r)
+			l := q.log.With(slog.String("client", client))
+			used, err := q.counter.QuotaUsed(r.Context(), client, day)
+			if err != nil {
+				l.With(log.Error(err)).ErrorContext(r.Context(), "Failed to fetch the quota usage")
+				response.New(w).InternalServer("failed to fetch the quota usage")
+				return
+			}
+			if used >= q.limit {
+				l.InfoContext(r.Context(), "Daily quota exceeded")
+				response.New(w).TooManyRequests(day.AddDate(0, 0, 1).Sub(now), "daily quota exceeded")
+				return
+			}
+			ctx, bookings := shipment.WithBookings(r.Context())
+			next.ServeHTTP(w, r.WithContext(ctx))
+			if n := bookings.Count(); n > 0 {
+				// The answer is sent already, the bookings are counted even if the caller went away.
+				if err := q.counter.UseQuota(context.WithoutCancel(ctx), client, day, n); err != nil {
+					l.With(slog.Int("shipments", n), log.Error(err)).ErrorContext(r.Context(), "Failed to count the booked shipments")
+				}
+			}
+		})
+	}
+}
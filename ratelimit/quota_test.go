@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type mockCounter struct{ mock.Mock }
+
+func (m *mockCounter) QuotaUsed(ctx context.Context, client string, day time.Time) (int, error) {
+	args := m.Called(ctx, client, day)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *mockCounter) UseQuota(ctx context.Context, client string, day time.Time, n int) error {
+	return m.Called(ctx, client, day, n).Error(0)
+}
+
+// bookingClient books on every provider but the failing one.
+type bookingClient struct{}
+
+func (bookingClient) Do(_ context.Context, to string, _ any) ([]byte, error) {
+	if to == "https://failing" {
+		return nil, errors.New("rejected")
+	}
+	return []byte(`{}`), nil
+}
+
+type discard struct{}
+
+func (discard) Save(context.Context, []shipment.Attempt) error { return nil }
+
+// book sends a shipment booked by n providers and rejected by another one.
+func book(t *testing.T, ctx context.Context, n int) {
+	t.Helper()
+	providers := map[string]shipment.Payloader{"failing": limited{to: "https://failing"}}
+	for i := range n {
+		name := fmt.Sprintf("p%d", i)
+		providers[name] = limited{to: "https://" + name}
+	}
+	_, err := shipment.New(bookingClient{}, providers, discard{}).Send(ctx, nil, &api.ShippingRequest{})
+	require.NoError(t, err)
+}
+
+func TestQuota_Middleware(t *testing.T) {
+	now := time.Date(2026, 10, 18, 18, 0, 0, 0, time.UTC)
+	day := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		limit      int
+		booked     int
+		setupMock  func(m *mockCounter)
+		statusCode int
+		retryAfter string
+	}{
+		{
+			name:       "disabled",
+			booked:     1,
+			setupMock:  func(*mockCounter) {},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:   "booked shipments are counted",
+			limit:  100,
+			booked: 2,
+			setupMock: func(m *mockCounter) {
+				m.On("QuotaUsed", mock.Anything, "ip:192.0.2.1", day).Return(99, nil)
+				m.On("UseQuota", mock.Anything, "ip:192.0.2.1", day, 2).Return(nil)
+			},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:  "nothing booked is not counted",
+			limit: 100,
+			setupMock: func(m *mockCounter) {
+				m.On("QuotaUsed", mock.Anything, "ip:192.0.2.1", day).Return(0, nil)
+			},
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:  "quota used",
+			limit: 100,
+			setupMock: func(m *mockCounter) {
+				m.On("QuotaUsed", mock.Anything, "ip:192.0.2.1", day).Return(100, nil)
+			},
+			statusCode: http.StatusTooManyRequests,
+			retryAfter: "21600",
+		},
+		{
+			name:  "counter fails",
+			limit: 100,
+			setupMock: func(m *mockCounter) {
+				m.On("QuotaUsed", mock.Anything, "ip:192.0.2.1", day).Return(0, errors.New("boom"))
+			},
+			statusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := new(mockCounter)
+			tt.setupMock(counter)
+			q := NewQuota(counter, tt.limit)
+			q.now = func() time.Time { return now }
+			h := q.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				book(t, r.Context(), tt.booked)
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.retryAfter, w.Header().Get("Retry-After"))
+			counter.AssertExpectations(t)
+		})
+	}
+}
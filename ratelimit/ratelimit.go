@@ -0,0 +1,30 @@
+// Package ratelimit limits how many requests each client can make, per
+// second with token buckets and per day with quotas, and how fast the
+// provider calls are made so they stay within the provider limits.
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/hoenirvili/axiogate/auth"
+)
+
+// ClientKey returns the key the request is limited by, the authenticated
+// principal or, for the anonymous requests, the client ip.
+func ClientKey(r *http.Request) string {
+	if p, ok := auth.From(r.Context()); ok {
+		return p.Subject
+	}
+	return IPKey(r)
+}
+
+// IPKey returns the client ip of the request as the key it's limited by,
+// authenticated or not.
+func IPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,49 @@
+// Package requestid carries the correlation id of a request through the context
+// so the logs, the storage rows and the carrier calls can be tied together.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header is the http header carrying the request id.
+const Header = "X-Request-ID"
+
+// maxLen bounds the size of an id accepted from the caller.
+const maxLen = 128
+
+type key struct{}
+
+// New generates a random request id.
+func New() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports if the id received from a caller can be used as it is,
+// it has to be short and made only of printable ascii characters.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// With returns a copy of the context carrying the request id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// From returns the request id from the context or empty if there is none.
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
@@ -0,0 +1,413 @@
+// Package retention scrubs the personal data out of the stored shipments
+// and the audited exchanges once they are old enough and deletes them,
+// the shipments archived or not, later on.
+package retention
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// Store holds the shipments and the exchanges the retention applies to.
+type Store interface {
+	// Pending counts the shipments created before scrubBefore that weren't
+	// scrubbed yet and won't be deleted, and the ones created before deleteBefore.
+	Pending(ctx context.Context, scrubBefore, deleteBefore time.Time) (scrub int, expired int, err error)
+	// Unscrubbed returns the oldest shipments created before the time that weren't scrubbed
+	// yet, leaving out the skipped ones. The ids of the shipments that can't be decrypted
+	// are returned apart from the records.
+	Unscrubbed(ctx context.Context, before time.Time, limit int, skip []string) (records []shipment.Record, unreadable []string, err error)
+	// Scrub replaces the payloads of the shipment and marks it as scrubbed.
+	Scrub(ctx context.Context, id string, request, response json.RawMessage) error
+	// Expired returns the oldest shipments created before the time, like Unscrubbed.
+	Expired(ctx context.Context, before time.Time, limit int, skip []string) (records []shipment.Record, unreadable []string, err error)
+	// Delete deletes the shipments.
+	Delete(ctx context.Context, ids []string) error
+	// PendingExchanges counts the exchanges started before scrubBefore that still
+	// have their bodies and won't be deleted, and the ones started before deleteBefore.
+	PendingExchanges(ctx context.Context, scrubBefore, deleteBefore time.Time) (scrub int, expired int, err error)
+	// ScrubExchanges drops the bodies of the exchanges started before the time.
+	ScrubExchanges(ctx context.Context, before time.Time) (int, error)
+	// DeleteExchanges deletes the exchanges started before the time.
+	DeleteExchanges(ctx context.Context, before time.Time) (int, error)
+	// Exclusive runs fn if no one else is running it, it reports
+	// false without running fn if someone is.
+	Exclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
+}
+
+// Sealer encrypts the archived shipments, they hold the personal data the
+// retention didn't scrub yet and are kept as encrypted as they were stored.
+type Sealer interface {
+	Seal(plaintext, additional []byte) ([]byte, error)
+}
+
+// DefaultFields match the payload keys holding personal data in the
+// provider formats, names, contacts and street addresses.
+var DefaultFields = []string{
+	"name", "person", "email", "phone", "mobile", "mob",
+	"address", "line1", "line2", "street", "zip", "postal",
+}
+
+// Policy sets how long each class of data is kept.
+type Policy struct {
+	// ScrubAfter is how long the personal data is kept in the payloads, zero keeps it.
+	ScrubAfter time.Duration
+	// DeleteAfter is how long the shipments are kept, zero keeps them.
+	DeleteAfter time.Duration
+	// ArchiveDir gets the deleted shipments as gzipped json lines, empty deletes
+	// them for good. With a sealer every line is the envelope of a shipment.
+	ArchiveDir string
+	// Fields match the payload keys holding personal data, a key matches
+	// if it contains any of them, ignoring the case. Empty uses DefaultFields.
+	Fields []string
+}
+
+// Report is the outcome of a retention run.
+type Report struct {
+	DryRun bool `json:"dryRun"`
+	// Skipped runs found another one in progress.
+	Skipped bool `json:"skipped,omitempty"`
+	// Scrubbed and Deleted are the shipments that were, or on a dry run would be, scrubbed and deleted.
+	Scrubbed int `json:"scrubbed"`
+	Deleted  int `json:"deleted"`
+	// ExchangesScrubbed and ExchangesDeleted are the same for the audited exchanges.
+	ExchangesScrubbed int `json:"exchangesScrubbed"`
+	ExchangesDeleted  int `json:"exchangesDeleted"`
+	// Archive is the file the deleted shipments were written to.
+	Archive string `json:"archive,omitempty"`
+	// Unreadable are the shipments left as they were because they can't be decrypted.
+	Unreadable []string `json:"unreadable,omitempty"`
+}
+
+// Retention applies the policy to the stored shipments and exchanges.
+type Retention struct {
+	store  Store
+	sealer Sealer
+	policy Policy
+	batch  int
+	log    *slog.Logger
+	now    func() time.Time
+}
+
+type Option func(r *Retention)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(r *Retention) {
+		r.log = log.WithGroup("retention")
+	}
+}
+
+// WithSealer encrypts the archived shipments, a nil sealer archives them as they are.
+func WithSealer(s Sealer) Option {
+	return func(r *Retention) {
+		r.sealer = s
+	}
+}
+
+// New returns the retention applying the policy to the store.
+func New(store Store, policy Policy, options ...Option) *Retention {
+	if len(policy.Fields) == 0 {
+		policy.Fields = DefaultFields
+	}
+	r := &Retention{
+		store:  store,
+		policy: policy,
+		batch:  500,
+		log:    log.Noop(),
+		now:    time.Now,
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// cutoff returns the creation time before which the data is past the ttl,
+// the zero time when the data is kept forever.
+func (r *Retention) cutoff(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return r.now().Add(-ttl)
+}
+
+// Run deletes the expired shipments and exchanges, scrubs the personal data
+// of the other shipments past their ttl and drops the bodies of the other
+// exchanges. A dry run only reports what would be done.
+func (r *Retention) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	scrubBefore, deleteBefore := r.cutoff(r.policy.ScrubAfter), r.cutoff(r.policy.DeleteAfter)
+	report := &Report{DryRun: dryRun}
+	if dryRun {
+		scrub, expired, err := r.store.Pending(ctx, scrubBefore, deleteBefore)
+		if err != nil {
+			return nil, err
+		}
+		report.Scrubbed, report.Deleted = scrub, expired
+		if report.ExchangesScrubbed, report.ExchangesDeleted, err = r.store.PendingExchanges(ctx, scrubBefore, deleteBefore); err != nil {
+			return nil, err
+		}
+		return report, nil
+	}
+	ran, err := r.store.Exclusive(ctx, func(ctx context.Context) error {
+		if !deleteBefore.IsZero() {
+			if err := r.delete(ctx, deleteBefore, report); err != nil {
+				return err
+			}
+			deleted, err := r.store.DeleteExchanges(ctx, deleteBefore)
+			if err != nil {
+				return err
+			}
+			report.ExchangesDeleted = deleted
+		}
+		if !scrubBefore.IsZero() {
+			if err := r.scrub(ctx, scrubBefore, report); err != nil {
+				return err
+			}
+			scrubbed, err := r.store.ScrubExchanges(ctx, scrubBefore)
+			if err != nil {
+				return err
+			}
+			report.ExchangesScrubbed = scrubbed
+		}
+		return nil
+	})
+	if !ran && err != nil {
+		r.log.With(log.Error(err)).ErrorContext(ctx, "Failed to start the retention")
+		return nil, err
+	}
+	report.Skipped = !ran
+	if report.Skipped {
+		r.log.InfoContext(ctx, "Retention skipped, it's already running elsewhere")
+		return report, nil
+	}
+	l := r.log.With(
+		slog.Int("scrubbed", report.Scrubbed),
+		slog.Int("deleted", report.Deleted),
+		slog.Int("exchangesScrubbed", report.ExchangesScrubbed),
+		slog.Int("exchangesDeleted", report.ExchangesDeleted),
+		slog.String("archive", report.Archive),
+		slog.Int("unreadable", len(report.Unreadable)),
+	)
+	if err != nil {
+		l.With(log.Error(err)).ErrorContext(ctx, "Retention stopped halfway")
+		return report, err
+	}
+	l.InfoContext(ctx, "Retention applied")
+	return report, nil
+}
+
+// Schedule runs the retention every interval until the context is done.
+func (r *Retention) Schedule(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The failures are logged by Run, the next tick tries again.
+			_, _ = r.Run(ctx, false)
+		}
+	}
+}
+
+func (r *Retention) delete(ctx context.Context, before time.Time, report *Report) error {
+	var archive *archive
+	defer func() {
+		if archive != nil {
+			if err := archive.Close(); err != nil {
+				r.log.With(log.Error(err)).ErrorContext(ctx, "Failed to close the archive")
+			}
+		}
+	}()
+	skip := []string{}
+	for {
+		records, unreadable, err := r.store.Expired(ctx, before, r.batch, skip)
+		if err != nil {
+			return err
+		}
+		skip = report.unreadable(skip, unreadable)
+		if len(records) == 0 {
+			if len(unreadable) > 0 {
+				continue
+			}
+			return nil
+		}
+		if r.policy.ArchiveDir != "" {
+			if archive == nil {
+				path := filepath.Join(r.policy.ArchiveDir, "shipments-"+r.now().UTC().Format("20060102T150405Z")+".jsonl.gz")
+				if archive, err = create(path, r.sealer); err != nil {
+					return err
+				}
+				report.Archive = path
+			}
+			// The shipments are on disk before they are deleted, if
+			// the delete fails they are archived again on the next run.
+			if err := archive.Write(records); err != nil {
+				return err
+			}
+		}
+		ids := make([]string, 0, len(records))
+		for _, rec := range records {
+			ids = append(ids, rec.ID)
+		}
+		if err := r.store.Delete(ctx, ids); err != nil {
+			return err
+		}
+		report.Deleted += len(records)
+	}
+}
+
+// unreadable reports the shipments that can't be decrypted and
+// returns them added to the ones skipped by the next batches.
+func (report *Report) unreadable(skip, ids []string) []string {
+	for _, id := range ids {
+		if !slices.Contains(report.Unreadable, id) {
+			report.Unreadable = append(report.Unreadable, id)
+		}
+	}
+	return append(skip, ids...)
+}
+
+func (r *Retention) scrub(ctx context.Context, before time.Time, report *Report) error {
+	skip := []string{}
+	for {
+		records, unreadable, err := r.store.Unscrubbed(ctx, before, r.batch, skip)
+		if err != nil {
+			return err
+		}
+		skip = report.unreadable(skip, unreadable)
+		if len(records) == 0 {
+			if len(unreadable) > 0 {
+				continue
+			}
+			return nil
+		}
+		for _, rec := range records {
+			request, response := Scrub(rec.Request, r.policy.Fields), Scrub(rec.Response, r.policy.Fields)
+			if err := r.store.Scrub(ctx, rec.ID, request, response); err != nil {
+				return err
+			}
+			report.Scrubbed++
+		}
+	}
+}
+
+// Redacted replaces the scrubbed values.
+const Redacted = "REDACTED"
+
+// Scrub returns the json payload with the values of the keys matching
+// any of the fields redacted, the objects and the arrays are scrubbed
+// deeply. A payload that isn't json is redacted entirely.
+func Scrub(payload json.RawMessage, fields []string) json.RawMessage {
+	if len(payload) == 0 {
+		return payload
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	// The numbers are kept as they are, account numbers don't fit a float.
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		b, _ := json.Marshal(Redacted)
+		return b
+	}
+	if s, ok := v.(string); ok && s != "" {
+		// A bare string is a provider answer that wasn't json, it can hold anything.
+		v = Redacted
+	}
+	b, err := json.Marshal(scrub(v, fields))
+	if err != nil {
+		b, _ = json.Marshal(Redacted)
+	}
+	return b
+}
+
+func scrub(v any, fields []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			switch value.(type) {
+			case map[string]any, []any:
+				v[key] = scrub(value, fields)
+			case nil:
+			default:
+				if sensitive(key, fields) {
+					v[key] = Redacted
+				}
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = scrub(value, fields)
+		}
+	}
+	return v
+}
+
+func sensitive(key string, fields []string) bool {
+	key = strings.ToLower(key)
+	for _, field := range fields {
+		if strings.Contains(key, strings.ToLower(field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// archive writes the shipments as gzipped json lines, sealed if it has a sealer.
+type archive struct {
+	f      *os.File
+	gz     *gzip.Writer
+	sealer Sealer
+}
+
+func create(path string, sealer Sealer) (*archive, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the archive, %w", err)
+	}
+	return &archive{f: f, gz: gzip.NewWriter(f), sealer: sealer}, nil
+}
+
+// Write appends the records and syncs them to disk.
+func (a *archive) Write(records []shipment.Record) error {
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err == nil && a.sealer != nil {
+			line, err = a.sealer.Seal(line, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to archive shipment %s, %w", rec.ID, err)
+		}
+		if _, err := a.gz.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write the archive, %w", err)
+		}
+	}
+	if err := a.gz.Flush(); err != nil {
+		return fmt.Errorf("failed to write the archive, %w", err)
+	}
+	if err := a.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync the archive, %w", err)
+	}
+	return nil
+}
+
+func (a *archive) Close() error {
+	if err := a.gz.Close(); err != nil {
+		_ = a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
@@ -0,0 +1,351 @@
+package retention
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/envelope"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+func TestScrub(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{
+			name:    "nested parties",
+			payload: `{"account":{"number":12345678901234567},"consignee":{"contact":{"name":"Jane","mobileNumber":"0700","emailAddress":"j@x.io"},"address":{"line1":"Main St 1","city":"Cluj","zipCode":"400000"}},"productCode":"EXP"}`,
+			want:    `{"account":{"number":12345678901234567},"consignee":{"contact":{"name":"REDACTED","mobileNumber":"REDACTED","emailAddress":"REDACTED"},"address":{"line1":"REDACTED","city":"Cluj","zipCode":"REDACTED"}},"productCode":"EXP"}`,
+		},
+		{
+			name:    "arrays of parties",
+			payload: `{"parties":[{"person":"Jane","phone":null},{"person":"John"}]}`,
+			want:    `{"parties":[{"person":"REDACTED","phone":null},{"person":"REDACTED"}]}`,
+		},
+		{
+			name:    "bare string answer",
+			payload: `"booked for Jane at Main St 1"`,
+			want:    `"REDACTED"`,
+		},
+		{
+			name:    "not json",
+			payload: `Jane, Main St 1`,
+			want:    `"REDACTED"`,
+		},
+		{
+			name:    "empty",
+			payload: ``,
+			want:    ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Scrub(json.RawMessage(tt.payload), DefaultFields)
+			if tt.want == "" {
+				assert.Empty(t, got)
+				return
+			}
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+// exchange is an audited exchange, scrubbed once its bodies are dropped.
+type exchange struct {
+	startedAt time.Time
+	scrubbed  bool
+}
+
+// store keeps the shipments and the exchanges in memory, ordered from the oldest.
+type store struct {
+	records   []shipment.Record
+	scrubbed  map[string]bool
+	exchanges []exchange
+	busy      bool
+	failOn    string
+	// unreadable are the shipments that can't be decrypted.
+	unreadable map[string]bool
+}
+
+// batch returns up to limit of the records matching, except the skipped ones,
+// and the unreadable ones apart.
+func (s *store) batch(limit int, skip []string, match func(rec shipment.Record) bool) ([]shipment.Record, []string) {
+	var (
+		out        []shipment.Record
+		unreadable []string
+	)
+	for _, rec := range s.records {
+		if !match(rec) || slices.Contains(skip, rec.ID) || len(out)+len(unreadable) == limit {
+			continue
+		}
+		if s.unreadable[rec.ID] {
+			unreadable = append(unreadable, rec.ID)
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, unreadable
+}
+
+func (s *store) Pending(_ context.Context, scrubBefore, deleteBefore time.Time) (int, int, error) {
+	scrub, expired := 0, 0
+	for _, rec := range s.records {
+		if !s.scrubbed[rec.ID] && rec.CreatedAt.Before(scrubBefore) && !rec.CreatedAt.Before(deleteBefore) {
+			scrub++
+		}
+		if rec.CreatedAt.Before(deleteBefore) {
+			expired++
+		}
+	}
+	return scrub, expired, nil
+}
+
+func (s *store) Unscrubbed(_ context.Context, before time.Time, limit int, skip []string) ([]shipment.Record, []string, error) {
+	out, unreadable := s.batch(limit, skip, func(rec shipment.Record) bool {
+		return !s.scrubbed[rec.ID] && rec.CreatedAt.Before(before)
+	})
+	return out, unreadable, nil
+}
+
+func (s *store) Scrub(_ context.Context, id string, request, response json.RawMessage) error {
+	if id == s.failOn {
+		return errors.New("db down")
+	}
+	for i, rec := range s.records {
+		if rec.ID == id {
+			s.records[i].Request, s.records[i].Response = request, response
+		}
+	}
+	s.scrubbed[id] = true
+	return nil
+}
+
+func (s *store) Expired(_ context.Context, before time.Time, limit int, skip []string) ([]shipment.Record, []string, error) {
+	out, unreadable := s.batch(limit, skip, func(rec shipment.Record) bool {
+		return rec.CreatedAt.Before(before)
+	})
+	return out, unreadable, nil
+}
+
+func (s *store) Delete(_ context.Context, ids []string) error {
+	deleted := map[string]bool{}
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	kept := s.records[:0]
+	for _, rec := range s.records {
+		if !deleted[rec.ID] {
+			kept = append(kept, rec)
+		}
+	}
+	s.records = kept
+	return nil
+}
+
+func (s *store) PendingExchanges(_ context.Context, scrubBefore, deleteBefore time.Time) (int, int, error) {
+	scrub, expired := 0, 0
+	for _, e := range s.exchanges {
+		if !e.scrubbed && e.startedAt.Before(scrubBefore) && !e.startedAt.Before(deleteBefore) {
+			scrub++
+		}
+		if e.startedAt.Before(deleteBefore) {
+			expired++
+		}
+	}
+	return scrub, expired, nil
+}
+
+func (s *store) ScrubExchanges(_ context.Context, before time.Time) (int, error) {
+	scrubbed := 0
+	for i, e := range s.exchanges {
+		if !e.scrubbed && e.startedAt.Before(before) {
+			s.exchanges[i].scrubbed = true
+			scrubbed++
+		}
+	}
+	return scrubbed, nil
+}
+
+func (s *store) DeleteExchanges(_ context.Context, before time.Time) (int, error) {
+	kept := s.exchanges[:0]
+	for _, e := range s.exchanges {
+		if !e.startedAt.Before(before) {
+			kept = append(kept, e)
+		}
+	}
+	deleted := len(s.exchanges) - len(kept)
+	s.exchanges = kept
+	return deleted, nil
+}
+
+func (s *store) Exclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	if s.busy {
+		return false, nil
+	}
+	return true, fn(ctx)
+}
+
+var now = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// newStore returns a store with a shipment created, and an
+// exchange audited, every day of the last days.
+func newStore(days int) *store {
+	s := &store{scrubbed: map[string]bool{}}
+	for day := days; day > 0; day-- {
+		s.records = append(s.records, shipment.Record{
+			ID:        time.Duration(day).String(),
+			Provider:  "a",
+			Status:    shipment.StatusBooked,
+			Request:   json.RawMessage(`{"consignee":{"contact":{"name":"Jane"}}}`),
+			Response:  json.RawMessage(`{"trackingNumber":"T1"}`),
+			CreatedAt: now.Add(-time.Duration(day) * 24 * time.Hour),
+		})
+		s.exchanges = append(s.exchanges, exchange{startedAt: now.Add(-time.Duration(day) * 24 * time.Hour)})
+	}
+	return s
+}
+
+func newRetention(s Store, policy Policy) *Retention {
+	r := New(s, policy)
+	r.batch = 2
+	r.now = func() time.Time { return now }
+	return r
+}
+
+func TestRun_DryRun(t *testing.T) {
+	s := newStore(10)
+	r := newRetention(s, Policy{ScrubAfter: 3 * 24 * time.Hour, DeleteAfter: 7 * 24 * time.Hour})
+
+	report, err := r.Run(context.Background(), true)
+	require.NoError(t, err)
+	assert.Equal(t, &Report{DryRun: true, Scrubbed: 4, Deleted: 3, ExchangesScrubbed: 4, ExchangesDeleted: 3}, report)
+	assert.Len(t, s.records, 10, "a dry run changes nothing")
+	assert.Empty(t, s.scrubbed)
+	assert.Len(t, s.exchanges, 10)
+}
+
+func TestRun(t *testing.T) {
+	s := newStore(10)
+	dir := t.TempDir()
+	r := newRetention(s, Policy{ScrubAfter: 3 * 24 * time.Hour, DeleteAfter: 7 * 24 * time.Hour, ArchiveDir: dir})
+
+	report, err := r.Run(context.Background(), false)
+	require.NoError(t, err)
+	archive := filepath.Join(dir, "shipments-20261018T120000Z.jsonl.gz")
+	assert.Equal(t, &Report{Scrubbed: 4, Deleted: 3, ExchangesScrubbed: 4, ExchangesDeleted: 3, Archive: archive}, report)
+
+	require.Len(t, s.exchanges, 7)
+	for _, e := range s.exchanges {
+		assert.Equal(t, e.startedAt.Before(now.Add(-3*24*time.Hour)), e.scrubbed, e.startedAt)
+	}
+
+	require.Len(t, s.records, 7)
+	for _, rec := range s.records {
+		scrubbed := rec.CreatedAt.Before(now.Add(-3 * 24 * time.Hour))
+		assert.Equal(t, scrubbed, s.scrubbed[rec.ID], rec.ID)
+		if scrubbed {
+			assert.JSONEq(t, `{"consignee":{"contact":{"name":"REDACTED"}}}`, string(rec.Request))
+			assert.JSONEq(t, `{"trackingNumber":"T1"}`, string(rec.Response))
+		}
+	}
+
+	f, err := os.Open(archive)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	var archived []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var rec shipment.Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		archived = append(archived, rec.ID)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"10ns", "9ns", "8ns"}, archived, "the deleted shipments are archived unscrubbed")
+}
+
+func TestRun_Sealed(t *testing.T) {
+	keyring, err := envelope.New("k1", map[string][]byte{"k1": make([]byte, envelope.KeySize)})
+	require.NoError(t, err)
+	s := newStore(10)
+	dir := t.TempDir()
+	r := newRetention(s, Policy{DeleteAfter: 7 * 24 * time.Hour, ArchiveDir: dir})
+	WithSealer(keyring)(r)
+
+	report, err := r.Run(context.Background(), false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Deleted)
+
+	f, err := os.Open(report.Archive)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	var archived []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		assert.NotContains(t, scanner.Text(), "Jane", "the personal data is never archived in the clear")
+		var e envelope.Envelope
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		assert.Equal(t, "k1", e.KeyID)
+		plaintext, err := keyring.Open(scanner.Bytes(), nil)
+		require.NoError(t, err)
+		var rec shipment.Record
+		require.NoError(t, json.Unmarshal(plaintext, &rec))
+		archived = append(archived, rec.ID)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"10ns", "9ns", "8ns"}, archived)
+}
+
+func TestRun_Unreadable(t *testing.T) {
+	s := newStore(10)
+	s.unreadable = map[string]bool{"10ns": true, "9ns": true}
+	r := newRetention(s, Policy{ScrubAfter: 3 * 24 * time.Hour, DeleteAfter: 7 * 24 * time.Hour})
+
+	report, err := r.Run(context.Background(), false)
+	require.NoError(t, err, "the shipments that can't be decrypted don't stop the run")
+	assert.Equal(t, &Report{Scrubbed: 4, Deleted: 1, ExchangesScrubbed: 4, ExchangesDeleted: 3, Unreadable: []string{"10ns", "9ns"}}, report)
+	require.Len(t, s.records, 9)
+	assert.Equal(t, "10ns", s.records[0].ID, "the unreadable shipments are left as they were")
+	assert.Equal(t, "9ns", s.records[1].ID)
+}
+
+func TestRun_Skipped(t *testing.T) {
+	s := newStore(10)
+	s.busy = true
+	r := newRetention(s, Policy{DeleteAfter: 24 * time.Hour})
+
+	report, err := r.Run(context.Background(), false)
+	require.NoError(t, err)
+	assert.Equal(t, &Report{Skipped: true}, report)
+	assert.Len(t, s.records, 10)
+	assert.Len(t, s.exchanges, 10)
+}
+
+func TestRun_Failed(t *testing.T) {
+	s := newStore(10)
+	s.failOn = "8ns"
+	r := newRetention(s, Policy{ScrubAfter: 24 * time.Hour})
+
+	report, err := r.Run(context.Background(), false)
+	assert.Error(t, err)
+	assert.Equal(t, &Report{Scrubbed: 2}, report, "the shipments scrubbed before the failure are reported")
+	for _, e := range s.exchanges {
+		assert.False(t, e.scrubbed, "the exchanges are scrubbed after the shipments")
+	}
+}
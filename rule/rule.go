@@ -0,0 +1,292 @@
+// Package rule decides which providers are eligible for a shipment
+// based on rules that can be changed at runtime.
+package rule
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// Actions a rule applies on the providers when its condition matches.
+const (
+	// ActionInclude restricts the candidates to the rule providers.
+	ActionInclude = "include"
+	// ActionExclude removes the rule providers from the candidates.
+	ActionExclude = "exclude"
+	// ActionPrefer moves the rule providers in front of the candidates.
+	ActionPrefer = "prefer"
+)
+
+var actions = []string{ActionInclude, ActionExclude, ActionPrefer}
+
+// regions are country groups that can be used instead of country codes.
+var regions = map[string][]string{
+	"GCC": {"AE", "SA", "KW", "QA", "BH", "OM"},
+	"EU": {
+		"AT", "BE", "BG", "HR", "CY", "CZ", "DK", "EE", "FI", "FR", "DE", "GR", "HU", "IE",
+		"IT", "LV", "LT", "LU", "MT", "NL", "PL", "PT", "RO", "SK", "SI", "ES", "SE",
+	},
+}
+
+// ErrNotFound is returned when a rule does not exist.
+var ErrNotFound = errors.New("rule not found")
+
+// ErrInvalidRule is returned when a rule can't be saved.
+type ErrInvalidRule struct {
+	Reason string
+}
+
+var _ error = (*ErrInvalidRule)(nil)
+
+func (e *ErrInvalidRule) Error() string {
+	return fmt.Sprintf("invalid rule, %s", e.Reason)
+}
+
+// Condition is matched against a shipment request, empty fields match everything.
+type Condition struct {
+	// Origins are shipper country codes or regions.
+	Origins []string `json:"origins,omitempty"`
+	// Destinations are consignee country codes or regions.
+	Destinations []string `json:"destinations,omitempty"`
+	// MinWeight and MaxWeight are in kilograms.
+	MinWeight *float64 `json:"minWeight,omitempty"`
+	MaxWeight *float64 `json:"maxWeight,omitempty"`
+	// MinDeclaredValue and MaxDeclaredValue are in the declared currency.
+	MinDeclaredValue *float64 `json:"minDeclaredValue,omitempty"`
+	MaxDeclaredValue *float64 `json:"maxDeclaredValue,omitempty"`
+	Currencies       []string `json:"currencies,omitempty"`
+	ServiceTypes     []string `json:"serviceTypes,omitempty"`
+	COD              *bool    `json:"cod,omitempty"`
+}
+
+func in(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+		countries, ok := regions[strings.ToUpper(value)]
+		if ok && slices.Contains(countries, strings.ToUpper(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+func between(min, max *float64, v float64) bool {
+	return (min == nil || v >= *min) && (max == nil || v <= *max)
+}
+
+// Match reports if the shipment request satisfies the condition.
+func (c *Condition) Match(req *api.ShippingRequest) bool {
+	return in(c.Origins, req.Shipper.Address.CountryCode) &&
+		in(c.Destinations, req.Consignee.Address.CountryCode) &&
+		between(c.MinWeight, c.MaxWeight, req.Weight.KG()) &&
+		between(c.MinDeclaredValue, c.MaxDeclaredValue, req.DeclaredValue.Amount) &&
+		in(c.Currencies, req.DeclaredValue.Currency) &&
+		in(c.ServiceTypes, req.ServiceType) &&
+		(c.COD == nil || *c.COD == req.IsCOD)
+}
+
+// Rule applies the action on its providers when the condition matches.
+type Rule struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Priority  int       `json:"priority"`
+	Condition Condition `json:"condition"`
+	Action    string    `json:"action"`
+	Providers []string  `json:"providers"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Validate checks if the rule can be applied.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return &ErrInvalidRule{Reason: "name is required"}
+	}
+	if !slices.Contains(actions, r.Action) {
+		return &ErrInvalidRule{Reason: fmt.Sprintf("unknown action %s", r.Action)}
+	}
+	if len(r.Providers) == 0 {
+		return &ErrInvalidRule{Reason: "at least one provider is required"}
+	}
+	return nil
+}
+
+// Store persists the rules.
+type Store interface {
+	CreateRule(ctx context.Context, r *Rule) error
+	Rule(ctx context.Context, id int64) (*Rule, error)
+	Rules(ctx context.Context) ([]Rule, error)
+	UpdateRule(ctx context.Context, r *Rule) error
+	DeleteRule(ctx context.Context, id int64) error
+}
+
+// Engine evaluates the stored rules against shipment requests.
+// Rules are cached and reloaded after the ttl or after every change.
+type Engine struct {
+	store Store
+	log   *slog.Logger
+	ttl   time.Duration
+
+	mu     sync.Mutex
+	rules  []Rule
+	loaded time.Time
+}
+
+type Option func(e *Engine)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(e *Engine) {
+		e.log = log.WithGroup("rule")
+	}
+}
+
+// WithTTL sets for how long the rules are cached, this bounds how
+// long it takes for changes made by other replicas to be picked up.
+func WithTTL(ttl time.Duration) Option {
+	return func(e *Engine) {
+		e.ttl = ttl
+	}
+}
+
+// New returns a new rule engine backed by the store.
+func New(store Store, options ...Option) *Engine {
+	e := &Engine{
+		store: store,
+		log:   log.Noop(),
+		ttl:   30 * time.Second,
+	}
+	for _, option := range options {
+		option(e)
+	}
+	return e
+}
+
+var _ shipment.Eligibility = (*Engine)(nil)
+
+func (e *Engine) load(ctx context.Context) ([]Rule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.rules != nil && time.Since(e.loaded) < e.ttl {
+		return e.rules, nil
+	}
+	rules, err := e.store.Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules = slices.DeleteFunc(rules, func(r Rule) bool { return !r.Enabled })
+	slices.SortStableFunc(rules, func(a, b Rule) int {
+		return cmp.Or(cmp.Compare(a.Priority, b.Priority), cmp.Compare(a.ID, b.ID))
+	})
+	e.rules, e.loaded = rules, time.Now()
+	return rules, nil
+}
+
+func (e *Engine) invalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = nil
+}
+
+// Eligible returns the providers allowed to handle the request, preferred ones first.
+// Rules are applied in priority order, lower values first.
+func (e *Engine) Eligible(ctx context.Context, req *api.ShippingRequest, providers []string) ([]string, error) {
+	rules, err := e.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		included  []string
+		excluded  []string
+		preferred []string
+		restrict  bool
+	)
+	for _, r := range rules {
+		if !r.Condition.Match(req) {
+			continue
+		}
+		e.log.With(slog.Int64("id", r.ID), slog.String("name", r.Name)).
+			DebugContext(ctx, "Rule matched")
+		switch r.Action {
+		case ActionInclude:
+			restrict = true
+			included = append(included, r.Providers...)
+		case ActionExclude:
+			excluded = append(excluded, r.Providers...)
+		case ActionPrefer:
+			preferred = append(preferred, r.Providers...)
+		}
+	}
+	out := slices.DeleteFunc(slices.Clone(providers), func(p string) bool {
+		return (restrict && !slices.Contains(included, p)) || slices.Contains(excluded, p)
+	})
+	rank := func(p string) int {
+		if i := slices.Index(preferred, p); i >= 0 {
+			return i
+		}
+		return len(preferred)
+	}
+	slices.SortStableFunc(out, func(a, b string) int {
+		return cmp.Compare(rank(a), rank(b))
+	})
+	return out, nil
+}
+
+// Rules returns all the rules, enabled or not.
+func (e *Engine) Rules(ctx context.Context) ([]Rule, error) {
+	return e.store.Rules(ctx)
+}
+
+// Rule returns the rule with the given id.
+func (e *Engine) Rule(ctx context.Context, id int64) (*Rule, error) {
+	return e.store.Rule(ctx, id)
+}
+
+// Create validates and saves a new rule.
+func (e *Engine) Create(ctx context.Context, r *Rule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	if err := e.store.CreateRule(ctx, r); err != nil {
+		return err
+	}
+	e.invalidate()
+	return nil
+}
+
+// Update validates and replaces an existing rule.
+func (e *Engine) Update(ctx context.Context, r *Rule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	if err := e.store.UpdateRule(ctx, r); err != nil {
+		return err
+	}
+	e.invalidate()
+	return nil
+}
+
+// Delete removes a rule.
+func (e *Engine) Delete(ctx context.Context, id int64) error {
+	if err := e.store.DeleteRule(ctx, id); err != nil {
+		return err
+	}
+	e.invalidate()
+	return nil
+}
@@ -0,0 +1,159 @@
+package rule
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+type mockStore struct{ mock.Mock }
+
+func (m *mockStore) CreateRule(ctx context.Context, r *Rule) error {
+	return m.Called(ctx, r).Error(0)
+}
+
+func (m *mockStore) Rule(ctx context.Context, id int64) (*Rule, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Rule), args.Error(1)
+}
+
+func (m *mockStore) Rules(ctx context.Context) ([]Rule, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Rule), args.Error(1)
+}
+
+func (m *mockStore) UpdateRule(ctx context.Context, r *Rule) error {
+	return m.Called(ctx, r).Error(0)
+}
+
+func (m *mockStore) DeleteRule(ctx context.Context, id int64) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestEngine_Eligible(t *testing.T) {
+	gccCOD := &api.ShippingRequest{
+		Weight:    api.Weight{Value: 2, Unit: "KG"},
+		Shipper:   api.Party{Address: api.Address{CountryCode: "US"}},
+		Consignee: api.Party{Address: api.Address{CountryCode: "AE"}},
+		IsCOD:     true,
+	}
+	heavy := &api.ShippingRequest{
+		Weight:        api.Weight{Value: 35000, Unit: "Grams"},
+		Shipper:       api.Party{Address: api.Address{CountryCode: "US"}},
+		Consignee:     api.Party{Address: api.Address{CountryCode: "RO"}},
+		DeclaredValue: api.Money{Amount: 1200, Currency: "USD"},
+	}
+	onlyBForGCCCOD := Rule{
+		ID:        1,
+		Name:      "only b for gcc cod",
+		Action:    ActionInclude,
+		Providers: []string{"b"},
+		Enabled:   true,
+		Condition: Condition{Destinations: []string{"GCC"}, COD: ptr(true)},
+	}
+	noHeavyA := Rule{
+		ID:        2,
+		Name:      "no heavy packages to a",
+		Action:    ActionExclude,
+		Providers: []string{"a"},
+		Enabled:   true,
+		Condition: Condition{MinWeight: ptr(30.0)},
+	}
+	preferCForValuable := Rule{
+		ID:        3,
+		Name:      "prefer c for valuable",
+		Action:    ActionPrefer,
+		Providers: []string{"c"},
+		Enabled:   true,
+		Condition: Condition{MinDeclaredValue: ptr(1000.0), Currencies: []string{"usd"}},
+	}
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		req   *api.ShippingRequest
+		want  []string
+	}{
+		{
+			name:  "no rules",
+			req:   gccCOD,
+			rules: []Rule{},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "include restricts the candidates",
+			req:   gccCOD,
+			rules: []Rule{onlyBForGCCCOD, noHeavyA, preferCForValuable},
+			want:  []string{"b"},
+		},
+		{
+			name:  "exclude and prefer",
+			req:   heavy,
+			rules: []Rule{onlyBForGCCCOD, noHeavyA, preferCForValuable},
+			want:  []string{"c", "b"},
+		},
+		{
+			name: "disabled rules are ignored",
+			req:  heavy,
+			rules: []Rule{
+				{ID: 2, Name: "off", Action: ActionExclude, Providers: []string{"a"}},
+			},
+			want: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := new(mockStore)
+			store.On("Rules", mock.Anything).Return(tt.rules, nil).Once()
+			e := New(store)
+
+			got, err := e.Eligible(context.Background(), tt.req, []string{"a", "b", "c"})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// Second evaluation is served from the cache.
+			_, err = e.Eligible(context.Background(), tt.req, []string{"a", "b", "c"})
+			assert.NoError(t, err)
+			store.AssertExpectations(t)
+		})
+	}
+}
+
+func TestEngine_EligibleStoreError(t *testing.T) {
+	store := new(mockStore)
+	store.On("Rules", mock.Anything).Return(nil, errors.New("db down"))
+	_, err := New(store).Eligible(context.Background(), &api.ShippingRequest{}, []string{"a"})
+	assert.EqualError(t, err, "db down")
+}
+
+func TestEngine_CreateInvalidatesCache(t *testing.T) {
+	store := new(mockStore)
+	store.On("Rules", mock.Anything).Return([]Rule{}, nil).Twice()
+	store.On("CreateRule", mock.Anything, mock.Anything).Return(nil)
+	e := New(store)
+
+	_, err := e.Eligible(context.Background(), &api.ShippingRequest{}, []string{"a"})
+	assert.NoError(t, err)
+	err = e.Create(context.Background(), &Rule{Name: "x", Action: ActionPrefer, Providers: []string{"a"}})
+	assert.NoError(t, err)
+	_, err = e.Eligible(context.Background(), &api.ShippingRequest{}, []string{"a"})
+	assert.NoError(t, err)
+	store.AssertExpectations(t)
+
+	err = e.Create(context.Background(), &Rule{Name: "x", Action: "drop", Providers: []string{"a"}})
+	assert.IsType(t, &ErrInvalidRule{}, err)
+}
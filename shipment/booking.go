@@ -0,0 +1,38 @@
+package shipment
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Bookings counts the shipments the providers booked while handling a request.
+type Bookings struct {
+	n atomic.Int64
+}
+
+// Count returns how many shipments were booked.
+func (b *Bookings) Count() int {
+	return int(b.n.Load())
+}
+
+type bookingsKey struct{}
+
+// WithBookings returns a copy of the context counting the shipments
+// booked with it in the returned bookings.
+func WithBookings(ctx context.Context) (context.Context, *Bookings) {
+	b := new(Bookings)
+	return context.WithValue(ctx, bookingsKey{}, b), b
+}
+
+// booked counts the booked attempts in the bookings of the context, if it has any.
+func booked(ctx context.Context, attempts []Attempt) {
+	b, ok := ctx.Value(bookingsKey{}).(*Bookings)
+	if !ok {
+		return
+	}
+	for _, a := range attempts {
+		if a.Status == StatusBooked {
+			b.n.Add(1)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package shipment
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/request"
+)
+
+// Providers returns the providers the caller may ship with,
+// in the order they are preferred.
+func (s *Shipment) Providers(ctx context.Context) ([]api.Provider, error) {
+	jobs, err := s.candidates(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err = s.authorized(ctx, jobs, false)
+	if err != nil {
+		return nil, err
+	}
+	jobs = s.byPriority(jobs)
+	out := make([]api.Provider, 0, len(jobs))
+	for _, j := range jobs {
+		_, quotes := j.payloader.(Quoter)
+		out = append(out, api.Provider{Name: j.provider, Endpoint: j.payloader.To(), Quotes: quotes})
+	}
+	return out, nil
+}
+
+// Map returns the payload the provider would be sent for the request,
+// without sending it. The credentials of the accounts are redacted.
+func (s *Shipment) Map(ctx context.Context, provider string, req *api.ShippingRequest) (json.RawMessage, error) {
+	jobs, err := s.candidates(ctx, []string{provider})
+	if err != nil {
+		return nil, err
+	}
+	jobs, err = s.authorized(ctx, jobs, true)
+	if err != nil {
+		return nil, err
+	}
+	return request.RedactBody(jobs[0].payloader.Payload(req)), nil
+}
+
+// Owner returns the provider owning the endpoint, the one with the longest
+// of its booking, rate or cancel endpoints the endpoint starts with. The
+// longest wins so a provider nested under another's base url is told apart.
+func Owner(providers map[string]Payloader, to string) (string, bool) {
+	var (
+		owner string
+		best  = -1
+	)
+	for name, p := range providers {
+		if p == nil {
+			continue
+		}
+		endpoints := []string{p.To()}
+		if q, ok := p.(Quoter); ok {
+			endpoints = append(endpoints, q.QuoteTo())
+		}
+		if v, ok := p.(Voider); ok {
+			endpoints = append(endpoints, v.VoidTo())
+		}
+		for _, e := range endpoints {
+			if !strings.HasPrefix(to, e) {
+				continue
+			}
+			if len(e) > best || (len(e) == best && name < owner) {
+				owner, best = name, len(e)
+			}
+		}
+	}
+	return owner, best >= 0
+}
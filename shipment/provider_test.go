@@ -0,0 +1,105 @@
+package shipment
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+func providers(names ...string) map[string]Payloader {
+	out := map[string]Payloader{}
+	for _, name := range names {
+		p := new(mockPayloader)
+		p.On("Payload", mock.Anything).Return([]byte(`{"carrier":"` + name + `"}`)).Maybe()
+		p.On("To").Return("https://" + name + ".example.com").Maybe()
+		out[name] = p
+	}
+	q := newMockQuoter("quoter", nil, nil)
+	q.On("To").Return("https://quoter.example.com").Maybe()
+	out["quoter"] = q
+	return out
+}
+
+func TestShipment_Providers(t *testing.T) {
+	s := New(nil, providers("provider1", "provider2", "provider3"), nil,
+		WithPriority("provider3"),
+		WithAuthorizer(scopeAuthorizer{"provider1", "provider3", "quoter"}),
+	)
+
+	got, err := s.Providers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []api.Provider{
+		{Name: "provider3", Endpoint: "https://provider3.example.com"},
+		{Name: "provider1", Endpoint: "https://provider1.example.com"},
+		{Name: "quoter", Endpoint: "https://quoter.example.com", Quotes: true},
+	}, got)
+}
+
+func TestShipment_Map(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		want     json.RawMessage
+		wantErr  error
+	}{
+		{name: "mapped", provider: "provider1", want: json.RawMessage(`{"carrier":"provider1"}`)},
+		{name: "unknown provider", provider: "provider9", wantErr: &ErrProviderUnsupported{Provider: "provider9"}},
+		{name: "outside the scope", provider: "provider2", wantErr: &ErrProviderForbidden{Provider: "provider2"}},
+		{
+			name:     "credentials redacted",
+			provider: "b",
+			want:     json.RawMessage(`{"AccountNo":"REDACTED","Origin":"CLJ","Password":"REDACTED","UserName":"REDACTED"}`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := new(mockClient)
+			payloaders := providers("provider1", "provider2")
+			payloaders["b"] = racePayloader(`{"UserName":"u1","Password":"p1","AccountNo":"a1","Origin":"CLJ"}`)
+			s := New(client, payloaders, nil, WithAuthorizer(scopeAuthorizer{"provider1", "b"}))
+
+			got, err := s.Map(context.Background(), tt.provider, &api.ShippingRequest{})
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, got)
+			client.AssertNotCalled(t, "Do", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestOwner(t *testing.T) {
+	q := newMockQuoter("quoter", nil, nil)
+	q.On("To").Return("https://quoter.example.com/ship")
+	providers := map[string]Payloader{
+		"gateway": racePayloader("gw"),
+		"nested":  racePayloader("gw/nested"),
+		"quoter":  q,
+	}
+	tests := []struct {
+		name   string
+		to     string
+		want   string
+		wantOk bool
+	}{
+		{name: "booking endpoint", to: "https://gw/shipments", want: "gateway", wantOk: true},
+		{name: "cancel endpoint", to: "https://gw/void", want: "gateway", wantOk: true},
+		{name: "nested under another provider", to: "https://gw/nested/shipments", want: "nested", wantOk: true},
+		{name: "nested cancel endpoint", to: "https://gw/nested/void", want: "nested", wantOk: true},
+		{name: "rate endpoint", to: "https://quoter.example.com/quote", want: "quoter", wantOk: true},
+		{name: "unknown endpoint", to: "https://elsewhere"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 10 {
+				got, ok := Owner(providers, tt.to)
+				assert.Equal(t, tt.wantOk, ok)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
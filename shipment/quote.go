@@ -0,0 +1,124 @@
+package shipment
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+// Quoter is implemented by the providers that can price
+// a shipment before it is booked.
+type Quoter interface {
+	// QuotePayload returns the rate request payload for the provider.
+	QuotePayload(req *api.ShippingRequest) []byte
+	// QuoteTo returns the rate endpoint of the provider.
+	QuoteTo() string
+	// Quote normalizes the raw provider rate response.
+	Quote(raw []byte) (*api.Quote, error)
+}
+
+// Criteria quotes can be sorted by.
+const (
+	SortPrice   = "price"
+	SortTransit = "transit"
+)
+
+// ErrSortUnsupported error returned when the caller asks to sort quotes by an unknown criterion.
+type ErrSortUnsupported struct {
+	Sort string
+}
+
+var _ error = (*ErrSortUnsupported)(nil)
+
+func (e *ErrSortUnsupported) Error() string {
+	return fmt.Sprintf("unsupported sort criterion %s", e.Sort)
+}
+
+func compareBy(sort string) (func(a, b api.Quote) int, error) {
+	// Prices are only compared within a currency, the quotes are grouped by it.
+	price := func(a, b api.Quote) int {
+		return cmp.Or(
+			cmp.Compare(a.Currency, b.Currency),
+			cmp.Compare(a.Price, b.Price),
+		)
+	}
+	transit := func(a, b api.Quote) int {
+		return cmp.Compare(a.TransitDays, b.TransitDays)
+	}
+	failed := func(a, b api.Quote) int {
+		rank := func(q api.Quote) int {
+			if q.Error != "" {
+				return 1
+			}
+			return 0
+		}
+		return cmp.Compare(rank(a), rank(b))
+	}
+	switch sort {
+	case "", SortPrice:
+		return func(a, b api.Quote) int {
+			return cmp.Or(failed(a, b), price(a, b), transit(a, b))
+		}, nil
+	case SortTransit:
+		return func(a, b api.Quote) int {
+			return cmp.Or(failed(a, b), transit(a, b), price(a, b))
+		}, nil
+	default:
+		return nil, &ErrSortUnsupported{Sort: sort}
+	}
+}
+
+// Quote asks the providers how much the shipment would cost and returns
+// the quotes ranked by the sort criterion, failed quotes are ranked last.
+// If providers slice is empty then all providers that support quotes are asked.
+// Prices in different currencies are not converted, they are grouped by currency.
+func (s *Shipment) Quote(ctx context.Context, providers []string, sort string, req *api.ShippingRequest) ([]api.Quote, error) {
+	compare, err := compareBy(sort)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := s.jobs(ctx, providers, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(providers) == 0 {
+		jobs = slices.DeleteFunc(jobs, func(j job) bool {
+			_, ok := j.payloader.(Quoter)
+			return !ok
+		})
+	}
+	quotes := fanout(ctx, s.observer, jobs, func(ctx context.Context, job job) api.Quote {
+		return s.quote(ctx, job, req)
+	})
+	slices.SortStableFunc(quotes, func(a, b api.Quote) int {
+		return cmp.Or(compare(a, b), cmp.Compare(a.Provider, b.Provider))
+	})
+	return quotes, nil
+}
+
+func (s *Shipment) quote(ctx context.Context, job job, req *api.ShippingRequest) api.Quote {
+	quoter, ok := job.payloader.(Quoter)
+	if !ok {
+		return api.Quote{
+			Provider: job.provider,
+			Error:    "provider does not support quotes",
+		}
+	}
+	payload := quoter.QuotePayload(req)
+	s.log.With(slog.String("payload", string(payload))).
+		InfoContext(ctx, "Sending quote payload")
+	raw, err := s.client.Do(ctx, quoter.QuoteTo(), payload)
+	if err != nil {
+		return api.Quote{Provider: job.provider, Error: err.Error()}
+	}
+	quote, err := quoter.Quote(raw)
+	if err != nil {
+		return api.Quote{Provider: job.provider, Error: err.Error()}
+	}
+	quote.Provider = job.provider
+	return *quote
+}
@@ -0,0 +1,170 @@
+package shipment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+type mockQuoter struct {
+	mockPayloader
+}
+
+func (m *mockQuoter) QuotePayload(req *api.ShippingRequest) []byte {
+	args := m.Called(req)
+	return args.Get(0).([]byte)
+}
+
+func (m *mockQuoter) QuoteTo() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *mockQuoter) Quote(raw []byte) (*api.Quote, error) {
+	args := m.Called(raw)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*api.Quote), args.Error(1)
+}
+
+func newMockQuoter(name string, quote *api.Quote, err error) *mockQuoter {
+	q := new(mockQuoter)
+	q.On("QuotePayload", mock.AnythingOfType("*api.ShippingRequest")).Return([]byte(name))
+	q.On("QuoteTo").Return("https://" + name + ".example.com/quote")
+	q.On("Quote", []byte(name)).Return(quote, err)
+	return q
+}
+
+func TestShipment_Quote(t *testing.T) {
+	cheap := &api.Quote{Price: 10, Currency: "USD", TransitDays: 7, ServiceLevel: "Economy"}
+	fast := &api.Quote{Price: 30, Currency: "USD", TransitDays: 1, ServiceLevel: "Express"}
+	middle := &api.Quote{Price: 20, Currency: "USD", TransitDays: 3, ServiceLevel: "Standard"}
+	euro := &api.Quote{Price: 15, Currency: "EUR", TransitDays: 2, ServiceLevel: "Standard"}
+
+	tests := []struct {
+		name      string
+		providers []string
+		sort      string
+		currency  string
+		payloader map[string]Payloader
+		wantErr   error
+		want      []string
+		wantError map[string]string
+	}{
+		{
+			name: "sorted by price by default",
+			payloader: map[string]Payloader{
+				"fast":   newMockQuoter("fast", fast, nil),
+				"cheap":  newMockQuoter("cheap", cheap, nil),
+				"middle": newMockQuoter("middle", middle, nil),
+			},
+			want: []string{"cheap", "middle", "fast"},
+		},
+		{
+			name: "sorted by transit",
+			sort: SortTransit,
+			payloader: map[string]Payloader{
+				"fast":   newMockQuoter("fast", fast, nil),
+				"cheap":  newMockQuoter("cheap", cheap, nil),
+				"middle": newMockQuoter("middle", middle, nil),
+			},
+			want: []string{"fast", "middle", "cheap"},
+		},
+		{
+			name: "failed quotes are ranked last",
+			payloader: map[string]Payloader{
+				"broken": newMockQuoter("broken", nil, errors.New("invalid rate")),
+				"fast":   newMockQuoter("fast", fast, nil),
+			},
+			want:      []string{"fast", "broken"},
+			wantError: map[string]string{"broken": "invalid rate"},
+		},
+		{
+			name:     "quotes in other currencies are kept, grouped by currency",
+			currency: "USD",
+			payloader: map[string]Payloader{
+				"euro":   newMockQuoter("euro", euro, nil),
+				"fast":   newMockQuoter("fast", fast, nil),
+				"middle": newMockQuoter("middle", middle, nil),
+			},
+			want: []string{"euro", "middle", "fast"},
+		},
+		{
+			name:     "quotes in any currency are sorted by transit",
+			sort:     SortTransit,
+			currency: "USD",
+			payloader: map[string]Payloader{
+				"euro": newMockQuoter("euro", euro, nil),
+				"fast": newMockQuoter("fast", fast, nil),
+			},
+			want: []string{"fast", "euro"},
+		},
+		{
+			name: "providers without quotes are skipped when asking all",
+			payloader: map[string]Payloader{
+				"fast":    newMockQuoter("fast", fast, nil),
+				"booking": new(mockPayloader),
+			},
+			want: []string{"fast"},
+		},
+		{
+			name:      "providers without quotes fail when asked explicitly",
+			providers: []string{"booking", "fast"},
+			payloader: map[string]Payloader{
+				"fast":    newMockQuoter("fast", fast, nil),
+				"booking": new(mockPayloader),
+			},
+			want:      []string{"fast", "booking"},
+			wantError: map[string]string{"booking": "provider does not support quotes"},
+		},
+		{
+			name: "unknown sort criterion",
+			sort: "weight",
+			payloader: map[string]Payloader{
+				"fast": new(mockQuoter),
+			},
+			wantErr: &ErrSortUnsupported{},
+		},
+		{
+			name:      "unknown provider",
+			providers: []string{"unknown"},
+			payloader: map[string]Payloader{
+				"fast": new(mockQuoter),
+			},
+			wantErr: &ErrProviderUnsupported{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := new(mockClient)
+			for name := range tt.payloader {
+				client.On("Do", mock.Anything, "https://"+name+".example.com/quote", []byte(name)).
+					Return([]byte(name), nil).Maybe()
+			}
+
+			s := New(client, tt.payloader, new(mockStorage))
+			quotes, err := s.Quote(context.Background(), tt.providers, tt.sort, &api.ShippingRequest{
+				DeclaredValue: api.Money{Currency: tt.currency},
+			})
+			if tt.wantErr != nil {
+				assert.IsType(t, tt.wantErr, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			got := []string{}
+			for _, q := range quotes {
+				got = append(got, q.Provider)
+				assert.Equal(t, tt.wantError[q.Provider], q.Error)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package shipment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// Voider is implemented by the providers that can cancel a booked shipment.
+type Voider interface {
+	// VoidPayload returns the payload that cancels the booking
+	// described by the raw provider booking response.
+	VoidPayload(raw []byte) ([]byte, error)
+	// VoidTo returns the cancel endpoint of the provider.
+	VoidTo() string
+}
+
+// ErrVoided is set on the bookings cancelled because another provider won the race.
+var ErrVoided = errors.New("booking voided, another provider was faster")
+
+type run struct {
+	id      string
+	job     job
+	payload []byte
+	raw     []byte
+	err     error
+	started bool
+}
+
+// Race sends the shipment to the eligible providers, each one starting hedge
+// after the previous one, and keeps the first successful booking. Once a
+// provider wins the attempts still in flight are cancelled and bookings that
+// succeeded anyway are voided, the cancelled attempts are voided too since
+// the provider may have booked them before they were cut short. The winner is
+// returned first, followed by the failed, cancelled and voided attempts, all
+// of them stored together. Providers that were never started are left out.
+// If providers slice is empty then all internal providers are candidates.
+func (s *Shipment) Race(ctx context.Context, providers []string, hedge time.Duration, req *api.ShippingRequest) ([]api.ShippingResponse, error) {
+	jobs, err := s.jobs(ctx, providers, req)
+	if err != nil {
+		return nil, err
+	}
+	jobs = s.eligible(jobs, req)
+	if len(jobs) == 0 {
+		return nil, ErrNoEligibleProvider
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.observer.FanOut(ctx, len(jobs))
+	runs := make(chan run, len(jobs))
+	for i, j := range jobs {
+		go func(i int, job job) {
+			raceCtx, done := s.observer.Job(raceCtx, job.provider)
+			defer done()
+			select {
+			case <-time.After(time.Duration(i) * hedge):
+			case <-raceCtx.Done():
+				runs <- run{job: job}
+				return
+			}
+			id := uuid.NewString()
+			payload := job.payloader.Payload(req)
+			s.log.With(slog.String("payload", string(payload))).
+				InfoContext(ctx, "Racing resulting payload")
+			raw, err := s.client.Do(request.WithShipment(raceCtx, id), job.payloader.To(), payload)
+			runs <- run{id: id, job: job, payload: payload, raw: raw, err: err, started: true}
+		}(i, j)
+	}
+
+	var (
+		winner *run
+		rest   []run
+	)
+	for range jobs {
+		r := <-runs
+		if winner == nil && r.started && r.err == nil {
+			winner = &r
+			cancel()
+			continue
+		}
+		rest = append(rest, r)
+	}
+
+	attempts := []Attempt{}
+	canceled := map[string]bool{}
+	lost := map[int]run{}
+	if winner != nil {
+		attempts = append(attempts, attempt(winner.id, winner.job, winner.payload, winner.raw, nil))
+	}
+	for _, r := range rest {
+		switch {
+		case !r.started:
+			continue
+		case r.err != nil && winner != nil && errors.Is(r.err, context.Canceled):
+			// The provider may have booked the call before it was cut
+			// short, it's voided like the late bookings if it can be.
+			a := attempt(r.id, r.job, r.payload, r.raw, r.err)
+			a.Status, canceled[a.ID] = StatusCanceled, true
+			lost[len(attempts)] = r
+			attempts = append(attempts, a)
+		case r.err != nil:
+			attempts = append(attempts, attempt(r.id, r.job, r.payload, r.raw, r.err))
+		default:
+			lost[len(attempts)] = r
+			attempts = append(attempts, attempt(r.id, r.job, r.payload, r.raw, nil))
+		}
+	}
+	// The losers are voided at the same time, a slow provider
+	// doesn't hold back the voids of the others.
+	var wg sync.WaitGroup
+	for i, r := range lost {
+		wg.Go(func() { attempts[i] = s.lost(ctx, r, attempts[i]) })
+	}
+	wg.Wait()
+	responses, err := s.save(ctx, req, attempts)
+	if err == nil && winner == nil {
+		// A single booking was wanted, only the attempt of the preferred
+		// provider is kept so requeueing it can't book twice.
+		for _, j := range jobs {
+			i := slices.IndexFunc(attempts, func(a Attempt) bool { return a.Provider == j.provider })
+			if i >= 0 {
+				s.deadLetter(ctx, attempts[i:i+1])
+				break
+			}
+		}
+	}
+	// The late bookings are reported as voided, the ones that couldn't
+	// be voided are reported with the error, they may still be booked.
+	for i, a := range attempts {
+		if a.Status != StatusVoided {
+			s.notifier.Notify(ctx, a.Provider, responses[i])
+			continue
+		}
+		from := StatusBooked
+		if canceled[a.ID] {
+			from = StatusCanceled
+		}
+		s.notifier.StatusChanged(ctx, StatusChange{
+			Shipment: a.ID,
+			Provider: a.Provider,
+			From:     from,
+			To:       StatusVoided,
+		})
+	}
+	return responses, nil
+}
+
+// lost voids the attempt of a provider that lost the race, the attempt
+// is voided if the provider cancelled the booking and keeps its status otherwise.
+func (s *Shipment) lost(ctx context.Context, r run, a Attempt) Attempt {
+	err := s.void(ctx, r)
+	if errors.Is(err, ErrVoided) {
+		a.Status = StatusVoided
+	}
+	a.Error = err.Error()
+	return a
+}
+
+func (s *Shipment) void(ctx context.Context, r run) error {
+	l := s.log.With(slog.String("provider", r.job.provider))
+	outcome := "booked after losing the race"
+	if r.err != nil {
+		outcome = "cancelled after losing the race, it may have been booked"
+	}
+	voider, ok := r.job.payloader.(Voider)
+	if !ok {
+		l.ErrorContext(ctx, "Provider lost the race and can't be voided")
+		return fmt.Errorf("%s, provider does not support voiding", outcome)
+	}
+	payload, err := voider.VoidPayload(r.raw)
+	if err == nil {
+		// The booking must be voided even if the caller went away, the
+		// exchange is audited with the booking it voids.
+		_, err = s.client.Do(request.WithShipment(context.WithoutCancel(ctx), r.id), voider.VoidTo(), payload)
+	}
+	if err != nil {
+		l.With(log.Error(err)).ErrorContext(ctx, "Failed to void booking after losing the race")
+		return fmt.Errorf("%s, failed to void, %w", outcome, err)
+	}
+	l.InfoContext(ctx, "Booking voided after losing the race")
+	return ErrVoided
+}
@@ -0,0 +1,241 @@
+package shipment
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+type racePayloader string
+
+func (p racePayloader) Payload(*api.ShippingRequest) []byte { return []byte(p) }
+
+func (p racePayloader) To() string { return "https://" + string(p) }
+
+func (p racePayloader) VoidPayload(raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("no booking")
+	}
+	return append([]byte("void-"), raw...), nil
+}
+
+// refPayloader voids the bookings by the reference it sends, it doesn't
+// need the booking response.
+type refPayloader struct{ racePayloader }
+
+func (p refPayloader) VoidPayload([]byte) ([]byte, error) {
+	return []byte("void-" + string(p.racePayloader)), nil
+}
+
+func (p racePayloader) VoidTo() string { return "https://" + string(p) + "/void" }
+
+type raceBehaviour struct {
+	delay    time.Duration
+	fail     bool
+	stubborn bool // ignores the cancellation and books anyway
+}
+
+type raceClient struct {
+	behaviour map[string]raceBehaviour
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (c *raceClient) Do(ctx context.Context, to string, payload any) ([]byte, error) {
+	c.mu.Lock()
+	c.calls = append(c.calls, to)
+	c.mu.Unlock()
+	b := c.behaviour[to]
+	select {
+	case <-time.After(b.delay):
+	case <-ctx.Done():
+		if !b.stubborn {
+			return nil, ctx.Err()
+		}
+		<-time.After(b.delay)
+	}
+	if b.fail {
+		return nil, errors.New("rejected")
+	}
+	return payload.([]byte), nil
+}
+
+func TestShipment_Race(t *testing.T) {
+	tests := []struct {
+		name      string
+		behaviour map[string]raceBehaviour
+		hedge     time.Duration
+		priority  []string
+		wantCalls []string
+		wantSaved map[string]Status
+		wantResp  []api.ShippingResponse
+		wantVoid  []string
+		wantFrom  Status
+		// wantNotify are the responses sent to the webhooks, all but the voided ones.
+		wantNotify []int
+	}{
+		{
+			name: "fastest wins and the slow one is cancelled",
+			behaviour: map[string]raceBehaviour{
+				"https://a": {delay: 200 * time.Millisecond},
+				"https://b": {delay: time.Millisecond},
+			},
+			wantCalls: []string{"https://a", "https://b"},
+			wantSaved: map[string]Status{"b": StatusBooked, "a": StatusCanceled},
+			wantResp: []api.ShippingResponse{
+				{Endpoint: "https://b", RawResponse: []byte("b")},
+				{Endpoint: "https://a", Error: "cancelled after losing the race, it may have been booked, failed to void, no booking"},
+			},
+			wantNotify: []int{0, 1},
+		},
+		{
+			name: "cancelled call is voided by reference",
+			behaviour: map[string]raceBehaviour{
+				"https://c": {delay: 200 * time.Millisecond},
+				"https://b": {delay: time.Millisecond},
+			},
+			priority:  []string{"b", "c"},
+			wantCalls: []string{"https://c", "https://b", "https://c/void"},
+			wantSaved: map[string]Status{"b": StatusBooked, "c": StatusVoided},
+			wantResp: []api.ShippingResponse{
+				{Endpoint: "https://b", RawResponse: []byte("b")},
+				{Endpoint: "https://c", Error: ErrVoided.Error()},
+			},
+			wantVoid:   []string{"c"},
+			wantNotify: []int{0},
+			wantFrom:   StatusCanceled,
+		},
+		{
+			name: "hedged provider is never started",
+			behaviour: map[string]raceBehaviour{
+				"https://a": {delay: time.Millisecond},
+				"https://b": {delay: time.Millisecond},
+			},
+			hedge:     time.Second,
+			priority:  []string{"a"},
+			wantCalls: []string{"https://a"},
+			wantSaved: map[string]Status{"a": StatusBooked},
+			wantResp: []api.ShippingResponse{
+				{Endpoint: "https://a", RawResponse: []byte("a")},
+			},
+			wantNotify: []int{0},
+		},
+		{
+			name: "failures do not win",
+			behaviour: map[string]raceBehaviour{
+				"https://a": {delay: time.Millisecond, fail: true},
+				"https://b": {delay: 50 * time.Millisecond},
+			},
+			wantCalls: []string{"https://a", "https://b"},
+			wantSaved: map[string]Status{"b": StatusBooked, "a": StatusFailed},
+			wantResp: []api.ShippingResponse{
+				{Endpoint: "https://b", RawResponse: []byte("b")},
+				{Endpoint: "https://a", Error: "rejected"},
+			},
+			wantNotify: []int{0, 1},
+		},
+		{
+			name: "late booking is voided",
+			behaviour: map[string]raceBehaviour{
+				"https://a": {delay: time.Millisecond},
+				"https://b": {delay: 50 * time.Millisecond, stubborn: true},
+			},
+			wantCalls: []string{"https://a", "https://b", "https://b/void"},
+			wantSaved: map[string]Status{"a": StatusBooked, "b": StatusVoided},
+			wantResp: []api.ShippingResponse{
+				{Endpoint: "https://a", RawResponse: []byte("a")},
+				{Endpoint: "https://b", RawResponse: []byte("b"), Error: ErrVoided.Error()},
+			},
+			wantVoid:   []string{"b"},
+			wantFrom:   StatusBooked,
+			wantNotify: []int{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &raceClient{behaviour: tt.behaviour}
+			storage := new(mockStorage)
+			storage.On("Save", mock.Anything, saved(tt.wantSaved)).Return(nil).Once()
+			providers := map[string]Payloader{
+				"a": racePayloader("a"),
+				"b": racePayloader("b"),
+			}
+			if _, ok := tt.behaviour["https://c"]; ok {
+				delete(providers, "a")
+				providers["c"] = refPayloader{"c"}
+			}
+
+			notifier := new(changes)
+			s := New(client, providers, storage, WithPriority(tt.priority...), WithNotifier(notifier))
+			responses, err := s.Race(context.Background(), nil, tt.hedge, &api.ShippingRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantResp, responses)
+			voided := []string{}
+			for _, change := range notifier.changed {
+				assert.Equal(t, tt.wantFrom, change.From)
+				assert.Equal(t, StatusVoided, change.To)
+				voided = append(voided, change.Provider)
+			}
+			assert.Equal(t, append([]string{}, tt.wantVoid...), voided)
+			notified := []api.ShippingResponse{}
+			for _, i := range tt.wantNotify {
+				notified = append(notified, tt.wantResp[i])
+			}
+			assert.ElementsMatch(t, notified, notifier.notified)
+			assert.ElementsMatch(t, tt.wantCalls, client.calls)
+			storage.AssertExpectations(t)
+		})
+	}
+}
+
+// voidClient holds every void until all of them are in flight.
+type voidClient struct {
+	raceClient
+	voids sync.WaitGroup
+}
+
+func (c *voidClient) Do(ctx context.Context, to string, payload any) ([]byte, error) {
+	if !strings.HasSuffix(to, "/void") {
+		return c.raceClient.Do(ctx, to, payload)
+	}
+	c.voids.Done()
+	all := make(chan struct{})
+	go func() {
+		c.voids.Wait()
+		close(all)
+	}()
+	select {
+	case <-all:
+		return nil, nil
+	case <-time.After(time.Second):
+		return nil, errors.New("the other voids never started")
+	}
+}
+
+func TestShipment_RaceVoidsConcurrently(t *testing.T) {
+	client := &voidClient{raceClient: raceClient{behaviour: map[string]raceBehaviour{
+		"https://a": {delay: time.Millisecond},
+		"https://b": {delay: 20 * time.Millisecond, stubborn: true},
+		"https://c": {delay: 20 * time.Millisecond, stubborn: true},
+	}}}
+	client.voids.Add(2)
+	storage := new(mockStorage)
+	storage.On("Save", mock.Anything, saved(map[string]Status{"a": StatusBooked, "b": StatusVoided, "c": StatusVoided})).
+		Return(nil).Once()
+	providers := map[string]Payloader{"a": racePayloader("a"), "b": racePayloader("b"), "c": racePayloader("c")}
+
+	s := New(client, providers, storage, WithPriority("a", "b", "c"))
+	_, err := s.Race(context.Background(), nil, 0, &api.ShippingRequest{})
+	assert.NoError(t, err)
+	storage.AssertExpectations(t)
+}
@@ -0,0 +1,132 @@
+package shipment
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+// ErrNotFound is returned when a stored shipment does not exist or
+// belongs to another tenant.
+var ErrNotFound = errors.New("shipment not found")
+
+// Status is the outcome of a provider call.
+type Status string
+
+const (
+	// StatusBooked is a shipment the provider accepted.
+	StatusBooked Status = "booked"
+	// StatusFailed is a shipment the provider rejected or that never reached it.
+	StatusFailed Status = "failed"
+	// StatusVoided is a booking cancelled because another provider won the race.
+	StatusVoided Status = "voided"
+	// StatusCanceled is a call cut short because another provider won the
+	// race, the provider may have booked it and it couldn't be voided.
+	StatusCanceled Status = "canceled"
+)
+
+// Valid reports if the status is a known one.
+func (s Status) Valid() bool {
+	switch s {
+	case StatusBooked, StatusFailed, StatusVoided, StatusCanceled:
+		return true
+	}
+	return false
+}
+
+// Attempt is a provider call made for a shipment, all the attempts
+// of a fan-out are stored together.
+type Attempt struct {
+	// ID identifies the stored shipment, the exchanges with the provider
+	// are audited under it. The storage makes one up if it's empty.
+	ID       string
+	Provider string
+	Endpoint string
+	Status   Status
+	// HTTPStatus is the status code of the rejected calls, zero otherwise.
+	HTTPStatus int
+	Error      string
+	Request    []byte
+	Response   []byte
+	// Original is the shipping request the payload was made from, it
+	// allows resending the shipment.
+	Original []byte
+	// ResendOf is the id of the shipment this attempt resends, if any.
+	ResendOf string
+}
+
+// StatusChange is a shipment whose status changed after its provider call.
+type StatusChange struct {
+	// Shipment is the id of the shipment whose status changed.
+	Shipment string `json:"shipment"`
+	// Resend is the id of the attempt the shipment was resent with, if any.
+	Resend   string `json:"resend,omitempty"`
+	Provider string `json:"provider"`
+	From     Status `json:"from"`
+	To       Status `json:"to"`
+}
+
+func (a Attempt) response() api.ShippingResponse {
+	return api.ShippingResponse{
+		Endpoint:    a.Endpoint,
+		RawResponse: a.Response,
+		Error:       a.Error,
+	}
+}
+
+// RawJSON returns the payload as it is if it's json, providers may answer
+// the failures with plain text or html which is returned as a json string.
+// An empty payload returns nil.
+func RawJSON(payload []byte) json.RawMessage {
+	switch {
+	case len(payload) == 0:
+		return nil
+	case json.Valid(payload):
+		return payload
+	default:
+		b, _ := json.Marshal(string(payload))
+		return b
+	}
+}
+
+// Record is a stored provider call.
+type Record struct {
+	ID string `json:"id"`
+	// GroupID is shared by the records of the same fan-out.
+	GroupID    string          `json:"groupId"`
+	Provider   string          `json:"provider"`
+	Endpoint   string          `json:"endpoint"`
+	Status     Status          `json:"status"`
+	HTTPStatus int             `json:"httpStatus,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Original   json.RawMessage `json:"original,omitempty"`
+	ResendOf   string          `json:"resendOf,omitempty"`
+	RequestID  string          `json:"requestId,omitempty"`
+	Tenant     string          `json:"tenant,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+}
+
+// Filter scopes the stored shipments a caller can read.
+type Filter struct {
+	// Tenant the shipments belong to, empty matches the shipments without a tenant.
+	Tenant string
+	// AllTenants ignores the tenant, only the admins can read all of them.
+	AllTenants bool
+	// Before returns only the shipments stored before the one with the given id, for paging.
+	Before string
+	// Provider returns only the shipments sent to the provider.
+	Provider string
+	// Status returns only the shipments with the status.
+	Status Status
+	// Since returns only the shipments stored at or after the time.
+	Since time.Time
+	// Unresent leaves out the shipments that were already resent.
+	Unresent bool
+	// Limit bounds the number of shipments returned.
+	Limit int
+}
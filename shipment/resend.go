@@ -0,0 +1,43 @@
+package shipment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+// ErrNotResendable error returned when a stored shipment is resent but its
+// original request was not kept, it was stored before the requests were
+// kept or its personal data was scrubbed.
+type ErrNotResendable struct {
+	ID string
+}
+
+var _ error = (*ErrNotResendable)(nil)
+
+func (e *ErrNotResendable) Error() string {
+	return fmt.Sprintf("shipment %s can't be resent, its original request is not stored", e.ID)
+}
+
+// Resend sends the original request of the stored shipment again to the
+// providers, to the provider it was first sent to if providers slice is
+// empty. The new attempts are stored linked to the resent shipment.
+func (s *Shipment) Resend(ctx context.Context, rec *Record, providers []string) ([]api.ShippingResponse, error) {
+	if len(rec.Original) == 0 {
+		return nil, &ErrNotResendable{ID: rec.ID}
+	}
+	req := &api.ShippingRequest{}
+	if err := json.Unmarshal(rec.Original, req); err != nil {
+		return nil, fmt.Errorf("failed to decode the original request of shipment %s, %w", rec.ID, err)
+	}
+	if len(providers) == 0 {
+		providers = []string{rec.Provider}
+	}
+	jobs, err := s.jobs(ctx, providers, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.send(ctx, jobs, req, rec)
+}
@@ -0,0 +1,111 @@
+package shipment
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+type changes struct {
+	mu       sync.Mutex
+	changed  []StatusChange
+	notified []api.ShippingResponse
+}
+
+func (c *changes) Notify(_ context.Context, _ string, resp api.ShippingResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notified = append(c.notified, resp)
+}
+
+func (c *changes) StatusChanged(_ context.Context, change StatusChange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changed = append(c.changed, change)
+}
+
+func TestShipment_Resend(t *testing.T) {
+	req := &api.ShippingRequest{Weight: api.Weight{Value: 2, Unit: "KG"}}
+	original, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		rec       *Record
+		providers []string
+		to        string
+		wantErr   error
+		wantFrom  Status
+	}{
+		{
+			name:     "resent to the provider it was sent to",
+			rec:      &Record{ID: "ship-1", Provider: "provider1", Status: StatusFailed, Original: original},
+			to:       "https://provider1.example.com",
+			wantFrom: StatusFailed,
+		},
+		{
+			name:      "resent to another provider",
+			rec:       &Record{ID: "ship-1", Provider: "provider1", Status: StatusFailed, Original: original},
+			providers: []string{"provider2"},
+			to:        "https://provider2.example.com",
+		},
+		{
+			name:      "unknown provider",
+			rec:       &Record{ID: "ship-1", Provider: "provider1", Original: original},
+			providers: []string{"provider3"},
+			wantErr:   &ErrProviderUnsupported{Provider: "provider3"},
+		},
+		{
+			name:    "original request not stored",
+			rec:     &Record{ID: "ship-1", Provider: "provider1"},
+			wantErr: &ErrNotResendable{ID: "ship-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers := map[string]Payloader{}
+			for _, name := range []string{"provider1", "provider2"} {
+				p := new(mockPayloader)
+				p.On("Payload", req).Return([]byte(`{"provider":"` + name + `"}`)).Maybe()
+				p.On("To").Return("https://" + name + ".example.com").Maybe()
+				providers[name] = p
+			}
+			client, storage := new(mockClient), new(mockStorage)
+			if tt.wantErr == nil {
+				client.On("Do", mock.Anything, tt.to, mock.Anything).Return([]byte(`{"tracking_id":"1"}`), nil)
+				storage.On("Save", mock.Anything, mock.MatchedBy(func(attempts []Attempt) bool {
+					return len(attempts) == 1 &&
+						attempts[0].ResendOf == "ship-1" &&
+						attempts[0].Status == StatusBooked &&
+						string(attempts[0].Original) == string(original)
+				})).Return(nil)
+			}
+
+			notifier := new(changes)
+			s := New(client, providers, storage, WithNotifier(notifier))
+			responses, err := s.Resend(context.Background(), tt.rec, tt.providers)
+			assert.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				require.Len(t, responses, 1)
+				assert.Equal(t, tt.to, responses[0].Endpoint)
+			}
+			if tt.wantFrom != "" {
+				require.Len(t, notifier.changed, 1)
+				change := notifier.changed[0]
+				assert.Equal(t, "ship-1", change.Shipment)
+				assert.NotEmpty(t, change.Resend)
+				assert.Equal(t, tt.wantFrom, change.From)
+				assert.Equal(t, StatusBooked, change.To)
+			}
+			client.AssertExpectations(t)
+			storage.AssertExpectations(t)
+		})
+	}
+}
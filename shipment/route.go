@@ -0,0 +1,216 @@
+package shipment
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+// Constraints describe which shipments a provider accepts.
+// Empty fields impose no restriction.
+type Constraints struct {
+	// Origins are the accepted shipper country codes.
+	Origins []string
+	// Destinations are the accepted consignee country codes.
+	Destinations []string
+	// MaxWeight is the maximum accepted weight in kilograms.
+	MaxWeight float64
+	// NoCOD is set when the provider does not support cash on delivery.
+	NoCOD bool
+	// ServiceTypes are the accepted service types.
+	ServiceTypes []string
+}
+
+// Allow reports if the shipment satisfies the constraints.
+func (c Constraints) Allow(req *api.ShippingRequest) bool {
+	in := func(values []string, v string) bool {
+		return len(values) == 0 || slices.ContainsFunc(values, func(value string) bool {
+			return strings.EqualFold(value, v)
+		})
+	}
+	switch {
+	case !in(c.Origins, req.Shipper.Address.CountryCode):
+		return false
+	case !in(c.Destinations, req.Consignee.Address.CountryCode):
+		return false
+	case !in(c.ServiceTypes, req.ServiceType):
+		return false
+	case c.MaxWeight > 0 && req.Weight.KG() > c.MaxWeight:
+		return false
+	case c.NoCOD && req.IsCOD:
+		return false
+	}
+	return true
+}
+
+// Constrained is implemented by the providers that don't accept every shipment.
+type Constrained interface {
+	Constraints() Constraints
+}
+
+// Strategies used to pick a single provider for a shipment.
+const (
+	StrategyCheapest   = "cheapest"
+	StrategyFastest    = "fastest"
+	StrategyPriority   = "priority"
+	StrategyRoundRobin = "round-robin"
+)
+
+var strategies = []string{
+	StrategyCheapest,
+	StrategyFastest,
+	StrategyPriority,
+	StrategyRoundRobin,
+}
+
+// ErrStrategyUnsupported error returned when the caller makes a shipment request with an unknown strategy.
+type ErrStrategyUnsupported struct {
+	Strategy string
+}
+
+var _ error = (*ErrStrategyUnsupported)(nil)
+
+func (e *ErrStrategyUnsupported) Error() string {
+	return fmt.Sprintf("unsupported strategy %s", e.Strategy)
+}
+
+// ErrNoEligibleProvider is returned when no provider accepts the shipment.
+var ErrNoEligibleProvider = errors.New("no provider accepts this shipment")
+
+// WithPriority sets the order in which providers are tried by the priority strategy.
+// Providers not in the list are tried afterwards, in alphabetical order.
+// Providers preferred by the eligibility rules are tried before all of them.
+func WithPriority(providers ...string) Option {
+	return func(s *Shipment) {
+		s.priority = providers
+	}
+}
+
+func (s *Shipment) eligible(jobs []job, req *api.ShippingRequest) []job {
+	return slices.DeleteFunc(jobs, func(j job) bool {
+		if j.payloader == nil {
+			return true
+		}
+		c, ok := j.payloader.(Constrained)
+		return ok && !c.Constraints().Allow(req)
+	})
+}
+
+func (s *Shipment) byPriority(jobs []job) []job {
+	rank := func(j job) int {
+		if i := slices.Index(s.priority, j.provider); i >= 0 {
+			return i
+		}
+		return len(s.priority)
+	}
+	slices.SortStableFunc(jobs, func(a, b job) int {
+		return cmp.Or(cmp.Compare(rank(a), rank(b)), cmp.Compare(a.provider, b.provider))
+	})
+	return jobs
+}
+
+func (s *Shipment) byQuote(ctx context.Context, jobs []job, sort string, req *api.ShippingRequest) ([]job, error) {
+	compare, err := compareBy(sort)
+	if err != nil {
+		return nil, err
+	}
+	quotes := fanout(ctx, s.observer, jobs, func(ctx context.Context, job job) api.Quote {
+		return s.quote(ctx, job, req)
+	})
+	index := func(q api.Quote) int {
+		return slices.IndexFunc(jobs, func(j job) bool { return j.provider == q.Provider })
+	}
+	byIndex := func(a, b api.Quote) int { return cmp.Compare(index(a), index(b)) }
+	if sort == SortPrice {
+		priced(quotes, req.DeclaredValue.Currency, byIndex)
+	}
+	slices.SortFunc(quotes, func(a, b api.Quote) int {
+		return cmp.Or(compare(a, b), byIndex(a, b))
+	})
+	ranked := make([]job, 0, len(jobs))
+	for _, q := range quotes {
+		ranked = append(ranked, jobs[index(q)])
+	}
+	return ranked, nil
+}
+
+// priced fails the quotes that are not in the currency, their prices can't
+// be compared to pick the cheapest. If the currency is empty the one of
+// the first successful quote in order is used.
+func priced(quotes []api.Quote, currency string, order func(a, b api.Quote) int) {
+	if currency == "" {
+		ok := slices.DeleteFunc(slices.Clone(quotes), func(q api.Quote) bool { return q.Error != "" })
+		if len(ok) == 0 {
+			return
+		}
+		currency = slices.MinFunc(ok, order).Currency
+	}
+	for i, q := range quotes {
+		if q.Error == "" && !strings.EqualFold(q.Currency, currency) {
+			quotes[i].Error = fmt.Sprintf("quoted in %s, not in %s", q.Currency, currency)
+		}
+	}
+}
+
+func (s *Shipment) rank(ctx context.Context, strategy string, jobs []job, req *api.ShippingRequest) ([]job, error) {
+	switch strategy {
+	case StrategyPriority:
+		return jobs, nil
+	case StrategyCheapest:
+		return s.byQuote(ctx, jobs, SortPrice, req)
+	case StrategyFastest:
+		return s.byQuote(ctx, jobs, SortTransit, req)
+	case StrategyRoundRobin:
+		slices.SortFunc(jobs, func(a, b job) int { return cmp.Compare(a.provider, b.provider) })
+		n := int(s.next.Add(1)-1) % len(jobs)
+		return slices.Concat(jobs[n:], jobs[:n]), nil
+	default:
+		return nil, &ErrStrategyUnsupported{Strategy: strategy}
+	}
+}
+
+// Route sends the shipment to exactly one provider, picked by the strategy
+// among the providers that accept it. If the picked provider fails the next
+// candidate is tried. All attempts are returned, the last one is the outcome.
+// If providers slice is empty then all internal providers are candidates.
+func (s *Shipment) Route(ctx context.Context, strategy string, providers []string, req *api.ShippingRequest) ([]api.ShippingResponse, error) {
+	if !slices.Contains(strategies, strategy) {
+		return nil, &ErrStrategyUnsupported{Strategy: strategy}
+	}
+	jobs, err := s.jobs(ctx, providers, req)
+	if err != nil {
+		return nil, err
+	}
+	jobs = s.eligible(jobs, req)
+	if len(jobs) == 0 {
+		return nil, ErrNoEligibleProvider
+	}
+	jobs, err = s.rank(ctx, strategy, jobs, req)
+	if err != nil {
+		return nil, err
+	}
+	attempts := []Attempt{}
+	for _, job := range jobs {
+		jobCtx, done := s.observer.Job(ctx, job.provider)
+		a := s.do(jobCtx, job, req)
+		done()
+		attempts = append(attempts, a)
+		if a.Status == StatusBooked {
+			break
+		}
+	}
+	responses, err := s.save(ctx, req, attempts)
+	if err == nil {
+		// The last attempt is the outcome, it failed only if every candidate did.
+		s.deadLetter(ctx, attempts[len(attempts)-1:])
+	}
+	for i, a := range attempts {
+		s.notifier.Notify(ctx, a.Provider, responses[i])
+	}
+	return responses, nil
+}
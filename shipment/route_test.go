@@ -0,0 +1,209 @@
+package shipment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hoenirvili/axiogate/http/api"
+)
+
+type constrainedPayloader struct {
+	mockQuoter
+	constraints Constraints
+}
+
+func (c *constrainedPayloader) Constraints() Constraints {
+	return c.constraints
+}
+
+func newRoutable(name string, quote *api.Quote, constraints Constraints) *constrainedPayloader {
+	p := &constrainedPayloader{constraints: constraints}
+	p.On("Payload", mock.AnythingOfType("*api.ShippingRequest")).Return([]byte(name)).Maybe()
+	p.On("To").Return("https://" + name + ".example.com").Maybe()
+	p.On("QuotePayload", mock.AnythingOfType("*api.ShippingRequest")).Return([]byte(name)).Maybe()
+	p.On("QuoteTo").Return("https://" + name + ".example.com/quote").Maybe()
+	p.On("Quote", []byte(name)).Return(quote, nil).Maybe()
+	return p
+}
+
+func TestConstraints_Allow(t *testing.T) {
+	req := &api.ShippingRequest{
+		Weight:      api.Weight{Value: 12000, Unit: "Grams"},
+		Shipper:     api.Party{Address: api.Address{CountryCode: "US"}},
+		Consignee:   api.Party{Address: api.Address{CountryCode: "AE"}},
+		ServiceType: "Express",
+		IsCOD:       true,
+	}
+	tests := []struct {
+		name        string
+		constraints Constraints
+		want        bool
+	}{
+		{name: "no constraints", want: true},
+		{name: "destination allowed", constraints: Constraints{Destinations: []string{"ae", "SA"}}, want: true},
+		{name: "destination denied", constraints: Constraints{Destinations: []string{"SA"}}},
+		{name: "origin denied", constraints: Constraints{Origins: []string{"RO"}}},
+		{name: "weight under limit", constraints: Constraints{MaxWeight: 30}, want: true},
+		{name: "weight over limit", constraints: Constraints{MaxWeight: 10}},
+		{name: "cod unsupported", constraints: Constraints{NoCOD: true}},
+		{name: "service type denied", constraints: Constraints{ServiceTypes: []string{"Economy"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.constraints.Allow(req))
+		})
+	}
+}
+
+func TestShipment_Route(t *testing.T) {
+	cheap := &api.Quote{Price: 10, Currency: "USD", TransitDays: 7}
+	fast := &api.Quote{Price: 30, Currency: "USD", TransitDays: 1}
+	euro := &api.Quote{Price: 5, Currency: "EUR", TransitDays: 3}
+
+	tests := []struct {
+		name      string
+		strategy  string
+		providers []string
+		payloader map[string]Payloader
+		priority  []string
+		fail      map[string]bool
+		want      []string
+		wantErr   error
+	}{
+		{
+			name:     "cheapest",
+			strategy: StrategyCheapest,
+			payloader: map[string]Payloader{
+				"fast":  newRoutable("fast", fast, Constraints{}),
+				"cheap": newRoutable("cheap", cheap, Constraints{}),
+			},
+			want: []string{"cheap"},
+		},
+		{
+			name:     "cheapest compares the prices in the declared currency",
+			strategy: StrategyCheapest,
+			payloader: map[string]Payloader{
+				"euro":  newRoutable("euro", euro, Constraints{}),
+				"fast":  newRoutable("fast", fast, Constraints{}),
+				"cheap": newRoutable("cheap", cheap, Constraints{}),
+			},
+			want: []string{"cheap"},
+		},
+		{
+			name:     "fastest",
+			strategy: StrategyFastest,
+			payloader: map[string]Payloader{
+				"fast":  newRoutable("fast", fast, Constraints{}),
+				"cheap": newRoutable("cheap", cheap, Constraints{}),
+			},
+			want: []string{"fast"},
+		},
+		{
+			name:     "priority with fallback",
+			strategy: StrategyPriority,
+			priority: []string{"fast", "cheap"},
+			payloader: map[string]Payloader{
+				"fast":  newRoutable("fast", fast, Constraints{}),
+				"cheap": newRoutable("cheap", cheap, Constraints{}),
+			},
+			fail: map[string]bool{"fast": true},
+			want: []string{"fast", "cheap"},
+		},
+		{
+			name:     "ineligible providers are skipped",
+			strategy: StrategyCheapest,
+			payloader: map[string]Payloader{
+				"fast":  newRoutable("fast", fast, Constraints{}),
+				"cheap": newRoutable("cheap", cheap, Constraints{MaxWeight: 1}),
+			},
+			want: []string{"fast"},
+		},
+		{
+			name:     "no eligible provider",
+			strategy: StrategyPriority,
+			payloader: map[string]Payloader{
+				"cheap": newRoutable("cheap", cheap, Constraints{MaxWeight: 1}),
+			},
+			wantErr: ErrNoEligibleProvider,
+		},
+		{
+			name:     "unknown strategy",
+			strategy: "random",
+			payloader: map[string]Payloader{
+				"cheap": newRoutable("cheap", cheap, Constraints{}),
+			},
+			wantErr: &ErrStrategyUnsupported{Strategy: "random"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := new(mockClient)
+			storage := new(mockStorage)
+			for name := range tt.payloader {
+				client.On("Do", mock.Anything, "https://"+name+".example.com/quote", []byte(name)).
+					Return([]byte(name), nil).Maybe()
+				if tt.fail[name] {
+					client.On("Do", mock.Anything, "https://"+name+".example.com", []byte(name)).
+						Return(nil, errors.New("down")).Maybe()
+					continue
+				}
+				client.On("Do", mock.Anything, "https://"+name+".example.com", []byte(name)).
+					Return([]byte(name), nil).Maybe()
+			}
+			storage.On("Save", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+			s := New(client, tt.payloader, storage, WithPriority(tt.priority...))
+			responses, err := s.Route(context.Background(), tt.strategy, tt.providers, &api.ShippingRequest{
+				Weight:        api.Weight{Value: 5, Unit: "KG"},
+				DeclaredValue: api.Money{Amount: 100, Currency: "USD"},
+			})
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			got := []string{}
+			for _, resp := range responses {
+				got = append(got, resp.Endpoint)
+			}
+			want := []string{}
+			for _, name := range tt.want {
+				want = append(want, "https://"+name+".example.com")
+			}
+			assert.Equal(t, want, got)
+			assert.Empty(t, responses[len(responses)-1].Error)
+		})
+	}
+}
+
+func TestShipment_RouteRoundRobin(t *testing.T) {
+	client := new(mockClient)
+	storage := new(mockStorage)
+	payloader := map[string]Payloader{}
+	for _, name := range []string{"a", "b", "c"} {
+		payloader[name] = newRoutable(name, nil, Constraints{})
+		client.On("Do", mock.Anything, "https://"+name+".example.com", []byte(name)).Return([]byte(name), nil)
+	}
+	storage.On("Save", mock.Anything, mock.Anything).Return(nil)
+
+	s := New(client, payloader, storage)
+	got := []string{}
+	for range 4 {
+		responses, err := s.Route(context.Background(), StrategyRoundRobin, nil, &api.ShippingRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, responses, 1)
+		got = append(got, responses[0].Endpoint)
+	}
+	assert.Equal(t, []string{
+		"https://a.example.com",
+		"https://b.example.com",
+		"https://c.example.com",
+		"https://a.example.com",
+	}, got)
+}
@@ -2,11 +2,18 @@ package shipment
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
 
 	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/request"
 	"github.com/hoenirvili/axiogate/log"
 )
 
@@ -17,18 +24,129 @@ type Payloader interface {
 }
 
 type Storage interface {
-	Save(ctx context.Context, provider string, payload []byte) error
+	// Save stores all the attempts of a fan-out at once, either all of them are stored or none.
+	Save(ctx context.Context, attempts []Attempt) error
 }
 
 type Client interface {
 	Do(ctx context.Context, to string, payload any) ([]byte, error)
 }
 
+// Notifier is told about the outcome of every provider call.
+type Notifier interface {
+	Notify(ctx context.Context, provider string, resp api.ShippingResponse)
+	// StatusChanged is told about a shipment whose status changed after
+	// its provider call, a late race booking voided or a shipment resent.
+	StatusChanged(ctx context.Context, change StatusChange)
+}
+
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(context.Context, string, api.ShippingResponse) {}
+
+func (noopNotifier) StatusChanged(context.Context, StatusChange) {}
+
+// Eligibility decides which providers may handle a request.
+type Eligibility interface {
+	// Eligible returns the subset of providers allowed to handle
+	// the request, in the order they should be preferred.
+	Eligible(ctx context.Context, req *api.ShippingRequest, providers []string) ([]string, error)
+}
+
+type allEligible struct{}
+
+func (allEligible) Eligible(_ context.Context, _ *api.ShippingRequest, providers []string) ([]string, error) {
+	return providers, nil
+}
+
+// Resolver returns the providers available to the caller, it allows
+// each caller to book with its own accounts.
+type Resolver interface {
+	// Providers returns the providers for the caller found in the context.
+	Providers(ctx context.Context) (map[string]Payloader, error)
+}
+
+type static map[string]Payloader
+
+func (s static) Providers(context.Context) (map[string]Payloader, error) {
+	return s, nil
+}
+
+// Authorizer decides which providers the caller may use.
+type Authorizer interface {
+	// Allowed reports if the caller found in the context may use the provider.
+	Allowed(ctx context.Context, provider string) bool
+}
+
+type allowAll struct{}
+
+func (allowAll) Allowed(context.Context, string) bool { return true }
+
+// Observer is told about the fan-out lifecycle, it allows instrumenting
+// the shipment without coupling it to a telemetry backend.
+type Observer interface {
+	// FanOut is called with the number of jobs before they are started.
+	FanOut(ctx context.Context, width int)
+	// Job is called when a provider job starts, the returned context is
+	// used by the job and done is called when the job finishes.
+	Job(ctx context.Context, provider string) (jobCtx context.Context, done func())
+}
+
+// DeadLetters keeps the provider calls that failed for good, once the
+// retries are exhausted, until an operator requeues or discards them.
+type DeadLetters interface {
+	// CreateDeadLetters keeps the failed attempts, they are stored already.
+	CreateDeadLetters(ctx context.Context, attempts []Attempt) error
+}
+
+type noopDeadLetters struct{}
+
+func (noopDeadLetters) CreateDeadLetters(context.Context, []Attempt) error { return nil }
+
+type noopObserver struct{}
+
+func (noopObserver) FanOut(context.Context, int) {}
+
+func (noopObserver) Job(ctx context.Context, _ string) (context.Context, func()) {
+	return ctx, func() {}
+}
+
+// observers fans the lifecycle out to every observer, each job context
+// is threaded through the next observer and the done calls run in reverse.
+type observers []Observer
+
+func (o observers) FanOut(ctx context.Context, width int) {
+	for _, observer := range o {
+		observer.FanOut(ctx, width)
+	}
+}
+
+func (o observers) Job(ctx context.Context, provider string) (context.Context, func()) {
+	dones := make([]func(), 0, len(o))
+	for _, observer := range o {
+		var done func()
+		ctx, done = observer.Job(ctx, provider)
+		dones = append(dones, done)
+	}
+	return ctx, func() {
+		for _, done := range slices.Backward(dones) {
+			done()
+		}
+	}
+}
+
 type Shipment struct {
-	providers map[string]Payloader
-	log       *slog.Logger
-	storage   Storage
-	client    Client
+	resolver    Resolver
+	log         *slog.Logger
+	storage     Storage
+	client      Client
+	notifier    Notifier
+	eligibility Eligibility
+	authorizer  Authorizer
+	observer    Observer
+	deadLetters DeadLetters
+	priority    []string
+	next        atomic.Uint64
 }
 
 type Option func(p *Shipment)
@@ -39,14 +157,63 @@ func WithLogger(log *slog.Logger) Option {
 	}
 }
 
+// WithNotifier sets who gets notified about the provider outcomes.
+func WithNotifier(n Notifier) Option {
+	return func(s *Shipment) {
+		s.notifier = n
+	}
+}
+
+// WithEligibility sets who decides which providers may handle a request.
+func WithEligibility(e Eligibility) Option {
+	return func(s *Shipment) {
+		s.eligibility = e
+	}
+}
+
+// WithResolver sets who returns the providers available to the caller,
+// by default all callers share the providers given to New.
+func WithResolver(r Resolver) Option {
+	return func(s *Shipment) {
+		s.resolver = r
+	}
+}
+
+// WithAuthorizer sets who decides which providers the caller may use.
+func WithAuthorizer(a Authorizer) Option {
+	return func(s *Shipment) {
+		s.authorizer = a
+	}
+}
+
+// WithObserver sets who observes the fan-out lifecycle,
+// multiple observers are called in the given order.
+func WithObserver(o ...Observer) Option {
+	return func(s *Shipment) {
+		s.observer = observers(o)
+	}
+}
+
+// WithDeadLetters sets who keeps the provider calls that failed for good.
+func WithDeadLetters(d DeadLetters) Option {
+	return func(s *Shipment) {
+		s.deadLetters = d
+	}
+}
+
 // New return a new shipment service that handlers the
 // multi provider fan out shipment.
 func New(cli Client, providers map[string]Payloader, st Storage, options ...Option) *Shipment {
 	s := &Shipment{
-		client:    cli,
-		providers: providers,
-		log:       log.Noop(),
-		storage:   st,
+		client:      cli,
+		resolver:    static(providers),
+		log:         log.Noop(),
+		storage:     st,
+		notifier:    noopNotifier{},
+		eligibility: allEligible{},
+		authorizer:  allowAll{},
+		observer:    noopObserver{},
+		deadLetters: noopDeadLetters{},
 	}
 	for _, option := range options {
 		option(s)
@@ -65,9 +232,33 @@ func (e *ErrProviderUnsupported) Error() string {
 	return fmt.Sprintf("unsupported provider %s", e.Provider)
 }
 
-func (s *Shipment) allJobs() []job {
-	jobs := make([]job, 0, len(s.providers))
-	for provider, payloader := range s.providers {
+// ErrProviderIneligible error returned when the caller makes a shipment request to a provider
+// that is not allowed to handle it.
+type ErrProviderIneligible struct {
+	Provider string
+}
+
+var _ error = (*ErrProviderIneligible)(nil)
+
+func (e *ErrProviderIneligible) Error() string {
+	return fmt.Sprintf("provider %s is not eligible for this shipment", e.Provider)
+}
+
+// ErrProviderForbidden error returned when the caller makes a shipment request to a provider
+// outside of its scope.
+type ErrProviderForbidden struct {
+	Provider string
+}
+
+var _ error = (*ErrProviderForbidden)(nil)
+
+func (e *ErrProviderForbidden) Error() string {
+	return fmt.Sprintf("provider %s is not allowed for this caller", e.Provider)
+}
+
+func allJobs(providers map[string]Payloader) []job {
+	jobs := make([]job, 0, len(providers))
+	for provider, payloader := range providers {
 		jobs = append(jobs, job{provider: provider, payloader: payloader})
 	}
 	return jobs
@@ -78,13 +269,17 @@ type job struct {
 	provider  string
 }
 
-func (s *Shipment) jobs(providers []string) ([]job, error) {
+func (s *Shipment) candidates(ctx context.Context, providers []string) ([]job, error) {
+	available, err := s.resolver.Providers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve providers, %w", err)
+	}
 	if len(providers) == 0 {
-		return s.allJobs(), nil
+		return allJobs(available), nil
 	}
-	jobs := make([]job, 0, len(s.providers))
+	jobs := make([]job, 0, len(providers))
 	for _, provider := range providers {
-		val, ok := s.providers[provider]
+		val, ok := available[provider]
 		if !ok {
 			return nil, &ErrProviderUnsupported{Provider: provider}
 		}
@@ -93,16 +288,101 @@ func (s *Shipment) jobs(providers []string) ([]job, error) {
 	return jobs, nil
 }
 
+// jobs returns the providers jobs for the request, ordered by preference.
+// Providers requested explicitly but outside of the caller scope or not eligible
+// for the request are an error, otherwise those providers are left out.
+func (s *Shipment) jobs(ctx context.Context, providers []string, req *api.ShippingRequest) ([]job, error) {
+	jobs, err := s.candidates(ctx, providers)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err = s.authorized(ctx, jobs, len(providers) != 0)
+	if err != nil {
+		return nil, err
+	}
+	jobs = s.byPriority(jobs)
+	names := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		names = append(names, j.provider)
+	}
+	eligible, err := s.eligibility.Eligible(ctx, req, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate eligibility, %w", err)
+	}
+	if len(providers) != 0 {
+		for _, name := range names {
+			if !slices.Contains(eligible, name) {
+				return nil, &ErrProviderIneligible{Provider: name}
+			}
+		}
+	}
+	out := make([]job, 0, len(eligible))
+	for _, name := range eligible {
+		i := slices.IndexFunc(jobs, func(j job) bool { return j.provider == name })
+		if i >= 0 {
+			out = append(out, jobs[i])
+		}
+	}
+	return out, nil
+}
+
+func (s *Shipment) authorized(ctx context.Context, jobs []job, explicit bool) ([]job, error) {
+	out := make([]job, 0, len(jobs))
+	for _, j := range jobs {
+		if s.authorizer.Allowed(ctx, j.provider) {
+			out = append(out, j)
+			continue
+		}
+		if explicit {
+			return nil, &ErrProviderForbidden{Provider: j.provider}
+		}
+	}
+	return out, nil
+}
+
 func (s *Shipment) Send(ctx context.Context, providers []string, req *api.ShippingRequest) ([]api.ShippingResponse, error) {
-	jobs, err := s.jobs(providers)
+	jobs, err := s.jobs(ctx, providers, req)
 	if err != nil {
 		return nil, err
 	}
-	return s.send(ctx, jobs, req)
+	return s.send(ctx, jobs, req, nil)
 }
 
-func (s *Shipment) send(ctx context.Context, jobs []job, req *api.ShippingRequest) ([]api.ShippingResponse, error) {
-	res := make(chan api.ShippingResponse, len(jobs))
+// send fans the request out to the jobs, the attempts are stored as
+// resends of the given shipment if there's one.
+func (s *Shipment) send(ctx context.Context, jobs []job, req *api.ShippingRequest, resent *Record) ([]api.ShippingResponse, error) {
+	attempts := fanout(ctx, s.observer, jobs, func(ctx context.Context, job job) Attempt {
+		return s.do(ctx, job, req)
+	})
+	if resent != nil {
+		for i := range attempts {
+			attempts[i].ResendOf = resent.ID
+		}
+	}
+	responses, err := s.save(ctx, req, attempts)
+	if err == nil {
+		s.deadLetter(ctx, attempts)
+	}
+	for i, a := range attempts {
+		s.notifier.Notify(ctx, a.Provider, responses[i])
+		if resent != nil && a.Status != resent.Status {
+			s.notifier.StatusChanged(ctx, StatusChange{
+				Shipment: resent.ID,
+				Resend:   a.ID,
+				Provider: a.Provider,
+				From:     resent.Status,
+				To:       a.Status,
+			})
+		}
+	}
+	return responses, nil
+}
+
+// fanout runs fn for every job in parallel and collects the results,
+// jobs without a payloader are skipped.
+func fanout[T any](ctx context.Context, o Observer, jobs []job, fn func(ctx context.Context, job job) T) []T {
+	o.FanOut(ctx, len(jobs))
+	res := make(chan T, len(jobs))
 	wg := new(sync.WaitGroup)
 	wg.Add(len(jobs))
 	for _, j := range jobs {
@@ -111,37 +391,88 @@ func (s *Shipment) send(ctx context.Context, jobs []job, req *api.ShippingReques
 			if job.payloader == nil {
 				return
 			}
-			payload := job.payloader.Payload(req)
-			s.log.With(slog.String("payload", string(payload))).
-				Info("Sending resulting payload")
-			payload, err := s.client.Do(ctx, job.payloader.To(), payload)
-			if err != nil {
-				res <- api.ShippingResponse{
-					Endpoint:    job.payloader.To(),
-					RawResponse: payload,
-					Error:       err.Error(),
-				}
-				return
-			}
-			if err := s.storage.Save(ctx, job.provider, payload); err != nil {
-				res <- api.ShippingResponse{
-					Endpoint:    job.payloader.To(),
-					RawResponse: payload,
-					Error:       err.Error(),
-				}
-				return
-			}
-			res <- api.ShippingResponse{
-				Endpoint:    job.payloader.To(),
-				RawResponse: payload,
-			}
+			ctx, done := o.Job(ctx, job.provider)
+			defer done()
+			res <- fn(ctx, job)
 		}(j)
 	}
 	wg.Wait()
 	close(res)
-	responses := []api.ShippingResponse{}
-	for resp := range res {
+	out := []T{}
+	for r := range res {
+		out = append(out, r)
+	}
+	return out
+}
+
+func (s *Shipment) do(ctx context.Context, job job, req *api.ShippingRequest) Attempt {
+	id := uuid.NewString()
+	payload := job.payloader.Payload(req)
+	s.log.With(slog.String("payload", string(payload))).
+		InfoContext(ctx, "Sending resulting payload")
+	raw, err := s.client.Do(request.WithShipment(ctx, id), job.payloader.To(), payload)
+	return attempt(id, job, payload, raw, err)
+}
+
+// attempt returns the outcome of the provider call made for the job.
+func attempt(id string, job job, payload, raw []byte, err error) Attempt {
+	a := Attempt{
+		ID:       id,
+		Provider: job.provider,
+		Endpoint: job.payloader.To(),
+		Status:   StatusBooked,
+		Request:  payload,
+		Response: raw,
+	}
+	if err != nil {
+		a.Status, a.Error = StatusFailed, err.Error()
+		var status *request.StatusError
+		if errors.As(err, &status) {
+			a.HTTPStatus = status.Code
+		}
+	}
+	return a
+}
+
+// save stores the attempts of a fan-out with the request they were made
+// from and returns their responses, if they can't be stored the bookings
+// are reported with the error, which is returned as well.
+func (s *Shipment) save(ctx context.Context, req *api.ShippingRequest, attempts []Attempt) ([]api.ShippingResponse, error) {
+	if len(attempts) == 0 {
+		return []api.ShippingResponse{}, nil
+	}
+	original, err := json.Marshal(req)
+	if err != nil {
+		s.log.With(log.Error(err)).ErrorContext(ctx, "Failed to encode the shipping request, it can't be resent")
+	}
+	for i := range attempts {
+		attempts[i].Original = original
+	}
+	// The providers booked them, stored or not.
+	booked(ctx, attempts)
+	if err = s.storage.Save(ctx, attempts); err != nil {
+		s.log.With(log.Error(err)).ErrorContext(ctx, "Failed to save shipment")
+	}
+	responses := make([]api.ShippingResponse, 0, len(attempts))
+	for _, a := range attempts {
+		resp := a.response()
+		if err != nil && resp.Error == "" {
+			resp.Error = err.Error()
+		}
 		responses = append(responses, resp)
 	}
-	return responses, nil
+	return responses, err
+}
+
+// deadLetter keeps the failed attempts, the retries of the client are
+// exhausted by then. The attempts that weren't stored can't be requeued,
+// they are left out by the callers.
+func (s *Shipment) deadLetter(ctx context.Context, attempts []Attempt) {
+	failed := slices.DeleteFunc(slices.Clone(attempts), func(a Attempt) bool { return a.Status != StatusFailed })
+	if len(failed) == 0 {
+		return
+	}
+	if err := s.deadLetters.CreateDeadLetters(ctx, failed); err != nil {
+		s.log.With(log.Error(err)).ErrorContext(ctx, "Failed to dead-letter the failed attempts")
+	}
 }
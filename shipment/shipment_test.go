@@ -3,9 +3,13 @@ package shipment
 import (
 	"context"
 	"errors"
+	"maps"
+	"slices"
+	"sync"
 	"testing"
 
 	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/http/request"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -27,11 +31,22 @@ func (m *mockPayloader) To() string {
 
 type mockStorage struct{ mock.Mock }
 
-func (m *mockStorage) Save(ctx context.Context, provider string, payload []byte) error {
-	args := m.Called(ctx, provider, payload)
+func (m *mockStorage) Save(ctx context.Context, attempts []Attempt) error {
+	args := m.Called(ctx, attempts)
 	return args.Error(0)
 }
 
+// saved matches the attempts of a fan-out by provider and status, in any order.
+func saved(want map[string]Status) any {
+	return mock.MatchedBy(func(attempts []Attempt) bool {
+		got := map[string]Status{}
+		for _, a := range attempts {
+			got[a.Provider] = a.Status
+		}
+		return maps.Equal(want, got)
+	})
+}
+
 type mockClient struct{ mock.Mock }
 
 func (m *mockClient) Do(ctx context.Context, to string, payload any) ([]byte, error) {
@@ -76,8 +91,10 @@ func TestShipment_Send(t *testing.T) {
 				mc.On("Do", mock.Anything, "https://provider2.example.com", []byte(`{"provider":"provider2"}`)).
 					Return([]byte(`{"tracking_id":"2"}`), nil)
 
-				ms.On("Save", mock.Anything, "provider1", []byte(`{"tracking_id":"1"}`)).Return(nil)
-				ms.On("Save", mock.Anything, "provider2", []byte(`{"tracking_id":"2"}`)).Return(nil)
+				ms.On("Save", mock.Anything, saved(map[string]Status{
+					"provider1": StatusBooked,
+					"provider2": StatusBooked,
+				})).Return(nil)
 			},
 			request: &api.ShippingRequest{
 				Weight: api.Weight{Value: 10.5, Unit: "KG"},
@@ -111,7 +128,7 @@ func TestShipment_Send(t *testing.T) {
 				mc.On("Do", mock.Anything, "https://provider1.example.com", []byte(`{"provider":"provider1"}`)).
 					Return([]byte(`{"tracking_id":"1"}`), nil)
 
-				ms.On("Save", mock.Anything, "provider1", []byte(`{"tracking_id":"1"}`)).Return(nil)
+				ms.On("Save", mock.Anything, saved(map[string]Status{"provider1": StatusBooked})).Return(nil)
 			},
 			request: &api.ShippingRequest{
 				Weight: api.Weight{Value: 5.0, Unit: "KG"},
@@ -158,6 +175,7 @@ func TestShipment_Send(t *testing.T) {
 			setupMocks: func(mc *mockClient, ms *mockStorage, mp map[string]*mockPayloader) {
 				mc.On("Do", mock.Anything, "https://provider1.example.com", []byte(`{"provider":"provider1"}`)).
 					Return([]byte(`{"error":"network timeout"}`), errors.New("network timeout"))
+				ms.On("Save", mock.Anything, saved(map[string]Status{"provider1": StatusFailed})).Return(nil)
 			},
 			request: &api.ShippingRequest{
 				Weight: api.Weight{Value: 5.0, Unit: "KG"},
@@ -185,7 +203,7 @@ func TestShipment_Send(t *testing.T) {
 				mc.On("Do", mock.Anything, "https://provider1.example.com", []byte(`{"provider":"provider1"}`)).
 					Return([]byte(`{"tracking_id":"1"}`), nil)
 
-				ms.On("Save", mock.Anything, "provider1", []byte(`{"tracking_id":"1"}`)).
+				ms.On("Save", mock.Anything, saved(map[string]Status{"provider1": StatusBooked})).
 					Return(errors.New("database connection failed"))
 			},
 			request: &api.ShippingRequest{
@@ -224,17 +242,19 @@ func TestShipment_Send(t *testing.T) {
 				// provider1 succeeds
 				mc.On("Do", mock.Anything, "https://provider1.example.com", []byte(`{"provider":"provider1"}`)).
 					Return([]byte(`{"tracking_id":"1"}`), nil)
-				ms.On("Save", mock.Anything, "provider1", []byte(`{"tracking_id":"1"}`)).Return(nil)
 
 				// provider2 fails at client Do
 				mc.On("Do", mock.Anything, "https://provider2.example.com", []byte(`{"provider":"provider2"}`)).
 					Return(nil, errors.New("client error"))
 
-				// provider3 fails at storage Save
+				// provider3 is rejected by the provider
 				mc.On("Do", mock.Anything, "https://provider3.example.com", []byte(`{"provider":"provider3"}`)).
-					Return([]byte(`{"tracking_id":"3"}`), nil)
-				ms.On("Save", mock.Anything, "provider3", []byte(`{"tracking_id":"3"}`)).
-					Return(errors.New("storage error"))
+					Return([]byte(`bad gateway`), &request.StatusError{Code: 502})
+
+				ms.On("Save", mock.Anything, mock.MatchedBy(func(attempts []Attempt) bool {
+					i := slices.IndexFunc(attempts, func(a Attempt) bool { return a.Provider == "provider3" })
+					return len(attempts) == 3 && i >= 0 && attempts[i].HTTPStatus == 502
+				})).Return(nil)
 			},
 			request: &api.ShippingRequest{
 				Weight: api.Weight{Value: 5.0, Unit: "KG"},
@@ -272,7 +292,7 @@ func TestShipment_Send(t *testing.T) {
 			setupMocks: func(mc *mockClient, ms *mockStorage, mp map[string]*mockPayloader) {
 				mc.On("Do", mock.Anything, "https://provider1.example.com", []byte(`{"provider":"provider1"}`)).
 					Return([]byte(`{"tracking_id":"1"}`), nil)
-				ms.On("Save", mock.Anything, "provider1", []byte(`{"tracking_id":"1"}`)).Return(nil)
+				ms.On("Save", mock.Anything, saved(map[string]Status{"provider1": StatusBooked})).Return(nil)
 			},
 			request: &api.ShippingRequest{
 				Weight: api.Weight{Value: 5.0, Unit: "KG"},
@@ -330,3 +350,253 @@ func TestShipment_Send(t *testing.T) {
 		})
 	}
 }
+
+type mockEligibility struct{ mock.Mock }
+
+func (m *mockEligibility) Eligible(ctx context.Context, req *api.ShippingRequest, providers []string) ([]string, error) {
+	args := m.Called(ctx, req, providers)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func TestShipment_SendEligibility(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []string
+		eligible  []string
+		wantErr   error
+		wantSent  []string
+	}{
+		{
+			name:     "ineligible providers are left out when sending to all",
+			eligible: []string{"provider2"},
+			wantSent: []string{"https://provider2.example.com"},
+		},
+		{
+			name:      "explicit ineligible provider is an error",
+			providers: []string{"provider1", "provider2"},
+			eligible:  []string{"provider2"},
+			wantErr:   &ErrProviderIneligible{Provider: "provider1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := new(mockClient)
+			storage := new(mockStorage)
+			providers := map[string]Payloader{}
+			for _, name := range []string{"provider1", "provider2"} {
+				p := new(mockPayloader)
+				p.On("Payload", mock.Anything).Return([]byte(name)).Maybe()
+				p.On("To").Return("https://" + name + ".example.com").Maybe()
+				providers[name] = p
+				client.On("Do", mock.Anything, "https://"+name+".example.com", []byte(name)).Return([]byte(name), nil).Maybe()
+			}
+			storage.On("Save", mock.Anything, mock.Anything).Return(nil).Maybe()
+			eligibility := new(mockEligibility)
+			eligibility.On("Eligible", mock.Anything, mock.Anything, []string{"provider1", "provider2"}).
+				Return(tt.eligible, nil)
+
+			s := New(client, providers, storage, WithEligibility(eligibility))
+			responses, err := s.Send(context.Background(), tt.providers, &api.ShippingRequest{})
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				return
+			}
+			assert.NoError(t, err)
+			sent := []string{}
+			for _, resp := range responses {
+				sent = append(sent, resp.Endpoint)
+			}
+			assert.Equal(t, tt.wantSent, sent)
+			eligibility.AssertExpectations(t)
+		})
+	}
+}
+
+type scopeAuthorizer []string
+
+func (a scopeAuthorizer) Allowed(_ context.Context, provider string) bool {
+	return slices.Contains(a, provider)
+}
+
+func TestShipment_SendAuthorizer(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []string
+		wantErr   error
+		wantSent  []string
+	}{
+		{
+			name:     "providers outside the scope are left out when sending to all",
+			wantSent: []string{"https://provider2.example.com"},
+		},
+		{
+			name:      "explicit provider outside the scope is an error",
+			providers: []string{"provider1"},
+			wantErr:   &ErrProviderForbidden{Provider: "provider1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := new(mockClient)
+			storage := new(mockStorage)
+			providers := map[string]Payloader{}
+			for _, name := range []string{"provider1", "provider2"} {
+				p := new(mockPayloader)
+				p.On("Payload", mock.Anything).Return([]byte(name)).Maybe()
+				p.On("To").Return("https://" + name + ".example.com").Maybe()
+				providers[name] = p
+				client.On("Do", mock.Anything, "https://"+name+".example.com", []byte(name)).Return([]byte(name), nil).Maybe()
+			}
+			storage.On("Save", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+			s := New(client, providers, storage, WithAuthorizer(scopeAuthorizer{"provider2"}))
+			responses, err := s.Send(context.Background(), tt.providers, &api.ShippingRequest{})
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				client.AssertNotCalled(t, "Do", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			sent := []string{}
+			for _, resp := range responses {
+				sent = append(sent, resp.Endpoint)
+			}
+			assert.Equal(t, tt.wantSent, sent)
+		})
+	}
+}
+
+type ctxKey string
+
+type recordingObserver struct {
+	name  string
+	calls *[]string
+}
+
+func (o recordingObserver) FanOut(_ context.Context, width int) {
+	*o.calls = append(*o.calls, o.name+".fanout")
+}
+
+func (o recordingObserver) Job(ctx context.Context, provider string) (context.Context, func()) {
+	*o.calls = append(*o.calls, o.name+".job:"+provider)
+	ctx = context.WithValue(ctx, ctxKey(o.name), true)
+	return ctx, func() { *o.calls = append(*o.calls, o.name+".done") }
+}
+
+func TestObservers(t *testing.T) {
+	var calls []string
+	o := observers{
+		recordingObserver{name: "first", calls: &calls},
+		recordingObserver{name: "second", calls: &calls},
+	}
+	o.FanOut(context.Background(), 1)
+	ctx, done := o.Job(context.Background(), "a")
+	done()
+
+	assert.Equal(t, true, ctx.Value(ctxKey("first")))
+	assert.Equal(t, true, ctx.Value(ctxKey("second")))
+	assert.Equal(t, []string{
+		"first.fanout", "second.fanout",
+		"first.job:a", "second.job:a",
+		"second.done", "first.done",
+	}, calls)
+}
+
+type deadLetters struct {
+	mu       sync.Mutex
+	provider []string
+}
+
+func (d *deadLetters) CreateDeadLetters(_ context.Context, attempts []Attempt) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, a := range attempts {
+		d.provider = append(d.provider, a.Provider)
+	}
+	return nil
+}
+
+func TestShipment_DeadLetter(t *testing.T) {
+	providers := map[string]Payloader{"a": racePayloader("a"), "b": racePayloader("b")}
+	tests := []struct {
+		name  string
+		fail  []string
+		send  func(s *Shipment) error
+		saved error
+		want  []string
+	}{
+		{
+			name: "broadcast keeps every failed call",
+			fail: []string{"a", "b"},
+			send: func(s *Shipment) error {
+				_, err := s.Send(context.Background(), nil, &api.ShippingRequest{})
+				return err
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "broadcast leaves the bookings out",
+			fail: []string{"b"},
+			send: func(s *Shipment) error {
+				_, err := s.Send(context.Background(), nil, &api.ShippingRequest{})
+				return err
+			},
+			want: []string{"b"},
+		},
+		{
+			name:  "attempts not stored can't be requeued",
+			fail:  []string{"a", "b"},
+			saved: errors.New("database is down"),
+			send: func(s *Shipment) error {
+				_, err := s.Send(context.Background(), nil, &api.ShippingRequest{})
+				return err
+			},
+		},
+		{
+			name: "route keeps the last candidate when all of them failed",
+			fail: []string{"a", "b"},
+			send: func(s *Shipment) error {
+				_, err := s.Route(context.Background(), StrategyPriority, nil, &api.ShippingRequest{})
+				return err
+			},
+			want: []string{"b"},
+		},
+		{
+			name: "route falling back to a booking keeps nothing",
+			fail: []string{"a"},
+			send: func(s *Shipment) error {
+				_, err := s.Route(context.Background(), StrategyPriority, nil, &api.ShippingRequest{})
+				return err
+			},
+		},
+		{
+			name: "race keeps the preferred provider when nobody booked",
+			fail: []string{"a", "b"},
+			send: func(s *Shipment) error {
+				_, err := s.Race(context.Background(), nil, 0, &api.ShippingRequest{})
+				return err
+			},
+			want: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &raceClient{behaviour: map[string]raceBehaviour{}}
+			for _, p := range tt.fail {
+				client.behaviour["https://"+p] = raceBehaviour{fail: true}
+			}
+			storage := new(mockStorage)
+			storage.On("Save", mock.Anything, mock.Anything).Return(tt.saved)
+			dl := new(deadLetters)
+			s := New(client, providers, storage, WithPriority("a", "b"), WithDeadLetters(dl))
+
+			assert.NoError(t, tt.send(s))
+			assert.ElementsMatch(t, tt.want, dl.provider)
+		})
+	}
+}
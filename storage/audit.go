@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+var _ request.Auditor = (*Storage)(nil)
+
+// digest returns the sha256 of the body, nil if there's no body.
+func digest(body []byte) []byte {
+	if body == nil {
+		return nil
+	}
+	sum := sha256.Sum256(body)
+	return sum[:]
+}
+
+const insertExchange = `INSERT INTO exchange (shipment_id, endpoint, attempt, request_headers, request, status,
+	response_headers, response, error, request_id, tenant_id, key_id, started_at, finished_at, request_sha256,
+	response_sha256, id) OVERRIDING SYSTEM VALUE VALUES (NULLIF($1, '')::uuid, $2, $3, $4, $5, NULLIF($6, 0), $7, $8,
+	NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), $13, $14, $15, $16, $17)`
+
+// The positions of the bodies in the exchange rows, they are sealed once
+// the exchange has an id, bound to it.
+const (
+	exchangeRequest  = 4
+	exchangeResponse = 7
+)
+
+// Audit appends the exchange to the audit log, tagged with the tenant found
+// in the context. The bodies are kept byte for byte, encrypted if there's a
+// cipher, with the digests of the plaintext they are verified against.
+func (r *Storage) Audit(ctx context.Context, e request.Exchange) error {
+	r.log.With(slog.String("query", insertExchange), slog.String("endpoint", e.Endpoint)).DebugContext(ctx, "Audit")
+	row, err := r.exchangeRow(ctx, e)
+	if err != nil {
+		return fmt.Errorf("failed to audit exchange, %w", err)
+	}
+	if err := r.insertExchanges(ctx, [][]any{row}); err != nil {
+		return fmt.Errorf("failed to audit exchange, %w", err)
+	}
+	return nil
+}
+
+// exchangeRow returns the values the exchange is inserted with, but its id.
+func (r *Storage) exchangeRow(ctx context.Context, e request.Exchange) ([]any, error) {
+	return []any{e.Shipment, e.Endpoint, e.Attempt, e.RequestHeaders, e.Request, e.Status, e.ResponseHeaders,
+		e.Response, e.Error, e.RequestID, tenant.ID(ctx), r.keyID(), e.StartedAt, e.FinishedAt,
+		digest(e.Request), digest(e.Response)}, nil
+}
+
+// insertExchanges writes the exchanges in one transaction, sent in a single
+// round trip. Their ids are taken first so their bodies are sealed bound to them.
+func (r *Storage) insertExchanges(ctx context.Context, rows [][]any) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		query := `SELECT nextval(pg_get_serial_sequence('exchange', 'id')) FROM generate_series(1, $1)`
+		ids, err := tx.Query(ctx, query, len(rows))
+		if err != nil {
+			return err
+		}
+		reserved, err := pgx.CollectRows(ids, pgx.RowTo[int64])
+		if err != nil {
+			return err
+		}
+		batch := &pgx.Batch{}
+		for i, row := range rows {
+			row = slices.Clone(row)
+			id := strconv.FormatInt(reserved[i], 10)
+			for at, column := range map[int]string{exchangeRequest: "request", exchangeResponse: "response"} {
+				body, err := r.seal(row[at].([]byte), aad("exchange", column, id))
+				if err != nil {
+					return err
+				}
+				row[at] = []byte(body)
+			}
+			batch.Queue(insertExchange, append(row, reserved[i])...)
+		}
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// Exchanges returns the exchanges audited for the shipment, in the order they
+// were made, with the digests of their bodies to be verified against.
+func (r *Storage) Exchanges(ctx context.Context, shipmentID string) ([]request.Exchange, error) {
+	query := `SELECT id::text, COALESCE(shipment_id::text, ''), COALESCE(request_id, ''), endpoint, attempt, request_headers,
+		request, COALESCE(status, 0), COALESCE(response_headers, '{}'), response, COALESCE(error, ''),
+		started_at, finished_at, COALESCE(key_id, ''), COALESCE(encode(request_sha256, 'hex'), ''),
+		COALESCE(encode(response_sha256, 'hex'), '')
+		FROM exchange WHERE shipment_id = $1 ORDER BY started_at, id`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Exchanges")
+	rows, err := r.db.Query(ctx, query, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exchanges, %w", err)
+	}
+	exchanges, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (request.Exchange, error) {
+		var (
+			e         request.Exchange
+			id, keyID string
+		)
+		err := row.Scan(&id, &e.Shipment, &e.RequestID, &e.Endpoint, &e.Attempt, &e.RequestHeaders, &e.Request, &e.Status,
+			&e.ResponseHeaders, &e.Response, &e.Error, &e.StartedAt, &e.FinishedAt, &keyID, &e.RequestSHA256, &e.ResponseSHA256)
+		if err != nil {
+			return e, err
+		}
+		if e.Request, err = r.open(e.Request, keyID, aad("exchange", "request", id)); err != nil {
+			return e, err
+		}
+		e.Response, err = r.open(e.Response, keyID, aad("exchange", "response", id))
+		return e, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan exchanges, %w", err)
+	}
+	return exchanges, nil
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// ErrAuditWriterClosed is returned when auditing through a closed writer.
+var ErrAuditWriterClosed = errors.New("audit writer closed")
+
+// AuditWriter audits the exchanges in the background, so the calls to the
+// providers don't wait on the db. The exchanges are buffered and written in
+// one transaction once the batch holds size exchanges or interval passed
+// since the first one was buffered. Audit only waits when the buffer is full.
+type AuditWriter struct {
+	row      func(ctx context.Context, e request.Exchange) ([]any, error)
+	insert   func(ctx context.Context, rows [][]any) error
+	size     int
+	interval time.Duration
+	buffer   int
+	log      *slog.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	rows   chan []any
+	done   chan struct{}
+}
+
+var _ request.Auditor = (*AuditWriter)(nil)
+
+type AuditWriterOption func(w *AuditWriter)
+
+func WithAuditWriterLogger(log *slog.Logger) AuditWriterOption {
+	return func(w *AuditWriter) {
+		w.log = log.WithGroup("audit")
+	}
+}
+
+// WithAuditBatchSize sets how many exchanges are written at most in one flush.
+func WithAuditBatchSize(size int) AuditWriterOption {
+	return func(w *AuditWriter) {
+		w.size = size
+	}
+}
+
+// WithAuditFlushInterval sets how long an exchange waits for others to be written with.
+func WithAuditFlushInterval(interval time.Duration) AuditWriterOption {
+	return func(w *AuditWriter) {
+		w.interval = interval
+	}
+}
+
+// WithAuditBuffer sets how many exchanges are buffered before Audit waits for them to be written.
+func WithAuditBuffer(buffer int) AuditWriterOption {
+	return func(w *AuditWriter) {
+		w.buffer = buffer
+	}
+}
+
+// NewAuditWriter returns a writer auditing the exchanges into the storage in
+// the background, it must be closed to write the exchanges still buffered.
+func (r *Storage) NewAuditWriter(options ...AuditWriterOption) *AuditWriter {
+	return newAuditWriter(r.exchangeRow, r.insertExchanges, options...)
+}
+
+func newAuditWriter(
+	row func(ctx context.Context, e request.Exchange) ([]any, error),
+	insert func(ctx context.Context, rows [][]any) error,
+	options ...AuditWriterOption,
+) *AuditWriter {
+	w := &AuditWriter{
+		row:      row,
+		insert:   insert,
+		size:     500,
+		interval: 50 * time.Millisecond,
+		buffer:   10_000,
+		log:      log.Noop(),
+		done:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(w)
+	}
+	w.rows = make(chan []any, w.buffer)
+	go w.run()
+	return w
+}
+
+// Audit buffers the exchange to be written, tagged with the tenant found in
+// the context. It returns before the exchange is written, the failures
+// to write it are logged.
+func (w *AuditWriter) Audit(ctx context.Context, e request.Exchange) error {
+	row, err := w.row(ctx, e)
+	if err != nil {
+		return fmt.Errorf("failed to audit exchange, %w", err)
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrAuditWriterClosed
+	}
+	select {
+	case w.rows <- row:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to audit exchange, %w", ctx.Err())
+	}
+}
+
+// Close writes the buffered exchanges and stops the writer.
+func (w *AuditWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.rows)
+	}
+	w.mu.Unlock()
+	<-w.done
+	return nil
+}
+
+func (w *AuditWriter) run() {
+	defer close(w.done)
+	var (
+		pending [][]any
+		timer   *time.Timer
+		expired <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		w.flush(pending)
+		pending = nil
+	}
+	for {
+		select {
+		case row, ok := <-w.rows:
+			if !ok {
+				flush()
+				return
+			}
+			pending = append(pending, row)
+			if len(pending) >= w.size {
+				flush()
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(w.interval)
+				expired = timer.C
+			}
+		case <-expired:
+			flush()
+		}
+	}
+}
+
+// flush writes the buffered exchanges at once. If that fails each exchange
+// is written on its own, so one bad exchange doesn't lose the others.
+func (w *AuditWriter) flush(pending [][]any) {
+	if len(pending) == 0 {
+		return
+	}
+	ctx := context.Background()
+	err := w.insert(ctx, pending)
+	if err == nil {
+		return
+	}
+	if len(pending) == 1 {
+		w.log.With(log.Error(err), slog.Any("to", pending[0][1])).Error("Failed to audit the provider exchange")
+		return
+	}
+	w.log.With(log.Error(err), slog.Int("exchanges", len(pending))).
+		Warn("Failed to write the audit batch, writing each exchange on its own")
+	for _, row := range pending {
+		w.flush([][]any{row})
+	}
+}
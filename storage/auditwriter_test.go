@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/http/request"
+)
+
+// auditor records the batches of endpoints written and fails the ones with a bad endpoint.
+type auditor struct {
+	mu      sync.Mutex
+	batches [][]string
+	written chan struct{}
+}
+
+func (a *auditor) insert(_ context.Context, rows [][]any) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	endpoints := []string{}
+	for _, row := range rows {
+		if row[1] == "bad" {
+			return errors.New("invalid row")
+		}
+		endpoints = append(endpoints, row[1].(string))
+	}
+	a.batches = append(a.batches, endpoints)
+	if a.written != nil {
+		a.written <- struct{}{}
+	}
+	return nil
+}
+
+func (a *auditor) flushed() [][]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.batches
+}
+
+func auditAll(t *testing.T, w *AuditWriter, endpoints ...string) {
+	t.Helper()
+	for _, endpoint := range endpoints {
+		require.NoError(t, w.Audit(context.Background(), request.Exchange{Endpoint: endpoint}))
+	}
+}
+
+func TestAuditWriter_FlushOnSize(t *testing.T) {
+	a := &auditor{written: make(chan struct{}, 1)}
+	w := newAuditWriter(New(nil).exchangeRow, a.insert, WithAuditBatchSize(3), WithAuditFlushInterval(time.Hour))
+	defer w.Close()
+
+	auditAll(t, w, "a", "b", "c")
+	<-a.written
+	assert.Equal(t, [][]string{{"a", "b", "c"}}, a.flushed())
+}
+
+func TestAuditWriter_FlushOnInterval(t *testing.T) {
+	a := &auditor{written: make(chan struct{}, 1)}
+	w := newAuditWriter(New(nil).exchangeRow, a.insert, WithAuditBatchSize(100), WithAuditFlushInterval(10*time.Millisecond))
+	defer w.Close()
+
+	start := time.Now()
+	auditAll(t, w, "a")
+	assert.Less(t, time.Since(start), 10*time.Millisecond, "the exchange is written in the background")
+	<-a.written
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	assert.Equal(t, [][]string{{"a"}}, a.flushed())
+}
+
+func TestAuditWriter_FailedBatchWritesEachExchange(t *testing.T) {
+	a := new(auditor)
+	w := newAuditWriter(New(nil).exchangeRow, a.insert, WithAuditBatchSize(100), WithAuditFlushInterval(time.Hour))
+
+	auditAll(t, w, "a", "bad", "b")
+	require.NoError(t, w.Close())
+	assert.Equal(t, [][]string{{"a"}, {"b"}}, a.flushed())
+}
+
+func TestAuditWriter_Close(t *testing.T) {
+	a := new(auditor)
+	w := newAuditWriter(New(nil).exchangeRow, a.insert, WithAuditBatchSize(100), WithAuditFlushInterval(time.Hour))
+
+	auditAll(t, w, "a", "b")
+	require.NoError(t, w.Close())
+	assert.Equal(t, [][]string{{"a", "b"}}, a.flushed(), "the buffered exchanges are written on close")
+	require.NoError(t, w.Close())
+	assert.ErrorIs(t, w.Audit(context.Background(), request.Exchange{Endpoint: "c"}), ErrAuditWriterClosed)
+}
+
+func TestAuditWriter_FullBuffer(t *testing.T) {
+	a := &auditor{written: make(chan struct{})}
+	w := newAuditWriter(New(nil).exchangeRow, a.insert, WithAuditBatchSize(1), WithAuditBuffer(1))
+	defer w.Close()
+
+	// The first one is held in the insert, the second one fills the buffer.
+	auditAll(t, w, "a", "b")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, w.Audit(ctx, request.Exchange{Endpoint: "c"}), context.DeadlineExceeded, "a full buffer waits")
+	<-a.written
+	<-a.written
+}
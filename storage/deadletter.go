@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/hoenirvili/axiogate/deadletter"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+var (
+	_ deadletter.Store     = (*Storage)(nil)
+	_ shipment.DeadLetters = (*Storage)(nil)
+)
+
+const deadLetterColumns = `id, shipment_id::text, provider, reason, COALESCE(http_status, 0), COALESCE(tenant_id, ''), created_at`
+
+func scanDeadLetter(row pgx.Row) (deadletter.Letter, error) {
+	var l deadletter.Letter
+	err := row.Scan(&l.ID, &l.Shipment, &l.Provider, &l.Reason, &l.HTTPStatus, &l.Tenant, &l.CreatedAt)
+	return l, err
+}
+
+// CreateDeadLetters keeps the failed attempts tagged with the tenant found
+// in the context, a shipment is dead-lettered once.
+func (r *Storage) CreateDeadLetters(ctx context.Context, attempts []shipment.Attempt) error {
+	query := `INSERT INTO dead_letter (shipment_id, provider, reason, http_status, tenant_id)
+		VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, '')) ON CONFLICT (shipment_id) DO NOTHING`
+	r.log.With(slog.String("query", query), slog.Int("attempts", len(attempts))).DebugContext(ctx, "CreateDeadLetters")
+	batch := &pgx.Batch{}
+	for _, a := range attempts {
+		batch.Queue(query, a.ID, a.Provider, a.Error, a.HTTPStatus, tenant.ID(ctx))
+	}
+	if err := r.db.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to save dead letters, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) DeadLetters(ctx context.Context, f deadletter.Filter) ([]deadletter.Letter, error) {
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letter
+		WHERE ($1 = '' OR provider = $1) AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "DeadLetters")
+	rows, err := r.db.Query(ctx, query, f.Provider, f.Before, f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dead letters, %w", err)
+	}
+	letters, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (deadletter.Letter, error) {
+		return scanDeadLetter(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan dead letters, %w", err)
+	}
+	return letters, nil
+}
+
+func (r *Storage) DeadLetter(ctx context.Context, id int64) (*deadletter.Letter, error) {
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letter WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "DeadLetter")
+	l, err := scanDeadLetter(r.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, deadletter.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dead letter, %w", err)
+	}
+	return &l, nil
+}
+
+func (r *Storage) DeleteDeadLetter(ctx context.Context, id int64) error {
+	query := `DELETE FROM dead_letter WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "DeleteDeadLetter")
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter, %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return deadletter.ErrNotFound
+	}
+	return nil
+}
+
+func (r *Storage) RestoreDeadLetter(ctx context.Context, l *deadletter.Letter) error {
+	query := `INSERT INTO dead_letter (id, shipment_id, provider, reason, http_status, tenant_id, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, ''), $7) ON CONFLICT DO NOTHING`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "RestoreDeadLetter")
+	_, err := r.db.Exec(ctx, query, l.ID, l.Shipment, l.Provider, l.Reason, l.HTTPStatus, l.Tenant, l.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to restore dead letter, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) CountDeadLetters(ctx context.Context) (int, error) {
+	query := `SELECT COUNT(*) FROM dead_letter`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "CountDeadLetters")
+	var n int
+	if err := r.db.QueryRow(ctx, query).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count dead letters, %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/hoenirvili/axiogate/log"
+)
+
+// sealed are the payloads of a row as stored, with their digests if the table keeps them.
+type sealed struct {
+	id       string
+	payloads [][]byte
+	digests  [][]byte
+	keyID    string
+}
+
+// table holds encrypted payloads.
+type table struct {
+	name, id string
+	// columns are encrypted, digests are the sha256 of their plaintext, if kept.
+	columns, digests []string
+	// reseal stores the payloads encrypted with another key, taking the id,
+	// the key id and the payloads. Empty updates the columns in place.
+	reseal string
+}
+
+// encrypted are the tables holding encrypted payloads.
+var encrypted = []table{
+	{name: "shipment", id: "uuid", columns: []string{"request", "response", "original"}},
+	{
+		name: "exchange", id: "bigint",
+		columns: []string{"request", "response"},
+		digests: []string{"request_sha256", "response_sha256"},
+		reseal:  `SELECT exchange_reseal($1::bigint, $2, $3, $4)`,
+	},
+}
+
+// Unreadable holds the ids of the rows, by table, whose payloads can't be
+// decrypted, their key is gone or they are corrupt.
+type Unreadable map[string][]string
+
+// Len returns how many rows are unreadable.
+func (u Unreadable) Len() int {
+	n := 0
+	for _, ids := range u {
+		n += len(ids)
+	}
+	return n
+}
+
+// Reencrypt encrypts with the current key up to limit shipments, and as
+// many audited exchanges, stored in plaintext or with another key, it
+// returns how many it encrypted. The rows being encrypted by someone else
+// at the same time are skipped. The rows that can't be decrypted are left
+// as they are and added to unreadable, the ones already in it aren't read again.
+func (r *Storage) Reencrypt(ctx context.Context, limit int, unreadable Unreadable) (int, error) {
+	if r.cipher == nil {
+		return 0, nil
+	}
+	total := 0
+	for _, t := range encrypted {
+		n, err := r.reencrypt(ctx, t, limit, unreadable)
+		if err != nil {
+			return total, fmt.Errorf("failed to reencrypt %s, %w", t.name, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (r *Storage) reencrypt(ctx context.Context, t table, limit int, unreadable Unreadable) (int, error) {
+	query := `SELECT id::text, COALESCE(key_id, ''), ` + strings.Join(append(slices.Clone(t.columns), t.digests...), ", ") +
+		` FROM ` + t.name + ` WHERE (key_id IS NULL OR key_id <> $1) AND id::text <> ALL($3::text[])
+		LIMIT $2 FOR UPDATE SKIP LOCKED`
+	update := t.reseal
+	if update == "" {
+		set := make([]string, 0, len(t.columns))
+		for i, column := range t.columns {
+			set = append(set, fmt.Sprintf("%s = $%d", column, i+3))
+		}
+		update = `UPDATE ` + t.name + ` SET key_id = $2, ` + strings.Join(set, ", ") + ` WHERE id = $1::` + t.id
+	}
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Reencrypt")
+	n := 0
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		skip := unreadable[t.name]
+		if skip == nil {
+			skip = []string{}
+		}
+		rows, err := tx.Query(ctx, query, r.cipher.KeyID(), limit, skip)
+		if err != nil {
+			return err
+		}
+		stored, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (sealed, error) {
+			s := sealed{payloads: make([][]byte, len(t.columns)), digests: make([][]byte, len(t.digests))}
+			dest := []any{&s.id, &s.keyID}
+			for i := range s.payloads {
+				dest = append(dest, &s.payloads[i])
+			}
+			for i := range s.digests {
+				dest = append(dest, &s.digests[i])
+			}
+			err := row.Scan(dest...)
+			return s, err
+		})
+		if err != nil {
+			return err
+		}
+		batch := &pgx.Batch{}
+		for _, s := range stored {
+			payloads, err := r.reseal(t, s)
+			if err != nil {
+				r.log.With(slog.String("table", t.name), slog.String("id", s.id), log.Error(err)).
+					WarnContext(ctx, "Skipped reencrypting a row that can't be decrypted")
+				if unreadable != nil {
+					unreadable[t.name] = append(unreadable[t.name], s.id)
+				}
+				continue
+			}
+			batch.Queue(update, append([]any{s.id, r.cipher.KeyID()}, payloads...)...)
+		}
+		n = batch.Len()
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	return n, err
+}
+
+// reseal returns the payloads of the row encrypted with the current key,
+// the payloads not matching their digest are refused.
+func (r *Storage) reseal(t table, s sealed) ([]any, error) {
+	out := make([]any, 0, len(s.payloads))
+	for i, payload := range s.payloads {
+		plaintext, err := r.open(payload, s.keyID, aad(t.name, t.columns[i], s.id))
+		if err != nil {
+			return nil, err
+		}
+		if i < len(s.digests) && s.digests[i] != nil && plaintext != nil && !bytes.Equal(digest(plaintext), s.digests[i]) {
+			return nil, errors.New("the payload doesn't match its digest")
+		}
+		payload, err := r.seal(plaintext, aad(t.name, t.columns[i], s.id))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, payload)
+	}
+	return out, nil
+}
+
+// Rotate re-encrypts the shipments and the exchanges not encrypted with the
+// current key right away and then every interval, until the context is done.
+func (r *Storage) Rotate(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		r.reencryptAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reencryptAll reencrypts every row it can, the ones that can't be
+// decrypted are skipped until the next run and counted.
+func (r *Storage) reencryptAll(ctx context.Context) {
+	total, unreadable := 0, Unreadable{}
+	defer func() {
+		if total > 0 {
+			r.log.With(slog.Int("rows", total), slog.String("key", r.cipher.KeyID())).
+				InfoContext(ctx, "Reencrypted the stored payloads")
+		}
+		if n := unreadable.Len(); n > 0 {
+			r.log.With(slog.Int("rows", n)).ErrorContext(ctx, "Some stored payloads can't be decrypted, they were left as they are")
+		}
+	}()
+	for {
+		skipped := unreadable.Len()
+		n, err := r.Reencrypt(ctx, 500, unreadable)
+		if err != nil {
+			r.log.With(log.Error(err)).ErrorContext(ctx, "Failed to reencrypt the stored payloads")
+			return
+		}
+		if n == 0 && unreadable.Len() == skipped {
+			return
+		}
+		total += n
+	}
+}
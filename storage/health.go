@@ -0,0 +1,14 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Ping checks the database can be reached.
+func (r *Storage) Ping(ctx context.Context) error {
+	if err := r.db.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping the db, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/hoenirvili/axiogate/auth"
+)
+
+var _ auth.KeyStore = (*Storage)(nil)
+
+const keyColumns = `id, name, prefix, hash, COALESCE(tenant, ''), providers, admin, created_at, revoked_at`
+
+func scanKey(row pgx.Row) (auth.Key, error) {
+	var k auth.Key
+	err := row.Scan(
+		&k.ID, &k.Name, &k.Prefix, &k.Hash, &k.Tenant,
+		&k.Providers, &k.Admin, &k.CreatedAt, &k.RevokedAt,
+	)
+	return k, err
+}
+
+func (r *Storage) CreateKey(ctx context.Context, k *auth.Key) error {
+	query := `INSERT INTO api_key (name, prefix, hash, tenant, providers, admin)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6) RETURNING id, created_at`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "CreateKey")
+	if k.Providers == nil {
+		k.Providers = []string{}
+	}
+	err := r.db.QueryRow(ctx, query,
+		k.Name, k.Prefix, k.Hash, k.Tenant, k.Providers, k.Admin,
+	).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save key, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) KeyByHash(ctx context.Context, hash string) (*auth.Key, error) {
+	query := `SELECT ` + keyColumns + ` FROM api_key WHERE hash = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "KeyByHash")
+	k, err := scanKey(r.db.QueryRow(ctx, query, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, auth.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key, %w", err)
+	}
+	return &k, nil
+}
+
+func (r *Storage) Keys(ctx context.Context) ([]auth.Key, error) {
+	query := `SELECT ` + keyColumns + ` FROM api_key ORDER BY id`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Keys")
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys, %w", err)
+	}
+	keys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (auth.Key, error) {
+		return scanKey(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan keys, %w", err)
+	}
+	return keys, nil
+}
+
+func (r *Storage) RevokeKey(ctx context.Context, id int64) error {
+	query := `UPDATE api_key SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "RevokeKey")
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke key, %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return auth.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package memory stores the shipments in memory, they are lost on restart.
+// It's meant for the tests and the local demo mode.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/requestid"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+// Storage keeps the shipments in memory, newest last.
+type Storage struct {
+	mu      sync.RWMutex
+	records []shipment.Record
+	now     func() time.Time
+}
+
+func New() *Storage {
+	return &Storage{now: time.Now}
+}
+
+var _ shipment.Storage = (*Storage)(nil)
+
+// Save stores the attempts of a fan-out grouped under the same id and tagged
+// with the request id and the tenant found in the context.
+func (s *Storage) Save(ctx context.Context, attempts []shipment.Attempt) error {
+	for _, a := range attempts {
+		if !a.Status.Valid() {
+			return fmt.Errorf("failed to save shipment, invalid status %q", a.Status)
+		}
+	}
+	group := uuid.NewString()
+	now := s.now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attempts {
+		s.records = append(s.records, shipment.Record{
+			ID:         cmp.Or(a.ID, uuid.NewString()),
+			GroupID:    group,
+			Provider:   a.Provider,
+			Endpoint:   a.Endpoint,
+			Status:     a.Status,
+			HTTPStatus: a.HTTPStatus,
+			Error:      a.Error,
+			Request:    shipment.RawJSON(slices.Clone(request.RedactBody(a.Request))),
+			Response:   shipment.RawJSON(slices.Clone(a.Response)),
+			Original:   shipment.RawJSON(slices.Clone(a.Original)),
+			ResendOf:   a.ResendOf,
+			RequestID:  requestid.From(ctx),
+			Tenant:     tenant.ID(ctx),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		})
+	}
+	return nil
+}
+
+// visible reports if the record is in the tenants the filter scopes to.
+func visible(f shipment.Filter, rec shipment.Record) bool {
+	return f.AllTenants || rec.Tenant == f.Tenant
+}
+
+// matches reports if the record is selected by the filter.
+func (s *Storage) matches(f shipment.Filter, rec shipment.Record) bool {
+	switch {
+	case !visible(f, rec):
+		return false
+	case f.Provider != "" && rec.Provider != f.Provider:
+		return false
+	case f.Status != "" && rec.Status != f.Status:
+		return false
+	case !f.Since.IsZero() && rec.CreatedAt.Before(f.Since):
+		return false
+	case f.Unresent:
+		return !slices.ContainsFunc(s.records, func(r shipment.Record) bool { return r.ResendOf == rec.ID })
+	}
+	return true
+}
+
+// newer orders the records newest first.
+func newer(a, b shipment.Record) int {
+	return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+}
+
+func (s *Storage) Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var before *shipment.Record
+	if f.Before != "" {
+		i := slices.IndexFunc(s.records, func(rec shipment.Record) bool { return rec.ID == f.Before })
+		if i < 0 {
+			return []shipment.Record{}, nil
+		}
+		before = &s.records[i]
+	}
+	records := []shipment.Record{}
+	for _, rec := range s.records {
+		if s.matches(f, rec) && (before == nil || newer(*before, rec) < 0) {
+			records = append(records, rec)
+		}
+	}
+	slices.SortFunc(records, newer)
+	if len(records) > f.Limit {
+		records = records[:f.Limit]
+	}
+	return records, nil
+}
+
+func (s *Storage) Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rec := range s.records {
+		if rec.ID == id && visible(f, rec) {
+			return &rec, nil
+		}
+	}
+	return nil, shipment.ErrNotFound
+}
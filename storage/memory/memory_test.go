@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/hoenirvili/axiogate/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Store {
+		return New()
+	})
+}
@@ -0,0 +1,101 @@
+// Package pgtest runs the tests needing postgres against a server of their own.
+package pgtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// URLEnv points the tests to a running postgres server instead of starting one.
+const URLEnv = "AXIOGATE_TEST_DATABASE_URL"
+
+var url string
+
+// Run starts an embedded postgres server, unless URLEnv points to one, runs
+// the tests and stops it. The short tests don't start a server, the ones
+// needing it are skipped. It's meant to be called from TestMain.
+func Run(m *testing.M) int {
+	flag.Parse()
+	if url = os.Getenv(URLEnv); url != "" || testing.Short() {
+		return m.Run()
+	}
+	dir, err := os.MkdirTemp("", "axiogate-pgtest-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create the postgres directory,", err)
+		return 1
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	port, err := freePort()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to find a port for postgres,", err)
+		return 1
+	}
+	logs := new(bytes.Buffer)
+	config := embeddedpostgres.DefaultConfig().
+		Version(embeddedpostgres.V17).
+		Port(port).
+		RuntimePath(filepath.Join(dir, "runtime")).
+		DataPath(filepath.Join(dir, "data")).
+		Logger(logs)
+	server := embeddedpostgres.NewDatabase(config)
+	if err := server.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start postgres, %s\n%s", err, logs)
+		return 1
+	}
+	url = config.GetConnectionURL() + "?sslmode=disable"
+	code := m.Run()
+	if err := server.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to stop postgres,", err)
+	}
+	return code
+}
+
+func freePort() (uint32, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// DB returns a pool on a fresh schema of the server, dropped once the test is done.
+func DB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	if url == "" {
+		t.Skip("postgres is not started in short mode, set " + URLEnv + " to use a running server")
+	}
+	ctx := context.Background()
+
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	schema := "test_" + hex.EncodeToString(b)
+	admin, err := pgxpool.New(ctx, url)
+	require.NoError(t, err)
+	_, err = admin.Exec(ctx, "CREATE SCHEMA "+schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		admin.Close()
+	})
+
+	config, err := pgxpool.ParseConfig(url)
+	require.NoError(t, err)
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	db, err := pgxpool.NewWithConfig(ctx, config)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+	return db
+}
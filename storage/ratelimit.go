@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/hoenirvili/axiogate/ratelimit"
+)
+
+var _ ratelimit.Counter = (*Storage)(nil)
+
+func (r *Storage) QuotaUsed(ctx context.Context, client string, day time.Time) (int, error) {
+	query := `SELECT COALESCE((SELECT count FROM quota_usage WHERE client = $1 AND day = $2), 0)`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "QuotaUsed")
+	var count int
+	if err := r.db.QueryRow(ctx, query, client, day).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to fetch quota usage, %w", err)
+	}
+	return count, nil
+}
+
+func (r *Storage) UseQuota(ctx context.Context, client string, day time.Time, n int) error {
+	query := `INSERT INTO quota_usage (client, day, count) VALUES ($1, $2, $3)
+		ON CONFLICT (client, day) DO UPDATE SET count = quota_usage.count + EXCLUDED.count`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "UseQuota")
+	if _, err := r.db.Exec(ctx, query, client, day, n); err != nil {
+		return fmt.Errorf("failed to count quota usage, %w", err)
+	}
+	return nil
+}
+
+var _ ratelimit.Tokens = (*Storage)(nil)
+
+// refilled is the number of tokens in the bucket once refilled at the rate $2 up to the burst $3.
+const refilled = `LEAST($3::float8, rate_token.tokens + EXTRACT(EPOCH FROM now() - rate_token.updated_at)::float8 * $2::float8)`
+
+func (r *Storage) TakeToken(ctx context.Context, key string, rate, burst int) (bool, time.Duration, error) {
+	// The row lock taken by the upsert serializes the replicas taking tokens of the same key.
+	query := `INSERT INTO rate_token (key, tokens, updated_at) VALUES ($1, $3::float8 - 1, now())
+		ON CONFLICT (key) DO UPDATE SET tokens = ` + refilled + ` - 1, updated_at = now()
+		WHERE ` + refilled + ` >= 1 RETURNING tokens`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "TakeToken")
+	var tokens float64
+	err := r.db.QueryRow(ctx, query, key, rate, burst).Scan(&tokens)
+	if err == nil {
+		return true, 0, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return false, 0, fmt.Errorf("failed to take token, %w", err)
+	}
+
+	query = `SELECT ` + refilled + ` FROM rate_token WHERE key = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "TakeToken")
+	if err := r.db.QueryRow(ctx, query, key, rate, burst).Scan(&tokens); err != nil {
+		return false, 0, fmt.Errorf("failed to fetch tokens, %w", err)
+	}
+	wait := (1 - tokens) / float64(rate)
+	return false, time.Duration(wait * float64(time.Second)), nil
+}
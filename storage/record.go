@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+const recordColumns = `id::text, group_id::text, provider, endpoint, status::text, COALESCE(http_status, 0),
+	COALESCE(error, ''), request, response, COALESCE(request_id, ''), COALESCE(tenant_id, ''), created_at, updated_at,
+	COALESCE(key_id, ''), original, COALESCE(resend_of::text, '')`
+
+// ErrUnreadable is returned when the payloads of a shipment can't be
+// decrypted, its key was dropped or the payloads were altered.
+type ErrUnreadable struct {
+	ID  string
+	Err error
+}
+
+var _ error = (*ErrUnreadable)(nil)
+
+func (e *ErrUnreadable) Error() string {
+	return fmt.Sprintf("shipment %s can't be decrypted, %v", e.ID, e.Err)
+}
+
+func (e *ErrUnreadable) Unwrap() error {
+	return e.Err
+}
+
+// scanRecord scans the record and decrypts its payloads.
+func (r *Storage) scanRecord(row pgx.Row) (shipment.Record, error) {
+	var (
+		rec   shipment.Record
+		keyID string
+	)
+	err := row.Scan(&rec.ID, &rec.GroupID, &rec.Provider, &rec.Endpoint, &rec.Status, &rec.HTTPStatus,
+		&rec.Error, &rec.Request, &rec.Response, &rec.RequestID, &rec.Tenant, &rec.CreatedAt, &rec.UpdatedAt, &keyID,
+		&rec.Original, &rec.ResendOf)
+	if err != nil {
+		return rec, err
+	}
+	if rec.Request, err = r.open(rec.Request, keyID, aad("shipment", "request", rec.ID)); err != nil {
+		return rec, &ErrUnreadable{ID: rec.ID, Err: err}
+	}
+	if rec.Response, err = r.open(rec.Response, keyID, aad("shipment", "response", rec.ID)); err != nil {
+		return rec, &ErrUnreadable{ID: rec.ID, Err: err}
+	}
+	if rec.Original, err = r.open(rec.Original, keyID, aad("shipment", "original", rec.ID)); err != nil {
+		return rec, &ErrUnreadable{ID: rec.ID, Err: err}
+	}
+	return rec, nil
+}
+
+// scope returns the where clause and its arguments limiting the shipments to the filter.
+func scope(f shipment.Filter, args []any) (string, []any) {
+	where := []string{}
+	if !f.AllTenants {
+		if f.Tenant == "" {
+			where = append(where, "tenant_id IS NULL")
+		} else {
+			args = append(args, f.Tenant)
+			where = append(where, fmt.Sprintf("tenant_id = $%d", len(args)))
+		}
+	}
+	if f.Before != "" {
+		args = append(args, f.Before)
+		where = append(where, fmt.Sprintf("(created_at, id) < (SELECT created_at, id FROM shipment WHERE id = $%d)", len(args)))
+	}
+	if f.Provider != "" {
+		args = append(args, f.Provider)
+		where = append(where, fmt.Sprintf("provider = $%d", len(args)))
+	}
+	if f.Status != "" {
+		args = append(args, string(f.Status))
+		where = append(where, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if !f.Since.IsZero() {
+		args = append(args, f.Since)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if f.Unresent {
+		where = append(where, "NOT EXISTS (SELECT 1 FROM shipment resend WHERE resend.resend_of = shipment.id)")
+	}
+	if len(where) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(where, " AND "), args
+}
+
+// records returns the shipments of the query, the ones that can't be
+// decrypted are skipped, logged and their ids returned apart.
+func (r *Storage) records(ctx context.Context, query string, args ...any) ([]shipment.Record, []string, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch shipments, %w", err)
+	}
+	defer rows.Close()
+	records, unreadable := []shipment.Record{}, []string{}
+	for rows.Next() {
+		rec, err := r.scanRecord(rows)
+		var skip *ErrUnreadable
+		if errors.As(err, &skip) {
+			r.log.With(slog.String("id", skip.ID), log.Error(skip.Err)).
+				WarnContext(ctx, "Skipped a shipment that can't be decrypted")
+			unreadable = append(unreadable, skip.ID)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan shipments, %w", err)
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to scan shipments, %w", err)
+	}
+	return records, unreadable, nil
+}
+
+func (r *Storage) Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error) {
+	where, args := scope(f, nil)
+	args = append(args, f.Limit)
+	query := `SELECT ` + recordColumns + ` FROM shipment WHERE TRUE` + where +
+		fmt.Sprintf(` ORDER BY created_at DESC, id DESC LIMIT $%d`, len(args))
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Records")
+	records, _, err := r.records(ctx, query, args...)
+	return records, err
+}
+
+func (r *Storage) Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error) {
+	where, args := scope(shipment.Filter{Tenant: f.Tenant, AllTenants: f.AllTenants}, []any{id})
+	query := `SELECT ` + recordColumns + ` FROM shipment WHERE id = $1` + where
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Record")
+	rec, err := r.scanRecord(r.db.QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, shipment.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch shipment, %w", err)
+	}
+	return &rec, nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// Pending counts the shipments created before scrubBefore that weren't
+// scrubbed yet and won't be deleted, and the ones created before deleteBefore.
+func (r *Storage) Pending(ctx context.Context, scrubBefore, deleteBefore time.Time) (int, int, error) {
+	query := `SELECT
+		count(*) FILTER (WHERE scrubbed_at IS NULL AND created_at < $1 AND created_at >= $2),
+		count(*) FILTER (WHERE created_at < $2)
+		FROM shipment`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Pending")
+	var scrub, expired int
+	if err := r.db.QueryRow(ctx, query, scrubBefore, deleteBefore).Scan(&scrub, &expired); err != nil {
+		return 0, 0, fmt.Errorf("failed to count the retained shipments, %w", err)
+	}
+	return scrub, expired, nil
+}
+
+// Unscrubbed returns the oldest shipments created before the time that weren't scrubbed
+// yet, except the skipped ones, and the ids of those that can't be decrypted.
+func (r *Storage) Unscrubbed(ctx context.Context, before time.Time, limit int, skip []string) ([]shipment.Record, []string, error) {
+	query := `SELECT ` + recordColumns + ` FROM shipment
+		WHERE scrubbed_at IS NULL AND created_at < $1 AND id::text <> ALL($3::text[]) ORDER BY created_at LIMIT $2`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Unscrubbed")
+	if skip == nil {
+		skip = []string{}
+	}
+	return r.records(ctx, query, before, limit, skip)
+}
+
+// Scrub replaces the payloads of the shipment and marks it as scrubbed,
+// its original request is dropped so it can't be resent anymore.
+func (r *Storage) Scrub(ctx context.Context, id string, request, response json.RawMessage) error {
+	query := `UPDATE shipment SET request = $2, response = $3, original = NULL, key_id = NULLIF($4, ''),
+		scrubbed_at = now(), updated_at = now() WHERE id = $1`
+	r.log.With(slog.String("query", query), slog.String("id", id)).DebugContext(ctx, "Scrub")
+	request, err := r.seal(request, aad("shipment", "request", id))
+	if err != nil {
+		return fmt.Errorf("failed to scrub shipment, %w", err)
+	}
+	response, err = r.seal(response, aad("shipment", "response", id))
+	if err != nil {
+		return fmt.Errorf("failed to scrub shipment, %w", err)
+	}
+	if _, err := r.db.Exec(ctx, query, id, request, response, r.keyID()); err != nil {
+		return fmt.Errorf("failed to scrub shipment, %w", err)
+	}
+	return nil
+}
+
+// Expired returns the oldest shipments created before the time, except the
+// skipped ones, and the ids of those that can't be decrypted.
+func (r *Storage) Expired(ctx context.Context, before time.Time, limit int, skip []string) ([]shipment.Record, []string, error) {
+	query := `SELECT ` + recordColumns + ` FROM shipment
+		WHERE created_at < $1 AND id::text <> ALL($3::text[]) ORDER BY created_at LIMIT $2`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Expired")
+	if skip == nil {
+		skip = []string{}
+	}
+	return r.records(ctx, query, before, limit, skip)
+}
+
+// Delete deletes the shipments and their dead letters.
+func (r *Storage) Delete(ctx context.Context, ids []string) error {
+	query := `WITH letters AS (DELETE FROM dead_letter WHERE shipment_id = ANY($1::uuid[]))
+		DELETE FROM shipment WHERE id = ANY($1::uuid[])`
+	r.log.With(slog.String("query", query), slog.Int("shipments", len(ids))).DebugContext(ctx, "Delete")
+	if _, err := r.db.Exec(ctx, query, ids); err != nil {
+		return fmt.Errorf("failed to delete shipments, %w", err)
+	}
+	return nil
+}
+
+// PendingExchanges counts the exchanges started before scrubBefore that
+// still have their bodies and won't be deleted, and the ones started before deleteBefore.
+func (r *Storage) PendingExchanges(ctx context.Context, scrubBefore, deleteBefore time.Time) (int, int, error) {
+	query := `SELECT
+		count(*) FILTER (WHERE (request IS NOT NULL OR response IS NOT NULL) AND started_at < $1 AND started_at >= $2),
+		count(*) FILTER (WHERE started_at < $2)
+		FROM exchange`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "PendingExchanges")
+	var scrub, expired int
+	if err := r.db.QueryRow(ctx, query, scrubBefore, deleteBefore).Scan(&scrub, &expired); err != nil {
+		return 0, 0, fmt.Errorf("failed to count the retained exchanges, %w", err)
+	}
+	return scrub, expired, nil
+}
+
+// ScrubExchanges drops the bodies of the exchanges started before the time,
+// their digests are kept. It returns how many exchanges were scrubbed.
+func (r *Storage) ScrubExchanges(ctx context.Context, before time.Time) (int, error) {
+	query := `SELECT exchange_scrub($1)`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "ScrubExchanges")
+	var scrubbed int
+	if err := r.db.QueryRow(ctx, query, before).Scan(&scrubbed); err != nil {
+		return 0, fmt.Errorf("failed to scrub exchanges, %w", err)
+	}
+	return scrubbed, nil
+}
+
+// DeleteExchanges deletes the exchanges started before the time
+// and returns how many were deleted.
+func (r *Storage) DeleteExchanges(ctx context.Context, before time.Time) (int, error) {
+	query := `SELECT exchange_expire($1)`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "DeleteExchanges")
+	var deleted int
+	if err := r.db.QueryRow(ctx, query, before).Scan(&deleted); err != nil {
+		return 0, fmt.Errorf("failed to delete exchanges, %w", err)
+	}
+	return deleted, nil
+}
+
+// retentionLock is the advisory lock held while purging, so
+// only one replica purges at a time.
+const retentionLock = 7_140_812_455_018
+
+// Exclusive runs fn if no other replica is running it, it reports
+// false without running fn if one is.
+func (r *Storage) Exclusive(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	conn, err := r.db.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire a connection, %w", err)
+	}
+	defer conn.Release()
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, retentionLock).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to take the retention lock, %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, retentionLock); err != nil {
+			r.log.With(log.Error(err)).ErrorContext(ctx, "Failed to release the retention lock")
+		}
+	}()
+	return true, fn(ctx)
+}
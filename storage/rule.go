@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/hoenirvili/axiogate/rule"
+)
+
+var _ rule.Store = (*Storage)(nil)
+
+const ruleColumns = `id, name, priority, condition, action, providers, enabled, created_at, updated_at`
+
+func scanRule(row pgx.Row) (rule.Rule, error) {
+	var r rule.Rule
+	err := row.Scan(
+		&r.ID, &r.Name, &r.Priority, &r.Condition, &r.Action,
+		&r.Providers, &r.Enabled, &r.CreatedAt, &r.UpdatedAt,
+	)
+	return r, err
+}
+
+func (r *Storage) CreateRule(ctx context.Context, rl *rule.Rule) error {
+	query := `INSERT INTO routing_rule (name, priority, condition, action, providers, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "CreateRule")
+	err := r.db.QueryRow(ctx, query,
+		rl.Name, rl.Priority, rl.Condition, rl.Action, rl.Providers, rl.Enabled,
+	).Scan(&rl.ID, &rl.CreatedAt, &rl.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save rule, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) Rule(ctx context.Context, id int64) (*rule.Rule, error) {
+	query := `SELECT ` + ruleColumns + ` FROM routing_rule WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Rule")
+	rl, err := scanRule(r.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, rule.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rule, %w", err)
+	}
+	return &rl, nil
+}
+
+func (r *Storage) Rules(ctx context.Context) ([]rule.Rule, error) {
+	query := `SELECT ` + ruleColumns + ` FROM routing_rule ORDER BY priority, id`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Rules")
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rules, %w", err)
+	}
+	rules, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (rule.Rule, error) {
+		return scanRule(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan rules, %w", err)
+	}
+	return rules, nil
+}
+
+func (r *Storage) UpdateRule(ctx context.Context, rl *rule.Rule) error {
+	query := `UPDATE routing_rule
+		SET name = $2, priority = $3, condition = $4, action = $5,
+			providers = $6, enabled = $7, updated_at = now()
+		WHERE id = $1 RETURNING created_at, updated_at`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "UpdateRule")
+	err := r.db.QueryRow(ctx, query,
+		rl.ID, rl.Name, rl.Priority, rl.Condition, rl.Action, rl.Providers, rl.Enabled,
+	).Scan(&rl.CreatedAt, &rl.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return rule.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update rule, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) DeleteRule(ctx context.Context, id int64) error {
+	query := `DELETE FROM routing_rule WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "DeleteRule")
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete rule, %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return rule.ErrNotFound
+	}
+	return nil
+}
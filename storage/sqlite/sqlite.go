@@ -0,0 +1,318 @@
+// Package sqlite stores the shipments in a sqlite database file, for
+// the single node deployments that don't run a postgres server.
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/requestid"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+// schema is created when the database is opened, the timestamps
+// are stored as unix nanoseconds so they sort as numbers.
+const schema = table + indexes
+
+const table = `
+CREATE TABLE IF NOT EXISTS shipment (
+    id TEXT PRIMARY KEY,
+    group_id TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    endpoint TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('booked', 'failed', 'voided', 'canceled')),
+    http_status INTEGER,
+    error TEXT,
+    request TEXT,
+    response TEXT,
+    request_id TEXT,
+    tenant_id TEXT,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    original TEXT,
+    resend_of TEXT
+);
+`
+
+const indexes = `
+CREATE INDEX IF NOT EXISTS shipment_group_idx ON shipment (group_id);
+CREATE INDEX IF NOT EXISTS shipment_provider_created_idx ON shipment (provider, created_at DESC);
+CREATE INDEX IF NOT EXISTS shipment_created_idx ON shipment (created_at);
+CREATE INDEX IF NOT EXISTS shipment_tenant_idx ON shipment (tenant_id, created_at DESC, id DESC);
+`
+
+// columns were added to the shipment table after it was first released,
+// they are added to the database files created before them.
+var columns = []struct{ name, definition string }{
+	{name: "original", definition: "TEXT"},
+	{name: "resend_of", definition: "TEXT"},
+}
+
+// upgrade adds the missing columns to the shipment table.
+func upgrade(ctx context.Context, db *sql.DB) error {
+	for _, c := range columns {
+		var n int
+		err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info('shipment') WHERE name = ?`, c.name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `ALTER TABLE shipment ADD COLUMN `+c.name+` `+c.definition); err != nil {
+			return err
+		}
+	}
+	if err := statuses(ctx, db); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS shipment_resend_of_idx ON shipment (resend_of)`)
+	return err
+}
+
+// statuses rebuilds the shipment table of the database files created before
+// the canceled status, a check constraint can't be altered in place.
+func statuses(ctx context.Context, db *sql.DB) error {
+	var ddl string
+	err := db.QueryRowContext(ctx, `SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'shipment'`).Scan(&ddl)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(ddl, "'canceled'") {
+		return nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	const columns = `id, group_id, provider, endpoint, status, http_status, error, request, response,
+		request_id, tenant_id, created_at, updated_at, original, resend_of`
+	for _, query := range []string{
+		strings.Replace(table, "IF NOT EXISTS shipment (", "shipment_rebuilt (", 1),
+		`INSERT INTO shipment_rebuilt (` + columns + `) SELECT ` + columns + ` FROM shipment`,
+		`DROP TABLE shipment`,
+		`ALTER TABLE shipment_rebuilt RENAME TO shipment`,
+		indexes,
+	} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type Storage struct {
+	db  *sql.DB
+	log *slog.Logger
+	now func() time.Time
+}
+
+type Option func(s *Storage)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(s *Storage) {
+		s.log = log
+	}
+}
+
+var _ shipment.Storage = (*Storage)(nil)
+
+// Open opens the database file at path, creating it and its schema if missing.
+func Open(ctx context.Context, path string, options ...Option) (*Storage, error) {
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database, %w", err)
+	}
+	// sqlite has a single writer, one connection avoids the busy errors.
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create the sqlite schema, %w", err)
+	}
+	if err := upgrade(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to upgrade the sqlite schema, %w", err)
+	}
+	s := &Storage{
+		db:  db,
+		log: log.Noop(),
+		now: time.Now,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s, nil
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks the database can be used.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Save stores the attempts of a fan-out in one transaction, grouped under
+// the same id and tagged with the request id and the tenant found in the context.
+func (s *Storage) Save(ctx context.Context, attempts []shipment.Attempt) error {
+	query := `INSERT INTO shipment (id, group_id, provider, endpoint, status, http_status, error, request, response, request_id, tenant_id, created_at, updated_at,
+		original, resend_of)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, ''), ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, NULLIF(?, ''))`
+	group := uuid.NewString()
+	s.log.With(
+		slog.String("query", query),
+		slog.String("group", group),
+		slog.Int("attempts", len(attempts)),
+	).DebugContext(ctx, "Save")
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save shipment, %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := s.now().UnixNano()
+	for _, a := range attempts {
+		_, err := tx.ExecContext(ctx, query, cmp.Or(a.ID, uuid.NewString()), group, a.Provider, a.Endpoint, string(a.Status),
+			a.HTTPStatus, a.Error, text(request.RedactBody(a.Request)), text(a.Response), requestid.From(ctx), tenant.ID(ctx), now, now,
+			text(a.Original), a.ResendOf)
+		if err != nil {
+			return fmt.Errorf("failed to save shipment, %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save shipment, %w", err)
+	}
+	return nil
+}
+
+// text returns the payload as a json text, nil if it's empty.
+func text(payload []byte) any {
+	raw := shipment.RawJSON(payload)
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+const recordColumns = `id, group_id, provider, endpoint, status, COALESCE(http_status, 0),
+	COALESCE(error, ''), request, response, COALESCE(request_id, ''), COALESCE(tenant_id, ''), created_at, updated_at,
+	original, COALESCE(resend_of, '')`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecord(row scanner) (shipment.Record, error) {
+	var (
+		rec                  shipment.Record
+		request, response    sql.NullString
+		original             sql.NullString
+		createdAt, updatedAt int64
+	)
+	err := row.Scan(&rec.ID, &rec.GroupID, &rec.Provider, &rec.Endpoint, &rec.Status, &rec.HTTPStatus,
+		&rec.Error, &request, &response, &rec.RequestID, &rec.Tenant, &createdAt, &updatedAt, &original, &rec.ResendOf)
+	if err != nil {
+		return rec, err
+	}
+	if request.Valid {
+		rec.Request = []byte(request.String)
+	}
+	if response.Valid {
+		rec.Response = []byte(response.String)
+	}
+	if original.Valid {
+		rec.Original = []byte(original.String)
+	}
+	rec.CreatedAt = time.Unix(0, createdAt).UTC()
+	rec.UpdatedAt = time.Unix(0, updatedAt).UTC()
+	return rec, nil
+}
+
+// scope returns the where clause and its arguments limiting the shipments to the filter.
+func scope(f shipment.Filter, args []any) (string, []any) {
+	where := []string{}
+	if !f.AllTenants {
+		if f.Tenant == "" {
+			where = append(where, "tenant_id IS NULL")
+		} else {
+			args = append(args, f.Tenant)
+			where = append(where, "tenant_id = ?")
+		}
+	}
+	if f.Before != "" {
+		args = append(args, f.Before)
+		where = append(where, "(created_at, id) < (SELECT created_at, id FROM shipment WHERE id = ?)")
+	}
+	if f.Provider != "" {
+		args = append(args, f.Provider)
+		where = append(where, "provider = ?")
+	}
+	if f.Status != "" {
+		args = append(args, string(f.Status))
+		where = append(where, "status = ?")
+	}
+	if !f.Since.IsZero() {
+		args = append(args, f.Since.UnixNano())
+		where = append(where, "created_at >= ?")
+	}
+	if f.Unresent {
+		where = append(where, "NOT EXISTS (SELECT 1 FROM shipment resend WHERE resend.resend_of = shipment.id)")
+	}
+	if len(where) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(where, " AND "), args
+}
+
+func (s *Storage) Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error) {
+	where, args := scope(f, nil)
+	args = append(args, f.Limit)
+	query := `SELECT ` + recordColumns + ` FROM shipment WHERE TRUE` + where + ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	s.log.With(slog.String("query", query)).DebugContext(ctx, "Records")
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch shipments, %w", err)
+	}
+	defer rows.Close()
+	records := []shipment.Record{}
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan shipments, %w", err)
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan shipments, %w", err)
+	}
+	return records, nil
+}
+
+func (s *Storage) Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error) {
+	where, args := scope(shipment.Filter{Tenant: f.Tenant, AllTenants: f.AllTenants}, []any{id})
+	query := `SELECT ` + recordColumns + ` FROM shipment WHERE id = ?` + where
+	s.log.With(slog.String("query", query)).DebugContext(ctx, "Record")
+	rec, err := scanRecord(s.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, shipment.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch shipment, %w", err)
+	}
+	return &rec, nil
+}
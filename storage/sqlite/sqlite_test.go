@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Store {
+		st, err := Open(context.Background(), filepath.Join(t.TempDir(), "axiogate.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = st.Close() })
+		return st
+	})
+}
+
+func TestOpen_Upgrade(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "axiogate.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `CREATE TABLE shipment (
+		id TEXT PRIMARY KEY, group_id TEXT NOT NULL, provider TEXT NOT NULL, endpoint TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('booked', 'failed', 'voided')), http_status INTEGER,
+		error TEXT, request TEXT, response TEXT,
+		request_id TEXT, tenant_id TEXT, created_at INTEGER NOT NULL, updated_at INTEGER NOT NULL)`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO shipment (id, group_id, provider, endpoint, status, created_at, updated_at)
+		VALUES ('0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10', 'g', 'b', 'https://b', 'booked', 1, 1)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	st, err := Open(ctx, path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = st.Close() })
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{{
+		Provider: "a",
+		Endpoint: "https://a",
+		Status:   shipment.StatusFailed,
+		Original: []byte(`{"weight":{"value":1}}`),
+	}}))
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{{
+		Provider: "b",
+		Endpoint: "https://b",
+		Status:   shipment.StatusCanceled,
+	}}), "the status check is rebuilt with the canceled status")
+	records, err := st.Records(ctx, shipment.Filter{Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, shipment.StatusCanceled, records[0].Status)
+	assert.JSONEq(t, `{"weight":{"value":1}}`, string(records[1].Original))
+	assert.Equal(t, "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10", records[2].ID, "the stored shipments are kept")
+}
@@ -1,18 +1,39 @@
 package storage
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/hoenirvili/axiogate/http/request"
 	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/requestid"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/tenant"
 )
 
+// Cipher encrypts the shipment payloads at rest.
+type Cipher interface {
+	// KeyID is the key the new payloads are encrypted with.
+	KeyID() string
+	// Seal and Open authenticate the additional data, where the payload is
+	// stored, so a payload copied to another row or column doesn't open.
+	Seal(plaintext, additional []byte) ([]byte, error)
+	Open(sealed, additional []byte) ([]byte, error)
+}
+
 type Storage struct {
-	db  *pgxpool.Pool
-	log *slog.Logger
+	db     *pgxpool.Pool
+	log    *slog.Logger
+	cipher Cipher
 }
 
 type Option func(p *Storage)
@@ -23,6 +44,19 @@ func WithLogger(log *slog.Logger) Option {
 	}
 }
 
+// WithCipher encrypts the request and the response of the shipments saved
+// from now on, the ones saved before are encrypted by Reencrypt.
+func WithCipher(c Cipher) Option {
+	return func(s *Storage) {
+		s.cipher = c
+	}
+}
+
+// Cipher returns the cipher the payloads are encrypted with, nil if they aren't.
+func (r *Storage) Cipher() Cipher {
+	return r.cipher
+}
+
 func New(db *pgxpool.Pool, options ...Option) *Storage {
 	s := &Storage{
 		db:  db,
@@ -34,15 +68,111 @@ func New(db *pgxpool.Pool, options ...Option) *Storage {
 	return s
 }
 
-func (r *Storage) Save(ctx context.Context, provider string, payload []byte) error {
-	query := `INSERT INTO shipment (provider, payload) VALUES ($1, $2)`
+const insertShipment = `INSERT INTO shipment (group_id, provider, endpoint, status, http_status, error, request, response, request_id, tenant_id, created_at, updated_at, key_id, id,
+	original, resend_of)
+	VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, ''), $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, $11, NULLIF($12, ''), $13,
+	$14, NULLIF($15, '')::uuid)`
+
+// keyID returns the key the payloads are encrypted with, empty if they aren't.
+func (r *Storage) keyID() string {
+	if r.cipher == nil {
+		return ""
+	}
+	return r.cipher.KeyID()
+}
+
+// aad returns the additional data of the payload stored in the column of the row.
+func aad(table, column, id string) []byte {
+	return []byte(table + "." + column + "/" + id)
+}
+
+// seal returns the payload as stored, encrypted if there's a cipher, bound
+// to where it's stored.
+func (r *Storage) seal(payload json.RawMessage, additional []byte) (json.RawMessage, error) {
+	if r.cipher == nil || len(payload) == 0 {
+		return payload, nil
+	}
+	sealed, err := r.cipher.Seal(payload, additional)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt the payload, %w", err)
+	}
+	return sealed, nil
+}
+
+// open returns the stored payload decrypted, if it was encrypted with the key.
+func (r *Storage) open(payload json.RawMessage, keyID string, additional []byte) (json.RawMessage, error) {
+	if keyID == "" || len(payload) == 0 {
+		return payload, nil
+	}
+	if r.cipher == nil {
+		return nil, fmt.Errorf("the payload is encrypted with key %q and no keys are configured", keyID)
+	}
+	plaintext, err := r.cipher.Open(payload, additional)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the payload, %w", err)
+	}
+	return plaintext, nil
+}
+
+// rows returns the insert arguments of the attempts of a fan-out, grouped
+// under a new id and tagged with the request id and the tenant found in the context.
+func (r *Storage) rows(ctx context.Context, attempts []shipment.Attempt, now time.Time) ([][]any, error) {
+	group := uuid.NewString()
+	out := make([][]any, 0, len(attempts))
+	for _, a := range attempts {
+		id := cmp.Or(a.ID, uuid.NewString())
+		// The payloads carry the credentials of the provider accounts.
+		sent, err := r.seal(shipment.RawJSON(request.RedactBody(a.Request)), aad("shipment", "request", id))
+		if err != nil {
+			return nil, err
+		}
+		response, err := r.seal(shipment.RawJSON(a.Response), aad("shipment", "response", id))
+		if err != nil {
+			return nil, err
+		}
+		original, err := r.seal(shipment.RawJSON(a.Original), aad("shipment", "original", id))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, []any{group, a.Provider, a.Endpoint, string(a.Status), a.HTTPStatus, a.Error,
+			sent, response, requestid.From(ctx), tenant.ID(ctx), now, r.keyID(), id,
+			original, a.ResendOf})
+	}
+	return out, nil
+}
+
+// insert writes the rows in one transaction, sent in a single round trip.
+// The dead letters of the shipments resent are dropped with it, the resend
+// is dead-lettered on its own if it fails and requeueing them would book twice.
+func (r *Storage) insert(ctx context.Context, rows [][]any) error {
+	batch := &pgx.Batch{}
+	resent := []string{}
+	for _, row := range rows {
+		batch.Queue(insertShipment, row...)
+		if id := row[14].(string); id != "" && !slices.Contains(resent, id) {
+			resent = append(resent, id)
+		}
+	}
+	if len(resent) > 0 {
+		batch.Queue(`DELETE FROM dead_letter WHERE shipment_id = ANY($1::uuid[])`, resent)
+	}
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// Save stores the attempts of a fan-out in one transaction, grouped under
+// the same id and tagged with the request id and the tenant found in the context.
+func (r *Storage) Save(ctx context.Context, attempts []shipment.Attempt) error {
 	r.log.With(
-		slog.String("query", query),
-		slog.Group("record",
-			slog.String("provider", provider),
-			slog.String("payload", string(payload)),
-		)).Debug("Save")
-	if _, err := r.db.Exec(ctx, query, provider, payload); err != nil {
+		slog.String("query", insertShipment),
+		slog.Int("attempts", len(attempts)),
+	).DebugContext(ctx, "Save")
+	rows, err := r.rows(ctx, attempts, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save shipment, %w", err)
+	}
+	if err := r.insert(ctx, rows); err != nil {
 		return fmt.Errorf("failed to save shipment, %w", err)
 	}
 	return nil
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/deadletter"
+	"github.com/hoenirvili/axiogate/envelope"
+	"github.com/hoenirvili/axiogate/http/request"
+	"github.com/hoenirvili/axiogate/migrate"
+	"github.com/hoenirvili/axiogate/migrations"
+	"github.com/hoenirvili/axiogate/shipment"
+	"github.com/hoenirvili/axiogate/storage/pgtest"
+	"github.com/hoenirvili/axiogate/storage/storagetest"
+	"github.com/hoenirvili/axiogate/webhook"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(pgtest.Run(m))
+}
+
+// testDB returns a pool on a fresh schema with all the migrations applied.
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	db := pgtest.DB(t)
+	m, err := migrate.New(db, migrations.FS)
+	require.NoError(t, err)
+	require.NoError(t, m.Up(context.Background()))
+	return db
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Store {
+		return New(testDB(t))
+	})
+}
+
+// batched saves through a writer and reads from the storage.
+type batched struct {
+	*Writer
+	storage *Storage
+}
+
+func (b batched) Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error) {
+	return b.storage.Records(ctx, f)
+}
+
+func (b batched) Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error) {
+	return b.storage.Record(ctx, f, id)
+}
+
+func TestWriter(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Store {
+		st := New(testDB(t))
+		w := st.NewWriter()
+		t.Cleanup(func() { _ = w.Close() })
+		return batched{Writer: w, storage: st}
+	})
+}
+
+// keyring returns a keyring of the ids, the first one encrypting.
+func keyring(t *testing.T, ids ...string) *envelope.Keyring {
+	t.Helper()
+	entries := []string{}
+	for _, id := range ids {
+		entries = append(entries, id+":"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte(id[:1]), envelope.KeySize)))
+	}
+	k, err := envelope.Parse(entries, "")
+	require.NoError(t, err)
+	return k
+}
+
+func TestStorage_Encrypted(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Store {
+		return New(testDB(t), WithCipher(keyring(t, "k1")))
+	})
+}
+
+func TestRows_Encrypted(t *testing.T) {
+	st := New(nil, WithCipher(keyring(t, "k1")))
+	rows, err := st.rows(context.Background(), []shipment.Attempt{{
+		Provider: "a",
+		Status:   shipment.StatusBooked,
+		Request:  []byte(`{"name":"Jane"}`),
+	}}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+
+	request := rows[0][6].(json.RawMessage)
+	assert.NotContains(t, string(request), "Jane")
+	id := rows[0][12].(string)
+	plaintext, err := st.open(request, "k1", aad("shipment", "request", id))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Jane"}`, string(plaintext))
+	_, err = st.open(request, "k1", aad("shipment", "original", id))
+	assert.Error(t, err, "the payload is bound to its column")
+	_, err = st.open(request, "k1", aad("shipment", "request", uuid.NewString()))
+	assert.Error(t, err, "the payload is bound to its row")
+	assert.Nil(t, rows[0][7], "a missing payload stays null")
+	assert.Equal(t, "k1", rows[0][11])
+}
+
+func TestReencrypt(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	plain := New(db)
+	require.NoError(t, plain.Save(ctx, []shipment.Attempt{{
+		Provider: "a",
+		Status:   shipment.StatusBooked,
+		Request:  []byte(`{"name":"Jane"}`),
+		Response: []byte(`{"awb":"1"}`),
+	}}))
+	stored := func() (string, string) {
+		var request, keyID string
+		require.NoError(t, db.QueryRow(ctx, `SELECT request::text, COALESCE(key_id, '') FROM shipment`).Scan(&request, &keyID))
+		return request, keyID
+	}
+
+	for _, st := range []*Storage{New(db, WithCipher(keyring(t, "k1"))), New(db, WithCipher(keyring(t, "k2", "k1")))} {
+		n, err := st.Reencrypt(ctx, 10, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		n, err = st.Reencrypt(ctx, 10, nil)
+		require.NoError(t, err)
+		assert.Zero(t, n, "the shipments are encrypted with the current key")
+
+		request, keyID := stored()
+		assert.NotContains(t, request, "Jane")
+		assert.Equal(t, st.cipher.KeyID(), keyID)
+		records, err := st.Records(ctx, shipment.Filter{AllTenants: true, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.JSONEq(t, `{"name":"Jane"}`, string(records[0].Request))
+		assert.JSONEq(t, `{"awb":"1"}`, string(records[0].Response))
+	}
+
+	records, err := plain.Records(ctx, shipment.Filter{AllTenants: true, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, records, "the encrypted shipments can't be read without the keys")
+
+	require.NoError(t, plain.Save(ctx, []shipment.Attempt{{
+		Provider: "a",
+		Status:   shipment.StatusBooked,
+		Request:  []byte(`{"name":"John"}`),
+	}}))
+	lost := New(db, WithCipher(keyring(t, "k3")))
+	unreadable := Unreadable{}
+	n, err := lost.Reencrypt(ctx, 10, unreadable)
+	require.NoError(t, err, "a row that can't be decrypted doesn't stop the rotation")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, unreadable.Len(), "the shipment encrypted with the removed key is skipped")
+	n, err = lost.Reencrypt(ctx, 10, unreadable)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, 1, unreadable.Len(), "the unreadable rows aren't read again")
+
+	expired, skipped, err := lost.Expired(ctx, time.Now().Add(time.Hour), 10, nil)
+	require.NoError(t, err, "a row that can't be decrypted doesn't fail the batch")
+	require.Len(t, expired, 1)
+	assert.JSONEq(t, `{"name":"John"}`, string(expired[0].Request))
+	assert.Equal(t, unreadable["shipment"], skipped)
+	expired, skipped, err = lost.Expired(ctx, time.Now().Add(time.Hour), 10, skipped)
+	require.NoError(t, err)
+	assert.Len(t, expired, 1)
+	assert.Empty(t, skipped, "the skipped rows aren't read again")
+}
+
+func TestAudit(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	st := New(db, WithCipher(keyring(t, "k1")))
+	started := time.Now().UTC().Truncate(time.Microsecond)
+	for attempt := 1; attempt <= 2; attempt++ {
+		require.NoError(t, st.Audit(ctx, request.Exchange{
+			Shipment:       "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10",
+			Endpoint:       "http://b/v1/b",
+			Attempt:        attempt,
+			RequestHeaders: http.Header{"Content-Type": {"application/json"}},
+			Request:        []byte(`{"name": "Jane"}`),
+			Status:         503,
+			Response:       []byte("busy"),
+			Error:          "unexpected status code 503",
+			StartedAt:      started.Add(time.Duration(attempt) * time.Millisecond),
+			FinishedAt:     started.Add(time.Duration(attempt+1) * time.Millisecond),
+		}))
+	}
+
+	exchanges, err := st.Exchanges(ctx, "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10")
+	require.NoError(t, err)
+	require.Len(t, exchanges, 2)
+	assert.Equal(t, 2, exchanges[1].Attempt)
+	assert.Equal(t, `{"name": "Jane"}`, string(exchanges[1].Request), "the body is kept byte for byte")
+	assert.Equal(t, "busy", string(exchanges[1].Response))
+
+	assert.Equal(t, request.Digest([]byte(`{"name": "Jane"}`)), exchanges[1].RequestSHA256)
+	assert.Equal(t, request.Digest([]byte("busy")), exchanges[1].ResponseSHA256)
+	assert.NoError(t, exchanges[1].Verify())
+
+	_, err = db.Exec(ctx, `DELETE FROM exchange`)
+	assert.Error(t, err, "the audit log is append-only")
+	_, err = db.Exec(ctx, `UPDATE exchange SET status = 200`)
+	assert.Error(t, err, "the audit log is append-only")
+	_, err = db.Exec(ctx, `UPDATE exchange SET key_id = 'forged', request = 'forged'`)
+	assert.Error(t, err, "the bodies are only changed by exchange_reseal")
+	_, err = db.Exec(ctx, `UPDATE exchange SET request_sha256 = sha256('forged')`)
+	assert.Error(t, err, "the digests never change")
+	_, err = db.Exec(ctx, `INSERT INTO exchange (endpoint, attempt, request_headers, request, request_sha256, started_at, finished_at)
+		VALUES ('http://b/v1/b', 1, '{}', 'forged', sha256('sent'), now(), now())`)
+	assert.Error(t, err, "the plaintext bodies are checked against their digests")
+	err = pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT set_config('axiogate.exchange', 'retention', true)`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM exchange`)
+		return err
+	})
+	assert.Error(t, err, "only the functions running as axiogate_exchange change the audit log")
+
+	rotated := New(db, WithCipher(keyring(t, "k2", "k1")))
+	n, err := rotated.Reencrypt(ctx, 10, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "the exchanges can be encrypted with another key")
+	exchanges, err = rotated.Exchanges(ctx, "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10")
+	require.NoError(t, err)
+	assert.Equal(t, "busy", string(exchanges[0].Response))
+
+	_, err = db.Exec(ctx, `UPDATE exchange SET request = NULL, response = NULL`)
+	assert.Error(t, err, "the bodies are only dropped by exchange_scrub")
+	scrub, expired, err := rotated.PendingExchanges(ctx, started.Add(3*time.Millisecond), started.Add(time.Millisecond+time.Microsecond))
+	require.NoError(t, err)
+	assert.Equal(t, 1, scrub)
+	assert.Equal(t, 1, expired)
+	n, err = rotated.DeleteExchanges(ctx, started.Add(time.Millisecond+time.Microsecond))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = rotated.ScrubExchanges(ctx, started.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	exchanges, err = rotated.Exchanges(ctx, "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10")
+	require.NoError(t, err)
+	require.Len(t, exchanges, 1)
+	assert.Nil(t, exchanges[0].Request)
+	assert.Nil(t, exchanges[0].Response)
+	assert.Equal(t, request.Digest([]byte("busy")), exchanges[0].ResponseSHA256, "the digests outlive the bodies")
+	assert.NoError(t, exchanges[0].Verify())
+}
+
+func TestDeadLetters(t *testing.T) {
+	db := testDB(t)
+	ctx := auth.With(context.Background(), &auth.Principal{Subject: "test", Tenant: "acme"})
+	st := New(db)
+	failed := []shipment.Attempt{
+		{ID: "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10", Provider: "a", Status: shipment.StatusFailed, Error: "rejected", HTTPStatus: 400},
+		{ID: "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c11", Provider: "b", Status: shipment.StatusFailed, Error: "connection refused"},
+	}
+	require.NoError(t, st.CreateDeadLetters(ctx, failed))
+	require.NoError(t, st.CreateDeadLetters(ctx, failed[:1]), "a shipment is dead-lettered once")
+
+	n, err := st.CountDeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	letters, err := st.DeadLetters(ctx, deadletter.Filter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "b", letters[0].Provider, "newest first")
+	assert.Equal(t, "acme", letters[1].Tenant)
+	assert.Equal(t, 400, letters[1].HTTPStatus)
+
+	only, err := st.DeadLetters(ctx, deadletter.Filter{Provider: "a", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, only, 1)
+	older, err := st.DeadLetters(ctx, deadletter.Filter{Before: letters[0].ID, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, only, older)
+
+	require.NoError(t, st.DeleteDeadLetter(ctx, only[0].ID))
+	assert.ErrorIs(t, st.DeleteDeadLetter(ctx, only[0].ID), deadletter.ErrNotFound)
+	_, err = st.DeadLetter(ctx, only[0].ID)
+	assert.ErrorIs(t, err, deadletter.ErrNotFound)
+
+	require.NoError(t, st.RestoreDeadLetter(ctx, &only[0]))
+	restored, err := st.DeadLetter(ctx, only[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, only[0], *restored)
+
+	require.NoError(t, st.Save(ctx, failed[:1]))
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{{Provider: "a", Status: shipment.StatusBooked, ResendOf: failed[0].ID}}))
+	_, err = st.DeadLetter(ctx, only[0].ID)
+	assert.ErrorIs(t, err, deadletter.ErrNotFound, "the letters of a resent shipment are dropped")
+	n, err = st.CountDeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestQuota(t *testing.T) {
+	st := New(testDB(t))
+	ctx := context.Background()
+	day := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	used, err := st.QuotaUsed(ctx, "key:1", day)
+	require.NoError(t, err)
+	assert.Zero(t, used)
+
+	require.NoError(t, st.UseQuota(ctx, "key:1", day, 2))
+	require.NoError(t, st.UseQuota(ctx, "key:1", day, 3))
+	require.NoError(t, st.UseQuota(ctx, "key:1", day.AddDate(0, 0, 1), 1))
+	used, err = st.QuotaUsed(ctx, "key:1", day)
+	require.NoError(t, err)
+	assert.Equal(t, 5, used, "the booked shipments add up per day")
+}
+
+func TestPendingDeliveries(t *testing.T) {
+	st := New(testDB(t))
+	ctx := context.Background()
+
+	sub := &webhook.Subscription{URL: "http://hooks", Secret: "secret", Events: webhook.Events}
+	require.NoError(t, st.CreateSubscription(ctx, sub))
+	for _, status := range []string{webhook.StatusPending, webhook.StatusDelivered, webhook.StatusPending} {
+		require.NoError(t, st.CreateDelivery(ctx, &webhook.Delivery{
+			SubscriptionID: sub.ID,
+			Event:          webhook.EventShipmentSucceeded,
+			Payload:        json.RawMessage(`{}`),
+			Status:         status,
+		}))
+	}
+
+	pending, err := st.PendingDeliveries(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	for _, d := range pending {
+		assert.Equal(t, webhook.StatusPending, d.Status)
+	}
+
+	_, err = st.ClaimDelivery(ctx, pending[0].ID)
+	assert.ErrorIs(t, err, webhook.ErrPending)
+	claimed, err := st.ClaimDelivery(ctx, pending[0].ID+1)
+	require.NoError(t, err)
+	assert.Equal(t, webhook.StatusPending, claimed.Status)
+	_, err = st.ClaimDelivery(ctx, pending[0].ID+1)
+	assert.ErrorIs(t, err, webhook.ErrPending, "a delivery is claimed once")
+	_, err = st.ClaimDelivery(ctx, 1000)
+	assert.ErrorIs(t, err, webhook.ErrNotFound)
+}
@@ -0,0 +1,277 @@
+// Package storagetest is the conformance suite every shipment storage backend must pass.
+package storagetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/requestid"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// Store is a shipment storage backend, it stores the shipments and reads them back.
+type Store interface {
+	shipment.Storage
+	Records(ctx context.Context, f shipment.Filter) ([]shipment.Record, error)
+	Record(ctx context.Context, f shipment.Filter, id string) (*shipment.Record, error)
+}
+
+// Run runs the suite against the backend, newStore returns an empty store on every call.
+func Run(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("Save", func(t *testing.T) { testSave(t, newStore(t)) })
+	t.Run("SaveRedacted", func(t *testing.T) { testSaveRedacted(t, newStore(t)) })
+	t.Run("SaveAtomic", func(t *testing.T) { testSaveAtomic(t, newStore(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, newStore(t)) })
+	t.Run("Paging", func(t *testing.T) { testPaging(t, newStore(t)) })
+	t.Run("Resend", func(t *testing.T) { testResend(t, newStore(t)) })
+}
+
+func as(tenant string) context.Context {
+	return auth.With(context.Background(), &auth.Principal{Subject: "test", Tenant: tenant})
+}
+
+func booked(provider, response string) shipment.Attempt {
+	return shipment.Attempt{
+		Provider: provider,
+		Endpoint: "https://" + provider,
+		Status:   shipment.StatusBooked,
+		Request:  []byte(`{}`),
+		Response: []byte(response),
+	}
+}
+
+func testSave(t *testing.T, st Store) {
+	ctx := requestid.With(context.Background(), "req-1")
+	err := st.Save(ctx, []shipment.Attempt{{
+		Provider: "a",
+		Endpoint: "https://a",
+		Status:   shipment.StatusBooked,
+		Request:  []byte(`{"weight":1}`),
+		Response: []byte(`{"awb":"1"}`),
+	}, {
+		Provider:   "b",
+		Endpoint:   "https://b",
+		Status:     shipment.StatusFailed,
+		HTTPStatus: 502,
+		Error:      "unexpected status code 502",
+		Request:    []byte(`{"weight":1}`),
+		Response:   []byte(`<html>bad gateway</html>`),
+	}, {
+		Provider: "c",
+		Endpoint: "https://c",
+		Status:   shipment.StatusFailed,
+		Error:    "connection refused",
+		Request:  []byte(`{"weight":1}`),
+	}})
+	require.NoError(t, err)
+
+	records, err := st.Records(ctx, shipment.Filter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	byProvider := map[string]shipment.Record{}
+	for _, rec := range records {
+		assert.NotEmpty(t, rec.ID)
+		assert.Equal(t, records[0].GroupID, rec.GroupID)
+		assert.Equal(t, "req-1", rec.RequestID)
+		assert.Empty(t, rec.Tenant)
+		assert.False(t, rec.CreatedAt.IsZero())
+		assert.False(t, rec.UpdatedAt.IsZero())
+		byProvider[rec.Provider] = rec
+	}
+
+	a := byProvider["a"]
+	assert.Equal(t, "https://a", a.Endpoint)
+	assert.Equal(t, shipment.StatusBooked, a.Status)
+	assert.Zero(t, a.HTTPStatus)
+	assert.Empty(t, a.Error)
+	assert.JSONEq(t, `{"weight":1}`, string(a.Request))
+	assert.JSONEq(t, `{"awb":"1"}`, string(a.Response))
+
+	b := byProvider["b"]
+	assert.Equal(t, shipment.StatusFailed, b.Status)
+	assert.Equal(t, 502, b.HTTPStatus)
+	assert.Equal(t, "unexpected status code 502", b.Error)
+	assert.JSONEq(t, `"<html>bad gateway</html>"`, string(b.Response))
+
+	assert.Nil(t, byProvider["c"].Response)
+
+	rec, err := st.Record(ctx, shipment.Filter{}, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, a, *rec)
+
+	_, err = st.Record(ctx, shipment.Filter{}, "0199f7a4-3c2e-7c1a-9d1e-5b7f3e2a6c10")
+	assert.ErrorIs(t, err, shipment.ErrNotFound)
+
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{booked("a", `{"awb":"2"}`)}))
+	records, err = st.Records(ctx, shipment.Filter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.JSONEq(t, `{"awb":"2"}`, string(records[0].Response), "newest first")
+	assert.NotEqual(t, records[0].GroupID, records[1].GroupID, "every fan-out has its own group")
+}
+
+func testSaveRedacted(t *testing.T, st Store) {
+	ctx := context.Background()
+	a := booked("b", `{"AirwayBillNumber":"1"}`)
+	a.Request = []byte(`{"UserName":"acme","Password":"s3cret","AccountNo":"4004","Origin":"DXB","Weight":1.5}`)
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{a}))
+
+	records, err := st.Records(ctx, shipment.Filter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.JSONEq(t, `{"UserName":"REDACTED","Password":"REDACTED","AccountNo":"REDACTED","Origin":"DXB","Weight":1.5}`,
+		string(records[0].Request), "the provider credentials are never stored")
+}
+
+func testSaveAtomic(t *testing.T, st Store) {
+	ctx := context.Background()
+	err := st.Save(ctx, []shipment.Attempt{
+		booked("a", `{"awb":"1"}`),
+		{Provider: "b", Endpoint: "https://b", Status: "unknown"},
+	})
+	assert.Error(t, err)
+	records, err := st.Records(ctx, shipment.Filter{AllTenants: true, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, records, "a fan-out is stored entirely or not at all")
+}
+
+func testTenantIsolation(t *testing.T, st Store) {
+	ctx := context.Background()
+	require.NoError(t, st.Save(as("acme"), []shipment.Attempt{booked("a", `{"awb":"acme-1"}`)}))
+	require.NoError(t, st.Save(as("acme"), []shipment.Attempt{booked("b", `{"awb":"acme-2"}`)}))
+	require.NoError(t, st.Save(as("globex"), []shipment.Attempt{booked("a", `{"awb":"globex-1"}`)}))
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{booked("a", `{"awb":"none-1"}`)}))
+
+	tenants := func(records []shipment.Record) []string {
+		out := []string{}
+		for _, rec := range records {
+			out = append(out, rec.Tenant)
+		}
+		return out
+	}
+
+	t.Run("a tenant lists only its shipments", func(t *testing.T) {
+		acme, err := st.Records(ctx, shipment.Filter{Tenant: "acme", Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"acme", "acme"}, tenants(acme))
+
+		globex, err := st.Records(ctx, shipment.Filter{Tenant: "globex", Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"globex"}, tenants(globex))
+	})
+
+	t.Run("callers without a tenant see only untagged shipments", func(t *testing.T) {
+		none, err := st.Records(ctx, shipment.Filter{Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{""}, tenants(none))
+	})
+
+	t.Run("admins read all tenants", func(t *testing.T) {
+		all, err := st.Records(ctx, shipment.Filter{AllTenants: true, Limit: 10})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"acme", "acme", "globex", ""}, tenants(all))
+	})
+
+	t.Run("a tenant can't read a shipment of another tenant", func(t *testing.T) {
+		globex, err := st.Records(ctx, shipment.Filter{Tenant: "globex", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, globex, 1)
+
+		_, err = st.Record(ctx, shipment.Filter{Tenant: "acme"}, globex[0].ID)
+		assert.ErrorIs(t, err, shipment.ErrNotFound)
+		_, err = st.Record(ctx, shipment.Filter{}, globex[0].ID)
+		assert.ErrorIs(t, err, shipment.ErrNotFound)
+
+		rec, err := st.Record(ctx, shipment.Filter{Tenant: "globex"}, globex[0].ID)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"awb":"globex-1"}`, string(rec.Response))
+
+		rec, err = st.Record(ctx, shipment.Filter{AllTenants: true}, globex[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, "globex", rec.Tenant)
+	})
+}
+
+func testPaging(t *testing.T, st Store) {
+	ctx := context.Background()
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{booked("a", `{"awb":"1"}`), booked("b", `{"awb":"2"}`)}))
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{booked("a", `{"awb":"3"}`), booked("b", `{"awb":"4"}`)}))
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{booked("a", `{"awb":"5"}`)}))
+
+	seen := map[string]bool{}
+	var (
+		before string
+		pages  int
+	)
+	for {
+		page, err := st.Records(ctx, shipment.Filter{Limit: 2, Before: before})
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		pages++
+		for _, rec := range page {
+			assert.False(t, seen[rec.ID], "shipment %s returned twice", rec.ID)
+			seen[rec.ID] = true
+		}
+		before = page[len(page)-1].ID
+	}
+	assert.Len(t, seen, 5)
+	assert.Equal(t, 3, pages)
+
+	newest, err := st.Records(ctx, shipment.Filter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, newest, 1)
+	assert.JSONEq(t, `{"awb":"5"}`, string(newest[0].Response))
+}
+
+func testResend(t *testing.T, st Store) {
+	ctx := context.Background()
+	failed := shipment.Attempt{
+		Provider: "a",
+		Endpoint: "https://a",
+		Status:   shipment.StatusFailed,
+		Error:    "connection refused",
+		Request:  []byte(`{}`),
+		Original: []byte(`{"weight":{"value":1,"unit":"KG"}}`),
+	}
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{failed, booked("b", `{"awb":"1"}`)}))
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{failed}))
+
+	all := shipment.Filter{Provider: "a", Status: shipment.StatusFailed, Since: time.Now().Add(-time.Hour), Limit: 10}
+	records, err := st.Records(ctx, all)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	for _, rec := range records {
+		assert.Equal(t, "a", rec.Provider)
+		assert.JSONEq(t, `{"weight":{"value":1,"unit":"KG"}}`, string(rec.Original))
+		assert.Empty(t, rec.ResendOf)
+	}
+
+	resent := booked("a", `{"awb":"2"}`)
+	resent.ResendOf = records[1].ID
+	require.NoError(t, st.Save(ctx, []shipment.Attempt{resent}))
+
+	newest, err := st.Records(ctx, shipment.Filter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, newest, 1)
+	assert.Equal(t, records[1].ID, newest[0].ResendOf)
+
+	unresent := all
+	unresent.Unresent = true
+	pending, err := st.Records(ctx, unresent)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, records[0].ID, pending[0].ID)
+
+	later := all
+	later.Since = time.Now().Add(time.Hour)
+	none, err := st.Records(ctx, later)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/hoenirvili/axiogate/tenant"
+)
+
+var _ tenant.Store = (*Storage)(nil)
+
+// defaults avoids storing nulls in the not null columns.
+func defaults(t *tenant.Tenant) {
+	if t.Providers == nil {
+		t.Providers = []string{}
+	}
+	if t.Credentials == nil {
+		t.Credentials = map[string]json.RawMessage{}
+	}
+}
+
+const tenantColumns = `id, name, providers, credentials, rate_limit, created_at, updated_at`
+
+func scanTenant(row pgx.Row) (tenant.Tenant, error) {
+	var t tenant.Tenant
+	err := row.Scan(
+		&t.ID, &t.Name, &t.Providers, &t.Credentials,
+		&t.RateLimit, &t.CreatedAt, &t.UpdatedAt,
+	)
+	return t, err
+}
+
+func (r *Storage) CreateTenant(ctx context.Context, t *tenant.Tenant) error {
+	query := `INSERT INTO tenant (id, name, providers, credentials, rate_limit)
+		VALUES ($1, $2, $3, $4, $5) RETURNING created_at, updated_at`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "CreateTenant")
+	defaults(t)
+	err := r.db.QueryRow(ctx, query,
+		t.ID, t.Name, t.Providers, t.Credentials, t.RateLimit,
+	).Scan(&t.CreatedAt, &t.UpdatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return &tenant.ErrInvalidTenant{Reason: fmt.Sprintf("tenant %s already exists", t.ID)}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save tenant, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) Tenant(ctx context.Context, id string) (*tenant.Tenant, error) {
+	query := `SELECT ` + tenantColumns + ` FROM tenant WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Tenant")
+	t, err := scanTenant(r.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, tenant.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tenant, %w", err)
+	}
+	return &t, nil
+}
+
+func (r *Storage) Tenants(ctx context.Context) ([]tenant.Tenant, error) {
+	query := `SELECT ` + tenantColumns + ` FROM tenant ORDER BY id`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Tenants")
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tenants, %w", err)
+	}
+	tenants, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (tenant.Tenant, error) {
+		return scanTenant(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan tenants, %w", err)
+	}
+	return tenants, nil
+}
+
+func (r *Storage) UpdateTenant(ctx context.Context, t *tenant.Tenant) error {
+	query := `UPDATE tenant
+		SET name = $2, providers = $3, credentials = $4, rate_limit = $5, updated_at = now()
+		WHERE id = $1 RETURNING created_at, updated_at`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "UpdateTenant")
+	defaults(t)
+	err := r.db.QueryRow(ctx, query,
+		t.ID, t.Name, t.Providers, t.Credentials, t.RateLimit,
+	).Scan(&t.CreatedAt, &t.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return tenant.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update tenant, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) DeleteTenant(ctx context.Context, id string) error {
+	query := `DELETE FROM tenant WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "DeleteTenant")
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant, %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return tenant.ErrNotFound
+	}
+	return nil
+}
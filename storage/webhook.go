@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/hoenirvili/axiogate/webhook"
+)
+
+var _ webhook.Store = (*Storage)(nil)
+
+func (r *Storage) CreateSubscription(ctx context.Context, sub *webhook.Subscription) error {
+	query := `INSERT INTO webhook_subscription (url, secret, events)
+		VALUES ($1, $2, $3) RETURNING id, created_at`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "CreateSubscription")
+	err := r.db.QueryRow(ctx, query, sub.URL, sub.Secret, sub.Events).
+		Scan(&sub.ID, &sub.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save subscription, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) Subscription(ctx context.Context, id int64) (*webhook.Subscription, error) {
+	query := `SELECT id, url, secret, events, created_at
+		FROM webhook_subscription WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Subscription")
+	sub := &webhook.Subscription{}
+	err := r.db.QueryRow(ctx, query, id).
+		Scan(&sub.ID, &sub.URL, &sub.Secret, &sub.Events, &sub.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, webhook.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subscription, %w", err)
+	}
+	return sub, nil
+}
+
+func (r *Storage) Subscriptions(ctx context.Context) ([]webhook.Subscription, error) {
+	query := `SELECT id, url, secret, events, created_at
+		FROM webhook_subscription ORDER BY id`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Subscriptions")
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subscriptions, %w", err)
+	}
+	subs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (webhook.Subscription, error) {
+		var sub webhook.Subscription
+		err := row.Scan(&sub.ID, &sub.URL, &sub.Secret, &sub.Events, &sub.CreatedAt)
+		return sub, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan subscriptions, %w", err)
+	}
+	return subs, nil
+}
+
+func (r *Storage) DeleteSubscription(ctx context.Context, id int64) error {
+	query := `DELETE FROM webhook_subscription WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "DeleteSubscription")
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete subscription, %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return webhook.ErrNotFound
+	}
+	return nil
+}
+
+func (r *Storage) CreateDelivery(ctx context.Context, d *webhook.Delivery) error {
+	query := `INSERT INTO webhook_delivery (subscription_id, event, payload, status)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "CreateDelivery")
+	err := r.db.QueryRow(ctx, query, d.SubscriptionID, d.Event, d.Payload, d.Status).
+		Scan(&d.ID, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save delivery, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) UpdateDelivery(ctx context.Context, d *webhook.Delivery) error {
+	query := `UPDATE webhook_delivery
+		SET status = $2, attempts = $3, response_status = $4, error = $5, updated_at = now()
+		WHERE id = $1 RETURNING updated_at`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "UpdateDelivery")
+	err := r.db.QueryRow(ctx, query, d.ID, d.Status, d.Attempts, d.ResponseStatus, d.Error).
+		Scan(&d.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return webhook.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update delivery, %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) ClaimDelivery(ctx context.Context, id int64) (*webhook.Delivery, error) {
+	query := `UPDATE webhook_delivery SET status = $2, error = '', updated_at = now()
+		WHERE id = $1 AND status <> $2 RETURNING ` + deliveryColumns
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "ClaimDelivery")
+	d, err := scanDelivery(r.db.QueryRow(ctx, query, id, webhook.StatusPending))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := r.Delivery(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, webhook.ErrPending
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim delivery, %w", err)
+	}
+	return &d, nil
+}
+
+const deliveryColumns = `id, subscription_id, event, payload, status,
+	attempts, response_status, error, created_at, updated_at`
+
+func scanDelivery(row pgx.Row) (webhook.Delivery, error) {
+	var d webhook.Delivery
+	err := row.Scan(
+		&d.ID, &d.SubscriptionID, &d.Event, &d.Payload, &d.Status,
+		&d.Attempts, &d.ResponseStatus, &d.Error, &d.CreatedAt, &d.UpdatedAt,
+	)
+	return d, err
+}
+
+func (r *Storage) Delivery(ctx context.Context, id int64) (*webhook.Delivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_delivery WHERE id = $1`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Delivery")
+	d, err := scanDelivery(r.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, webhook.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch delivery, %w", err)
+	}
+	return &d, nil
+}
+
+func (r *Storage) Deliveries(ctx context.Context, subscriptionID int64) ([]webhook.Delivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_delivery
+		WHERE subscription_id = $1 ORDER BY created_at DESC`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "Deliveries")
+	rows, err := r.db.Query(ctx, query, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch deliveries, %w", err)
+	}
+	deliveries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (webhook.Delivery, error) {
+		return scanDelivery(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan deliveries, %w", err)
+	}
+	return deliveries, nil
+}
+
+func (r *Storage) PendingDeliveries(ctx context.Context) ([]webhook.Delivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_delivery
+		WHERE status = $1 ORDER BY id`
+	r.log.With(slog.String("query", query)).DebugContext(ctx, "PendingDeliveries")
+	rows, err := r.db.Query(ctx, query, webhook.StatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending deliveries, %w", err)
+	}
+	deliveries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (webhook.Delivery, error) {
+		return scanDelivery(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan pending deliveries, %w", err)
+	}
+	return deliveries, nil
+}
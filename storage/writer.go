@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// ErrWriterClosed is returned when saving through a closed writer.
+var ErrWriterClosed = errors.New("shipment writer closed")
+
+// FlushObserver is told about every flush of the writer.
+type FlushObserver interface {
+	// Flushed is called with the number of rows written at
+	// once, how long it took and why it failed, if it did.
+	Flushed(rows int, duration time.Duration, err error)
+}
+
+type noopFlushObserver struct{}
+
+func (noopFlushObserver) Flushed(int, time.Duration, error) {}
+
+// write is a fan-out waiting to be flushed.
+type write struct {
+	rows [][]any
+	done chan error
+}
+
+// Writer batches the fan-outs saved at the same time and writes them in one
+// transaction, once the batch holds size rows or interval passed since the
+// first one was queued. Save returns only after its fan-out was written.
+type Writer struct {
+	rows     func(ctx context.Context, attempts []shipment.Attempt, now time.Time) ([][]any, error)
+	insert   func(ctx context.Context, rows [][]any) error
+	size     int
+	interval time.Duration
+	observer FlushObserver
+	log      *slog.Logger
+	now      func() time.Time
+
+	writes    chan write
+	closed    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ shipment.Storage = (*Writer)(nil)
+
+type WriterOption func(w *Writer)
+
+func WithWriterLogger(log *slog.Logger) WriterOption {
+	return func(w *Writer) {
+		w.log = log.WithGroup("writer")
+	}
+}
+
+// WithBatchSize sets how many rows are written at most in one flush.
+func WithBatchSize(size int) WriterOption {
+	return func(w *Writer) {
+		w.size = size
+	}
+}
+
+// WithFlushInterval sets how long a fan-out waits for others to be written with.
+func WithFlushInterval(interval time.Duration) WriterOption {
+	return func(w *Writer) {
+		w.interval = interval
+	}
+}
+
+// WithFlushObserver sets who is told about the flushes.
+func WithFlushObserver(o FlushObserver) WriterOption {
+	return func(w *Writer) {
+		w.observer = o
+	}
+}
+
+// NewWriter returns a writer batching the shipments into the storage,
+// it must be closed to write the batch still pending.
+func (r *Storage) NewWriter(options ...WriterOption) *Writer {
+	return newWriter(r.rows, r.insert, options...)
+}
+
+func newWriter(
+	rows func(ctx context.Context, attempts []shipment.Attempt, now time.Time) ([][]any, error),
+	insert func(ctx context.Context, rows [][]any) error,
+	options ...WriterOption,
+) *Writer {
+	w := &Writer{
+		rows:     rows,
+		insert:   insert,
+		size:     500,
+		interval: 5 * time.Millisecond,
+		observer: noopFlushObserver{},
+		log:      log.Noop(),
+		now:      time.Now,
+		writes:   make(chan write),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(w)
+	}
+	go w.run()
+	return w
+}
+
+// Save queues the attempts of a fan-out and waits until they are written,
+// the fan-out is written entirely or not at all.
+func (w *Writer) Save(ctx context.Context, attempts []shipment.Attempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+	rows, err := w.rows(ctx, attempts, w.now())
+	if err != nil {
+		return fmt.Errorf("failed to save shipment, %w", err)
+	}
+	wr := write{rows: rows, done: make(chan error, 1)}
+	select {
+	case w.writes <- wr:
+	case <-w.closed:
+		return ErrWriterClosed
+	case <-ctx.Done():
+		return fmt.Errorf("failed to save shipment, %w", ctx.Err())
+	}
+	// Once queued the fan-out is written even if the caller goes away,
+	// waiting for it keeps the response honest about what was stored.
+	if err := <-wr.done; err != nil {
+		return fmt.Errorf("failed to save shipment, %w", err)
+	}
+	return nil
+}
+
+// Close writes the pending batch and stops the writer.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() { close(w.closed) })
+	<-w.done
+	return nil
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	var (
+		pending []write
+		rows    int
+		timer   *time.Timer
+		expired <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		w.flush(pending, rows)
+		pending, rows = nil, 0
+	}
+	for {
+		select {
+		case wr := <-w.writes:
+			pending = append(pending, wr)
+			rows += len(wr.rows)
+			if rows >= w.size {
+				flush()
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(w.interval)
+				expired = timer.C
+			}
+		case <-expired:
+			flush()
+		case <-w.closed:
+			flush()
+			return
+		}
+	}
+}
+
+// flush writes the pending fan-outs at once. If that fails each fan-out
+// is written on its own, so one bad fan-out doesn't fail the others.
+func (w *Writer) flush(pending []write, rows int) {
+	if len(pending) == 0 {
+		return
+	}
+	ctx := context.Background()
+	all := make([][]any, 0, rows)
+	for _, wr := range pending {
+		all = append(all, wr.rows...)
+	}
+	start := w.now()
+	err := w.insert(ctx, all)
+	w.observer.Flushed(rows, w.now().Sub(start), err)
+	if err != nil && len(pending) > 1 {
+		w.log.With(log.Error(err), slog.Int("fanouts", len(pending))).
+			Warn("Failed to write the batch, writing each fan-out on its own")
+		for _, wr := range pending {
+			w.flush([]write{wr}, len(wr.rows))
+		}
+		return
+	}
+	if err != nil {
+		w.log.With(log.Error(err)).Error("Failed to write shipment")
+	}
+	for _, wr := range pending {
+		wr.done <- err
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// inserter records the batches written and fails the ones with a bad provider.
+type inserter struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (i *inserter) insert(_ context.Context, rows [][]any) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	providers := []string{}
+	for _, row := range rows {
+		if row[1] == "bad" {
+			return errors.New("invalid row")
+		}
+		providers = append(providers, row[1].(string))
+	}
+	i.batches = append(i.batches, providers)
+	return nil
+}
+
+type flushes struct {
+	mu   sync.Mutex
+	rows []int
+	errs int
+}
+
+func (f *flushes) Flushed(rows int, _ time.Duration, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rows = append(f.rows, rows)
+	if err != nil {
+		f.errs++
+	}
+}
+
+func attempts(providers ...string) []shipment.Attempt {
+	out := []shipment.Attempt{}
+	for _, p := range providers {
+		out = append(out, shipment.Attempt{Provider: p, Status: shipment.StatusBooked})
+	}
+	return out
+}
+
+// saveAll saves every fan-out at the same time and returns their errors.
+func saveAll(w *Writer, fanouts ...[]shipment.Attempt) []error {
+	errs := make([]error, len(fanouts))
+	var wg sync.WaitGroup
+	for i, fanout := range fanouts {
+		wg.Go(func() {
+			errs[i] = w.Save(context.Background(), fanout)
+		})
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestWriter_FlushOnSize(t *testing.T) {
+	ins, obs := new(inserter), new(flushes)
+	w := newWriter(New(nil).rows, ins.insert, WithBatchSize(4), WithFlushInterval(time.Hour), WithFlushObserver(obs))
+	defer w.Close()
+
+	errs := saveAll(w, attempts("a", "b"), attempts("c", "d"))
+	assert.Equal(t, []error{nil, nil}, errs)
+	require.Len(t, ins.batches, 1)
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d"}, ins.batches[0])
+	assert.Equal(t, []int{4}, obs.rows)
+}
+
+func TestWriter_FlushOnInterval(t *testing.T) {
+	ins := new(inserter)
+	w := newWriter(New(nil).rows, ins.insert, WithBatchSize(100), WithFlushInterval(10*time.Millisecond))
+	defer w.Close()
+
+	start := time.Now()
+	require.NoError(t, w.Save(context.Background(), attempts("a")))
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	assert.Equal(t, [][]string{{"a"}}, ins.batches)
+}
+
+func TestWriter_FailedBatchWritesEachFanOut(t *testing.T) {
+	ins, obs := new(inserter), new(flushes)
+	w := newWriter(New(nil).rows, ins.insert, WithBatchSize(3), WithFlushInterval(time.Hour), WithFlushObserver(obs))
+	defer w.Close()
+
+	errs := saveAll(w, attempts("a", "b"), attempts("bad"))
+	require.Len(t, errs, 2)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.Equal(t, [][]string{{"a", "b"}}, ins.batches)
+	assert.Equal(t, 2, obs.errs)
+}
+
+func TestWriter_Close(t *testing.T) {
+	ins := new(inserter)
+	w := newWriter(New(nil).rows, ins.insert, WithBatchSize(100), WithFlushInterval(time.Hour))
+
+	saved := make(chan error)
+	go func() { saved <- w.Save(context.Background(), attempts("a")) }()
+	// Give the save time to be queued, it waits for the batch to fill up.
+	time.Sleep(20 * time.Millisecond)
+
+	require.NoError(t, w.Close())
+	assert.NoError(t, <-saved, "the pending batch is written on close")
+	assert.Equal(t, [][]string{{"a"}}, ins.batches)
+	assert.ErrorIs(t, w.Save(context.Background(), attempts("b")), ErrWriterClosed)
+}
@@ -0,0 +1,288 @@
+// Package tenant holds the business units sharing the service, each one
+// booking with its own provider accounts and seeing only its own shipments.
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// ErrNotFound is returned when a tenant does not exist.
+var ErrNotFound = errors.New("tenant not found")
+
+// ErrInvalidTenant is returned when a tenant can't be saved.
+type ErrInvalidTenant struct {
+	Reason string
+}
+
+var _ error = (*ErrInvalidTenant)(nil)
+
+func (e *ErrInvalidTenant) Error() string {
+	return fmt.Sprintf("invalid tenant, %s", e.Reason)
+}
+
+// Tenant is a business unit with its own provider accounts.
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Providers are the providers enabled for the tenant.
+	Providers []string `json:"providers"`
+	// Credentials are the provider accounts by provider, their
+	// shape is defined by each provider.
+	Credentials map[string]json.RawMessage `json:"credentials,omitempty"`
+	// RateLimit is the number of requests per second each client of
+	// the tenant can make, zero means the default limit applies.
+	RateLimit int       `json:"rateLimit"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+var id = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,99}$`)
+
+// Validate checks if the tenant can be saved.
+func (t *Tenant) Validate() error {
+	if !id.MatchString(t.ID) {
+		return &ErrInvalidTenant{Reason: "id must be lowercase letters, digits and dashes"}
+	}
+	if t.Name == "" {
+		return &ErrInvalidTenant{Reason: "name is required"}
+	}
+	if t.RateLimit < 0 {
+		return &ErrInvalidTenant{Reason: "rate limit can't be negative"}
+	}
+	return nil
+}
+
+var redacted = json.RawMessage(`"redacted"`)
+
+// Redacted returns a copy of the tenant with the credentials hidden.
+func (t Tenant) Redacted() Tenant {
+	credentials := make(map[string]json.RawMessage, len(t.Credentials))
+	for provider := range t.Credentials {
+		credentials[provider] = redacted
+	}
+	t.Credentials = credentials
+	return t
+}
+
+// Store persists the tenants.
+type Store interface {
+	CreateTenant(ctx context.Context, t *Tenant) error
+	Tenant(ctx context.Context, id string) (*Tenant, error)
+	Tenants(ctx context.Context) ([]Tenant, error)
+	UpdateTenant(ctx context.Context, t *Tenant) error
+	DeleteTenant(ctx context.Context, id string) error
+}
+
+// Configurable is a provider that can book with the credentials of a tenant.
+type Configurable interface {
+	WithCredentials(raw json.RawMessage) (shipment.Payloader, error)
+}
+
+// ID returns the tenant of the caller found in the context,
+// empty if the caller does not belong to a tenant.
+func ID(ctx context.Context) string {
+	p, ok := auth.From(ctx)
+	if !ok {
+		return ""
+	}
+	return p.Tenant
+}
+
+type entry struct {
+	providers map[string]shipment.Payloader
+	rateLimit int
+	loaded    time.Time
+}
+
+// Manager resolves the providers of each tenant and manages the tenants.
+// The resolved providers are cached and reloaded after the ttl or after every change.
+type Manager struct {
+	store     Store
+	providers map[string]shipment.Payloader
+	log       *slog.Logger
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]entry
+}
+
+type Option func(m *Manager)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(m *Manager) {
+		m.log = log.WithGroup("tenant")
+	}
+}
+
+// WithTTL sets for how long the tenant providers are cached.
+func WithTTL(ttl time.Duration) Option {
+	return func(m *Manager) {
+		m.ttl = ttl
+	}
+}
+
+// New returns a new manager backed by the store, the providers are the
+// defaults used by the callers without a tenant and configured for each tenant.
+func New(store Store, providers map[string]shipment.Payloader, options ...Option) *Manager {
+	m := &Manager{
+		store:     store,
+		providers: providers,
+		log:       log.Noop(),
+		ttl:       30 * time.Second,
+		cache:     map[string]entry{},
+	}
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+var _ shipment.Resolver = (*Manager)(nil)
+
+// Providers returns the providers of the tenant found in the context,
+// callers without a tenant get the default providers.
+func (m *Manager) Providers(ctx context.Context) (map[string]shipment.Payloader, error) {
+	id := ID(ctx)
+	if id == "" {
+		return m.providers, nil
+	}
+	e, err := m.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return e.providers, nil
+}
+
+// RateLimit returns the requests per second allowed for the clients of
+// the tenant found in the context, zero if the default limit applies.
+func (m *Manager) RateLimit(ctx context.Context) (int, error) {
+	id := ID(ctx)
+	if id == "" {
+		return 0, nil
+	}
+	e, err := m.load(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return e.rateLimit, nil
+}
+
+func (m *Manager) load(ctx context.Context, id string) (entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.cache[id]; ok && time.Since(e.loaded) < m.ttl {
+		return e, nil
+	}
+	t, err := m.store.Tenant(ctx, id)
+	if err != nil {
+		return entry{}, err
+	}
+	providers, err := m.resolve(t)
+	if err != nil {
+		return entry{}, err
+	}
+	e := entry{providers: providers, rateLimit: t.RateLimit, loaded: time.Now()}
+	m.cache[id] = e
+	return e, nil
+}
+
+// resolve configures the enabled providers with the tenant credentials.
+func (m *Manager) resolve(t *Tenant) (map[string]shipment.Payloader, error) {
+	providers := make(map[string]shipment.Payloader, len(t.Providers))
+	for _, name := range t.Providers {
+		base, ok := m.providers[name]
+		if !ok {
+			return nil, &ErrInvalidTenant{Reason: fmt.Sprintf("unknown provider %s", name)}
+		}
+		configurable, ok := base.(Configurable)
+		if !ok {
+			return nil, &ErrInvalidTenant{Reason: fmt.Sprintf("provider %s does not support tenant credentials", name)}
+		}
+		raw, ok := t.Credentials[name]
+		if !ok {
+			return nil, &ErrInvalidTenant{Reason: fmt.Sprintf("missing credentials for provider %s", name)}
+		}
+		p, err := configurable.WithCredentials(raw)
+		if err != nil {
+			return nil, &ErrInvalidTenant{Reason: fmt.Sprintf("invalid credentials for provider %s, %s", name, err)}
+		}
+		providers[name] = p
+	}
+	return providers, nil
+}
+
+func (m *Manager) invalidate(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.cache, id)
+}
+
+// Tenants returns all the tenants.
+func (m *Manager) Tenants(ctx context.Context) ([]Tenant, error) {
+	return m.store.Tenants(ctx)
+}
+
+// Tenant returns the tenant with the given id.
+func (m *Manager) Tenant(ctx context.Context, id string) (*Tenant, error) {
+	return m.store.Tenant(ctx, id)
+}
+
+// Create validates and saves a new tenant.
+func (m *Manager) Create(ctx context.Context, t *Tenant) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	if _, err := m.resolve(t); err != nil {
+		return err
+	}
+	return m.store.CreateTenant(ctx, t)
+}
+
+// Update validates and replaces an existing tenant, the credentials
+// of the providers left out of the update are kept.
+func (m *Manager) Update(ctx context.Context, t *Tenant) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	old, err := m.store.Tenant(ctx, t.ID)
+	if err != nil {
+		return err
+	}
+	if t.Credentials == nil {
+		t.Credentials = map[string]json.RawMessage{}
+	}
+	for provider, raw := range old.Credentials {
+		if current, ok := t.Credentials[provider]; !ok || slices.Equal(current, redacted) {
+			t.Credentials[provider] = raw
+		}
+	}
+	if _, err := m.resolve(t); err != nil {
+		return err
+	}
+	if err := m.store.UpdateTenant(ctx, t); err != nil {
+		return err
+	}
+	m.invalidate(t.ID)
+	return nil
+}
+
+// Delete removes a tenant.
+func (m *Manager) Delete(ctx context.Context, id string) error {
+	if err := m.store.DeleteTenant(ctx, id); err != nil {
+		return err
+	}
+	m.invalidate(id)
+	return nil
+}
@@ -0,0 +1,172 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/auth"
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type mockStore struct{ mock.Mock }
+
+func (m *mockStore) CreateTenant(ctx context.Context, t *Tenant) error {
+	return m.Called(ctx, t).Error(0)
+}
+
+func (m *mockStore) Tenant(ctx context.Context, id string) (*Tenant, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Tenant), args.Error(1)
+}
+
+func (m *mockStore) Tenants(ctx context.Context) ([]Tenant, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]Tenant), args.Error(1)
+}
+
+func (m *mockStore) UpdateTenant(ctx context.Context, t *Tenant) error {
+	return m.Called(ctx, t).Error(0)
+}
+
+func (m *mockStore) DeleteTenant(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+// account is a provider booking with an account number.
+type account struct {
+	Number string `json:"number"`
+}
+
+func (a account) Payload(*api.ShippingRequest) []byte { return []byte(a.Number) }
+
+func (a account) To() string { return "https://provider" }
+
+func (a account) WithCredentials(raw json.RawMessage) (shipment.Payloader, error) {
+	var next account
+	if err := json.Unmarshal(raw, &next); err != nil {
+		return nil, err
+	}
+	if next.Number == "" {
+		return nil, errors.New("number is required")
+	}
+	return next, nil
+}
+
+type fixed struct{}
+
+func (fixed) Payload(*api.ShippingRequest) []byte { return nil }
+
+func (fixed) To() string { return "https://fixed" }
+
+var providers = map[string]shipment.Payloader{
+	"a":     account{Number: "default"},
+	"fixed": fixed{},
+}
+
+func as(tenant string) context.Context {
+	return auth.With(context.Background(), &auth.Principal{Subject: "test", Tenant: tenant})
+}
+
+func TestManager_Providers(t *testing.T) {
+	store := new(mockStore)
+	store.On("Tenant", mock.Anything, "acme").Return(&Tenant{
+		ID:          "acme",
+		Providers:   []string{"a"},
+		Credentials: map[string]json.RawMessage{"a": json.RawMessage(`{"number":"acme-1"}`)},
+	}, nil).Once()
+	store.On("Tenant", mock.Anything, "ghost").Return(nil, ErrNotFound)
+	m := New(store, providers)
+
+	got, err := m.Providers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, providers, got)
+
+	for range 2 {
+		got, err = m.Providers(as("acme"))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]shipment.Payloader{"a": account{Number: "acme-1"}}, got)
+	}
+
+	_, err = m.Providers(as("ghost"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	store.AssertExpectations(t)
+}
+
+func TestManager_Create(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenant  *Tenant
+		wantErr error
+	}{
+		{
+			name:    "invalid id",
+			tenant:  &Tenant{ID: "Acme Corp", Name: "Acme"},
+			wantErr: &ErrInvalidTenant{Reason: "id must be lowercase letters, digits and dashes"},
+		},
+		{
+			name:    "unknown provider",
+			tenant:  &Tenant{ID: "acme", Name: "Acme", Providers: []string{"z"}},
+			wantErr: &ErrInvalidTenant{Reason: "unknown provider z"},
+		},
+		{
+			name:    "provider without tenant credentials support",
+			tenant:  &Tenant{ID: "acme", Name: "Acme", Providers: []string{"fixed"}},
+			wantErr: &ErrInvalidTenant{Reason: "provider fixed does not support tenant credentials"},
+		},
+		{
+			name:    "missing credentials",
+			tenant:  &Tenant{ID: "acme", Name: "Acme", Providers: []string{"a"}},
+			wantErr: &ErrInvalidTenant{Reason: "missing credentials for provider a"},
+		},
+		{
+			name: "invalid credentials",
+			tenant: &Tenant{ID: "acme", Name: "Acme", Providers: []string{"a"},
+				Credentials: map[string]json.RawMessage{"a": json.RawMessage(`{}`)}},
+			wantErr: &ErrInvalidTenant{Reason: "invalid credentials for provider a, number is required"},
+		},
+		{
+			name: "valid",
+			tenant: &Tenant{ID: "acme", Name: "Acme", Providers: []string{"a"},
+				Credentials: map[string]json.RawMessage{"a": json.RawMessage(`{"number":"1"}`)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := new(mockStore)
+			store.On("CreateTenant", mock.Anything, tt.tenant).Return(nil).Maybe()
+			err := New(store, providers).Create(context.Background(), tt.tenant)
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestManager_UpdateKeepsCredentials(t *testing.T) {
+	store := new(mockStore)
+	store.On("Tenant", mock.Anything, "acme").Return(&Tenant{
+		ID:          "acme",
+		Providers:   []string{"a"},
+		Credentials: map[string]json.RawMessage{"a": json.RawMessage(`{"number":"acme-1"}`)},
+	}, nil)
+	store.On("UpdateTenant", mock.Anything, mock.Anything).Return(nil)
+	m := New(store, providers)
+
+	// A tenant read through the api comes back with its credentials redacted.
+	update := (Tenant{
+		ID:          "acme",
+		Name:        "Acme",
+		Providers:   []string{"a"},
+		Credentials: map[string]json.RawMessage{"a": json.RawMessage(`{"number":"acme-1"}`)},
+	}).Redacted()
+	require.NoError(t, m.Update(context.Background(), &update))
+	assert.JSONEq(t, `{"number":"acme-1"}`, string(update.Credentials["a"]))
+}
@@ -0,0 +1,183 @@
+// Package tracing instruments the http handlers, the shipment fan-out,
+// the outbound provider calls and the postgres queries with OpenTelemetry spans.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/hoenirvili/axiogate/http/middleware"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+const name = "github.com/hoenirvili/axiogate"
+
+// NewProvider returns a tracer provider exporting the spans in batches over
+// OTLP/HTTP to the endpoint, e.g. http://localhost:4318.
+func NewProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter, %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("axiogate"),
+		)),
+	), nil
+}
+
+// Tracing creates the spans using the tracer provider
+// and propagates them using the W3C trace context.
+type Tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New returns a new tracing instance, if the provider is nil no spans are recorded.
+func New(tp trace.TracerProvider) *Tracing {
+	if tp == nil {
+		tp = noop.NewTracerProvider()
+	}
+	return &Tracing{
+		tracer:     tp.Tracer(name),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+type recorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *recorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Middleware starts a server span for every request, continuing
+// the trace of the caller if one is propagated.
+func (t *Tracing) Middleware() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := t.tracer.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+				),
+			)
+			defer span.End()
+
+			rec := &recorder{ResponseWriter: w, code: http.StatusOK}
+			r = r.WithContext(ctx)
+			next.ServeHTTP(rec, r)
+
+			if r.Pattern != "" {
+				span.SetName(r.Pattern)
+				span.SetAttributes(semconv.HTTPRoute(r.Pattern))
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(rec.code))
+			if rec.code >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(rec.code))
+			}
+		})
+	}
+}
+
+var _ shipment.Observer = (*Tracing)(nil)
+
+// FanOut records the fan-out width on the current span.
+func (t *Tracing) FanOut(ctx context.Context, width int) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("shipment.fanout.width", width))
+}
+
+// Job starts a span for a provider job.
+func (t *Tracing) Job(ctx context.Context, provider string) (context.Context, func()) {
+	ctx, span := t.tracer.Start(ctx, "shipment.job",
+		trace.WithAttributes(attribute.String("shipment.provider", provider)),
+	)
+	return ctx, func() { span.End() }
+}
+
+type transport struct {
+	next    http.RoundTripper
+	tracing *Tracing
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := t.tracing.tracer.Start(r.Context(), r.Method+" "+r.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLFull(r.URL.String()),
+			semconv.ServerAddress(r.URL.Hostname()),
+		),
+	)
+	defer span.End()
+
+	r = r.Clone(ctx)
+	t.tracing.propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// Transport wraps the round tripper with a client span for every
+// outbound request and propagates the trace context to the provider.
+func (t *Tracing) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{next: next, tracing: t}
+}
+
+var _ pgx.QueryTracer = (*Tracing)(nil)
+
+// TraceQueryStart starts a span for a pgx query, it satisfies pgx.QueryTracer.
+func (t *Tracing) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "pgx.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBQueryText(data.SQL),
+		),
+	)
+	return ctx
+}
+
+// TraceQueryEnd ends the span started by TraceQueryStart.
+func (t *Tracing) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
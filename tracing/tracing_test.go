@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracing() (*Tracing, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return New(tp), recorder
+}
+
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddleware(t *testing.T) {
+	tr, recorder := newTracing()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, trace.SpanContextFromContext(r.Context()).IsValid())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/things/1", nil)
+	req.Header.Set("traceparent", parent)
+	tr.Middleware()(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "POST /api/v1/things/{id}", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Parent().TraceID().String())
+	assert.Equal(t, "POST /api/v1/things/{id}", attr(span, "http.route").AsString())
+	assert.Equal(t, int64(500), attr(span, "http.response.status_code").AsInt64())
+	assert.Equal(t, codes.Error, span.Status().Code)
+}
+
+func TestTransport(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	tr, recorder := newTracing()
+	ctx, job := tr.Job(context.Background(), "a")
+	cli := &http.Client{Transport: tr.Transport(nil)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+	require.NoError(t, err)
+	resp, err := cli.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	job()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	client, parent := spans[0], spans[1]
+	assert.Equal(t, "shipment.job", parent.Name())
+	assert.Equal(t, "a", attr(parent, "shipment.provider").AsString())
+	assert.Equal(t, trace.SpanKindClient, client.SpanKind())
+	assert.Equal(t, parent.SpanContext().SpanID(), client.Parent().SpanID())
+	assert.Contains(t, traceparent, client.SpanContext().TraceID().String())
+	assert.Contains(t, traceparent, client.SpanContext().SpanID().String())
+	assert.Equal(t, int64(502), attr(client, "http.response.status_code").AsInt64())
+	assert.Equal(t, codes.Error, client.Status().Code)
+}
+
+func TestQueryTracer(t *testing.T) {
+	tr, recorder := newTracing()
+	ctx := tr.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "SELECT 1"})
+	tr.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("db down")})
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SELECT 1", attr(spans[0], "db.query.text").AsString())
+	assert.Equal(t, "postgresql", attr(spans[0], "db.system.name").AsString())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+}
@@ -0,0 +1,540 @@
+// Package webhook delivers signed notifications about shipment
+// outcomes to the callback urls registered by the api consumers.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/log"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+// Events a subscription can be interested in.
+const (
+	EventShipmentSucceeded     = "shipment.succeeded"
+	EventShipmentFailed        = "shipment.failed"
+	EventShipmentStatusChanged = "shipment.status_changed"
+)
+
+// Events lists all the events that can be delivered.
+var Events = []string{
+	EventShipmentSucceeded,
+	EventShipmentFailed,
+	EventShipmentStatusChanged,
+}
+
+// Headers sent on every delivery.
+const (
+	HeaderEvent     = "X-Axiogate-Event"
+	HeaderDelivery  = "X-Axiogate-Delivery"
+	HeaderTimestamp = "X-Axiogate-Timestamp"
+	HeaderSignature = "X-Axiogate-Signature"
+)
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// ErrNotFound is returned when a subscription or a delivery does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrPending is returned when redelivering a delivery still being sent.
+var ErrPending = errors.New("delivery still pending")
+
+// ErrClosed is returned when publishing on a closed dispatcher.
+var ErrClosed = errors.New("dispatcher closed")
+
+// Subscription is a callback url registered for a set of events.
+type Subscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Wants reports if the subscription is interested in the event.
+func (s *Subscription) Wants(event string) bool {
+	return slices.Contains(s.Events, event)
+}
+
+// Delivery is one event sent, or to be sent, to a subscription.
+type Delivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscriptionId"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"responseStatus"`
+	Error          string          `json:"error"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+}
+
+// Store persists the subscriptions and the delivery log.
+type Store interface {
+	CreateSubscription(ctx context.Context, sub *Subscription) error
+	Subscription(ctx context.Context, id int64) (*Subscription, error)
+	Subscriptions(ctx context.Context) ([]Subscription, error)
+	DeleteSubscription(ctx context.Context, id int64) error
+	CreateDelivery(ctx context.Context, d *Delivery) error
+	UpdateDelivery(ctx context.Context, d *Delivery) error
+	// ClaimDelivery sets the delivery back to pending and returns it, unless
+	// it's pending already, then it returns ErrPending.
+	ClaimDelivery(ctx context.Context, id int64) (*Delivery, error)
+	Delivery(ctx context.Context, id int64) (*Delivery, error)
+	Deliveries(ctx context.Context, subscriptionID int64) ([]Delivery, error)
+	PendingDeliveries(ctx context.Context) ([]Delivery, error)
+}
+
+// Dispatcher fans out events to all interested subscriptions
+// and retries failed deliveries with exponential backoff.
+type Dispatcher struct {
+	store   Store
+	client  *http.Client
+	log     *slog.Logger
+	retries int
+	backoff time.Duration
+
+	wg     sync.WaitGroup
+	done   chan struct{}
+	mu     sync.Mutex
+	closed bool
+}
+
+type Option func(d *Dispatcher)
+
+func WithLogger(log *slog.Logger) Option {
+	return func(d *Dispatcher) {
+		d.log = log.WithGroup("webhook")
+	}
+}
+
+// WithHTTPClient sets the client used to deliver the events.
+func WithHTTPClient(cli *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = cli
+	}
+}
+
+// WithRetries sets how many times a failed delivery is retried.
+func WithRetries(n int) Option {
+	return func(d *Dispatcher) {
+		d.retries = n
+	}
+}
+
+// WithBackoff sets the initial wait between two attempts, the wait
+// doubles after every failed attempt.
+func WithBackoff(backoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.backoff = backoff
+	}
+}
+
+// New returns a new dispatcher that persists the deliveries in the store.
+// Its client refuses to connect to the private addresses.
+func New(store Store, options ...Option) *Dispatcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// The callbacks are reached directly, the guard checks the address
+	// dialed, a proxy would hide it.
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second, Control: public}).DialContext
+	d := &Dispatcher{
+		store:   store,
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		log:     log.Noop(),
+		retries: 5,
+		backoff: time.Second,
+		done:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp and body
+// using the subscription secret, receivers should compute the same
+// value and compare it against the signature header.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func secret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate secret, %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ErrInvalidSubscription is returned when a subscription can't be registered.
+type ErrInvalidSubscription struct {
+	Reason string
+}
+
+var _ error = (*ErrInvalidSubscription)(nil)
+
+func (e *ErrInvalidSubscription) Error() string {
+	return fmt.Sprintf("invalid subscription, %s", e.Reason)
+}
+
+// private reports if the address isn't reachable from the internet, the
+// callbacks can't reach into the network the service runs in.
+func private(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// public refuses to dial the private addresses, the names resolving to
+// one when they are delivered to included.
+func public(_, address string, _ syscall.RawConn) error {
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if private(addr.Addr()) {
+		return fmt.Errorf("refusing to deliver to the private address %s", addr.Addr())
+	}
+	return nil
+}
+
+// callback reports why the url can't be called back, only the http and
+// https urls of public hosts can.
+func callback(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "url is invalid"
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "url must be http or https"
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "url has no host"
+	}
+	if ip, err := netip.ParseAddr(host); (err == nil && private(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return "url must not be a private address"
+	}
+	return ""
+}
+
+// Subscribe registers a new subscription, if no secret is given one is generated.
+func (d *Dispatcher) Subscribe(ctx context.Context, sub *Subscription) error {
+	if sub.URL == "" {
+		return &ErrInvalidSubscription{Reason: "url is required"}
+	}
+	if reason := callback(sub.URL); reason != "" {
+		return &ErrInvalidSubscription{Reason: reason}
+	}
+	if len(sub.Events) == 0 {
+		return &ErrInvalidSubscription{Reason: "at least one event is required"}
+	}
+	for _, event := range sub.Events {
+		if !slices.Contains(Events, event) {
+			return &ErrInvalidSubscription{Reason: fmt.Sprintf("unknown event %s", event)}
+		}
+	}
+	if sub.Secret == "" {
+		s, err := secret()
+		if err != nil {
+			return err
+		}
+		sub.Secret = s
+	}
+	return d.store.CreateSubscription(ctx, sub)
+}
+
+// Subscriptions returns all registered subscriptions.
+func (d *Dispatcher) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	return d.store.Subscriptions(ctx)
+}
+
+// Unsubscribe removes the subscription and its delivery log.
+func (d *Dispatcher) Unsubscribe(ctx context.Context, id int64) error {
+	return d.store.DeleteSubscription(ctx, id)
+}
+
+// Deliveries returns the delivery log of a subscription.
+func (d *Dispatcher) Deliveries(ctx context.Context, subscriptionID int64) ([]Delivery, error) {
+	if _, err := d.store.Subscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+	return d.store.Deliveries(ctx, subscriptionID)
+}
+
+type envelope struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// Publish sends the event with the given data to every interested subscription.
+// The deliveries happen in the background, Publish only records them.
+// Once the dispatcher is closed Publish returns ErrClosed.
+func (d *Dispatcher) Publish(ctx context.Context, event string, data any) error {
+	if d.isClosed() {
+		return ErrClosed
+	}
+	payload, err := encode(event, data)
+	if err != nil {
+		return err
+	}
+	return d.record(ctx, event, payload)
+}
+
+func encode(event string, data any) ([]byte, error) {
+	payload, err := json.Marshal(&envelope{
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event, %w", err)
+	}
+	return payload, nil
+}
+
+// record records a delivery of the event for every interested subscription and sends them.
+func (d *Dispatcher) record(ctx context.Context, event string, payload []byte) error {
+	subs, err := d.store.Subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if !sub.Wants(event) {
+			continue
+		}
+		delivery := &Delivery{
+			SubscriptionID: sub.ID,
+			Event:          event,
+			Payload:        payload,
+			Status:         StatusPending,
+		}
+		if err := d.store.CreateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		d.deliver(sub, delivery)
+	}
+	return nil
+}
+
+// enqueue publishes the event in the background, the shipments don't wait
+// on the db to be answered. The events enqueued before the dispatcher is
+// closed are still recorded, Resume sends them.
+func (d *Dispatcher) enqueue(ctx context.Context, event string, data any) {
+	l := d.log.With(slog.String("event", event))
+	payload, err := encode(event, data)
+	if err != nil {
+		l.With(log.Error(err)).Error("Failed to publish event")
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		l.With(log.Error(ErrClosed)).Error("Failed to publish event")
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		if err := d.record(context.WithoutCancel(ctx), event, payload); err != nil {
+			l.With(log.Error(err)).Error("Failed to publish event")
+		}
+	}()
+}
+
+// Outcome is the data sent for the shipment succeeded and failed events.
+type Outcome struct {
+	Provider string               `json:"provider"`
+	Response api.ShippingResponse `json:"response"`
+}
+
+// Notify publishes the result of a provider call in the background, it
+// satisfies shipment.Notifier.
+func (d *Dispatcher) Notify(ctx context.Context, provider string, resp api.ShippingResponse) {
+	event := EventShipmentSucceeded
+	if resp.Error != "" {
+		event = EventShipmentFailed
+	}
+	d.enqueue(ctx, event, &Outcome{Provider: provider, Response: resp})
+}
+
+// StatusChanged publishes the new status of a shipment in the background,
+// it satisfies shipment.Notifier.
+func (d *Dispatcher) StatusChanged(ctx context.Context, change shipment.StatusChange) {
+	d.enqueue(ctx, EventShipmentStatusChanged, &change)
+}
+
+var _ shipment.Notifier = (*Dispatcher)(nil)
+
+// Redeliver sends again a recorded delivery once it was delivered or it
+// failed. A pending one is still being sent, it returns ErrPending for it.
+func (d *Dispatcher) Redeliver(ctx context.Context, id int64) (*Delivery, error) {
+	delivery, err := d.store.Delivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := d.store.Subscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	// The delivery is claimed so two redeliveries, or a redelivery and
+	// the retries in flight, don't send it twice.
+	if delivery, err = d.store.ClaimDelivery(ctx, id); err != nil {
+		return nil, err
+	}
+	out := *delivery
+	d.deliver(*sub, delivery)
+	return &out, nil
+}
+
+// Resume sends the deliveries left pending by a previous run, the ones
+// still retrying when it was closed or stopped. A delivery may be sent
+// twice, receivers can tell by the delivery header.
+func (d *Dispatcher) Resume(ctx context.Context) error {
+	deliveries, err := d.store.PendingDeliveries(ctx)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		sub, err := d.store.Subscription(ctx, delivery.SubscriptionID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		d.deliver(*sub, &delivery)
+	}
+	d.log.With(slog.Int("deliveries", len(deliveries))).
+		InfoContext(ctx, "Resumed pending deliveries")
+	return nil
+}
+
+// Close stops retrying and waits for the in flight deliveries to finish
+// and the enqueued events to be recorded. The deliveries it gives up on
+// are left pending, Resume sends them again.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.done)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
+// deliver sends the delivery in the background, unless the dispatcher
+// is closed, then the delivery is left as it is.
+func (d *Dispatcher) deliver(sub Subscription, delivery *Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		l := d.log.With(
+			slog.Int64("subscription", sub.ID),
+			slog.Int64("delivery", delivery.ID),
+			slog.String("event", delivery.Event),
+		)
+		backoff := d.backoff
+		for attempt := 0; attempt <= d.retries; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-time.After(backoff):
+					backoff *= 2
+				case <-d.done:
+					l.Warn("Dispatcher closed, giving up on delivery")
+					return
+				}
+			}
+			status, err := d.send(sub, delivery)
+			delivery.Attempts++
+			delivery.ResponseStatus = status
+			if err == nil {
+				delivery.Status = StatusDelivered
+				delivery.Error = ""
+				d.update(l, delivery)
+				l.Info("Webhook delivered")
+				return
+			}
+			delivery.Error = err.Error()
+			l.With(log.Error(err), slog.Int("attempt", delivery.Attempts)).
+				Warn("Webhook delivery failed")
+			if attempt == d.retries {
+				delivery.Status = StatusFailed
+			}
+			d.update(l, delivery)
+		}
+	}()
+}
+
+func (d *Dispatcher) update(l *slog.Logger, delivery *Delivery) {
+	if err := d.store.UpdateDelivery(context.Background(), delivery); err != nil {
+		l.With(log.Error(err)).Error("Failed to update delivery")
+	}
+}
+
+func (d *Dispatcher) send(sub Subscription, delivery *Delivery) (int, error) {
+	ts := time.Now().Unix()
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, ts, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
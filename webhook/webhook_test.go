@@ -0,0 +1,484 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hoenirvili/axiogate/http/api"
+	"github.com/hoenirvili/axiogate/shipment"
+)
+
+type memStore struct {
+	mu         sync.Mutex
+	subs       []Subscription
+	deliveries map[int64]Delivery
+}
+
+func newMemStore(subs ...Subscription) *memStore {
+	return &memStore{subs: subs, deliveries: map[int64]Delivery{}}
+}
+
+func (m *memStore) CreateSubscription(_ context.Context, sub *Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub.ID = int64(len(m.subs) + 1)
+	m.subs = append(m.subs, *sub)
+	return nil
+}
+
+func (m *memStore) Subscription(_ context.Context, id int64) (*Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sub := range m.subs {
+		if sub.ID == id {
+			return &sub, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memStore) Subscriptions(context.Context) ([]Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Subscription(nil), m.subs...), nil
+}
+
+func (m *memStore) DeleteSubscription(context.Context, int64) error { return nil }
+
+func (m *memStore) CreateDelivery(_ context.Context, d *Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d.ID = int64(len(m.deliveries) + 1)
+	m.deliveries[d.ID] = *d
+	return nil
+}
+
+func (m *memStore) UpdateDelivery(_ context.Context, d *Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries[d.ID] = *d
+	return nil
+}
+
+func (m *memStore) ClaimDelivery(_ context.Context, id int64) (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if d.Status == StatusPending {
+		return nil, ErrPending
+	}
+	d.Status, d.Error = StatusPending, ""
+	m.deliveries[id] = d
+	return &d, nil
+}
+
+func (m *memStore) Delivery(_ context.Context, id int64) (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &d, nil
+}
+
+func (m *memStore) Deliveries(context.Context, int64) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []Delivery{}
+	for _, d := range m.deliveries {
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func (m *memStore) PendingDeliveries(context.Context) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []Delivery{}
+	for _, d := range m.deliveries {
+		if d.Status == StatusPending {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) delivery(id int64) Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deliveries[id]
+}
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", 1700000000, []byte(`{"event":"shipment.succeeded"}`))
+	assert.Equal(t, got, Sign("secret", 1700000000, []byte(`{"event":"shipment.succeeded"}`)))
+	assert.NotEqual(t, got, Sign("other", 1700000000, []byte(`{"event":"shipment.succeeded"}`)))
+	assert.NotEqual(t, got, Sign("secret", 1700000001, []byte(`{"event":"shipment.succeeded"}`)))
+	assert.Len(t, got, len("sha256=")+64)
+}
+
+func TestDispatcher_Notify(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		retries      int
+		resp         api.ShippingResponse
+		events       []string
+		wantCalls    int32
+		wantStatus   string
+		wantAttempts int
+		wantEvent    string
+	}{
+		{
+			name:         "delivered on first attempt",
+			resp:         api.ShippingResponse{Endpoint: "http://a"},
+			events:       []string{EventShipmentSucceeded},
+			wantCalls:    1,
+			wantStatus:   StatusDelivered,
+			wantAttempts: 1,
+			wantEvent:    EventShipmentSucceeded,
+		},
+		{
+			name:         "delivered after retries",
+			failures:     2,
+			retries:      3,
+			resp:         api.ShippingResponse{Endpoint: "http://a", Error: "boom"},
+			events:       []string{EventShipmentFailed},
+			wantCalls:    3,
+			wantStatus:   StatusDelivered,
+			wantAttempts: 3,
+			wantEvent:    EventShipmentFailed,
+		},
+		{
+			name:         "failed after exhausting retries",
+			failures:     10,
+			retries:      2,
+			resp:         api.ShippingResponse{Endpoint: "http://a"},
+			events:       []string{EventShipmentSucceeded},
+			wantCalls:    3,
+			wantStatus:   StatusFailed,
+			wantAttempts: 3,
+			wantEvent:    EventShipmentSucceeded,
+		},
+		{
+			name:      "not interested in event",
+			resp:      api.ShippingResponse{Endpoint: "http://a"},
+			events:    []string{EventShipmentFailed},
+			wantCalls: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				body, _ := io.ReadAll(r.Body)
+				ts, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+				assert.NoError(t, err)
+				assert.Equal(t, Sign("secret", ts, body), r.Header.Get(HeaderSignature))
+				assert.Equal(t, tt.wantEvent, r.Header.Get(HeaderEvent))
+				if n <= tt.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			store := newMemStore(Subscription{ID: 1, URL: srv.URL, Secret: "secret", Events: tt.events})
+			d := New(store, WithHTTPClient(srv.Client()), WithRetries(tt.retries), WithBackoff(time.Millisecond))
+			d.Notify(context.Background(), "a", tt.resp)
+			d.wg.Wait()
+
+			assert.Equal(t, tt.wantCalls, calls.Load())
+			if tt.wantCalls == 0 {
+				assert.Empty(t, store.deliveries)
+				return
+			}
+			require.Len(t, store.deliveries, 1)
+			delivery := store.deliveries[1]
+			assert.Equal(t, tt.wantStatus, delivery.Status)
+			assert.Equal(t, tt.wantAttempts, delivery.Attempts)
+
+			var env struct {
+				Event string  `json:"event"`
+				Data  Outcome `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(delivery.Payload, &env))
+			assert.Equal(t, tt.wantEvent, env.Event)
+			assert.Equal(t, "a", env.Data.Provider)
+		})
+	}
+}
+
+// slowStore holds the subscriptions back until it's released.
+type slowStore struct {
+	*memStore
+	release chan struct{}
+}
+
+func (s *slowStore) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	<-s.release
+	return s.memStore.Subscriptions(ctx)
+}
+
+func TestDispatcher_NotifyInBackground(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	store := &slowStore{
+		memStore: newMemStore(Subscription{ID: 1, URL: srv.URL, Secret: "secret", Events: Events}),
+		release:  make(chan struct{}),
+	}
+	d := New(store, WithHTTPClient(srv.Client()))
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Notify(ctx, "a", api.ShippingResponse{Endpoint: "http://a"})
+	cancel()
+	assert.Empty(t, store.deliveries, "the caller doesn't wait on the store")
+
+	close(store.release)
+	d.wg.Wait()
+	assert.Equal(t, int32(1), calls.Load(), "the event outlives the request it was published for")
+	assert.Equal(t, StatusDelivered, store.delivery(1).Status)
+}
+
+func TestDispatcher_CloseRecordsEnqueued(t *testing.T) {
+	store := &slowStore{
+		memStore: newMemStore(Subscription{ID: 1, URL: "http://example.com", Secret: "secret", Events: Events}),
+		release:  make(chan struct{}),
+	}
+	d := New(store)
+	d.Notify(context.Background(), "a", api.ShippingResponse{Endpoint: "http://a"})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(store.release)
+	}()
+	d.Close()
+	assert.Equal(t, StatusPending, store.delivery(1).Status, "the enqueued event is recorded for Resume")
+}
+
+func TestDispatcher_StatusChanged(t *testing.T) {
+	delivered := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, EventShipmentStatusChanged, r.Header.Get(HeaderEvent))
+		body, _ := io.ReadAll(r.Body)
+		delivered <- body
+	}))
+	defer srv.Close()
+
+	store := newMemStore(Subscription{ID: 1, URL: srv.URL, Secret: "secret", Events: []string{EventShipmentStatusChanged}})
+	d := New(store, WithHTTPClient(srv.Client()))
+	change := shipment.StatusChange{Shipment: "ship-1", Provider: "b", From: shipment.StatusBooked, To: shipment.StatusVoided}
+	d.StatusChanged(context.Background(), change)
+	d.wg.Wait()
+
+	var env struct {
+		Event string                `json:"event"`
+		Data  shipment.StatusChange `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(<-delivered, &env))
+	assert.Equal(t, EventShipmentStatusChanged, env.Event)
+	assert.Equal(t, change, env.Data)
+}
+
+func TestDispatcher_Subscribe(t *testing.T) {
+	tests := []struct {
+		name    string
+		sub     *Subscription
+		wantErr bool
+	}{
+		{
+			name: "secret is generated",
+			sub:  &Subscription{URL: "http://example.com", Events: []string{EventShipmentFailed}},
+		},
+		{
+			name:    "missing url",
+			sub:     &Subscription{Events: []string{EventShipmentFailed}},
+			wantErr: true,
+		},
+		{
+			name:    "not http",
+			sub:     &Subscription{URL: "file:///etc/passwd", Events: []string{EventShipmentFailed}},
+			wantErr: true,
+		},
+		{
+			name:    "loopback",
+			sub:     &Subscription{URL: "http://127.0.0.1:8080/hook", Events: []string{EventShipmentFailed}},
+			wantErr: true,
+		},
+		{
+			name:    "localhost",
+			sub:     &Subscription{URL: "http://localhost:9000/hook", Events: []string{EventShipmentFailed}},
+			wantErr: true,
+		},
+		{
+			name:    "private",
+			sub:     &Subscription{URL: "https://10.0.0.1/hook", Events: []string{EventShipmentFailed}},
+			wantErr: true,
+		},
+		{
+			name:    "cloud metadata",
+			sub:     &Subscription{URL: "http://169.254.169.254/latest/meta-data", Events: []string{EventShipmentFailed}},
+			wantErr: true,
+		},
+		{
+			name:    "mapped loopback",
+			sub:     &Subscription{URL: "http://[::ffff:127.0.0.1]/hook", Events: []string{EventShipmentFailed}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown event",
+			sub:     &Subscription{URL: "http://example.com", Events: []string{"shipment.lost"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(newMemStore())
+			err := d.Subscribe(context.Background(), tt.sub)
+			if tt.wantErr {
+				assert.IsType(t, &ErrInvalidSubscription{}, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotEmpty(t, tt.sub.Secret)
+			assert.NotZero(t, tt.sub.ID)
+		})
+	}
+}
+
+func TestDispatcher_PrivateAddress(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	// The subscriptions saved before the urls were checked, and the names
+	// resolving to a private address, are refused when they are dialed.
+	store := newMemStore(Subscription{ID: 1, URL: srv.URL, Secret: "secret", Events: Events})
+	d := New(store, WithRetries(0))
+	require.NoError(t, d.Publish(context.Background(), EventShipmentSucceeded, "data"))
+	d.wg.Wait()
+
+	assert.Zero(t, calls.Load())
+	assert.Equal(t, StatusFailed, store.delivery(1).Status)
+	assert.Contains(t, store.delivery(1).Error, "refusing to deliver to the private address 127.0.0.1")
+}
+
+func TestDispatcher_Redeliver(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	store := newMemStore(Subscription{ID: 1, URL: srv.URL, Secret: "secret", Events: Events})
+	store.deliveries[7] = Delivery{
+		ID:             7,
+		SubscriptionID: 1,
+		Event:          EventShipmentFailed,
+		Payload:        json.RawMessage(`{}`),
+		Status:         StatusFailed,
+		Attempts:       6,
+	}
+	d := New(store, WithHTTPClient(srv.Client()))
+
+	_, err := d.Redeliver(context.Background(), 7)
+	assert.NoError(t, err)
+	d.wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, StatusDelivered, store.deliveries[7].Status)
+	assert.Equal(t, 7, store.deliveries[7].Attempts)
+
+	_, err = d.Redeliver(context.Background(), 8)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDispatcher_RedeliverPending(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer srv.Close()
+
+	store := newMemStore(Subscription{ID: 1, URL: srv.URL, Secret: "secret", Events: Events})
+	store.deliveries[7] = Delivery{ID: 7, SubscriptionID: 1, Event: EventShipmentFailed, Payload: json.RawMessage(`{}`), Status: StatusFailed}
+	d := New(store, WithHTTPClient(srv.Client()))
+
+	_, err := d.Redeliver(context.Background(), 7)
+	require.NoError(t, err)
+	_, err = d.Redeliver(context.Background(), 7)
+	assert.ErrorIs(t, err, ErrPending, "the delivery in flight isn't sent twice")
+	close(release)
+	d.wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestDispatcher_Resume(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	store := newMemStore(Subscription{ID: 1, URL: srv.URL, Secret: "secret", Events: Events})
+	store.deliveries[1] = Delivery{ID: 1, SubscriptionID: 1, Event: EventShipmentSucceeded, Payload: json.RawMessage(`{}`), Status: StatusPending, Attempts: 2}
+	store.deliveries[2] = Delivery{ID: 2, SubscriptionID: 1, Event: EventShipmentFailed, Payload: json.RawMessage(`{}`), Status: StatusFailed}
+	store.deliveries[3] = Delivery{ID: 3, SubscriptionID: 9, Event: EventShipmentFailed, Payload: json.RawMessage(`{}`), Status: StatusPending}
+	d := New(store, WithHTTPClient(srv.Client()))
+
+	require.NoError(t, d.Resume(context.Background()))
+	d.wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, StatusDelivered, store.delivery(1).Status)
+	assert.Equal(t, 3, store.delivery(1).Attempts)
+	assert.Equal(t, StatusFailed, store.delivery(2).Status)
+	assert.Equal(t, StatusPending, store.delivery(3).Status, "the deliveries of removed subscriptions are skipped")
+}
+
+func TestDispatcher_Close(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	store := newMemStore(Subscription{ID: 1, URL: srv.URL, Secret: "secret", Events: Events})
+	d := New(store, WithHTTPClient(srv.Client()), WithBackoff(time.Hour))
+	require.NoError(t, d.Publish(context.Background(), EventShipmentSucceeded, "data"))
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	d.Close()
+	assert.Equal(t, StatusPending, store.delivery(1).Status, "the retried delivery is left to resume")
+	assert.ErrorIs(t, d.Publish(context.Background(), EventShipmentSucceeded, "data"), ErrClosed)
+	_, err := d.Redeliver(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrPending, "the pending delivery is left to resume")
+	assert.Equal(t, int32(1), calls.Load())
+	d.Close()
+}